@@ -0,0 +1,110 @@
+// Package apikey defines the format of API keys: a prefix naming the environment the key belongs to,
+// the identifier of the key, a random secret and a checksum, e.g. "gb_live_{id}_{secret}{checksum}".
+// The checksum lets mistyped or made-up keys be refused without a database lookup, and the prefix lets
+// secret scanners spot leaked keys.
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"globeboard/internal/utils/constants"
+	"hash/crc32"
+	"slices"
+	"strings"
+)
+
+// Environments API keys are issued for; keys are only accepted by deployments of their own environment.
+const (
+	EnvironmentLive = "live" // EnvironmentLive marks keys issued by production deployments.
+	EnvironmentTest = "test" // EnvironmentTest marks keys issued by test and development deployments.
+)
+
+// Environments lists every environment API keys may be issued for.
+var Environments = []string{EnvironmentLive, EnvironmentTest}
+
+const (
+	Prefix         = "gb"  // Prefix starts every API key.
+	LegacyPrefix   = "sk-" // LegacyPrefix starts the API keys issued before keys were checksummed, which are still accepted.
+	separator      = "_"   // separator joins the parts of an API key.
+	checksumLength = 6     // checksumLength is the length of the checksum ending an API key, enough for a CRC-32 in base 62.
+)
+
+// Alphabet is the characters the identifier, secret and checksum of API keys are made of.
+const Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+var (
+	ErrMalformed   = errors.New("malformed API key")              // ErrMalformed reports a key not in the API key format.
+	ErrChecksum    = errors.New("API key checksum mismatch")      // ErrChecksum reports a key whose checksum does not match, e.g. a mistyped one.
+	ErrEnvironment = errors.New("API key of another environment") // ErrEnvironment reports a key issued for another environment.
+)
+
+// Key is the public part of an API key.
+type Key struct {
+	Environment string // The environment the key was issued for.
+	ID          string // The identifier of the key, as listed to its owner.
+}
+
+// Format returns the API key of the given environment, identifier and secret, adding its checksum.
+func Format(environment, ID, secret string) string {
+	body := strings.Join([]string{Prefix, environment, ID, secret}, separator)
+	return body + checksum(body)
+}
+
+// Parse checks the format and checksum of an API key issued for the given environment, returning its public part.
+func Parse(key, environment string) (Key, error) {
+	parts := strings.Split(key, separator)
+	if len(parts) != 4 || parts[0] != Prefix || !slices.Contains(Environments, parts[1]) ||
+		len(parts[2]) != constants.IdLength || !inAlphabet(parts[2]) ||
+		len(parts[3]) <= checksumLength || !inAlphabet(parts[3]) {
+		return Key{}, ErrMalformed
+	}
+	body, sum := key[:len(key)-checksumLength], key[len(key)-checksumLength:]
+	if checksum(body) != sum {
+		return Key{}, ErrChecksum
+	}
+	if parts[1] != environment {
+		return Key{}, fmt.Errorf("%w: %s", ErrEnvironment, parts[1])
+	}
+	return Key{Environment: parts[1], ID: parts[2]}, nil
+}
+
+// IsLegacy reports whether a key is in the format issued before keys were checksummed.
+func IsLegacy(key string) bool {
+	return strings.HasPrefix(key, LegacyPrefix)
+}
+
+// Recognisable reports whether a bearer token looks like an API key rather than another kind of token,
+// without checking it.
+func Recognisable(token string) bool {
+	return IsLegacy(token) || strings.HasPrefix(token, Prefix+separator)
+}
+
+// Identifier returns the part of an API key that may be stored, logged and shown to identify it: the prefix,
+// environment and identifier of the key, or the first few characters of keys in another format.
+func Identifier(key string) string {
+	if parts := strings.SplitN(key, separator, 4); len(parts) == 4 && parts[0] == Prefix {
+		return strings.Join(parts[:3], separator)
+	}
+	return key[:min(len(key), constants.ApiKeyPrefixLength)]
+}
+
+// checksum returns the CRC-32 of an API key body in base 62, padded to checksumLength characters.
+func checksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	b := make([]byte, checksumLength)
+	for i := checksumLength - 1; i >= 0; i-- {
+		b[i] = Alphabet[sum%uint32(len(Alphabet))]
+		sum /= uint32(len(Alphabet))
+	}
+	return string(b)
+}
+
+// inAlphabet reports whether s is made of the characters of the Alphabet only.
+func inAlphabet(s string) bool {
+	for _, c := range []byte(s) {
+		if strings.IndexByte(Alphabet, c) < 0 {
+			return false
+		}
+	}
+	return true
+}
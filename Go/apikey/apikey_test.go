@@ -0,0 +1,88 @@
+package apikey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testID     = "ABCDEFGHIJabcdefghij" // testID is an identifier of constants.IdLength characters.
+	testSecret = "s3cr3tS3CR3Ts3cr3tS3CR3T"
+)
+
+func TestFormatParse(t *testing.T) {
+	key := Format(EnvironmentTest, testID, testSecret)
+	if !strings.HasPrefix(key, "gb_test_"+testID+"_"+testSecret) {
+		t.Fatalf("Format returned %q, want the prefix, environment, identifier and secret", key)
+	}
+
+	parsed, err := Parse(key, EnvironmentTest)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if parsed.Environment != EnvironmentTest || parsed.ID != testID {
+		t.Errorf("Parse returned %+v, want environment %s and ID %s", parsed, EnvironmentTest, testID)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	key := Format(EnvironmentTest, testID, testSecret)
+	// Change the last character of the secret, as a typo would, keeping the checksum.
+	body, sum := key[:len(key)-checksumLength], key[len(key)-checksumLength:]
+	typo := body[:len(body)-1] + "X" + sum
+
+	tests := []struct {
+		name        string
+		key         string
+		environment string
+		want        error
+	}{
+		{"empty", "", EnvironmentTest, ErrMalformed},
+		{"legacy", LegacyPrefix + testSecret, EnvironmentTest, ErrMalformed},
+		{"wrong prefix", strings.Replace(key, Prefix, "xx", 1), EnvironmentTest, ErrMalformed},
+		{"unknown environment", Format("prod", testID, testSecret), EnvironmentTest, ErrMalformed},
+		{"short identifier", Format(EnvironmentTest, testID[1:], testSecret), EnvironmentTest, ErrMalformed},
+		{"no secret", Format(EnvironmentTest, testID, ""), EnvironmentTest, ErrMalformed},
+		{"outside alphabet", Format(EnvironmentTest, testID, "s3cr3t-S3CR3T"), EnvironmentTest, ErrMalformed},
+		{"typo", typo, EnvironmentTest, ErrChecksum},
+		{"other environment", key, EnvironmentLive, ErrEnvironment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.key, tt.environment); !errors.Is(err, tt.want) {
+				t.Errorf("Parse(%q) returned error %v, want %v", tt.key, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecognisable(t *testing.T) {
+	tests := map[string]bool{
+		Format(EnvironmentLive, testID, testSecret): true,
+		LegacyPrefix + testSecret:                   true,
+		"eyJhbGciOiJSUzI1NiJ9.e30.c2ln":             false, // An ID token.
+		"":                                          false,
+	}
+	for token, want := range tests {
+		if got := Recognisable(token); got != want {
+			t.Errorf("Recognisable(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	key := Format(EnvironmentLive, testID, testSecret)
+	if got, want := Identifier(key), "gb_live_"+testID; got != want {
+		t.Errorf("Identifier(%q) = %q, want %q", key, got, want)
+	}
+	if got, want := Identifier(LegacyPrefix+testSecret), (LegacyPrefix + testSecret)[:8]; got != want {
+		t.Errorf("Identifier of legacy key = %q, want %q", got, want)
+	}
+	if got := Identifier("sk"); got != "sk" {
+		t.Errorf("Identifier of short key = %q, want it whole", got)
+	}
+	if strings.Contains(Identifier(key), testSecret) {
+		t.Errorf("Identifier(%q) contains the secret", key)
+	}
+}
@@ -1,33 +1,64 @@
-// Package authenticate provides functionality for initializing and accessing Firebase Authentication.
+// Package authenticate provides the identity providers users register and sign in with, and the sessions issued to them.
 package authenticate
 
 import (
 	"context"
-	firebase "firebase.google.com/go"
-	"firebase.google.com/go/auth"
-	"google.golang.org/api/option"
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"globeboard/db"
+	"globeboard/internal/utils/structs"
+)
+
+// Identity providers selectable through $AUTH_PROVIDER.
+const (
+	ProviderFirebase = "firebase" // ProviderFirebase keeps users in Firebase Authentication.
+	ProviderLocal    = "local"    // ProviderLocal keeps users, with bcrypt hashes of their passwords, in the store.
 )
 
 var (
-	ctx    = context.Background() // Background context for Firebase operations
-	Client *auth.Client           // Singleton Firebase Authentication client
+	// ErrInvalidCredentials is returned when an email and password, or an ID token, do not identify a user.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrPasswordSignInUnavailable is returned when the provider is not configured to verify passwords.
+	ErrPasswordSignInUnavailable = errors.New("password sign-in is not configured")
+	// ErrUserNotFound is returned when no user has the given UUID.
+	ErrUserNotFound = errors.New("no user was found")
+	// ErrEmailTaken is returned when registering an email another user already has.
+	ErrEmailTaken = errors.New("a user with that email already exists")
 )
 
-func init() {
-	// Load Firebase service account credentials from environment variable
-	sa := option.WithCredentialsFile(os.Getenv("FIREBASE_CREDENTIALS_FILE"))
+// Provider manages the identity of users: registering them, looking them up, verifying who is signing in and
+// deleting them. User passwords never leave the provider.
+type Provider interface {
+	// CreateUser registers a new user, returning them with their UUID.
+	CreateUser(ctx context.Context, email, password, displayName string) (*structs.User, error)
+	// GetUser looks up a user by UUID, or returns ErrUserNotFound.
+	GetUser(ctx context.Context, UUID string) (*structs.User, error)
+	// ListUsers returns every user, for administrators.
+	ListUsers(ctx context.Context) ([]*structs.User, error)
+	// DeleteUser deletes a user by UUID, or returns ErrUserNotFound.
+	DeleteUser(ctx context.Context, UUID string) error
+	// SignIn verifies an email and password, returning the UUID of the user they belong to.
+	SignIn(ctx context.Context, email, password string) (string, error)
+	// VerifyIDToken verifies an ID token issued by the provider, returning the UUID of the user it was issued to.
+	VerifyIDToken(ctx context.Context, idToken string) (string, error)
+}
 
-	// Initialize Firebase app with the loaded credentials
-	app, err := firebase.NewApp(ctx, nil, sa)
-	if err != nil {
-		log.Panic("Firebase Failed to initialize: ", err)
-	}
+// Options describes which identity provider to open and how to connect to it.
+type Options struct {
+	Provider                string       // One of the Provider* constants.
+	FirebaseCredentialsFile string       // Path to the Firebase credentials file, used by the Firebase provider.
+	FirebaseWebAPIKey       string       // Firebase Web API key for password sign-in, used by the Firebase provider.
+	Users                   db.UserStore // Store keeping users, used by the local provider.
+}
 
-	// Initialize the Firebase Authentication client
-	Client, err = app.Auth(ctx)
-	if err != nil {
-		log.Panic("Firebase Failed to initialize Authentication client: ", err)
+// Open creates the Provider selected by the given options.
+func Open(ctx context.Context, opts Options) (Provider, error) {
+	switch opts.Provider {
+	case ProviderFirebase, "":
+		return NewFirebaseProvider(ctx, opts.FirebaseCredentialsFile, opts.FirebaseWebAPIKey)
+	case ProviderLocal:
+		return NewLocalProvider(opts.Users), nil
+	default:
+		return nil, fmt.Errorf("unknown identity provider: %q", opts.Provider)
 	}
 }
@@ -0,0 +1,173 @@
+// Package authenticate provides the identity providers users register and sign in with, and the sessions issued to them.
+package authenticate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+	"fmt"
+	"globeboard/internal/utils/structs"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// passwordSignInURL is the Firebase Auth REST endpoint verifying an email and password.
+const passwordSignInURL = "https://identitytoolkit.googleapis.com/v1/accounts:signInWithPassword"
+
+// FirebaseProvider is a Provider backed by Firebase Authentication. Passwords are verified through the
+// Firebase Auth REST API, which needs the project's Web API key; everything else only needs the Admin client.
+type FirebaseProvider struct {
+	Client     *auth.Client // Firebase Admin client managing users and verifying ID tokens.
+	WebAPIKey  string       // Firebase Web API key for password sign-in; password sign-in is unavailable without it.
+	HTTPClient *http.Client // HTTP client for the REST API.
+}
+
+var _ Provider = (*FirebaseProvider)(nil) // Ensure FirebaseProvider implements Provider.
+
+// NewFirebaseProvider initializes a Firebase Authentication client using the provided credentials file,
+// returning a Provider that signs users in with passwords through the given Web API key.
+func NewFirebaseProvider(ctx context.Context, credentialsFile, webAPIKey string) (*FirebaseProvider, error) {
+	// Initialize Firebase app with the service account credentials
+	app, err := firebase.NewApp(ctx, nil, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, fmt.Errorf("firebase failed to initialize: %v", err)
+	}
+
+	// Initialize the Firebase Authentication client
+	client, err := app.Auth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("firebase failed to initialize Authentication client: %v", err)
+	}
+
+	return &FirebaseProvider{
+		Client:     client,
+		WebAPIKey:  webAPIKey,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// CreateUser registers a new user with Firebase Authentication.
+func (p *FirebaseProvider) CreateUser(ctx context.Context, email, password, displayName string) (*structs.User, error) {
+	params := (&auth.UserToCreate{}).
+		DisplayName(displayName).
+		Email(email).
+		Password(password)
+
+	u, err := p.Client.CreateUser(ctx, params)
+	if auth.IsEmailAlreadyExists(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return firebaseUser(u), nil
+}
+
+// GetUser looks up a user in Firebase Authentication.
+func (p *FirebaseProvider) GetUser(ctx context.Context, UUID string) (*structs.User, error) {
+	u, err := p.Client.GetUser(ctx, UUID)
+	if auth.IsUserNotFound(err) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return firebaseUser(u), nil
+}
+
+// ListUsers pages through every user in Firebase Authentication.
+func (p *FirebaseProvider) ListUsers(ctx context.Context) ([]*structs.User, error) {
+	var users []*structs.User
+	iter := p.Client.Users(ctx, "")
+	for {
+		u, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing users: %v", err)
+		}
+		users = append(users, firebaseUser(u.UserRecord))
+	}
+	return users, nil
+}
+
+// DeleteUser deletes a user from Firebase Authentication.
+func (p *FirebaseProvider) DeleteUser(ctx context.Context, UUID string) error {
+	err := p.Client.DeleteUser(ctx, UUID)
+	if auth.IsUserNotFound(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// firebaseUser converts a Firebase user record to a User.
+func firebaseUser(u *auth.UserRecord) *structs.User {
+	user := &structs.User{
+		UUID:        u.UID,
+		Email:       u.Email,
+		DisplayName: u.DisplayName,
+	}
+	if u.UserMetadata != nil {
+		user.Created = time.UnixMilli(u.UserMetadata.CreationTimestamp).UTC()
+	}
+	return user
+}
+
+// SignIn verifies an email and password with the Firebase Auth REST API.
+func (p *FirebaseProvider) SignIn(ctx context.Context, email, password string) (string, error) {
+	if p.WebAPIKey == "" {
+		return "", ErrPasswordSignInUnavailable
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"email":             email,
+		"password":          password,
+		"returnSecureToken": false,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		passwordSignInURL+"?key="+url.QueryEscape(p.WebAPIKey), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error contacting Firebase Auth: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode == http.StatusBadRequest:
+		return "", ErrInvalidCredentials // Firebase reports unknown emails and wrong passwords alike.
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("firebase Auth responded with status: %s", res.Status)
+	}
+
+	var signedIn struct {
+		LocalID string `json:"localId"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&signedIn); err != nil {
+		return "", fmt.Errorf("error decoding Firebase Auth response: %v", err)
+	}
+	return signedIn.LocalID, nil
+}
+
+// VerifyIDToken verifies a Firebase ID token with the Admin client.
+func (p *FirebaseProvider) VerifyIDToken(ctx context.Context, idToken string) (string, error) {
+	token, err := p.Client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return token.UID, nil
+}
@@ -0,0 +1,114 @@
+// Package authenticate provides the identity providers users register and sign in with, and the sessions issued to them.
+package authenticate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/utils/structs"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// localUIDLength is the length of the UUIDs given to users of the local provider, matching Firebase's.
+const localUIDLength = 28
+
+// LocalProvider is a Provider keeping users in the store, so GlobeBoard runs without Firebase.
+// Only bcrypt hashes of passwords are stored, and emails are stored in lower case, so they match case-insensitively.
+// It issues no ID tokens; users sign in with their email and password.
+type LocalProvider struct {
+	users db.UserStore // Store the users are kept in.
+	cost  int          // bcrypt cost of new password hashes.
+}
+
+var _ Provider = (*LocalProvider)(nil) // Ensure LocalProvider implements Provider.
+
+// NewLocalProvider returns a Provider keeping users in the given store.
+func NewLocalProvider(users db.UserStore) *LocalProvider {
+	return &LocalProvider{users: users, cost: bcrypt.DefaultCost}
+}
+
+// CreateUser registers a new user in the store, with a bcrypt hash of their password.
+func (p *LocalProvider) CreateUser(_ context.Context, email, password, displayName string) (*structs.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.cost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %v", err)
+	}
+	user := &structs.User{
+		UUID:         _func.GenerateUID(localUIDLength),
+		Email:        strings.ToLower(email),
+		DisplayName:  displayName,
+		PasswordHash: string(hash),
+	}
+	if err := p.users.AddUser(user); err != nil {
+		if errors.Is(err, db.ErrEmailTaken) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+	user.PasswordHash = "" // The hash never leaves the provider.
+	return user, nil
+}
+
+// GetUser looks up a user in the store.
+func (p *LocalProvider) GetUser(_ context.Context, UUID string) (*structs.User, error) {
+	user, err := p.users.GetUser(UUID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = "" // The hash never leaves the provider.
+	return user, nil
+}
+
+// ListUsers returns every user in the store, in order of registration.
+func (p *LocalProvider) ListUsers(_ context.Context) ([]*structs.User, error) {
+	stored, err := p.users.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*structs.User, 0, len(stored))
+	for i := range stored {
+		stored[i].PasswordHash = "" // The hash never leaves the provider.
+		users = append(users, &stored[i])
+	}
+	return users, nil
+}
+
+// DeleteUser deletes a user from the store.
+func (p *LocalProvider) DeleteUser(_ context.Context, UUID string) error {
+	err := p.users.DeleteUser(UUID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// dummyHash is compared against when signing in with an unknown email, so that unknown emails
+// take as long to reject as wrong passwords, and cannot be told apart by timing.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("globeboard-dummy-password"), bcrypt.DefaultCost)
+
+// SignIn verifies an email and password against the bcrypt hash in the store.
+func (p *LocalProvider) SignIn(_ context.Context, email, password string) (string, error) {
+	user, err := p.users.GetUserByEmail(strings.ToLower(email))
+	if errors.Is(err, db.ErrUserNotFound) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return "", ErrInvalidCredentials
+	}
+	return user.UUID, nil
+}
+
+// VerifyIDToken rejects every ID token, as the local provider issues none.
+func (p *LocalProvider) VerifyIDToken(_ context.Context, _ string) (string, error) {
+	return "", fmt.Errorf("%w: the local identity provider issues no ID tokens", ErrInvalidCredentials)
+}
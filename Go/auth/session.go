@@ -0,0 +1,85 @@
+// Package authenticate provides the identity providers users register and sign in with, and the sessions issued to them.
+package authenticate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionPrefix starts every session token, telling them apart from API keys and ID tokens.
+const SessionPrefix = "gbs-"
+
+// ErrInvalidSession is returned for session tokens that were not issued by this service, were tampered with or expired.
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Sessions issues and verifies short-lived session tokens, proving a user signed in without asking them again.
+// Tokens are signed rather than stored, so every instance sharing the secret accepts them until they expire.
+type Sessions struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSessions returns a Sessions issuing tokens valid for 'ttl', signed with 'secret'. Without a secret, a random
+// one is used, so sessions do not survive restarts and are not shared between instances.
+func NewSessions(secret string, ttl time.Duration) *Sessions {
+	key := []byte(secret)
+	if secret == "" {
+		log.Println("No session secret is set; sessions will not survive restarts or be shared between instances.")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Panic("Unable to generate session secret: ", err)
+		}
+	}
+	return &Sessions{secret: key, ttl: ttl}
+}
+
+// TTL returns how long issued session tokens are valid.
+func (s *Sessions) TTL() time.Duration {
+	return s.ttl
+}
+
+// Issue returns a session token for a user (UUID), along with when it expires.
+func (s *Sessions) Issue(UUID string) (string, time.Time) {
+	expires := time.Now().UTC().Add(s.ttl).Truncate(time.Second)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(UUID + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return SessionPrefix + payload + "." + s.sign(payload), expires
+}
+
+// Verify returns the user (UUID) a session token was issued to, or ErrInvalidSession.
+func (s *Sessions) Verify(token string) (string, error) {
+	payload, signature, ok := strings.Cut(strings.TrimPrefix(token, SessionPrefix), ".")
+	if !ok || !strings.HasPrefix(token, SessionPrefix) || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return "", ErrInvalidSession
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	UUID, expiry, ok := strings.Cut(string(decoded), "|")
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || UUID == "" || !time.Now().Before(time.Unix(expires, 0)) {
+		return "", ErrInvalidSession
+	}
+	return UUID, nil
+}
+
+// SessionIdentifier returns the part of a session token that may be logged to identify it: the token without
+// its signature.
+func SessionIdentifier(token string) string {
+	payload, _, _ := strings.Cut(token, ".")
+	return payload
+}
+
+// sign returns the signature of a session token payload.
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -2,15 +2,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"globeboard/apikey"
+	authenticate "globeboard/auth"
 	"globeboard/db"
+	_func "globeboard/internal/func"
 	"globeboard/internal/handlers"
+	"globeboard/internal/handlers/endpoint/admin"
 	"globeboard/internal/handlers/endpoint/dashboard"
 	"globeboard/internal/handlers/endpoint/util"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/config"
+	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
 	"globeboard/internal/utils/constants/Paths"
+	"globeboard/lockout"
+	"globeboard/metering"
+	"globeboard/ratelimit"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 )
 
 // fileExists checks if a file exists, and is not a directory.
@@ -23,16 +39,33 @@ func fileExists(filename string) bool {
 }
 
 func main() {
-	// Confirm that the Firebase Credentials file is accessible, if not, panic.
-	if !fileExists(os.Getenv("FIREBASE_CREDENTIALS_FILE")) {
+	// Firestore and Firebase Authentication need the Firebase Credentials file; if it is not accessible, panic.
+	backend := config.String("STORAGE_BACKEND", db.BackendFirestore)
+	identity := config.String("AUTH_PROVIDER", authenticate.ProviderFirebase)
+	if (backend == db.BackendFirestore || identity == authenticate.ProviderFirebase) &&
+		!fileExists(os.Getenv("FIREBASE_CREDENTIALS_FILE")) {
 		log.Panic("Firebase Credentials file is not mounted")
 	}
+
+	// Initialize the storage backend selected by $STORAGE_BACKEND (default: Firestore).
+	store, err := db.Open(db.Options{
+		Backend:                 backend,
+		DSN:                     config.String("DATABASE_URL", "./globeboard.db"),
+		FirestoreProjectID:      os.Getenv("FIRESTORE_PROJECT_ID"),
+		FirebaseCredentialsFile: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		APIKeySecret:            os.Getenv("API_KEY_SECRET"),
+		WebhookCacheTTL:         config.Duration("WEBHOOK_CACHE_TTL", db.DefaultWebhookCacheTTL),
+	})
+	if err != nil {
+		log.Panicf("Storage backend %q was unable to initialize: %v", backend, err)
+	}
 	defer func() {
-		// Close the Firestore client connection on application exit
-		if err := db.Client.Close(); err != nil {
-			log.Printf("Error closing Firestore client: %v", err)
+		// Close the storage backend connection on application exit
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing storage backend: %v", err)
 		}
 	}()
+	log.Printf("Using %q storage backend.", backend)
 
 	// Get the port from the environment variable or set default to 8080
 	port := os.Getenv("PORT")
@@ -41,20 +74,172 @@ func main() {
 		port = "8080"
 	}
 
-	// Define HTTP endpoints
+	// Whether API keys are still accepted in the query string, from $QUERY_TOKEN_POLICY (default: deprecate).
+	queryTokens := config.String("QUERY_TOKEN_POLICY", middleware.QueryTokensDeprecate)
+	if !slices.Contains(middleware.QueryTokenPolicies, queryTokens) {
+		log.Printf("Invalid $QUERY_TOKEN_POLICY: %q, using default: %s", queryTokens, middleware.QueryTokensDeprecate)
+		queryTokens = middleware.QueryTokensDeprecate
+	}
+
+	// The environment API keys are issued for and accepted from, from $API_KEY_ENVIRONMENT (default: live).
+	keyEnvironment := config.String("API_KEY_ENVIRONMENT", apikey.EnvironmentLive)
+	if !slices.Contains(apikey.Environments, keyEnvironment) {
+		log.Printf("Invalid $API_KEY_ENVIRONMENT: %q, using default: %s", keyEnvironment, apikey.EnvironmentLive)
+		keyEnvironment = apikey.EnvironmentLive
+	}
+
+	// Reach the upstream APIs as configured in the file at $CONFIG_FILE and by $UPSTREAM_{NAME}_URL, $UPSTREAM_{NAME}_TIMEOUT
+	// and $UPSTREAM_{NAME}_HEADERS, where NAME is COUNTRIES, METEO or CURRENCY.
+	upstreams, err := _func.LoadUpstreams(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Panicf("Upstream APIs are misconfigured: %v", err)
+	}
+	_func.ConfigureUpstreams(upstreams)
+	for _, name := range _func.UpstreamNames {
+		log.Printf("Using %s upstream at %s (timeout %s).", name, upstreams[name].BaseURL, upstreams[name].Timeout)
+	}
+
+	// Cache upstream lookups for $CACHE_TTL_* per data type (zero disables it), serving stale values while they are
+	// refreshed unless $CACHE_STALE_WHILE_REVALIDATE is false.
+	_func.ConfigureCache(_func.CacheConfig{
+		TTLs: map[string]time.Duration{
+			_func.CacheCountries: config.Duration("CACHE_TTL_COUNTRIES", constants.DefaultCacheTTLCountries),
+			_func.CacheCountry:   config.Duration("CACHE_TTL_COUNTRY", constants.DefaultCacheTTLCountry),
+			_func.CacheRates:     config.Duration("CACHE_TTL_RATES", constants.DefaultCacheTTLRates),
+			_func.CacheWeather:   config.Duration("CACHE_TTL_WEATHER", constants.DefaultCacheTTLWeather),
+		},
+		StaleWhileRevalidate: config.Bool("CACHE_STALE_WHILE_REVALIDATE", true),
+	})
+
+	// Rate limits per client address and API key, from $RATE_LIMIT_TIERS and $RATE_LIMIT_USERS.
+	policy, err := ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, os.Getenv("RATE_LIMIT_TIERS"), os.Getenv("RATE_LIMIT_USERS"))
+	if err != nil {
+		log.Printf("Invalid rate limits: %v, using defaults: %s", err, constants.DefaultRateLimitTiers)
+		policy, _ = ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, "", "")
+	}
+	limited := middleware.RateLimit(ratelimit.NewMemoryLimiter(), policy) // Refuses clients over their rate limits.
+
+	// Meter requests made with API keys, persisting the counts every $USAGE_FLUSH_INTERVAL (default: 10s).
+	meter := metering.NewMeter(store, config.Duration("USAGE_FLUSH_INTERVAL", constants.DefaultUsageFlushInterval))
+	defer func() {
+		// Persist the requests metered since the last flush on application exit
+		if err := meter.Close(); err != nil {
+			log.Printf("Error flushing API usage: %v", err)
+		}
+	}()
+
 	mux := http.NewServeMux()
-	mux.HandleFunc(Paths.Root, handlers.EmptyHandler)                           // Root endpoint
-	mux.HandleFunc(Endpoints.UserRegistration, util.UserRegistrationHandler)    // User registration endpoint
-	mux.HandleFunc(Endpoints.UserDeletionID, util.UserDeletionHandler)          // User deletion endpoint
-	mux.HandleFunc(Endpoints.ApiKey, util.APIKeyHandler)                        // API key endpoint
-	mux.HandleFunc(Endpoints.RegistrationsID, dashboard.RegistrationsIdHandler) // Registrations by ID endpoint
-	mux.HandleFunc(Endpoints.Registrations, dashboard.RegistrationsHandler)     // Registrations endpoint
-	mux.HandleFunc(Endpoints.DashboardsID, dashboard.DashboardsIdHandler)       // Dashboards by ID endpoint
-	mux.HandleFunc(Endpoints.NotificationsID, dashboard.NotificationsIdHandler) // Notifications by ID endpoint
-	mux.HandleFunc(Endpoints.Notifications, dashboard.NotificationsHandler)     // Notifications endpoint
-	mux.HandleFunc(Endpoints.Status, dashboard.StatusHandler)                   // Status endpoint
+	metered := middleware.Meter(meter, mux) // Counts requests by API key, endpoint and status code.
+
+	// Delay and then lock out clients and accounts after repeated failed authentication attempts, notifying
+	// developer webhooks subscribed to security events of lockouts.
+	guard := lockout.NewGuard(lockout.Policy{
+		Free:      config.Int("LOCKOUT_FREE_ATTEMPTS", constants.DefaultLockoutFreeAttempts),
+		Delay:     config.Duration("LOCKOUT_DELAY", constants.DefaultLockoutDelay),
+		MaxDelay:  config.Duration("LOCKOUT_MAX_DELAY", constants.DefaultLockoutMaxDelay),
+		Threshold: config.Int("LOCKOUT_THRESHOLD", constants.DefaultLockoutThreshold),
+		Lockout:   config.Duration("LOCKOUT_DURATION", constants.DefaultLockoutDuration),
+		Window:    config.Duration("LOCKOUT_WINDOW", constants.DefaultLockoutWindow),
+	}, func(event lockout.Event) {
+		go _func.LoopSendWebhooksSecurityEvent(store, event)
+	})
+
+	resolveKey := middleware.Authenticate(store, queryTokens, keyEnvironment, guard) // Resolves the API key of requests to its owner.
+	// Rate limits by API key too, metering the requests refused for it.
+	authenticated := func(h http.Handler) http.Handler { return resolveKey(metered(limited(h))) }
+
+	// How long a rotated API key stays valid, from $API_KEY_ROTATION_GRACE (default: 24h).
+	rotationGrace := config.Duration("API_KEY_ROTATION_GRACE", constants.DefaultApiKeyRotationGrace)
+
+	// How long resolving the features of a dashboard may take, from $DASHBOARD_TIMEOUT (default: 15s).
+	dashboardTimeout := config.Duration("DASHBOARD_TIMEOUT", constants.DefaultDashboardTimeout)
+
+	// The status of dashboards returned without some of their features, from $DASHBOARD_PARTIAL_STATUS (default: 200).
+	partialStatus := config.Int("DASHBOARD_PARTIAL_STATUS", http.StatusOK)
+	if partialStatus != http.StatusOK && partialStatus != http.StatusPartialContent {
+		log.Printf("Invalid $DASHBOARD_PARTIAL_STATUS: %d, using default: %d", partialStatus, http.StatusOK)
+		partialStatus = http.StatusOK
+	}
+
+	// Initialize the identity provider selected by $AUTH_PROVIDER (default: Firebase).
+	users, err := authenticate.Open(context.Background(), authenticate.Options{
+		Provider:                identity,
+		FirebaseCredentialsFile: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		FirebaseWebAPIKey:       os.Getenv("FIREBASE_WEB_API_KEY"),
+		Users:                   store,
+	})
+	if err != nil {
+		log.Panicf("Identity provider %q was unable to initialize: %v", identity, err)
+	}
+	log.Printf("Using %q identity provider.", identity)
+
+	// Sign users in with the identity provider, issuing sessions valid for $SESSION_TTL (default: 1h).
+	sessions := authenticate.NewSessions(os.Getenv("SESSION_SECRET"), config.Duration("SESSION_TTL", constants.DefaultSessionTTL))
+	resolveSession := middleware.AuthenticateSession(sessions, store, guard) // Resolves the session of requests to its user.
+	signedIn := func(h http.Handler) http.Handler { return resolveSession(limited(h)) }
+
+	// Users holding the admin role, from $ADMIN_USERS (comma-separated UUIDs).
+	admins := config.List("ADMIN_USERS")
+	adminOnly := func(h http.Handler) http.Handler { return authenticated(middleware.Admin(admins)(h)) }
+
+	// Define HTTP endpoints
+	mux.HandleFunc(Paths.Root, handlers.EmptyHandler)                                                                               // Root endpoint
+	mux.Handle(Endpoints.Login, limited(util.LoginHandler(store, users, sessions, guard)))                                          // Login endpoint
+	mux.Handle(Endpoints.UserRegistration, limited(util.UserRegistrationHandler(store, users, keyEnvironment)))                     // User registration endpoint
+	mux.Handle(Endpoints.UserDeletionID, authenticated(util.UserDeletionHandler(store, users, guard)))                              // User deletion endpoint
+	mux.Handle(Endpoints.UserExport, authenticated(util.UserExportHandler(store)))                                                  // User export endpoint
+	mux.Handle(Endpoints.UserImport, authenticated(util.UserImportHandler(store)))                                                  // User import endpoint
+	mux.Handle(Endpoints.ApiKey, signedIn(util.APIKeyHandler(store, admins, keyEnvironment)))                                       // API key endpoint
+	mux.Handle(Endpoints.ApiKeyList, signedIn(util.APIKeyListHandler(store)))                                                       // API key listing endpoint
+	mux.Handle(Endpoints.ApiKeyID, signedIn(util.APIKeyIdHandler(store)))                                                           // API key by ID endpoint
+	mux.Handle(Endpoints.ApiKeyRotate, signedIn(util.APIKeyRotateHandler(store, rotationGrace, admins, keyEnvironment)))            // API key rotation endpoint
+	mux.Handle(Endpoints.RegistrationsID, authenticated(dashboard.RegistrationsIdHandler(store, users)))                            // Registrations by ID endpoint
+	mux.Handle(Endpoints.RegistrationsHistory, authenticated(dashboard.RegistrationsHistoryHandler(store)))                         // Registration history endpoint
+	mux.Handle(Endpoints.RegistrationsRestore, authenticated(dashboard.RegistrationsRestoreHandler(store, users)))                  // Registration restore endpoint
+	mux.Handle(Endpoints.Registrations, authenticated(dashboard.RegistrationsHandler(store, users)))                                // Registrations endpoint
+	mux.Handle(Endpoints.DashboardsID, authenticated(dashboard.DashboardsIdHandler(store, users, dashboardTimeout, partialStatus))) // Dashboards by ID endpoint
+	mux.Handle(Endpoints.NotificationsID, authenticated(dashboard.NotificationsIdHandler(store)))                                   // Notifications by ID endpoint
+	mux.Handle(Endpoints.Notifications, authenticated(dashboard.NotificationsHandler(store)))                                       // Notifications endpoint
+	mux.Handle(Endpoints.Status, authenticated(dashboard.StatusHandler(store)))                                                     // Status endpoint
+	mux.Handle(Endpoints.Usage, authenticated(dashboard.UsageHandler(store, meter)))                                                // Usage endpoint
+	mux.Handle(Endpoints.AdminUsers, adminOnly(admin.UsersHandler(store, users, admins)))                                           // Admin user listing endpoint
+	mux.Handle(Endpoints.AdminUserKeys, adminOnly(admin.UserKeysHandler(store)))                                                    // Admin user API keys endpoint
+	mux.Handle(Endpoints.AdminUserKeyID, adminOnly(admin.UserKeyIdHandler(store)))                                                  // Admin user API key by ID endpoint
+	mux.Handle(Endpoints.AdminUserDisable, adminOnly(admin.UserDisableHandler(store, users)))                                       // Admin user disabling endpoint
+	mux.Handle(Endpoints.AdminWebhooks, adminOnly(admin.WebhooksHandler(store)))                                                    // Admin developer webhooks endpoint
+	mux.Handle(Endpoints.AdminWebhookID, adminOnly(admin.WebhookIdHandler(store)))                                                  // Admin developer webhook by ID endpoint
+	mux.Handle(Endpoints.AdminAudit, adminOnly(admin.AuditHandler(store)))                                                          // Admin audit trail endpoint
+	mux.Handle(Endpoints.AdminUsage, adminOnly(admin.UsageHandler(store, meter)))                                                   // Admin usage endpoint
+	mux.Handle(Endpoints.AdminLockouts, adminOnly(admin.LockoutsHandler(store, guard)))                                             // Admin lockout listing endpoint
+	mux.Handle(Endpoints.AdminLockoutKey, adminOnly(admin.LockoutKeyHandler(store, guard)))                                         // Admin lockout clearing endpoint
+
+	// Stop on an interrupt or SIGTERM, giving requests in flight $SHUTDOWN_TIMEOUT (default: 30s) to finish before the
+	// usage metered and the storage backend are closed by the deferred calls.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start the HTTP server
-	log.Println("Starting server on port " + port + " ...")
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting server on port " + port + " ...")
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Panicf("Server stopped: %v", err) // Panicking still runs the deferred calls.
+		}
+		return
+	case <-ctx.Done():
+		stop() // A second signal stops the server at once.
+	}
+
+	log.Println("Shutting down server ...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Duration("SHUTDOWN_TIMEOUT", constants.DefaultShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 }
@@ -2,39 +2,125 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"globeboard/apikey"
+	authenticate "globeboard/auth"
+	"globeboard/db"
+	_func "globeboard/internal/func"
 	"globeboard/internal/handlers"
+	adminhandlers "globeboard/internal/handlers/endpoint/admin"
 	"globeboard/internal/handlers/endpoint/dashboard"
 	"globeboard/internal/handlers/endpoint/util"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/config"
+	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
 	"globeboard/internal/utils/constants/Paths"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/internal/utils/constants/Webhooks"
+	"globeboard/internal/utils/structs"
+	"globeboard/lockout"
+	"globeboard/metering"
+	"globeboard/ratelimit"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
 	DisplayName = "Tester Testing"
 	Email       = "Tester@Testing.test"
 	Password    = "TestTesting123?!"
+
+	AdminDisplayName = "Admin Testing"
+	AdminEmail       = "Admin@Testing.test"
 )
 
 var (
 	mux        = http.NewServeMux()
-	wrongToken = "bhuiozdfbbjkwsrbnjlsfbjnklsdv" //Keyboard Mash
+	store      db.Store                                  // Storage backend shared by all handlers under test.
+	users      authenticate.Provider                     // Identity provider shared by all handlers under test.
+	guard      = lockout.NewGuard(lockout.Policy{}, nil) // Counts failed attempts without punishing them, as tests make plenty.
+	wrongToken = "bhuiozdfbbjkwsrbnjlsfbjnklsdv"         //Keyboard Mash
 	token      = "sk-token-brrr-access"
 	UUID       = "me_me_me_me"
+	session    = "gbs-session"
 	docId1     = "420"
 	docId2     = "420"
 	webhookId1 = "69"
 	webhookId2 = "69"
+	adminUUID  = "admin_admin_admin"
+	adminToken = "sk-admin-brrr-access"
 )
 
+// testProvider adds ID tokens to the identity provider under test, as the local provider issues none.
+type testProvider struct {
+	authenticate.Provider
+}
+
+func (testProvider) VerifyIDToken(_ context.Context, idToken string) (string, error) {
+	if idToken != "id-token-"+UUID {
+		return "", authenticate.ErrInvalidCredentials
+	}
+	return UUID, nil
+}
+
+// fakeCountries are the countries known to the fake upstream APIs, by ISO code.
+var fakeCountries = map[string]struct {
+	name, capital, currency string
+	lat, lng                float64
+}{
+	"NO": {"Norway", "Oslo", "NOK", 62, 10},
+	"SE": {"Sweden", "Stockholm", "SEK", 62, 15},
+	"GB": {"United Kingdom", "London", "GBP", 54, -2},
+	"US": {"United States", "Washington, D.C.", "USD", 38, -97},
+}
+
+// newFakeUpstreams starts fake REST Countries, Open-Meteo and Currency APIs serving fakeCountries,
+// so that tests neither depend on nor wait for the real ones.
+func newFakeUpstreams() *httptest.Server {
+	fake := http.NewServeMux()
+	fake.HandleFunc("/countries/all", func(w http.ResponseWriter, r *http.Request) {
+		var countries []map[string]any
+		for code, country := range fakeCountries {
+			countries = append(countries, map[string]any{"name": map[string]string{"common": country.name}, "cca2": code})
+		}
+		_ = json.NewEncoder(w).Encode(countries)
+	})
+	fake.HandleFunc("/countries/alpha", func(w http.ResponseWriter, r *http.Request) {
+		country, ok := fakeCountries[strings.ToUpper(r.URL.Query().Get("codes"))]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]map[string]any{{
+			"capital":    []string{country.capital},
+			"latlng":     []float64{country.lat, country.lng},
+			"population": 5379475,
+			"area":       323802.0,
+			"currencies": map[string]any{country.currency: map[string]string{"name": country.currency, "symbol": "$"}},
+		}})
+	})
+	fake.HandleFunc("/meteo/forecast", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"current": {"temperature_2m": -5.4, "precipitation": 0.5}}`))
+	})
+	fake.HandleFunc("/currency/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"rates": {"EUR": 0.085, "USD": 0.091, "JPY": 14.04, "NOK": 1, "SEK": 0.98, "GBP": 0.073}}`))
+	})
+	return httptest.NewServer(fake)
+}
+
 func fileExistsTest(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -44,20 +130,91 @@ func fileExistsTest(filename string) bool {
 }
 
 func init() {
-	if !fileExistsTest(os.Getenv("FIREBASE_CREDENTIALS_FILE")) {
+	// Tests run against the in-memory store and the local identity provider unless $STORAGE_BACKEND and
+	// $AUTH_PROVIDER select others; only Firestore and Firebase Authentication need the Firebase Credentials file.
+	backend := config.String("STORAGE_BACKEND", db.BackendMemory)
+	identity := config.String("AUTH_PROVIDER", authenticate.ProviderLocal)
+	if (backend == db.BackendFirestore || identity == authenticate.ProviderFirebase) &&
+		!fileExistsTest(os.Getenv("FIREBASE_CREDENTIALS_FILE")) {
 		log.Panic("Firebase Credentials file is not mounted: ", os.Getenv("FIREBASE_CREDENTIALS_FILE"))
 	}
 
+	// Upstream APIs are faked, so that tests run offline.
+	upstreams := newFakeUpstreams()
+	_func.ConfigureUpstreams(map[string]_func.Upstream{
+		_func.UpstreamCountries: {BaseURL: upstreams.URL + "/countries/", Timeout: constants.DefaultUpstreamTimeout},
+		_func.UpstreamMeteo:     {BaseURL: upstreams.URL + "/meteo/", Timeout: constants.DefaultUpstreamTimeout},
+		_func.UpstreamCurrency:  {BaseURL: upstreams.URL + "/currency/", Timeout: constants.DefaultUpstreamTimeout},
+	})
+
+	var err error
+	store, err = db.Open(db.Options{
+		Backend:                 backend,
+		DSN:                     config.String("DATABASE_URL", "file::memory:"),
+		FirestoreProjectID:      os.Getenv("FIRESTORE_PROJECT_ID"),
+		FirebaseCredentialsFile: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		APIKeySecret:            config.String("API_KEY_SECRET", "globeboard-test-secret"),
+		WebhookCacheTTL:         db.DefaultWebhookCacheTTL,
+	})
+	if err != nil {
+		log.Panic("Storage backend was unable to initialize: ", err)
+	}
+
+	provider, err := authenticate.Open(context.Background(), authenticate.Options{
+		Provider:                identity,
+		FirebaseCredentialsFile: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		FirebaseWebAPIKey:       os.Getenv("FIREBASE_WEB_API_KEY"),
+		Users:                   store,
+	})
+	if err != nil {
+		log.Panic("Identity provider was unable to initialize: ", err)
+	}
+	users = testProvider{provider}
+
+	// The administrator has to be known before the admin endpoints are set up.
+	admin, err := users.CreateUser(context.Background(), AdminEmail, Password, AdminDisplayName)
+	if err != nil {
+		log.Panic("Administrator was unable to register: ", err)
+	}
+	adminUUID = admin.UUID
+	admins := []string{adminUUID}
+
+	// Usage is flushed by the usage endpoints only, so that tests see every request they metered.
+	meter := metering.NewMeter(store, 0)
+	metered := middleware.Meter(meter, mux)
+	resolveKey := middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)
+	authenticated := func(h http.Handler) http.Handler { return resolveKey(metered(h)) }
+	sessions := authenticate.NewSessions("globeboard-test-secret", constants.DefaultSessionTTL)
+	signedIn := middleware.AuthenticateSession(sessions, store, guard)
+	adminOnly := func(h http.Handler) http.Handler { return authenticated(middleware.Admin(admins)(h)) }
+
 	mux.HandleFunc(Paths.Root, handlers.EmptyHandler)
-	mux.HandleFunc(Endpoints.UserRegistration, util.UserRegistrationHandler)
-	mux.HandleFunc(Endpoints.UserDeletionID, util.UserDeletionHandler)
-	mux.HandleFunc(Endpoints.ApiKey, util.APIKeyHandler)
-	mux.HandleFunc(Endpoints.RegistrationsID, dashboard.RegistrationsIdHandler)
-	mux.HandleFunc(Endpoints.Registrations, dashboard.RegistrationsHandler)
-	mux.HandleFunc(Endpoints.DashboardsID, dashboard.DashboardsIdHandler)
-	mux.HandleFunc(Endpoints.NotificationsID, dashboard.NotificationsIdHandler)
-	mux.HandleFunc(Endpoints.Notifications, dashboard.NotificationsHandler)
-	mux.HandleFunc(Endpoints.Status, dashboard.StatusHandler)
+	mux.HandleFunc(Endpoints.Login, util.LoginHandler(store, users, sessions, guard))
+	mux.HandleFunc(Endpoints.UserRegistration, util.UserRegistrationHandler(store, users, apikey.EnvironmentTest))
+	mux.Handle(Endpoints.UserDeletionID, authenticated(util.UserDeletionHandler(store, users, guard)))
+	mux.Handle(Endpoints.UserExport, authenticated(util.UserExportHandler(store)))
+	mux.Handle(Endpoints.UserImport, authenticated(util.UserImportHandler(store)))
+	mux.Handle(Endpoints.ApiKey, signedIn(util.APIKeyHandler(store, admins, apikey.EnvironmentTest)))
+	mux.Handle(Endpoints.ApiKeyList, signedIn(util.APIKeyListHandler(store)))
+	mux.Handle(Endpoints.ApiKeyID, signedIn(util.APIKeyIdHandler(store)))
+	mux.Handle(Endpoints.ApiKeyRotate, signedIn(util.APIKeyRotateHandler(store, constants.DefaultApiKeyRotationGrace, admins, apikey.EnvironmentTest)))
+	mux.Handle(Endpoints.RegistrationsID, authenticated(dashboard.RegistrationsIdHandler(store, users)))
+	mux.Handle(Endpoints.RegistrationsHistory, authenticated(dashboard.RegistrationsHistoryHandler(store)))
+	mux.Handle(Endpoints.RegistrationsRestore, authenticated(dashboard.RegistrationsRestoreHandler(store, users)))
+	mux.Handle(Endpoints.Registrations, authenticated(dashboard.RegistrationsHandler(store, users)))
+	mux.Handle(Endpoints.DashboardsID, authenticated(dashboard.DashboardsIdHandler(store, users, constants.DefaultDashboardTimeout, http.StatusOK)))
+	mux.Handle(Endpoints.NotificationsID, authenticated(dashboard.NotificationsIdHandler(store)))
+	mux.Handle(Endpoints.Notifications, authenticated(dashboard.NotificationsHandler(store)))
+	mux.Handle(Endpoints.Status, authenticated(dashboard.StatusHandler(store)))
+	mux.Handle(Endpoints.Usage, authenticated(dashboard.UsageHandler(store, meter)))
+	mux.Handle(Endpoints.AdminUsers, adminOnly(adminhandlers.UsersHandler(store, users, admins)))
+	mux.Handle(Endpoints.AdminUserKeys, adminOnly(adminhandlers.UserKeysHandler(store)))
+	mux.Handle(Endpoints.AdminUserKeyID, adminOnly(adminhandlers.UserKeyIdHandler(store)))
+	mux.Handle(Endpoints.AdminUserDisable, adminOnly(adminhandlers.UserDisableHandler(store, users)))
+	mux.Handle(Endpoints.AdminWebhooks, adminOnly(adminhandlers.WebhooksHandler(store)))
+	mux.Handle(Endpoints.AdminWebhookID, adminOnly(adminhandlers.WebhookIdHandler(store)))
+	mux.Handle(Endpoints.AdminAudit, adminOnly(adminhandlers.AuditHandler(store)))
+	mux.Handle(Endpoints.AdminUsage, adminOnly(adminhandlers.UsageHandler(store, meter)))
 
 }
 
@@ -162,17 +319,162 @@ func TestRegisterHandlerRegister(t *testing.T) {
 	UUID = response.UserID
 }
 
+func TestRegisterHandlerRegisterEmailTaken(t *testing.T) {
+	form := url.Values{}
+	form.Add("username", DisplayName)
+	form.Add("email", strings.ToUpper(Email))
+	form.Add("password", Password)
+
+	req, err := http.NewRequest(http.MethodPost, Endpoints.UserRegistration, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	form := url.Values{}
+	form.Add("email", Email)
+	form.Add("password", Password)
+
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Login, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response struct {
+		Session string    `json:"session"`
+		Expires time.Time `json:"expires"`
+		UserID  string    `json:"userid"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if response.UserID != UUID || !strings.HasPrefix(response.Session, authenticate.SessionPrefix) {
+		t.Errorf("login returned session %q for %q, want a session for %q", response.Session, response.UserID, UUID)
+	}
+	if !response.Expires.After(time.Now()) {
+		t.Errorf("login returned a session expiring at %v, want it to expire in the future", response.Expires)
+	}
+
+	session = response.Session
+}
+
+func TestLoginIDToken(t *testing.T) {
+	form := url.Values{}
+	form.Add("idToken", "id-token-"+UUID)
+
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Login, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	cases := map[string]struct {
+		form url.Values
+		want int
+	}{
+		"wrong password":   {url.Values{"email": {Email}, "password": {"wrong"}}, http.StatusUnauthorized},
+		"unknown email":    {url.Values{"email": {"nobody@testing.test"}, "password": {Password}}, http.StatusUnauthorized},
+		"invalid ID token": {url.Values{"idToken": {wrongToken}}, http.StatusUnauthorized},
+		"no credentials":   {url.Values{"email": {Email}}, http.StatusBadRequest},
+	}
+	for name, c := range cases {
+		req, err := http.NewRequest(http.MethodPost, Endpoints.Login, strings.NewReader(c.form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != c.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, status, c.want)
+		}
+	}
+}
+
+func TestAPIKeyHandlerRequiresSession(t *testing.T) {
+	cases := map[string]string{
+		"raw UUID":        UUID,
+		"API key":         "Bearer " + token,
+		"forged session":  "Bearer " + authenticate.SessionPrefix + "e30." + wrongToken,
+		"foreign session": "Bearer " + foreignSession(),
+	}
+	for name, authorization := range cases {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.ApiKeyList, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", authorization)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+// foreignSession returns a session for the test user signed with another secret.
+func foreignSession() string {
+	foreign, _ := authenticate.NewSessions("another-secret", time.Hour).Issue(UUID)
+	return foreign
+}
+
 func TestDeleteAPIKeyHandler(t *testing.T) {
+	// The key to delete is refused in the query string.
 	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey+"?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req.Header.Add("Authorization", UUID)
+	req.Header.Add("Authorization", "Bearer "+session)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("DELETE handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	req, err = http.NewRequest(http.MethodDelete, Endpoints.ApiKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+session)
+	req.Header.Add(middleware.APIKeyHeader, token)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
 	if status := rr.Code; status != http.StatusNoContent {
 		t.Errorf("DELETE handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
 	}
@@ -183,7 +485,7 @@ func TestGetAPIKeyHandler(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Add("Authorization", UUID)
+	req.Header.Add("Authorization", "Bearer "+session)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
@@ -202,83 +504,1800 @@ func TestGetAPIKeyHandler(t *testing.T) {
 	token = response.APIKey
 }
 
-func TestStatusGet(t *testing.T) {
-	req, err := http.NewRequest(http.MethodGet, Endpoints.Status+"?token="+token, nil)
+func TestAPIKeyHandlerNamedList(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?name=ci&expiresIn=720h", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Add("Authorization", "Bearer "+session)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("GET handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var created struct {
+		Token   string     `json:"token"`
+		ID      string     `json:"id"`
+		Name    string     `json:"name"`
+		Expires *time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if created.Name != "ci" || created.ID == "" || created.Expires == nil {
+		t.Errorf("created key has unexpected metadata: %+v", created)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.ApiKeyList, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+		t.Fatalf("list handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), token) || strings.Contains(rr.Body.String(), created.Token) {
+		t.Error("key listing revealed an API key")
+	}
+	var keys []map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&keys); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("list handler returned %d keys, want 2", len(keys))
 	}
+
+	deleteAPIKeyByID(t, created.ID)
 }
 
-func TestNotificationsHandlerPostDiscord(t *testing.T) {
-	notificationData := []byte(`{
-		"url": "https://discord.com",
-		"country": "",
-		"event": ["INVOKE","REGISTER","CHANGE","DELETE"]
-	}`)
-	req, err := http.NewRequest(http.MethodPost, Endpoints.Notifications+"?token="+token, bytes.NewBuffer(notificationData))
+func TestAPIKeyRotate(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?name=rotating", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var old struct {
+		Token string `json:"token"`
+		ID    string `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&old); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	// With a grace period the old key stays valid.
+	req, err = http.NewRequest(http.MethodPost, Endpoints.ApiKey+"/"+old.ID+"/rotate?gracePeriod=1h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("rotate handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var rotated struct {
+		Token    string `json:"token"`
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Replaces struct {
+			ID      string     `json:"id"`
+			Expires *time.Time `json:"expires"`
+		} `json:"replaces"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&rotated); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if rotated.Name != "rotating" || rotated.Replaces.ID != old.ID || rotated.Replaces.Expires == nil {
+		t.Errorf("rotated key has unexpected metadata: %+v", rotated)
+	}
+	if store.GetAPIKeyUUID("test", old.Token) != UUID || store.GetAPIKeyUUID("test", rotated.Token) != UUID {
+		t.Error("old and new keys should both be valid during the grace period")
+	}
+
+	// Without a grace period the old key stops being accepted at once.
+	req, err = http.NewRequest(http.MethodPost, Endpoints.ApiKey+"/"+rotated.ID+"/rotate?gracePeriod=0s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("rotate handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var latest struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&latest); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if store.GetAPIKeyUUID("test", rotated.Token) != "" {
+		t.Error("rotated key should be rejected once its grace period is over")
+	}
+
+	// Rotating an expired key is refused, and keys can be deleted by ID.
+	req, err = http.NewRequest(http.MethodPost, Endpoints.ApiKey+"/"+rotated.ID+"/rotate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("rotate handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+
+	for _, ID := range []string{old.ID, rotated.ID, latest.ID} {
+		deleteAPIKeyByID(t, ID)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?scopes=registrations:write,bogus:scope", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("GET handler returned wrong status code for unknown scope: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?name=read-only&scopes=registrations:read", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var readOnly struct {
+		Token  string   `json:"token"`
+		ID     string   `json:"id"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&readOnly); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if len(readOnly.Scopes) != 1 || readOnly.Scopes[0] != "registrations:read" {
+		t.Errorf("created key has unexpected scopes: %v", readOnly.Scopes)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+readOnly.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET handler returned wrong status code for read-only key: got %v want %v", status, http.StatusOK)
+	}
+
+	req, err = http.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+readOnly.Token, strings.NewReader(`{"isocode": "NO", "features": {"capital": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("POST handler returned wrong status code for read-only key: got %v want %v", status, http.StatusForbidden)
+	}
+	if !strings.Contains(rr.Body.String(), "registrations:write") {
+		t.Errorf("forbidden response does not name the missing scope: %q", rr.Body.String())
+	}
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.Status+"?token="+readOnly.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("GET handler returned wrong status code for read-only key: got %v want %v", status, http.StatusForbidden)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.Usage+"?token="+readOnly.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden || !strings.Contains(rr.Body.String(), "usage:read") {
+		t.Errorf("usage handler returned %v: %q for read-only key, want %v naming usage:read", status, rr.Body.String(), http.StatusForbidden)
+	}
+
+	deleteAPIKeyByID(t, readOnly.ID)
+}
+
+// deleteAPIKeyByID deletes one of the test user's API keys by its ID.
+func deleteAPIKeyByID(t *testing.T, ID string) {
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey+"/"+ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("DELETE handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+}
+
+func TestCountryProfile(t *testing.T) {
+	// A REST Countries response to alpha?codes=NO&fields=capital,latlng,population,area,currencies.
+	body := `[{"capital":["Oslo"],"latlng":[62.0,10.0],"population":5379475,"area":323802.0,
+		"currencies":{"NOK":{"name":"Norwegian krone","symbol":"kr"}}}]`
+	var profiles []_func.CountryProfile
+	if err := json.Unmarshal([]byte(body), &profiles); err != nil || len(profiles) != 1 {
+		t.Fatalf("country profile did not parse: %v", err)
+	}
+	profile := profiles[0]
+
+	if capital, err := profile.CapitalCity(); err != nil || capital != "Oslo" {
+		t.Errorf("capital = %q, %v, want Oslo", capital, err)
+	}
+	if coords, err := profile.Coordinates(); err != nil || coords.Latitude != "62.00000" || coords.Longitude != "10.00000" {
+		t.Errorf("coordinates = %+v, %v, want 62.00000, 10.00000", coords, err)
+	}
+	if _, ok := profile.Currencies["NOK"]; profile.Population != 5379475 || profile.Area != 323802 || !ok {
+		t.Errorf("profile = %+v, want population, area and NOK", profile)
+	}
+
+	// A country without a capital or coordinates is reported, rather than assembled from zero values.
+	if _, err := (_func.CountryProfile{}).CapitalCity(); err == nil {
+		t.Error("capital of an empty profile did not fail")
+	}
+	if _, err := (_func.CountryProfile{}).Coordinates(); err == nil {
+		t.Error("coordinates of an empty profile did not fail")
+	}
+}
+
+func TestUpstreamCancellation(t *testing.T) {
+	// A client that has disconnected cancels the upstream requests made on its behalf, which are not cached.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	coords := structs.CoordinatesDashboard{Latitude: "62.00000", Longitude: "10.00000"}
+	for name, get := range map[string]func() error{
+		"profile":       func() error { _, err := _func.GetCountryProfile(ctx, "NO"); return err },
+		"temperature":   func() error { _, err := _func.GetTemp(ctx, coords); return err },
+		"precipitation": func() error { _, err := _func.GetPrecipitation(ctx, coords); return err },
+	} {
+		start := time.Now()
+		if err := get(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: cancelled lookup returned %v, want %v", name, err, context.Canceled)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: cancelled lookup took %v", name, elapsed)
+		}
+	}
+	if entries := _func.CacheStats()[_func.CacheWeather].Entries; entries != 0 {
+		t.Errorf("upstream cache holds %d weather values after cancelled lookups, want 0", entries)
+	}
+}
+
+func TestStatusGet(t *testing.T) {
+	// Look a country up, so that the upstream cache counts it as a hit or a miss.
+	lookups := func() int64 {
+		stats := _func.CacheStats()[_func.CacheCountries]
+		return stats.Hits + stats.StaleHits + stats.Misses
+	}
+	before := lookups()
+	_ = _func.ValidateCountryInfo(context.Background(), &structs.CountryInfoInternal{IsoCode: "NO"})
+	if after := lookups(); after != before+1 {
+		t.Errorf("upstream cache counted %d country list lookups, want 1", after-before)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Status+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var status structs.StatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{_func.CacheCountries, _func.CacheCountry, _func.CacheRates, _func.CacheWeather} {
+		if _, ok := status.Cache[kind]; !ok {
+			t.Errorf("status is missing the upstream cache counts of %s: %+v", kind, status.Cache)
+		}
+	}
+	if countries := status.Cache[_func.CacheCountries]; countries.Hits+countries.StaleHits+countries.Misses < 1 {
+		t.Errorf("status reports no country list lookups: %+v", countries)
+	}
+}
+
+func TestLoadUpstreams(t *testing.T) {
+	file := t.TempDir() + "/config.json"
+	configuration := `{"upstreams": {"countries": {"url": "http://countries.test/v3.1", "timeout": "2s",
+		"headers": {"Authorization": "Bearer countries"}}}}`
+	if err := os.WriteFile(file, []byte(configuration), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("UPSTREAM_METEO_URL", "https://meteo.test/v1/")
+	t.Setenv("UPSTREAM_CURRENCY_HEADERS", "X-Api-Key: currency, Accept: application/json")
+
+	upstreams, err := _func.LoadUpstreams(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	countries := upstreams[_func.UpstreamCountries]
+	if countries.BaseURL != "http://countries.test/v3.1/" || countries.Timeout != 2*time.Second || countries.Headers["Authorization"] != "Bearer countries" {
+		t.Errorf("countries upstream = %+v, want the configuration file's", countries)
+	}
+	if meteo := upstreams[_func.UpstreamMeteo]; meteo.BaseURL != "https://meteo.test/v1/" || meteo.Timeout != constants.DefaultUpstreamTimeout {
+		t.Errorf("meteo upstream = %+v, want $UPSTREAM_METEO_URL and the default timeout", meteo)
+	}
+	if currency := upstreams[_func.UpstreamCurrency]; currency.Headers["X-Api-Key"] != "currency" || currency.Headers["Accept"] != "application/json" {
+		t.Errorf("currency upstream = %+v, want $UPSTREAM_CURRENCY_HEADERS", currency)
+	}
+
+	// Misconfigured upstreams are refused rather than silently replaced by the defaults.
+	for name, configuration := range map[string]string{
+		"unknown upstream": `{"upstreams": {"weather": {"url": "http://weather.test/"}}}`,
+		"relative URL":     `{"upstreams": {"countries": {"url": "countries.test"}}}`,
+		"invalid timeout":  `{"upstreams": {"currency": {"timeout": "soon"}}}`,
+		"invalid JSON":     `{"upstreams":`,
+	} {
+		if err := os.WriteFile(file, []byte(configuration), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := _func.LoadUpstreams(file); err == nil {
+			t.Errorf("%s: configuration was accepted", name)
+		}
+	}
+}
+
+func TestUpstreams(t *testing.T) {
+	// Fake upstream APIs, requiring the configured header from the REST Countries mirror.
+	meteoDown, countriesDown := false, false
+	fake := http.NewServeMux()
+	fake.HandleFunc("/countries/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer mirror" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/countries/all":
+			_, _ = w.Write([]byte(`[{"name": {"common": "Norway"}, "cca2": "NO"}]`))
+		case "/countries/alpha":
+			if countriesDown {
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`[{"capital": ["Oslo"], "latlng": [62.0, 10.0], "population": 5379475, "area": 323802.0,
+				"currencies": {"NOK": {"name": "Norwegian krone", "symbol": "kr"}}}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	fake.HandleFunc("/meteo/forecast", func(w http.ResponseWriter, r *http.Request) {
+		if meteoDown {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"current": {"temperature_2m": -5.4, "precipitation": 0.5}}`))
+	})
+	fake.HandleFunc("/currency/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.URL.Path, "/currency/nok") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"rates": {"EUR": 0.085, "USD": 0.091}}`))
+	})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	previous := _func.Upstreams()
+	defer _func.ConfigureUpstreams(previous)
+	defer _func.ConfigureCache(_func.DefaultCacheConfig())
+	_func.ConfigureCache(_func.DefaultCacheConfig())
+	_func.ConfigureUpstreams(map[string]_func.Upstream{
+		_func.UpstreamCountries: {BaseURL: server.URL + "/countries/", Timeout: time.Second, Headers: map[string]string{"Authorization": "Bearer mirror"}},
+		_func.UpstreamMeteo:     {BaseURL: server.URL + "/meteo/", Timeout: time.Second},
+		_func.UpstreamCurrency:  {BaseURL: server.URL + "/currency/", Timeout: time.Second},
+	})
+
+	// The status probes the configured upstreams.
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, Endpoints.Status+"?token="+token, nil))
+	var status structs.StatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.CountriesApi != "200 OK" || status.MeteoApi != "200 OK" || status.CurrencyApi != "200 OK" {
+		t.Errorf("status probed %q, %q, %q, want 200 OK from the fake upstreams", status.CountriesApi, status.MeteoApi, status.CurrencyApi)
+	}
+
+	// A registration is validated against, and its dashboard assembled from, the configured upstreams.
+	registration := `{"isocode": "no", "features": {"temperature": true, "precipitation": true, "capital": true,
+		"coordinates": true, "population": true, "area": true, "targetCurrencies": ["eur", "usd"]}}`
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+token, strings.NewReader(registration)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("registration returned %d: %s", rr.Code, rr.Body)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	defer mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, Endpoints.Registrations+"/"+created.ID+"?token="+token, nil))
+
+	getDashboard := func(handler http.Handler) (int, structs.DashboardResponse) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s?token=%s", Endpoints.Dashboards, created.ID, token), nil))
+		var dr structs.DashboardResponse
+		if rr.Header().Get("Content-Type") == "application/json" {
+			if err := json.Unmarshal(rr.Body.Bytes(), &dr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, dr
+	}
+
+	code, dr := getDashboard(mux)
+	features := dr.Features
+	if code != http.StatusOK || len(dr.Errors) != 0 {
+		t.Fatalf("dashboard returned %d with errors %+v, want 200 without errors", code, dr.Errors)
+	}
+	if features.Capital != "Oslo" || features.Population != 5379475 || features.Area != "323802.0" ||
+		features.Temperature != "-5.4" || features.Precipitation != "0.50" || features.TargetCurrencies["USD"] != 0.091 ||
+		features.Coordinates == nil || features.Coordinates.Latitude != "62.00000" {
+		t.Errorf("dashboard features = %+v, want every feature from the fake upstreams", features)
+	}
+
+	// While Open-Meteo is down, the other features are returned, as configured with 206 Partial Content.
+	meteoDown = true
+	_func.ConfigureCache(_func.DefaultCacheConfig())
+	partial := http.NewServeMux()
+	partial.Handle(Endpoints.DashboardsID, middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		dashboard.DashboardsIdHandler(store, users, constants.DefaultDashboardTimeout, http.StatusPartialContent)))
+	code, dr = getDashboard(partial)
+	if code != http.StatusPartialContent || dr.Features.Capital != "Oslo" || dr.Features.Temperature != "" {
+		t.Errorf("dashboard returned %d with features %+v, want 206 without the weather", code, dr.Features)
+	}
+	want := []structs.FeatureError{
+		{Feature: "precipitation", Source: "meteo_api", Error: "upstream responded 503 Service Unavailable"},
+		{Feature: "temperature", Source: "meteo_api", Error: "upstream responded 503 Service Unavailable"},
+	}
+	if fmt.Sprint(dr.Errors) != fmt.Sprint(want) {
+		t.Errorf("dashboard errors = %+v, want %+v", dr.Errors, want)
+	}
+
+	// While REST Countries is down too, no feature can be retrieved; the dashboard is returned with every error.
+	countriesDown = true
+	_func.ConfigureCache(_func.DefaultCacheConfig())
+	code, dr = getDashboard(mux)
+	features = dr.Features
+	if code != http.StatusBadGateway || dr.ID != created.ID || features.Capital != "" || features.Population != 0 || features.Area != "" ||
+		features.Temperature != "" || features.Coordinates != nil || len(features.TargetCurrencies) != 0 {
+		t.Errorf("dashboard returned %d with features %+v, want 502 without features", code, dr.Features)
+	}
+	if len(dr.Errors) != 7 {
+		t.Errorf("dashboard errors = %+v, want one for each of the 7 features", dr.Errors)
+	}
+	for _, e := range dr.Errors {
+		if e.Source != "countries_api" || e.Error != "upstream responded 503 Service Unavailable" {
+			t.Errorf("dashboard error = %+v, want REST Countries unavailable", e)
+		}
+	}
+}
+
+func TestAuthenticationHeaders(t *testing.T) {
+	for name, header := range map[string]http.Header{
+		"bearer":    {"Authorization": {"Bearer " + token}},
+		"X-API-Key": {"X-Api-Key": {token}},
+	} {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, status, http.StatusOK)
+		}
+	}
+}
+
+func TestAPIKeyFormat(t *testing.T) {
+	key, err := apikey.Parse(token, apikey.EnvironmentTest)
+	if err != nil || key.Environment != apikey.EnvironmentTest || !strings.HasPrefix(token, "gb_test_"+key.ID+"_") {
+		t.Fatalf("issued API key %s is not a test key: %+v, %v", db.APIKeyPrefix(token), key, err)
+	}
+	if db.APIKeyPrefix(token) != "gb_test_"+key.ID {
+		t.Errorf("API key is identified as %q, want gb_test_%s", db.APIKeyPrefix(token), key.ID)
+	}
+	if _func.GenerateUID(constants.IdLength) == _func.GenerateUID(constants.IdLength) {
+		t.Error("generated identifiers repeat")
+	}
+
+	// Keys issued before keys were checksummed are still accepted.
+	legacy := "sk-" + _func.GenerateUID(constants.ApiKeyLength)
+	if err := store.AddApiKey("", _func.GenerateUID(constants.DocIdLength), &structs.APIKey{ID: _func.GenerateUID(constants.IdLength), UUID: UUID, Scopes: []string{Scopes.RegistrationsRead}}, legacy); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := store.DeleteApiKey("", UUID, legacy); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	mistyped := []byte(token)
+	if i := len("gb_test_") + len(key.ID) + 3; mistyped[i] == 'A' { // Change a character of the secret.
+		mistyped[i] = 'B'
+	} else {
+		mistyped[i] = 'A'
+	}
+	live := apikey.Format(apikey.EnvironmentLive, key.ID, strings.Repeat("a", constants.ApiKeyLength))
+	for name, tc := range map[string]struct {
+		key  string
+		want int
+	}{
+		"issued":          {token, http.StatusOK},
+		"legacy":          {legacy, http.StatusOK},
+		"mistyped":        {string(mistyped), http.StatusNotAcceptable},
+		"truncated":       {token[:len(token)-1], http.StatusNotAcceptable},
+		"live key":        {live, http.StatusNotAcceptable},
+		"unknown checked": {apikey.Format(apikey.EnvironmentTest, key.ID, strings.Repeat("a", constants.ApiKeyLength)), http.StatusNotAcceptable},
+	} {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", "Bearer "+tc.key)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tc.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, status, tc.want)
+		}
+	}
+
+	for key, want := range map[string]error{
+		"gb_test_abc_def": apikey.ErrMalformed,
+		"gb_prod_" + key.ID + "_" + strings.Repeat("a", 40): apikey.ErrMalformed,
+		string(mistyped): apikey.ErrChecksum,
+		live:             apikey.ErrEnvironment,
+	} {
+		if _, err := apikey.Parse(key, apikey.EnvironmentTest); !errors.Is(err, want) {
+			t.Errorf("parsing %q returned %v, want %v", key, err, want)
+		}
+	}
+}
+
+func TestAuthenticationQueryTokenPolicy(t *testing.T) {
+	deprecated := middleware.Authenticate(store, middleware.QueryTokensDeprecate, apikey.EnvironmentTest, guard)(dashboard.RegistrationsHandler(store, users))
+	rejected := middleware.Authenticate(store, middleware.QueryTokensReject, apikey.EnvironmentTest, guard)(dashboard.RegistrationsHandler(store, users))
+
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	deprecated.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if rr.Header().Get("Deprecation") == "" {
+		t.Error("query-string API key was not flagged as deprecated")
+	}
+
+	rr = httptest.NewRecorder()
+	rejected.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	req.URL.RawQuery = ""
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr = httptest.NewRecorder()
+	rejected.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	policy, err := ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, "default=1:2:0,ip=0:0:0,daily=1000:1000:3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		middleware.RateLimit(ratelimit.NewMemoryLimiter(), policy)(dashboard.RegistrationsHandler(store, users)))
+
+	// The bucket holds two requests and refills at one a second.
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rr := rateLimitedRequest(t, limited, token)
+		if status := rr.Code; status != want {
+			t.Errorf("request %d: handler returned wrong status code: got %v want %v", i+1, status, want)
+		}
+		if remaining := rr.Header().Get("RateLimit-Remaining"); remaining != fmt.Sprint(max(1-i, 0)) {
+			t.Errorf("request %d: RateLimit-Remaining is %q, want %d", i+1, remaining, max(1-i, 0))
+		}
+		if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Policy") != "2;w=2" {
+			t.Errorf("request %d: unexpected RateLimit headers: %v", i+1, rr.Header())
+		}
+		if want == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("Retry-After is %q, want 1", rr.Header().Get("Retry-After"))
+		}
+	}
+
+	// Users on the daily tier may make three requests a day, however fast.
+	policy, err = ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, "default=1:2:0,ip=0:0:0,daily=1000:1000:3", UUID+"=daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited = middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		middleware.RateLimit(ratelimit.NewMemoryLimiter(), policy)(dashboard.RegistrationsHandler(store, users)))
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rr := rateLimitedRequest(t, limited, token)
+		if status := rr.Code; status != want {
+			t.Errorf("request %d: handler returned wrong status code: got %v want %v", i+1, status, want)
+		}
+		if want == http.StatusTooManyRequests {
+			if retry, _ := strconv.Atoi(rr.Header().Get("Retry-After")); retry < 1 || retry > 24*60*60 {
+				t.Errorf("Retry-After is %q, want the time until midnight", rr.Header().Get("Retry-After"))
+			}
+			if !strings.Contains(rr.Body.String(), "Daily quota") {
+				t.Errorf("quota response does not name the quota: %q", rr.Body.String())
+			}
+		}
+	}
+
+	// Client addresses are limited whether they present an API key or not.
+	policy, err = ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, "default=0:0:0,ip=1:1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited = middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		middleware.RateLimit(ratelimit.NewMemoryLimiter(), policy)(dashboard.RegistrationsHandler(store, users)))
+	for i, want := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if status := rateLimitedRequest(t, limited, "").Code; status != want {
+			t.Errorf("request %d: handler returned wrong status code: got %v want %v", i+1, status, want)
+		}
+	}
+
+	for _, tiers := range []string{"default=fast:2:0", "default=1:2", "premium"} {
+		if _, err := ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, tiers, ""); err == nil {
+			t.Errorf("invalid tiers %q were accepted", tiers)
+		}
+	}
+	if _, err := ratelimit.ParsePolicy(constants.DefaultRateLimitTiers, "", UUID+"=premium"); err == nil {
+		t.Error("assignment to an unknown tier was accepted")
+	}
+}
+
+// rateLimitedRequest sends a request for the registrations with the given API key, if any, to a rate limited handler.
+func rateLimitedRequest(t *testing.T, handler http.Handler, apiKey string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	if apiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+apiKey)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminScopeRequiresRole(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?scopes=admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("GET handler returned wrong status code for admin scope: got %v want %v", status, http.StatusForbidden)
+	}
+}
+
+func TestAdminGetAPIKey(t *testing.T) {
+	form := url.Values{}
+	form.Add("email", AdminEmail)
+	form.Add("password", Password)
+
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Login, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var login struct {
+		Session string `json:"session"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?name=admin&scopes=admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+login.Session)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("GET handler returned wrong status code for admin scope: got %v want %v", status, http.StatusCreated)
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	adminToken = created.Token
+}
+
+func TestAdminRequiresAdmin(t *testing.T) {
+	// An administrator removed from the admin role keeps the scope on their keys, but loses access.
+	formerAdmin := middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		middleware.Admin(nil)(adminhandlers.UsersHandler(store, users, nil)))
+
+	cases := map[string]struct {
+		handler       http.Handler
+		authorization string
+		want          int
+	}{
+		"no API key":         {mux, "", http.StatusUnauthorized},
+		"user API key":       {mux, "Bearer " + token, http.StatusForbidden},
+		"former admin's key": {formerAdmin, "Bearer " + adminToken, http.StatusForbidden},
+	}
+	for name, c := range cases {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.AdminUsers, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.authorization != "" {
+			req.Header.Add("Authorization", c.authorization)
+		}
+
+		rr := httptest.NewRecorder()
+		c.handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != c.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, status, c.want)
+		}
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.AdminUsers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+adminToken)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var summaries []struct {
+		UUID    string `json:"uuid"`
+		Email   string `json:"email"`
+		Admin   bool   `json:"admin"`
+		APIKeys int    `json:"apiKeys"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&summaries); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	found := map[string]bool{}
+	for _, s := range summaries {
+		switch s.UUID {
+		case UUID:
+			found[UUID] = s.Email == strings.ToLower(Email) && !s.Admin && s.APIKeys >= 1
+		case adminUUID:
+			found[adminUUID] = s.Admin && s.APIKeys == 1
+		}
+	}
+	if !found[UUID] || !found[adminUUID] {
+		t.Errorf("user listing does not describe the test user and administrator: %+v", summaries)
+	}
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	adminRequest(t, http.MethodPost, strings.Replace(Endpoints.AdminUserDisable, "{ID}", adminUUID, 1), "", http.StatusConflict)
+	adminRequest(t, http.MethodPost, strings.Replace(Endpoints.AdminUserDisable, "{ID}", "nobody", 1), "", http.StatusNotFound)
+	adminRequest(t, http.MethodPost, strings.Replace(Endpoints.AdminUserDisable, "{ID}", UUID, 1), "", http.StatusNoContent)
+
+	// While disabled, the user's API keys and sessions are refused and they cannot sign in.
+	for name, req := range disabledUserRequests(t) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("%s: handler returned wrong status code for disabled user: got %v want %v", name, status, http.StatusForbidden)
+		}
+	}
+
+	// Nor can they delete themselves.
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+UUID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(middleware.APIKeyHeader, token)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code for deletion by disabled user: got %v want %v", status, http.StatusForbidden)
+	}
+
+	adminRequest(t, http.MethodDelete, strings.Replace(Endpoints.AdminUserDisable, "{ID}", UUID, 1), "", http.StatusNoContent)
+
+	for name, req := range disabledUserRequests(t) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code for re-enabled user: got %v want %v", name, status, http.StatusOK)
+		}
+	}
+}
+
+// disabledUserRequests returns requests on behalf of the test user, which are refused while they are disabled.
+func disabledUserRequests(t *testing.T) map[string]*http.Request {
+	apiKey, err := http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey.Header.Add("Authorization", "Bearer "+token)
+
+	signedIn, err := http.NewRequest(http.MethodGet, Endpoints.ApiKeyList, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedIn.Header.Add("Authorization", "Bearer "+session)
+
+	form := url.Values{"email": {Email}, "password": {Password}}
+	login, err := http.NewRequest(http.MethodPost, Endpoints.Login, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	login.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	return map[string]*http.Request{"API key": apiKey, "session": signedIn, "login": login}
+}
+
+func TestAdminRevokeAPIKey(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.ApiKey+"?name=revoked", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+session)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var created struct {
+		Token string `json:"token"`
+		ID    string `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	keys := strings.Replace(Endpoints.AdminUserKeys, "{ID}", UUID, 1)
+	if body := adminRequest(t, http.MethodGet, keys, "", http.StatusOK); !strings.Contains(body, created.ID) {
+		t.Errorf("key listing does not include key %s: %s", created.ID, body)
+	}
+	adminRequest(t, http.MethodDelete, keys+"/"+created.ID, "", http.StatusNoContent)
+	adminRequest(t, http.MethodDelete, keys+"/"+created.ID, "", http.StatusNotFound)
+
+	req, err = http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+created.Token)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotAcceptable {
+		t.Errorf("handler returned wrong status code for revoked key: got %v want %v", status, http.StatusNotAcceptable)
+	}
+}
+
+func TestAdminDeveloperWebhooks(t *testing.T) {
+	adminRequest(t, http.MethodPost, Endpoints.AdminWebhooks, `{"url": "not a url", "event": ["REGISTER"]}`, http.StatusBadRequest)
+	adminRequest(t, http.MethodPost, Endpoints.AdminWebhooks, `{"url": "https://example.com/hook", "event": ["BOGUS"]}`, http.StatusBadRequest)
+
+	body := adminRequest(t, http.MethodPost, Endpoints.AdminWebhooks, `{"url": "https://example.com/hook", "event": ["USER_DELETE"]}`, http.StatusCreated)
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	if body := adminRequest(t, http.MethodGet, Endpoints.AdminWebhooks, "", http.StatusOK); !strings.Contains(body, created.ID) {
+		t.Errorf("developer webhook listing does not include webhook %s: %s", created.ID, body)
+	}
+	hook := strings.Replace(Endpoints.AdminWebhookID, "{ID}", created.ID, 1)
+	adminRequest(t, http.MethodGet, hook, "", http.StatusOK)
+
+	// Developer webhooks are not the test user's.
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Notifications+"/"+created.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for developer webhook: got %v want %v", status, http.StatusNotFound)
+	}
+
+	adminRequest(t, http.MethodDelete, hook, "", http.StatusNoContent)
+	adminRequest(t, http.MethodGet, hook, "", http.StatusNotFound)
+}
+
+func TestAdminAudit(t *testing.T) {
+	body := adminRequest(t, http.MethodGet, Endpoints.AdminAudit+"?target="+UUID, "", http.StatusOK)
+	var entries []struct {
+		Actor  string `json:"actor"`
+		Action string `json:"action"`
+		Target string `json:"target"`
+	}
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		if entry.Actor != adminUUID || entry.Target != UUID {
+			t.Errorf("audit trail filtered on target %s returned %+v", UUID, entry)
+		}
+		actions = append(actions, entry.Action)
+	}
+	// Newest first.
+	want := []string{adminhandlers.ActionRevokeKey, adminhandlers.ActionListKeys, adminhandlers.ActionEnableUser, adminhandlers.ActionDisableUser}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("audit trail for %s recorded %v, want %v", UUID, actions, want)
+	}
+
+	body = adminRequest(t, http.MethodGet, Endpoints.AdminAudit+"?limit=1", "", http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if len(entries) != 1 || entries[0].Action != adminhandlers.ActionReadAuditTrail {
+		t.Errorf("audit trail limited to 1 entry returned %+v, want the previous read of the audit trail", entries)
+	}
+
+	adminRequest(t, http.MethodGet, Endpoints.AdminAudit+"?limit=0", "", http.StatusBadRequest)
+}
+
+// adminRequest sends a request to the administrative API with the administrator's API key,
+// checking the status code and returning the response body.
+func adminRequest(t *testing.T, method, target, body string, want int) string {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+adminToken)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != want {
+		t.Errorf("%s %s returned wrong status code: got %v want %v: %s", method, target, status, want, rr.Body.String())
+	}
+	return rr.Body.String()
+}
+
+func TestLockout(t *testing.T) {
+	// After one free failure, every failure delays the next attempt from the same address.
+	guard := lockout.NewGuard(lockout.Policy{Free: 1, Delay: time.Hour, MaxDelay: 2 * time.Hour, Window: time.Hour}, nil)
+	guarded := middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(dashboard.RegistrationsHandler(store, users))
+	for i, attempt := range []struct {
+		addr, key string
+		want      int
+	}{
+		{"203.0.113.1:1234", wrongToken, http.StatusNotAcceptable},
+		{"203.0.113.1:1234", wrongToken, http.StatusNotAcceptable},
+		{"203.0.113.1:1234", token, http.StatusTooManyRequests},
+		{"203.0.113.2:1234", token, http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = attempt.addr
+		req.Header.Add(middleware.APIKeyHeader, attempt.key)
+
+		rr := httptest.NewRecorder()
+		guarded.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != attempt.want {
+			t.Errorf("attempt %d: handler returned wrong status code: got %v want %v", i+1, status, attempt.want)
+		}
+		if attempt.want == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "3600" {
+			t.Errorf("Retry-After is %q, want 3600", rr.Header().Get("Retry-After"))
+		}
+	}
+
+	// Guesses at the secret of an API key lock the key out, wherever they come from.
+	guard = lockout.NewGuard(lockout.Policy{Free: 10, Threshold: 3, Lockout: time.Hour, Window: time.Hour}, nil)
+	guarded = middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(dashboard.RegistrationsHandler(store, users))
+	for i, attempt := range []struct {
+		addr, key string
+		want      int
+	}{
+		{"192.0.2.1:1234", token + "-1", http.StatusNotAcceptable},
+		{"192.0.2.2:1234", token + "-2", http.StatusNotAcceptable},
+		{"192.0.2.3:1234", token + "-3", http.StatusNotAcceptable},
+		{"192.0.2.4:1234", token, http.StatusTooManyRequests},
+	} {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = attempt.addr
+		req.Header.Add(middleware.APIKeyHeader, attempt.key)
+
+		rr := httptest.NewRecorder()
+		guarded.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != attempt.want {
+			t.Errorf("guess %d: handler returned wrong status code: got %v want %v", i+1, status, attempt.want)
+		}
+	}
+	if credential := lockout.Credential(apikey.Identifier(token)); guard.Check(credential).Allowed {
+		t.Errorf("%s is not locked out after guesses at its secret", credential)
+	}
+
+	// Attempts to delete another user count against the client only, so that they cannot lock that user out.
+	guard = lockout.NewGuard(lockout.Policy{Free: 10, Threshold: 3, Lockout: time.Hour, Window: time.Hour}, nil)
+	deletion := http.NewServeMux()
+	deletion.Handle(Endpoints.UserDeletionID, middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		util.UserDeletionHandler(store, users, guard)))
+	for i := range 3 {
+		req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+adminUUID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", 10+i)
+		req.Header.Add(middleware.APIKeyHeader, token)
+
+		rr := httptest.NewRecorder()
+		deletion.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("deleting another user: handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+	}
+	if !guard.Check(lockout.User(adminUUID)).Allowed {
+		t.Errorf("%s is locked out after others attempted to delete them", lockout.User(adminUUID))
+	}
+
+	// Failed sign-ins lock the account out, wherever they come from.
+	var events []lockout.Event
+	guard = lockout.NewGuard(lockout.Policy{Free: 10, Threshold: 3, Lockout: time.Hour, Window: time.Hour}, func(event lockout.Event) {
+		events = append(events, event)
+	})
+	login := util.LoginHandler(store, users, authenticate.NewSessions("globeboard-test-secret", constants.DefaultSessionTTL), guard)
+	for i, attempt := range []struct {
+		addr, password string
+		want           int
+	}{
+		{"198.51.100.1:1234", "wrong", http.StatusUnauthorized},
+		{"198.51.100.2:1234", "wrong", http.StatusUnauthorized},
+		{"198.51.100.3:1234", "wrong", http.StatusUnauthorized},
+		{"198.51.100.4:1234", Password, http.StatusTooManyRequests},
+	} {
+		form := url.Values{"email": {Email}, "password": {attempt.password}}
+		req, err := http.NewRequest(http.MethodPost, Endpoints.Login, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = attempt.addr
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		login.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != attempt.want {
+			t.Errorf("sign-in %d: handler returned wrong status code: got %v want %v", i+1, status, attempt.want)
+		}
+	}
+	account := lockout.User(Email)
+	if len(events) != 1 || events[0].Type != lockout.EventLockout || events[0].Key != account || events[0].Failures != 3 {
+		t.Errorf("failed sign-ins raised %+v, want a lockout of %s", events, account)
+	}
+
+	// Administrators list and clear lockouts.
+	admin := http.NewServeMux()
+	adminOnly := func(h http.Handler) http.Handler {
+		return middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(middleware.Admin([]string{adminUUID})(h))
+	}
+	admin.Handle(Endpoints.AdminLockouts, adminOnly(adminhandlers.LockoutsHandler(store, guard)))
+	admin.Handle(Endpoints.AdminLockoutKey, adminOnly(adminhandlers.LockoutKeyHandler(store, guard)))
+	lockoutRequest := func(method, target string, want int) string {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", "Bearer "+adminToken)
+
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("%s %s returned wrong status code: got %v want %v", method, target, status, want)
+		}
+		return rr.Body.String()
+	}
+
+	var lockouts []lockout.Lockout
+	if err := json.Unmarshal([]byte(lockoutRequest(http.MethodGet, Endpoints.AdminLockouts, http.StatusOK)), &lockouts); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Key != account || !lockouts[0].Until.After(time.Now()) {
+		t.Errorf("lockouts listed %+v, want %s", lockouts, account)
+	}
+
+	clear := strings.Replace(Endpoints.AdminLockoutKey, "{KEY}", account, 1)
+	lockoutRequest(http.MethodDelete, clear, http.StatusNoContent)
+	lockoutRequest(http.MethodDelete, clear, http.StatusNotFound)
+	if len(events) != 2 || events[1].Type != lockout.EventUnlock || events[1].Actor != adminUUID {
+		t.Errorf("clearing the lockout raised %+v, want an unlock by %s", events[1:], adminUUID)
+	}
+	if guard.Check(account).Allowed != true {
+		t.Errorf("%s is still locked out after clearing its lockout", account)
+	}
+}
+
+// TestMeterFlush confirms that metered handlers can still flush their responses.
+func TestMeterFlush(t *testing.T) {
+	metered := http.NewServeMux()
+	flushing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flushing a metered response failed: %v", err)
+		}
+	})
+	metered.Handle(Endpoints.Status, middleware.Authenticate(store, middleware.QueryTokensAllow, apikey.EnvironmentTest, guard)(
+		middleware.Meter(metering.NewMeter(store, 0), metered)(flushing)))
+
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Status, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(middleware.APIKeyHeader, token)
+
+	rr := httptest.NewRecorder()
+	metered.ServeHTTP(rr, req)
+
+	if !rr.Flushed {
+		t.Error("metered response was not flushed")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	report := usageRequest(t, Endpoints.Usage, token)
+	var status *structs.Usage
+	requests := 0
+	for i, u := range report.Usage {
+		if u.UUID != UUID {
+			t.Errorf("usage of %s included %+v", UUID, u)
+		}
+		if u.Endpoint == Endpoints.Status && u.Status == http.StatusOK {
+			status = &report.Usage[i]
+		}
+		requests += u.Count
+	}
+	if status == nil || status.Count < 1 || status.KeyID == "" || status.MaxLatencyMs > status.TotalLatencyMs {
+		t.Errorf("usage of %s metered %+v for %s, want at least 1 request", UUID, status, Endpoints.Status)
+	}
+	if report.Requests != requests {
+		t.Errorf("usage report counted %d requests, want %d", report.Requests, requests)
+	}
+
+	// The usage request itself is metered too.
+	if again := usageRequest(t, Endpoints.Usage, token); again.Requests != report.Requests+1 {
+		t.Errorf("usage report counted %d requests after reading it, want %d", again.Requests, report.Requests+1)
+	}
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	if report.To != today || report.From != time.Now().UTC().AddDate(0, 0, 1-constants.DefaultUsageDays).Format(time.DateOnly) {
+		t.Errorf("usage report covers %s to %s by default, want the last %d days", report.From, report.To, constants.DefaultUsageDays)
+	}
+	if report := usageRequest(t, Endpoints.Usage+"?from=2000-01-01&to=2000-01-31", token); len(report.Usage) != 0 {
+		t.Errorf("usage report for January 2000 returned %+v, want none", report.Usage)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?to=2024-13-01", "?from=2024-02-01&to=2024-01-01", "?from=2020-01-01&to=2024-01-01"} {
+		req, err := http.NewRequest(http.MethodGet, Endpoints.Usage+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+
+	// Administrators see the usage of every user, or of one.
+	seen := map[string]bool{}
+	for _, u := range usageRequest(t, Endpoints.AdminUsage, adminToken).Usage {
+		seen[u.UUID] = true
+	}
+	if !seen[UUID] || !seen[adminUUID] {
+		t.Errorf("usage of every user covered %v, want %s and %s", seen, UUID, adminUUID)
+	}
+	for _, u := range usageRequest(t, Endpoints.AdminUsage+"?uuid="+UUID, adminToken).Usage {
+		if u.UUID != UUID {
+			t.Errorf("usage of %s included %+v", UUID, u)
+		}
+	}
+}
+
+// usageRequest reads a usage report with the given API key.
+func usageRequest(t *testing.T, target, key string) structs.UsageReport {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+key)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var report structs.UsageReport
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET %s returned wrong status code: got %v want %v: %s", target, status, http.StatusOK, rr.Body.String())
+		return report
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	return report
+}
+
+func TestNotificationsHandlerPostDiscord(t *testing.T) {
+	notificationData := []byte(`{
+		"url": "https://discord.com",
+		"country": "",
+		"event": ["INVOKE","REGISTER","CHANGE","DELETE"]
+	}`)
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Notifications+"?token="+token, bytes.NewBuffer(notificationData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	webhookId1 = response.ID
+}
+
+func TestNotificationsHandlerPost(t *testing.T) {
+	notificationData := []byte(`{
+		"url": "https://google.com/",
+		"country": "",
+		"event": ["INVOKE","DELETE"]
+	}`)
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Notifications+"?token="+token, bytes.NewBuffer(notificationData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	webhookId2 = response.ID
+}
+
+func TestNotificationsHandlerIdGet(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Notifications+"/"+webhookId1+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestNotificationsHandlerGet(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Notifications+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+// countingStore counts the webhook lookups reaching the store it wraps.
+type countingStore struct {
+	db.Store
+	lookups int
+}
+
+func (s *countingStore) GetWebhooksForEvent(UUID, country, event string) ([]structs.WebhookInternal, error) {
+	s.lookups++
+	return s.Store.GetWebhooksForEvent(UUID, country, event)
+}
+
+// TestWebhookCache confirms that webhook routes are served from the cache until webhooks of the user,
+// or developer webhooks reaching every user, are added or deleted.
+func TestWebhookCache(t *testing.T) {
+	backend := &countingStore{Store: db.NewMemoryStore()}
+	cache := db.NewWebhookCacheStore(backend, time.Hour)
+	const user, other = "cache-user", "cache-other"
+
+	// route looks up the webhooks of a user for an event, checking how many there are and whether the store was asked.
+	route := func(UUID string, want int, fromCache bool) {
+		t.Helper()
+		lookups := backend.lookups
+		webhooks, err := cache.GetWebhooksForEvent(UUID, "NO", Webhooks.EventInvoke)
+		if err != nil {
+			t.Fatal("Failed to look up webhooks:", err)
+		}
+		if len(webhooks) != want {
+			t.Errorf("route of %q has %d webhooks, want %d", UUID, len(webhooks), want)
+		}
+		if cached := backend.lookups == lookups; cached != fromCache {
+			t.Errorf("route of %q served from the cache: %t, want %t", UUID, cached, fromCache)
+		}
+	}
+	add := func(ID, UUID string) {
+		t.Helper()
+		hook := &structs.WebhookInternal{ID: ID, UUID: UUID, URL: "https://example.com/" + ID, Event: []string{Webhooks.EventInvoke}}
+		if err := cache.AddWebhook("", ID, hook); err != nil {
+			t.Fatal("Failed to add webhook:", err)
+		}
+	}
+	remove := func(ID, UUID string) {
+		t.Helper()
+		if err := cache.DeleteWebhook("", ID, UUID); err != nil {
+			t.Fatal("Failed to delete webhook:", err)
+		}
+	}
+
+	add("user-hook", user)
+	route(user, 1, false)
+	route(user, 1, true)
+	route(other, 0, false)
+
+	// Webhooks of a user invalidate the routes of that user only.
+	add("user-hook-2", user)
+	route(user, 2, false)
+	route(other, 0, true)
+	remove("user-hook", user)
+	route(user, 1, false)
+	route(other, 0, true)
+
+	// Developer webhooks invalidate every route.
+	add("developer-hook", "")
+	route(user, 2, false)
+	route(other, 1, false)
+	route(other, 1, true)
+	remove("developer-hook", "")
+	route(user, 1, false)
+	route(other, 0, false)
+}
+
+func TestRegistrationsHandlerPost(t *testing.T) {
+	registrationData := []byte(`{
+		"isocode": "us",
+		"features": { 
+			"temperature": true,
+			"coordinates": true
+		   }
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+token, bytes.NewBuffer(registrationData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	var response struct {
+		ID         string `json:"id"`
+		LastChange string `json:"lastChange"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	docId1 = response.ID
+}
+
+func TestRegistrationsHandlerPostMinimal(t *testing.T) {
+	registrationData := []byte(`{
+		"country": "norway",
+		"features": { 
+			"temperature": true
+		}
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+token, bytes.NewBuffer(registrationData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	var response struct {
+		ID         string `json:"id"`
+		LastChange string `json:"lastChange"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	docId2 = response.ID
+}
+
+func TestRegistrationsHandlerGet(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestRegistrationsHandlerGetPaginated(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+token+"&limit=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	link := rr.Header().Get("Link")
+	if !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("handler returned no next link: got %q", link)
+	}
+	if strings.Contains(link, "token") || strings.Contains(link, token) || !strings.Contains(link, "limit=1") {
+		t.Errorf("handler returned a next link without the limit or with the API key: got %q", link)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(middleware.APIKeyHeader, token)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if link := rr.Header().Get("Link"); link != "" {
+		t.Errorf("handler returned a next link on the last page: got %q", link)
+	}
+}
+
+func TestRegistrationsHandlerGetFiltered(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+token+"&isoCode=us&feature=coordinates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response []struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if len(response) != 1 || response[0].ID != docId1 {
+		t.Errorf("handler returned wrong registrations: got %v want [%v]", response, docId1)
+	}
+}
+
+func TestRegistrationsIdHandlerPatch(t *testing.T) {
+	patchData := []byte(`{
+		"features": { 
+			"temperature": true,
+			"precipitation": true,
+			"capital": true,
+			"coordinates": true,
+			"population": true,
+			"area": true,
+			"targetCurrencies": ["jpy", "nok", "eur","gbp"]
+		}
+    }`)
+
+	req, err := http.NewRequest(http.MethodPatch, Endpoints.Registrations+"/"+docId1+"?token="+token, bytes.NewBuffer(patchData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+}
+
+func TestRegistrationsIdHandlerGet(t *testing.T) {
+	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Registrations, docId1, token)
+	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestRegistrationsIdHandlerPatchIfMatch(t *testing.T) {
+	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Registrations, docId1, token)
+	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("handler returned no ETag header")
+	}
+
+	patchData := []byte(`{"features": {"area": false}}`)
+
+	req, err = http.NewRequest(http.MethodPatch, testUrl, bytes.NewBuffer(patchData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etag)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+	if newEtag := rr.Header().Get("ETag"); newEtag == "" || newEtag == etag {
+		t.Errorf("handler returned unchanged ETag: got %q", newEtag)
+	}
+
+	req, err = http.NewRequest(http.MethodPatch, testUrl, bytes.NewBuffer(patchData))
 	if err != nil {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etag) // Now stale.
 
-	rr := httptest.NewRecorder()
+	rr = httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusCreated {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
-	}
-
-	var response struct {
-		ID string `json:"id"`
-	}
-
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
-		t.Fatal("Failed to decode response body:", err)
+	if status := rr.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPreconditionFailed)
 	}
-
-	webhookId1 = response.ID
 }
 
-func TestNotificationsHandlerPost(t *testing.T) {
-	notificationData := []byte(`{
-		"url": "https://google.com/",
-		"country": "",
-		"event": ["INVOKE","DELETE"]
-	}`)
-	req, err := http.NewRequest(http.MethodPost, Endpoints.Notifications+"?token="+token, bytes.NewBuffer(notificationData))
+func TestRegistrationsIdHandlerDeleteStale(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.Registrations+"/"+docId1+"?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"0"`)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusCreated {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	if status := rr.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPreconditionFailed)
 	}
+}
 
-	var response struct {
-		ID string `json:"id"`
+// TestRegistrationsIdHandlerPatchConcurrent confirms that concurrent PATCH requests without If-Match are never
+// refused with 412 Precondition Failed, as they set no precondition.
+func TestRegistrationsIdHandlerPatchConcurrent(t *testing.T) {
+	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Registrations, docId1, token)
+	codes := make(chan int, 10)
+	for i := range cap(codes) {
+		go func() {
+			patchData := []byte(fmt.Sprintf(`{"features": {"area": %t}}`, i%2 == 0))
+			req := httptest.NewRequest(http.MethodPatch, testUrl, bytes.NewBuffer(patchData))
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			codes <- rr.Code
+		}()
+	}
+
+	for range cap(codes) {
+		if status := <-codes; status != http.StatusAccepted && status != http.StatusConflict {
+			t.Errorf("handler returned wrong status code: got %v want %v or %v", status, http.StatusAccepted, http.StatusConflict)
+		}
 	}
+}
 
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
-		t.Fatal("Failed to decode response body:", err)
+// TestRegistrationsIdHandlerDeleteConcurrent confirms that of concurrent DELETE requests for a registration,
+// one deletes it and the others find it gone (404), however far they got before it was deleted.
+func TestRegistrationsIdHandlerDeleteConcurrent(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+token, strings.NewReader(`{"isocode": "se", "features": {"capital": true}}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	var created structs.CountryInfoExternal
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("creating a registration returned %d: %v", rr.Code, err)
+	}
+
+	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Registrations, created.ID, token)
+	codes := make(chan int, 10)
+	for range cap(codes) {
+		go func() {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, testUrl, nil))
+			codes <- rr.Code
+		}()
+	}
+
+	deleted := 0
+	for range cap(codes) {
+		switch status := <-codes; status {
+		case http.StatusNoContent:
+			deleted++
+		case http.StatusNotFound:
+		default:
+			t.Errorf("handler returned wrong status code: got %v want %v or %v", status, http.StatusNoContent, http.StatusNotFound)
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("%d concurrent requests deleted the registration, want 1", deleted)
 	}
 
-	webhookId2 = response.ID
+	// A registration deleted between being retrieved and being deleted is reported as not found.
+	if err := store.DeleteRegistration("", created.ID, UUID, "", nil); !errors.Is(err, db.ErrRegistrationNotFound) {
+		t.Errorf("deleting a deleted registration returned %v, want %v", err, db.ErrRegistrationNotFound)
+	}
 }
 
-func TestNotificationsHandlerIdGet(t *testing.T) {
-	req, err := http.NewRequest(http.MethodGet, Endpoints.Notifications+"/"+webhookId1+"?token="+token, nil)
+func TestDashboardIdHandlerGet(t *testing.T) {
+	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Dashboards, docId1, token)
+	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -291,8 +2310,9 @@ func TestNotificationsHandlerIdGet(t *testing.T) {
 	}
 }
 
-func TestNotificationsHandlerGet(t *testing.T) {
-	req, err := http.NewRequest(http.MethodGet, Endpoints.Notifications+"?token="+token, nil)
+func TestDashboardIdHandlerGetMinimal(t *testing.T) {
+	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Dashboards, docId2, token)
+	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -305,117 +2325,90 @@ func TestNotificationsHandlerGet(t *testing.T) {
 	}
 }
 
-func TestRegistrationsHandlerPost(t *testing.T) {
-	registrationData := []byte(`{
-		"isocode": "us",
-		"features": { 
-			"temperature": true,
-			"coordinates": true
-		   }
-	}`)
-
-	req, err := http.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+token, bytes.NewBuffer(registrationData))
+func TestRegistrationsIdHandlerDeleteMinimal(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.Registrations+"/"+docId2+"?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusCreated {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
-	}
-
-	var response struct {
-		ID         string `json:"id"`
-		LastChange string `json:"lastChange"`
-	}
-
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
-		t.Fatal("Failed to decode response body:", err)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
 	}
-
-	docId1 = response.ID
 }
 
-func TestRegistrationsHandlerPostMinimal(t *testing.T) {
-	registrationData := []byte(`{
-		"country": "norway",
-		"features": { 
-			"temperature": true
-		}
-	}`)
-
-	req, err := http.NewRequest(http.MethodPost, Endpoints.Registrations+"?token="+token, bytes.NewBuffer(registrationData))
+func TestRegistrationsHistoryRestoreDeleted(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"/"+docId2+"/history?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusCreated {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
-	var response struct {
-		ID         string `json:"id"`
-		LastChange string `json:"lastChange"`
+	var history []struct {
+		ID     string `json:"id"`
+		Event  string `json:"event"`
+		Caller string `json:"caller"`
 	}
-
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+	if err := json.NewDecoder(rr.Body).Decode(&history); err != nil {
 		t.Fatal("Failed to decode response body:", err)
 	}
-
-	docId2 = response.ID
-}
-
-func TestRegistrationsHandlerGet(t *testing.T) {
-	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+token, nil)
-	if err != nil {
-		t.Fatal(err)
+	if len(history) != 2 || history[0].Event != "DELETE" || history[1].Event != "REGISTER" {
+		t.Fatalf("handler returned wrong history: got %v", history)
 	}
-
-	rr := httptest.NewRecorder()
-	mux.ServeHTTP(rr, req)
-
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	for _, rev := range history {
+		if rev.Caller != apikey.Identifier(token) {
+			t.Errorf("handler returned wrong caller: got %q want the API key identifier %q", rev.Caller, apikey.Identifier(token))
+		}
 	}
-}
 
-func TestRegistrationsIdHandlerPatch(t *testing.T) {
-	patchData := []byte(`{
-		"features": { 
-			"temperature": true,
-			"precipitation": true,
-			"capital": true,
-			"coordinates": true,
-			"population": true,
-			"area": true,
-			"targetCurrencies": ["jpy", "nok", "eur","gbp"]
+	// Concurrent restores recreate the registration once; the others reapply the revision to it or conflict.
+	testUrl := Endpoints.Registrations + "/" + docId2 + "/history/" + history[0].ID + "/restore?token=" + token
+	codes := make(chan int, 5)
+	for range cap(codes) {
+		go func() {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, testUrl, nil))
+			codes <- rr.Code
+		}()
+	}
+
+	created := 0
+	for range cap(codes) {
+		switch status := <-codes; status {
+		case http.StatusCreated:
+			created++
+		case http.StatusOK, http.StatusConflict:
+		default:
+			t.Errorf("handler returned wrong status code: got %v want %v, %v or %v", status, http.StatusCreated, http.StatusOK, http.StatusConflict)
 		}
-    }`)
+	}
+	if created != 1 {
+		t.Errorf("handler recreated the registration %d times, want once", created)
+	}
 
-	req, err := http.NewRequest(http.MethodPatch, Endpoints.Registrations+"/"+docId1+"?token="+token, bytes.NewBuffer(patchData))
+	req, err = http.NewRequest(http.MethodDelete, Endpoints.Registrations+"/"+docId2+"?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	rr := httptest.NewRecorder()
+	rr = httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusAccepted {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
 	}
 }
 
-func TestRegistrationsIdHandlerGet(t *testing.T) {
-	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Registrations, docId1, token)
-	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+func TestUserExportImportDryRun(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.UserExport+"?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -424,18 +2417,31 @@ func TestRegistrationsIdHandlerGet(t *testing.T) {
 	mux.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), token) {
+		t.Fatal("export contains the API key")
 	}
-}
 
-func TestDashboardIdHandlerGet(t *testing.T) {
-	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Dashboards, docId1, token)
-	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+	archive := rr.Body.Bytes()
+	var response struct {
+		Version       int               `json:"version"`
+		Registrations []json.RawMessage `json:"registrations"`
+		APIKeys       []json.RawMessage `json:"apiKeys"`
+	}
+	if err := json.Unmarshal(archive, &response); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+	if response.Version != 1 || len(response.Registrations) == 0 || len(response.APIKeys) != 1 {
+		t.Fatalf("handler returned wrong archive: got %s", archive)
+	}
+
+	req, err = http.NewRequest(http.MethodPost, Endpoints.UserImport+"?dryRun=true&token="+token, bytes.NewReader(archive))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
+	rr = httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -443,9 +2449,9 @@ func TestDashboardIdHandlerGet(t *testing.T) {
 	}
 }
 
-func TestDashboardIdHandlerGetMinimal(t *testing.T) {
-	testUrl := fmt.Sprintf("%s/%s?token=%s", Endpoints.Dashboards, docId2, token)
-	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+func TestUserImportInvalid(t *testing.T) {
+	archive := []byte(`{"version": 1, "registrations": [{"isoCode": "XX", "features": {"capital": true}}]}`)
+	req, err := http.NewRequest(http.MethodPost, Endpoints.UserImport+"?token="+token, bytes.NewReader(archive))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -453,13 +2459,14 @@ func TestDashboardIdHandlerGetMinimal(t *testing.T) {
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 }
 
-func TestRegistrationsIdHandlerDeleteMinimal(t *testing.T) {
-	req, err := http.NewRequest(http.MethodDelete, Endpoints.Registrations+"/"+docId2+"?token="+token, nil)
+func TestUserImportTooLarge(t *testing.T) {
+	archive := `{"version": 1, "padding": "` + strings.Repeat("a", constants.MaxArchiveSize) + `"}`
+	req, err := http.NewRequest(http.MethodPost, Endpoints.UserImport+"?token="+token, strings.NewReader(archive))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -467,20 +2474,21 @@ func TestRegistrationsIdHandlerDeleteMinimal(t *testing.T) {
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusNoContent {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
 	}
 }
 
 /* Run test with wrong token */
 
 func TestDeleteAPIKeyHandlerWrongToken(t *testing.T) {
-	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey+"?token="+wrongToken, nil)
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req.Header.Add("Authorization", UUID)
+	req.Header.Add("Authorization", "Bearer "+session)
+	req.Header.Add(middleware.APIKeyHeader, wrongToken)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
@@ -663,7 +2671,7 @@ func TestDeleteAPIKeyHandlerNoToken(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	req.Header.Add("Authorization", UUID)
+	req.Header.Add("Authorization", "Bearer "+session)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
@@ -1223,12 +3231,13 @@ func TestRegisterHandlerRegisterBadLongPasswordUppercaseNumber(t *testing.T) {
 /* Empty POST/PATCH Body */
 
 func TestDeleteAPIKeyHandlerEmpty(t *testing.T) {
-	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey+"?token="+token, nil)
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	req.Header.Add("Authorization", "")
+	req.Header.Add(middleware.APIKeyHeader, token)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
@@ -1315,6 +3324,34 @@ func TestRegistrationsIdHandlerEmptyPatch(t *testing.T) {
 
 /* Wrong POST/PATCH BODY */
 
+func TestRegistrationsGetBadLimit(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Registrations+"?token="+token+"&limit=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestNotificationsGetBadCursor(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, Endpoints.Notifications+"?token="+token+"&cursor=not-a-cursor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
 func TestRegistrationsIdHandlerPostNoFeatures(t *testing.T) {
 	patchData := []byte(`{
 		"country": "Sweden",
@@ -1599,7 +3636,7 @@ func TestRegisterHandlerDeleteWhitespaceUUID(t *testing.T) {
 }
 
 func TestRegisterHandlerDeleteWrongUUID(t *testing.T) {
-	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/NTNU2024", nil)
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/NTNU2024?token="+token, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1607,8 +3644,36 @@ func TestRegisterHandlerDeleteWrongUUID(t *testing.T) {
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+}
+
+func TestRegisterHandlerDeleteNoCredentials(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+UUID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRegisterHandlerDeleteWrongToken(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+UUID+"?token="+wrongToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotAcceptable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotAcceptable)
 	}
 }
 
@@ -2055,12 +4120,13 @@ func TestNotificationsHandlerDelete(t *testing.T) {
 }
 
 func TestDeleteAPIKey(t *testing.T) {
-	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey+"?token="+token, nil)
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.ApiKey, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req.Header.Add("Authorization", UUID)
+	req.Header.Add("Authorization", "Bearer "+session)
+	req.Header.Add(middleware.APIKeyHeader, token)
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
@@ -2071,15 +4137,92 @@ func TestDeleteAPIKey(t *testing.T) {
 }
 
 func TestRegisterHandlerDelete(t *testing.T) {
-	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+UUID, nil)
+	TestGetAPIKeyHandler(t) // The previous key was deleted; deleting the user requires proof of identity.
+
+	req, err := http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+UUID+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+
+	if owner := store.GetAPIKeyUUID("test", token); owner != "" {
+		t.Errorf("user deletion left API key behind for user: %v", owner)
+	}
+}
+
+func TestUserDeletionRetry(t *testing.T) {
+	form := url.Values{"username": {DisplayName}, "email": {"retry." + Email}, "password": {Password}}
+	req, err := http.NewRequest(http.MethodPost, Endpoints.UserRegistration, strings.NewReader(form.Encode()))
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
 	mux.ServeHTTP(rr, req)
 
+	var registered struct {
+		Token  string `json:"token"`
+		UserID string `json:"userid"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&registered); err != nil {
+		t.Fatal("Failed to decode response body:", err)
+	}
+
+	// An earlier attempt deleted the user, but failed to delete their data; repeating it finishes the deletion.
+	if err := users.DeleteUser(context.Background(), registered.UserID); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest(http.MethodDelete, Endpoints.UserDeletion+"/"+registered.UserID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(middleware.APIKeyHeader, registered.Token)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
 	if status := rr.Code; status != http.StatusNoContent {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
 	}
+	if owner := store.GetAPIKeyUUID("test", registered.Token); owner != "" {
+		t.Errorf("user deletion left API key behind for user: %v", owner)
+	}
+}
+
+func TestAdminCleanup(t *testing.T) {
+	if err := store.DeleteUserData("test", adminUUID); err != nil {
+		t.Errorf("error deleting the administrator's data: %v", err)
+	}
+	if err := users.DeleteUser(context.Background(), adminUUID); err != nil {
+		t.Errorf("error deleting the administrator: %v", err)
+	}
+}
+
+// TestSQLiteBackend runs the integration suite again against the SQL backend, in a temporary SQLite database,
+// when the suite runs against the in-memory store, so that both are covered without a database server.
+func TestSQLiteBackend(t *testing.T) {
+	if testing.Short() || config.String("STORAGE_BACKEND", db.BackendMemory) != db.BackendMemory {
+		t.Skip("the integration suite runs against the SQL backend only after running against the in-memory store")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.count=1", "-test.skip=^TestSQLiteBackend$")
+	cmd.Env = append(os.Environ(), "STORAGE_BACKEND="+db.BackendSQLite, "DATABASE_URL="+filepath.Join(t.TempDir(), "globeboard.db"))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		var failures []string // Report the failures only, as the suite logs every request.
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "--- FAIL") || strings.Contains(line, "_test.go:") || strings.HasPrefix(line, "panic:") {
+				failures = append(failures, line)
+			}
+		}
+		t.Fatalf("integration suite failed against %s: %v\n%s", db.BackendSQLite, err, strings.Join(failures, "\n"))
+	}
 }
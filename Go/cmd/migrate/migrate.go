@@ -0,0 +1,115 @@
+// Package main is the entry point for the migration runner, which upgrades stored data to the current schema.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"globeboard/db"
+	"globeboard/internal/utils/config"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// usage describes how to invoke the migration runner.
+const usage = `Usage: migrate [flags] <command>
+
+Commands:
+  status   Report the migrations still pending against the store.
+  run      Apply every pending migration (use -dry-run to only report what would be applied).
+
+The store is selected by $STORAGE_BACKEND, $DATABASE_URL, $FIRESTORE_PROJECT_ID,
+$FIREBASE_CREDENTIALS_FILE and $API_KEY_SECRET, as for the server.
+
+Flags:
+`
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "Report what 'run' would apply without writing anything.")
+	asJSON := flag.Bool("json", false, "Print the report as JSON.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Open the store without migrating it, so that pending schema migrations can be reported and dry-run.
+	backend := config.String("STORAGE_BACKEND", db.BackendFirestore)
+	store, err := db.Open(db.Options{
+		Backend:                 backend,
+		DSN:                     config.String("DATABASE_URL", "./globeboard.db"),
+		FirestoreProjectID:      os.Getenv("FIRESTORE_PROJECT_ID"),
+		FirebaseCredentialsFile: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		APIKeySecret:            os.Getenv("API_KEY_SECRET"),
+		SkipMigrations:          true,
+	})
+	if err != nil {
+		log.Fatalf("Storage backend %q was unable to initialize: %v", backend, err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing storage backend: %v", err)
+		}
+	}()
+
+	var report []db.MigrationStatus
+	switch flag.Arg(0) {
+	case "status":
+		report, err = store.PendingMigrations()
+	case "run":
+		report, err = store.Migrate(*dryRun)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	if *asJSON {
+		if report == nil {
+			report = []db.MigrationStatus{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Error encoding report: %v", err)
+		}
+		return
+	}
+	printReport(flag.Arg(0), *dryRun, report)
+}
+
+// printReport writes the migration report as a table.
+func printReport(command string, dryRun bool, report []db.MigrationStatus) {
+	switch {
+	case len(report) == 0 && command == "run" && !dryRun:
+		fmt.Printf("Nothing to migrate; the store is at schema version %d.\n", db.SchemaVersion)
+		return
+	case len(report) == 0:
+		fmt.Printf("No pending migrations; the store is at schema version %d.\n", db.SchemaVersion)
+		return
+	case command == "status":
+		fmt.Println("Pending migrations:")
+	case dryRun:
+		fmt.Println("Migrations that would be applied (dry-run, nothing was written):")
+	default:
+		fmt.Println("Applied migrations:")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KIND\tVERSION\tDOCUMENTS\tDESCRIPTION")
+	for _, status := range report {
+		documents := fmt.Sprint(status.Documents)
+		if status.Kind == db.KindSchema {
+			documents = "-" // Schema migrations change tables, not documents.
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", status.Kind, status.Version, documents, status.Description)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,48 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"globeboard/apikey"
+	"globeboard/internal/utils/structs"
+	"time"
+)
+
+var (
+	apiKeySecret []byte // Secret keying the hash of stored API keys, set by Open.
+)
+
+// apiKeyUsageResolution is how often the last-used time of an API key is updated, to spare a write per request.
+const apiKeyUsageResolution = time.Minute
+
+// HashAPIKey returns the keyed hash under which an API key is stored and looked up.
+func HashAPIKey(key string) string {
+	mac := hmac.New(sha256.New, apiKeySecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// APIKeyPrefix returns the part of an API key that may be stored, logged and shown to identify it.
+func APIKeyPrefix(key string) string {
+	return apikey.Identifier(key)
+}
+
+// apiKeyExpired reports whether an API key is no longer accepted at 'now'.
+func apiKeyExpired(key structs.APIKey, now time.Time) bool {
+	return key.Expires != nil && !now.Before(*key.Expires)
+}
+
+// apiKeyUsageStale reports whether the last-used time of an API key should be updated at 'now'.
+func apiKeyUsageStale(key structs.APIKey, now time.Time) bool {
+	return key.LastUsed == nil || now.Sub(*key.LastUsed) >= apiKeyUsageResolution
+}
+
+// apiKeyOwner returns the UUID of the user owning an API key, or an empty string if there is no key.
+func apiKeyOwner(key *structs.APIKey) string {
+	if key == nil {
+		return ""
+	}
+	return key.UUID
+}
@@ -1,21 +1,13 @@
-// Package db provides data access functions for interacting with Firestore.
+// Package db provides data access functions for interacting with the application's storage backends.
 package db
 
 import (
-	"cloud.google.com/go/firestore"
 	"context"
 	"errors"
 	"fmt"
-	authenticate "globeboard/auth"
-	"globeboard/internal/utils/constants/Firestore"
 	"globeboard/internal/utils/structs"
-	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"log"
-	"net/http"
-	"os"
+	"time"
 )
 
 const (
@@ -23,420 +15,210 @@ const (
 	IterationFailed = "failed to iterate over query results: %v\n"
 )
 
-var (
-	ctx    = context.Background() // Global context for Firestore operations, used across all Firestore calls.
-	Client *firestore.Client      // Singleton Firestore client.
-	err    error                  // Variable to handle errors globally within this package.
+// Storage backends selectable through Open.
+const (
+	BackendFirestore = "firestore" // BackendFirestore stores data in Google Cloud Firestore.
+	BackendSQLite    = "sqlite"    // BackendSQLite stores data in a local SQLite database file.
+	BackendPostgres  = "postgres"  // BackendPostgres stores data in a PostgreSQL database.
+	BackendMemory    = "memory"    // BackendMemory keeps data in process memory; data is lost on restart.
 )
 
-// init initializes the Firestore client using environment variables for credentials and project ID.
-func init() {
-	sa := option.WithCredentialsFile(os.Getenv("FIREBASE_CREDENTIALS_FILE"))      // Set up the credential file from environment.
-	Client, err = firestore.NewClient(ctx, os.Getenv("FIRESTORE_PROJECT_ID"), sa) // Create a new Firestore client.
-	if err != nil {
-		log.Panic("Firestore was unable to initialize: ", err) // Panic if Firestore client initialization fails.
-	}
-}
-
-// TestDBConnection tests the Firestore database connection by attempting to write and immediately read a document.
-func TestDBConnection() string {
-	collectionID := "Connectivity"     // Define the collection ID for connection tests.
-	documentID := "DB_Connection_Test" // Define the document ID for connection tests.
-
-	// Attempt to set a document in the Firestore collection, tagging it with server timestamp.
-	_, err = Client.Collection(collectionID).Doc(documentID).Set(ctx, map[string]interface{}{
-		"PSA":         "DO NOT DELETE THIS DOCUMENT!",
-		"lastChecked": firestore.ServerTimestamp,
-	}, firestore.MergeAll)
-
-	// Handle potential errors and map them to HTTP status codes.
-	grpcStatusCode := status.Code(err)
-	switch grpcStatusCode {
-	case codes.OK:
-		return fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
-	case codes.Canceled:
-		return fmt.Sprintf("%d %s", http.StatusRequestTimeout, http.StatusText(http.StatusRequestTimeout))
-	case codes.DeadlineExceeded:
-		return fmt.Sprintf("%d %s", http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout))
-	case codes.PermissionDenied:
-		return fmt.Sprintf("%d %s", http.StatusForbidden, http.StatusText(http.StatusForbidden))
-	case codes.NotFound:
-		// Treat not found as OK for this operation; it indicates collection/document was simply not found.
-		// Another error in-of-itself.
-		return fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
-	case codes.ResourceExhausted:
-		return fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
-	case codes.Unauthenticated:
-		return fmt.Sprintf("%d %s", http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
-	case codes.Unavailable:
-		return fmt.Sprintf("%d %s", http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
-	case codes.Unknown, codes.Internal:
-		return fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-	default:
-		return fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-	}
-}
-
-// AddApiKey adds a new API key to Firestore, ensuring it does not already exist for the provided user (UUID).
-func AddApiKey(IP, docID, UUID string, key string) error {
-	ref := Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
-
-	// Query for existing API keys with the same UUID.
-	iter := ref.Where("UUID", "==", UUID).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	for {
-		_, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		err = errors.New("API key is already registered to user")
-		return err // Return error if an existing key is found.
-	}
-
-	apiKeys := structs.APIKey{UUID: UUID, APIKey: key} // Create an APIKey struct to be saved.
+var (
+	// ErrUserNotFound is returned when no user has the given UUID or email.
+	ErrUserNotFound = errors.New("no user was found")
+	// ErrEmailTaken is returned when adding a user whose email another user already has.
+	ErrEmailTaken = errors.New("a user with that email already exists")
+)
 
-	_, err = ref.Doc(docID).Set(ctx, apiKeys) // Set the APIKey document in Firestore.
-	if err != nil {
-		err := fmt.Errorf("error saving API key to Database: %v", err)
-		return err // Return formatted error if setting the document fails.
-	}
+var (
+	ctx = context.Background() // Global context for storage operations, used across all storage calls.
+)
 
-	log.Printf("%s: API key %s created successfully.", IP, apiKeys.APIKey) // Log success.
-	return nil                                                             // Return nil error on success.
+// APIKeyStore defines the storage operations for API keys.
+type APIKeyStore interface {
+	// AddApiKey stores the metadata of a new API key along with the hash of the key itself, stamping its creation time.
+	AddApiKey(IP, docID string, apiKey *structs.APIKey, key string) error
+	// DeleteApiKey deletes an API key based on UUID and key value.
+	DeleteApiKey(IP, UUID, apiKey string) error
+	// DeleteApiKeyByID deletes an API key based on its ID and UUID.
+	DeleteApiKeyByID(IP, ID, UUID string) error
+	// ExpireApiKey sets the time at which an API key, identified by its ID and UUID, stops being accepted.
+	ExpireApiKey(IP, ID, UUID string, expires time.Time) error
+	// GetAPIKey retrieves a specific, unexpired API key, or nil if none is found.
+	// The key's last-used time is updated as a side effect.
+	GetAPIKey(IP, apiKey string) *structs.APIKey
+	// GetAPIKeyUUID retrieves the UUID associated with a specific, unexpired API key, or an empty string if none
+	// is found. The key's last-used time is updated as a side effect.
+	GetAPIKeyUUID(IP, apiKey string) string
+	// GetAPIKeys retrieves all API keys belonging to a given user (UUID), expired ones included.
+	GetAPIKeys(IP, UUID string) ([]structs.APIKey, error)
 }
 
-// DeleteApiKey deletes an API key from Firestore based on UUID and key value.
-func DeleteApiKey(IP, UUID, apiKey string) error {
-	ref := Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
-
-	// Query for the API key document based on UUID and key.
-	iter := ref.Where("UUID", "==", UUID).Where("APIKey", "==", apiKey).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	var docID string // Variable to store the document ID of the found API key.
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		docID = doc.Ref.ID // Store the document ID.
-	}
-
-	if docID == "" {
-		return errors.New("API key not found") // Return error if no document ID was found.
-	} // Handle if the API key was not found.
-
-	_, err = ref.Doc(docID).Delete(ctx) // Delete the document from Firestore.
-	if err != nil {
-		return fmt.Errorf("failed to delete API Key: %v", err) // Return formatted error if delete fails.
-	}
-
-	log.Printf("%s: API key %s deleted successfully.", IP, apiKey) // Log success.
-	return nil                                                     // Return nil error on success.
+// RegistrationStore defines the storage operations for country registrations.
+type RegistrationStore interface {
+	// AddRegistration adds a new registration document, writing the revision recording it along with it.
+	AddRegistration(IP, docID string, data *structs.CountryInfoInternal, revision RevisionOf) error
+	// GetRegistrations retrieves all registration documents for a given user (UUID), newest change first.
+	GetRegistrations(IP, UUID string) ([]*structs.CountryInfoInternal, error)
+	// ListRegistrations retrieves one page of a user's registrations matching the query,
+	// along with the cursor for the next page (empty when there are no more results).
+	ListRegistrations(IP, UUID string, query RegistrationQuery) ([]*structs.CountryInfoInternal, string, error)
+	// GetSpecificRegistration retrieves a specific registration document by ID and UUID.
+	GetSpecificRegistration(IP, ID, UUID string) (*structs.CountryInfoInternal, error)
+	// PatchRegistration atomically applies 'patch' to a specific registration document by ID and UUID.
+	// A non-empty 'ifMatch' must match the registration's current ETag, otherwise ErrPreconditionFailed is returned.
+	// Without one, ErrConflict is returned if concurrent writers keep the patch from being applied.
+	// The revision recording the change is written along with it.
+	PatchRegistration(IP, ID, UUID, ifMatch string, patch RegistrationPatch, revision RevisionOf) (*structs.CountryInfoInternal, error)
+	// RestoreRegistration adds a deleted registration back under its original ID, along with the revision recording
+	// it, only if no registration has that ID, returning ErrRegistrationExists otherwise. It is stored under its ID as
+	// document ID, so that concurrent restores of one registration conflict rather than duplicate it.
+	RestoreRegistration(IP string, data *structs.CountryInfoInternal, revision RevisionOf) error
+	// DeleteRegistration deletes a specific registration document by ID and UUID, returning ErrRegistrationNotFound
+	// if there is none. A non-empty 'ifMatch' must match the registration's current ETag, otherwise ErrPreconditionFailed is returned.
+	// Without one, ErrConflict is returned if concurrent writers keep the deletion from being applied.
+	// The revision recording the deletion is written along with it.
+	DeleteRegistration(IP, ID, UUID, ifMatch string, revision RevisionOf) error
 }
 
-// GetAPIKeyUUID retrieves the UUID associated with a specific API key from Firestore.
-func GetAPIKeyUUID(IP, apiKey string) string {
-	ref := Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
-
-	// Query for the API key document based on the key value.
-	iter := ref.Where("APIKey", "==", apiKey).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	var key structs.APIKey // Variable to store the API key data.
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			log.Printf(IterationFailed, err)
-			return "" // Return an empty string on error.
-		}
-		if err := doc.DataTo(&key); err != nil {
-			log.Println("Error parsing document:", err)
-			return "" // Return an empty string on parsing error.
-		}
-	}
-
-	_, err = authenticate.Client.GetUser(ctx, key.UUID) // Authenticate the user based on UUID.
-	if err != nil {
-		log.Println("Error getting user:", err)
-		return "" // Return an empty string if user authentication fails.
-	} else {
-		log.Printf("%s: UUID: %s successfully retrieved from API key: %s.", IP, key.UUID, key.APIKey)
-		return key.UUID // Return the UUID on success.
-	}
+// RevisionOf builds the revision recording a change of a registration from 'before' to 'after', along with the ID
+// of the document to store it under, for the store to write in the same transaction as the change. A nil 'before'
+// describes a new registration and a nil 'after' a deleted one. It may be called more than once when a backend
+// retries a conflicting transaction. A nil RevisionOf records no revision.
+type RevisionOf func(before, after *structs.CountryInfoInternal) (docID string, revision *structs.RevisionInternal)
+
+// RevisionStore defines the storage operations for registration revisions. Revisions are only written along with
+// the change they record, in its transaction, as built by a RevisionOf.
+type RevisionStore interface {
+	// GetRevisions retrieves all revisions of a registration by ID and UUID, newest first.
+	GetRevisions(IP, ID, UUID string) ([]structs.RevisionInternal, error)
+	// GetSpecificRevision retrieves a specific revision of a registration by revision ID, registration ID and UUID.
+	GetSpecificRevision(IP, revisionID, ID, UUID string) (*structs.RevisionInternal, error)
 }
 
-// AddRegistration adds a new registration document to Firestore.
-func AddRegistration(IP, docID string, data *structs.CountryInfoInternal) error {
-	ref := Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
-
-	// Set the registration document in Firestore with the given ID and data.
-	_, err = ref.Doc(docID).Set(ctx, map[string]interface{}{
-		"ID":         data.ID,
-		"UUID":       data.UUID,
-		"Country":    data.Country,
-		"IsoCode":    data.IsoCode,
-		"Features":   data.Features,
-		"Lastchange": firestore.ServerTimestamp, // Use server timestamp to record last change.
-	})
-	if err != nil {
-		return err // Return error if the document set operation fails.
-	}
-
-	log.Printf("%s: Registration documents %s created successfully.", IP, data.ID)
-	return nil // Return nil if the addition is successful.
+// WebhookStore defines the storage operations for webhooks.
+type WebhookStore interface {
+	// AddWebhook creates a new webhook entry.
+	AddWebhook(IP, docID string, webhook *structs.WebhookInternal) error
+	// GetWebhooksForEvent retrieves the webhooks to notify of an event: those owned by the user (UUID) or the
+	// developer (empty UUID), registered for the country or for every country, and subscribed to the event.
+	GetWebhooksForEvent(UUID, country, event string) ([]structs.WebhookInternal, error)
+	// GetWebhooksUser retrieves all webhook entries for a specific user (UUID).
+	GetWebhooksUser(IP, UUID string) ([]structs.WebhookResponse, error)
+	// ListWebhooks retrieves one page of a user's webhooks matching the query,
+	// along with the cursor for the next page (empty when there are no more results).
+	ListWebhooks(IP, UUID string, query WebhookQuery) ([]structs.WebhookResponse, string, error)
+	// GetSpecificWebhook retrieves a specific webhook entry by ID and UUID.
+	GetSpecificWebhook(IP, ID, UUID string) (*structs.WebhookResponse, error)
+	// DeleteWebhook deletes a specific webhook entry by ID and UUID.
+	DeleteWebhook(IP, ID, UUID string) error
 }
 
-// GetRegistrations retrieves all registration documents for a given user (UUID) from Firestore.
-func GetRegistrations(IP, UUID string) ([]*structs.CountryInfoInternal, error) {
-	ref := Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
-
-	// Query and retrieve all documents where 'UUID' matches, ordered by 'Lastchange' descending.
-	docs, err := ref.Where("UUID", "==", UUID).OrderBy("Lastchange", firestore.Desc).Documents(ctx).GetAll()
-	if err != nil {
-		return nil, err // Return error if the fetch operation fails.
-	}
-
-	var cis []*structs.CountryInfoInternal // Slice to store the fetched documents.
-
-	for _, doc := range docs {
-		var ci *structs.CountryInfoInternal
-		if err := doc.DataTo(&ci); err != nil {
-			return nil, err // Return error if parsing any document fails.
-		}
-		cis = append(cis, ci) // Append the parsed document to the slice.
-	}
-	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
-	return cis, nil // Return the slice of documents.
+// UserStore defines the storage operations for users of the built-in identity provider.
+type UserStore interface {
+	// AddUser stores a new user, stamping their creation time. It fails with ErrEmailTaken if another user
+	// has the same email.
+	AddUser(user *structs.User) error
+	// GetUser retrieves a user by UUID, or ErrUserNotFound.
+	GetUser(UUID string) (*structs.User, error)
+	// GetUserByEmail retrieves a user by email, or ErrUserNotFound.
+	GetUserByEmail(email string) (*structs.User, error)
+	// GetUsers retrieves every user.
+	GetUsers() ([]structs.User, error)
+	// DeleteUser deletes a user by UUID, or returns ErrUserNotFound.
+	DeleteUser(UUID string) error
 }
 
-// GetSpecificRegistration retrieves a specific registration document by ID and UUID from Firestore.
-func GetSpecificRegistration(IP, ID, UUID string) (*structs.CountryInfoInternal, error) {
-	ref := Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
-
-	// Query for the specific document with the given 'ID' and 'UUID'.
-	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	var ci *structs.CountryInfoInternal // Variable to store the fetched document.
-
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		if err := doc.DataTo(&ci); err != nil {
-			return nil, err // Return error if parsing the document fails.
-		}
-		log.Printf("%s: Registration document %s retrieved successfully.", IP, ci.ID)
-		return ci, nil // Return the parsed document.
-	}
-
-	return nil, errors.New("no registration with that ID was found") // Return error if no document is found.
+// AdminStore defines the storage operations behind the administrative API.
+type AdminStore interface {
+	// CountUserData counts the API keys, registrations and webhooks owned by each user (UUID).
+	// Developer webhooks (empty UUID) are left out.
+	CountUserData() (map[string]structs.UserCounts, error)
+	// DisableUser disables the account of a user (UUID), so that their API keys and sessions are refused.
+	DisableUser(IP, UUID string) error
+	// EnableUser re-enables the account of a disabled user (UUID).
+	EnableUser(IP, UUID string) error
+	// IsUserDisabled reports whether the account of a user (UUID) is disabled.
+	IsUserDisabled(UUID string) (bool, error)
+	// GetDisabledUsers retrieves every disabled user (UUID) along with the time they were disabled.
+	GetDisabledUsers() (map[string]time.Time, error)
+	// AddAuditEntry records an administrative action, stamping its time.
+	AddAuditEntry(entry *structs.AuditEntry) error
+	// GetAuditEntries retrieves the recorded administrative actions matching the query, newest first.
+	GetAuditEntries(query AuditQuery) ([]structs.AuditEntry, error)
 }
 
-// UpdateRegistration updates a specific registration document by ID and UUID in Firestore.
-func UpdateRegistration(IP, ID, UUID string, data *structs.CountryInfoInternal) error {
-	ref := Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
-
-	// Query for the specific document to update.
-	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		// Update the document with the provided data.
-		_, err = ref.Doc(doc.Ref.ID).Set(ctx, map[string]interface{}{
-			"ID":         data.ID,
-			"UUID":       data.UUID,
-			"Country":    data.Country,
-			"IsoCode":    data.IsoCode,
-			"Features":   data.Features,
-			"Lastchange": firestore.ServerTimestamp, // Use server timestamp to update 'Lastchange'.
-		})
-		if err != nil {
-			return err // Return error if the document update operation fails.
-		}
-		log.Printf("%s: Registration document %s patched successfully.", IP, doc.Ref.ID)
-		return nil // Return nil error if the update is successful.
-	}
-
-	return errors.New("no registration with that ID was found") // Return error if no document is found.
+// UsageStore defines the storage operations for metered API usage.
+type UsageStore interface {
+	// AddUsage adds the given requests to the stored usage counters, matched by day, API key, endpoint and status.
+	// Should it fail, it returns how many of the counters, in order, were added before the failure, so that only
+	// the rest are added again.
+	AddUsage(usage []structs.Usage) (int, error)
+	// GetUsage retrieves the usage counters matching the query, ordered by day, API key, endpoint and status.
+	GetUsage(query UsageQuery) ([]structs.Usage, error)
 }
 
-// DeleteRegistration deletes a specific registration document by ID and UUID from Firestore.
-func DeleteRegistration(IP, ID, UUID string) error {
-	ref := Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
-
-	// Query for the specific document to delete.
-	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	var docID string // Variable to store the document ID of the found registration.
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		docID = doc.Ref.ID // Store the document ID.
-	}
-
-	if docID == "" {
-		return fmt.Errorf("ID match was not found") // Return error if no document ID was found.
-	}
-
-	_, err = ref.Doc(docID).Delete(ctx) // Delete the document from Firestore.
-	if err != nil {
-		return fmt.Errorf("failed to delete document: %v", err) // Return formatted error if delete fails.
-	}
-
-	log.Printf("%s: Registration document %s deleted successfully\n", IP, docID)
-	return nil // Return nil if the deletion is successful.
+// Store is the complete storage backend used by the handlers.
+type Store interface {
+	APIKeyStore
+	RegistrationStore
+	RevisionStore
+	WebhookStore
+	UserStore
+	AdminStore
+	UsageStore
+	Migrator
+
+	// DeleteUserData deletes every API key, registration, revision, webhook and usage counter owned by a user (UUID),
+	// and re-enables their account if it was disabled. Their audit trail is kept. Backends that cannot delete it all
+	// at once leave what they did delete deleted on failure, so that calling it again finishes the deletion.
+	DeleteUserData(IP, UUID string) error
+	// TestDBConnection tests the connection to the storage backend and returns an HTTP status line.
+	TestDBConnection() string
+	// Close releases any resources held by the storage backend.
+	Close() error
 }
 
-// AddWebhook creates a new webhook entry in Firestore.
-func AddWebhook(IP, docID string, webhook *structs.WebhookInternal) error {
-	ref := Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection in Firestore.
-
-	// Set the webhook document with the provided ID and data.
-	_, err = ref.Doc(docID).Set(ctx, webhook)
-	if err != nil {
-		return err // Return error if addition fails.
-	}
-
-	log.Printf("%s: Webhook %s created successfully.", IP, webhook.ID) // Log success.
-	return nil                                                         // Return nil error on successful addition.
+// Options describes which storage backend to open and how to connect to it.
+type Options struct {
+	Backend                 string        // One of the Backend* constants.
+	DSN                     string        // SQLite file path or PostgreSQL connection string.
+	FirestoreProjectID      string        // Firebase project ID, used by the Firestore backend.
+	FirebaseCredentialsFile string        // Path to the Firebase credentials file, used by the Firestore backend.
+	WebhookCacheTTL         time.Duration // How long webhook routes are cached; zero disables the cache.
+	SkipMigrations          bool          // Leave the SQL schema as it is instead of migrating it on open.
+	APIKeySecret            string        // Secret keying the hash under which API keys are stored.
 }
 
-// GetAllWebhooks retrieves all webhook entries from Firestore.
-func GetAllWebhooks() ([]structs.WebhookInternal, error) {
-	ref := Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
-
-	// Retrieve all documents from the webhook collection.
-	docs, err := ref.Documents(ctx).GetAll()
-	if err != nil {
-		return nil, err // Return the error if the fetch operation fails.
-	}
-
-	var webhooks []structs.WebhookInternal // Slice to store the fetched webhook documents.
-
-	for _, doc := range docs {
-		var webhook structs.WebhookInternal
-		if err := doc.DataTo(&webhook); err != nil {
-			return nil, err // Return error if parsing any document fails.
-		}
-		webhooks = append(webhooks, webhook) // Append the parsed document to the slice.
+// Open creates the Store selected by the given options.
+func Open(opts Options) (Store, error) {
+	if opts.APIKeySecret == "" {
+		log.Println("No API key secret has been set; API keys are hashed without a secret.")
 	}
+	apiKeySecret = []byte(opts.APIKeySecret) // Set before opening, as SQL migrations hash legacy keys.
 
-	log.Printf("All Webhooks retrieved successfully.") // Log success.
-	return webhooks, nil                               // Return the slice of webhook documents.
-}
-
-// GetWebhooksUser retrieves all webhook entries for a specific user (UUID) from Firestore.
-func GetWebhooksUser(IP, UUID string) ([]structs.WebhookResponse, error) {
-	ref := Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
-
-	// Query and retrieve all documents from the webhook collection where 'UUID' matches the provided UUID.
-	docs, err := ref.Where("UUID", "==", UUID).Documents(ctx).GetAll()
+	store, err := openBackend(opts)
 	if err != nil {
-		return nil, err // Return the error if the fetch operation fails.
-	}
-
-	var webhooks []structs.WebhookResponse // Slice to store the fetched webhook documents for the user.
-
-	for _, doc := range docs {
-		var webhook structs.WebhookResponse
-		if err := doc.DataTo(&webhook); err != nil {
-			return nil, err // Return error if parsing any document fails.
-		}
-		webhooks = append(webhooks, webhook) // Append the parsed document to the slice.
+		return nil, err
 	}
-
-	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
-	return webhooks, nil                                                      // Return the slice of webhook documents for the user.
-}
-
-// GetSpecificWebhook retrieves a specific webhook entry by ID and UUID from Firestore.
-func GetSpecificWebhook(IP, ID, UUID string) (*structs.WebhookResponse, error) {
-	ref := Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
-
-	// Query for the specific document with the given 'ID' and 'UUID'.
-	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	var webhook *structs.WebhookResponse // Variable to store the fetched document.
-
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		if err := doc.DataTo(&webhook); err != nil {
-			return nil, err // Return error if parsing the document fails.
-		}
-
-		log.Printf("%s: Webhook %s retrieved successfully.", IP, webhook.ID) // Log success.
-		return webhook, nil                                                  // Return the parsed document.
+	if opts.WebhookCacheTTL > 0 {
+		return NewWebhookCacheStore(store, opts.WebhookCacheTTL), nil
 	}
-
-	return nil, errors.New("no document with that ID was found") // Return error if no document is found.
+	return store, nil
 }
 
-// DeleteWebhook deletes a specific webhook entry by ID and UUID from Firestore.
-func DeleteWebhook(IP, ID, UUID string) error {
-	ref := Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
-
-	// Query for the specific document to delete.
-	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
-	defer iter.Stop() // Ensure the iterator is cleaned up properly.
-
-	var docID string // Variable to store the document ID of the found webhook.
-	for {
-		doc, err := iter.Next()
-		if errors.Is(err, iterator.Done) {
-			break // Exit the loop if all documents have been iterated over.
-		}
-		if err != nil {
-			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
-		}
-		docID = doc.Ref.ID // Store the document ID.
-	}
-
-	if docID == "" {
-		return fmt.Errorf("ID match was not found") // Return error if no document ID was found.
-	}
-
-	_, err = ref.Doc(docID).Delete(ctx) // Delete the document from Firestore.
-	if err != nil {
-		log.Print(err)                                          // Log errors during the delete operation.
-		return fmt.Errorf("failed to delete document: %v", err) // Return formatted error if delete fails.
+// openBackend creates the storage backend selected by the given options.
+func openBackend(opts Options) (Store, error) {
+	switch opts.Backend {
+	case BackendFirestore, "":
+		return NewFirestoreStore(opts.FirestoreProjectID, opts.FirebaseCredentialsFile)
+	case BackendSQLite:
+		return NewSQLiteStore(opts.DSN, !opts.SkipMigrations)
+	case BackendPostgres:
+		return NewPostgresStore(opts.DSN, !opts.SkipMigrations)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %q", opts.Backend)
 	}
-
-	log.Printf("%s: Webhook %s deleted successfully.", IP, docID) // Log success.
-	return nil                                                    // Return nil error on successful operation.
 }
@@ -0,0 +1,59 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"errors"
+	"globeboard/internal/utils/structs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRegistrationNotFound is returned when no registration matches the given ID and UUID.
+	ErrRegistrationNotFound = errors.New("no registration with that ID was found")
+	// ErrRevisionNotFound is returned when no revision matches the given IDs.
+	ErrRevisionNotFound = errors.New("no revision with that ID was found")
+	// ErrPreconditionFailed is returned when a conditional write targets a registration that has since changed.
+	ErrPreconditionFailed = errors.New("registration has been modified since it was retrieved")
+	// ErrConflict is returned when a registration changed concurrently in a way the write cannot be applied to.
+	// The client may retry the request.
+	ErrConflict = errors.New("registration was modified concurrently, please retry")
+	// ErrRegistrationExists is returned when restoring a registration whose ID is taken by an existing registration.
+	ErrRegistrationExists = errors.New("a registration with that ID already exists")
+)
+
+// RegistrationPatch computes the new state of a registration from its current state.
+// It may be called more than once when a backend retries a conflicting transaction.
+type RegistrationPatch func(current *structs.CountryInfoInternal) (*structs.CountryInfoInternal, error)
+
+// RegistrationETag derives the entity tag of a registration from its last change time.
+func RegistrationETag(lastchange time.Time) string {
+	return `"` + strconv.FormatInt(lastchange.UnixNano(), 36) + `"`
+}
+
+// MatchesETag reports whether an If-Match header value is satisfied by a registration last changed at 'lastchange'.
+// An empty header places no condition on the write, and "*" matches any existing registration.
+func MatchesETag(ifMatch string, lastchange time.Time) bool {
+	if ifMatch == "" {
+		return true
+	}
+	etag := RegistrationETag(lastchange)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag { // Weak tags never match, as If-Match uses strong comparison.
+			return true
+		}
+	}
+	return false
+}
+
+// nextChange returns the last change time for a write following one made at 'previous',
+// guaranteeing a new entity tag even if the clock has not advanced.
+func nextChange(previous time.Time) time.Time {
+	now := time.Now().UTC()
+	if !now.After(previous) {
+		now = previous.Add(time.Nanosecond)
+	}
+	return now
+}
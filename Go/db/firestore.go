@@ -0,0 +1,1226 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"fmt"
+	"globeboard/internal/utils/constants/Firestore"
+	"globeboard/internal/utils/structs"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FirestoreStore is a Store backed by Google Cloud Firestore.
+type FirestoreStore struct {
+	Client *firestore.Client // Firestore client used for all operations.
+}
+
+var _ Store = (*FirestoreStore)(nil) // Ensure FirestoreStore implements Store.
+
+// apiKeyDocument is an API key as stored in Firestore, with the schema version it was written under.
+type apiKeyDocument struct {
+	structs.APIKey
+	SchemaVersion int
+}
+
+// revisionDocument is a revision as stored in Firestore, with the schema version it was written under.
+type revisionDocument struct {
+	structs.RevisionInternal
+	SchemaVersion int
+}
+
+// webhookDocument is a webhook as stored in Firestore, with the schema version it was written under.
+type webhookDocument struct {
+	structs.WebhookInternal
+	SchemaVersion int
+}
+
+// userDocument is a user as stored in Firestore, with the schema version it was written under.
+type userDocument struct {
+	structs.User
+	SchemaVersion int
+}
+
+// disabledUserDocument marks the account of a user as disabled in Firestore, stored under their UUID.
+type disabledUserDocument struct {
+	UUID          string
+	Disabled      time.Time
+	SchemaVersion int
+}
+
+// auditDocument is an audit entry as stored in Firestore, with the schema version it was written under.
+type auditDocument struct {
+	structs.AuditEntry
+	SchemaVersion int
+}
+
+// usageDocument is a usage counter as stored in Firestore, with the schema version it was written under.
+type usageDocument struct {
+	structs.Usage
+	SchemaVersion int
+}
+
+// firestoreCollections maps each kind of stored document to its Firestore collection.
+var firestoreCollections = map[string]string{
+	KindAPIKey:       Firestore.ApiKeyCollection,
+	KindRegistration: Firestore.RegistrationCollection,
+	KindRevision:     Firestore.RevisionCollection,
+	KindWebhook:      Firestore.WebhookCollection,
+	KindUser:         Firestore.UserCollection,
+	KindDisabledUser: Firestore.DisabledUserCollection,
+	KindAuditEntry:   Firestore.AuditCollection,
+	KindUsage:        Firestore.UsageCollection,
+}
+
+// NewFirestoreStore initializes a Firestore client using the provided project ID and credentials file.
+func NewFirestoreStore(projectID, credentialsFile string) (*FirestoreStore, error) {
+	sa := option.WithCredentialsFile(credentialsFile)      // Set up the credential file.
+	client, err := firestore.NewClient(ctx, projectID, sa) // Create a new Firestore client.
+	if err != nil {
+		return nil, err
+	}
+	return &FirestoreStore{Client: client}, nil
+}
+
+// Close closes the underlying Firestore client connection.
+func (s *FirestoreStore) Close() error {
+	return s.Client.Close()
+}
+
+// TestDBConnection tests the Firestore database connection by attempting to write and immediately read a document.
+func (s *FirestoreStore) TestDBConnection() string {
+	collectionID := "Connectivity"     // Define the collection ID for connection tests.
+	documentID := "DB_Connection_Test" // Define the document ID for connection tests.
+
+	// Attempt to set a document in the Firestore collection, tagging it with server timestamp.
+	_, err := s.Client.Collection(collectionID).Doc(documentID).Set(ctx, map[string]interface{}{
+		"PSA":         "DO NOT DELETE THIS DOCUMENT!",
+		"lastChecked": firestore.ServerTimestamp,
+	}, firestore.MergeAll)
+
+	// Handle potential errors and map them to HTTP status codes.
+	grpcStatusCode := status.Code(err)
+	switch grpcStatusCode {
+	case codes.OK:
+		return fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
+	case codes.Canceled:
+		return fmt.Sprintf("%d %s", http.StatusRequestTimeout, http.StatusText(http.StatusRequestTimeout))
+	case codes.DeadlineExceeded:
+		return fmt.Sprintf("%d %s", http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout))
+	case codes.PermissionDenied:
+		return fmt.Sprintf("%d %s", http.StatusForbidden, http.StatusText(http.StatusForbidden))
+	case codes.NotFound:
+		// Treat not found as OK for this operation; it indicates collection/document was simply not found.
+		// Another error in-of-itself.
+		return fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
+	case codes.ResourceExhausted:
+		return fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+	case codes.Unauthenticated:
+		return fmt.Sprintf("%d %s", http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	case codes.Unavailable:
+		return fmt.Sprintf("%d %s", http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
+	case codes.Unknown, codes.Internal:
+		return fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	default:
+		return fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// DeleteUserData deletes every API key, registration, revision, webhook, disabled marker and usage counter owned by
+// a user (UUID). A user may own more documents than one transaction can write, so they are deleted in chunks of at
+// most maxTransactionWrites. Should a chunk fail, the documents deleted so far stay deleted and calling it again
+// deletes the rest. API keys go last, so that the user can still authenticate to call it again.
+func (s *FirestoreStore) DeleteUserData(IP, UUID string) error {
+	collections := []string{
+		Firestore.RegistrationCollection,
+		Firestore.RevisionCollection,
+		Firestore.WebhookCollection,
+		Firestore.UsageCollection,
+		Firestore.DisabledUserCollection,
+		Firestore.ApiKeyCollection,
+	}
+
+	deleted := 0 // Number of documents deleted across all collections.
+	for _, collection := range collections {
+		query := s.Client.Collection(collection).Where("UUID", "==", UUID).Limit(maxTransactionWrites)
+		for {
+			docs, err := query.Documents(ctx).GetAll()
+			if err != nil {
+				return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+			}
+			if len(docs) == 0 {
+				break
+			}
+			if err := s.deleteDocuments(docs); err != nil {
+				return fmt.Errorf("failed to delete user data after deleting %d documents: %v", deleted, err)
+			}
+			deleted += len(docs)
+		}
+	}
+
+	log.Printf("%s: Deleted %d documents owned by user: %s.", IP, deleted, UUID)
+	return nil // Return nil if the deletion is successful.
+}
+
+// deleteDocuments deletes each snapshot's document, returning the first error met.
+func (s *FirestoreStore) deleteDocuments(docs []*firestore.DocumentSnapshot) error {
+	writer := s.Client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+	for _, doc := range docs {
+		job, err := writer.Delete(doc.Ref)
+		if err != nil {
+			writer.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End() // Flush every pending delete and wait for them to complete.
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddApiKey stores the metadata of a new API key in Firestore along with the hash of the key itself,
+// stamping its creation time.
+func (s *FirestoreStore) AddApiKey(IP, docID string, apiKey *structs.APIKey, key string) error {
+	ref := s.Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
+
+	apiKey.KeyHash = HashAPIKey(key)  // Only the hash of the key is stored.
+	apiKey.Prefix = APIKeyPrefix(key) // Along with a prefix to recognise it by.
+	apiKey.Created = time.Now().UTC() // Stamp the creation time.
+
+	_, err := ref.Doc(docID).Set(ctx, apiKeyDocument{APIKey: *apiKey, SchemaVersion: SchemaVersion}) // Set the APIKey document in Firestore.
+	if err != nil {
+		err := fmt.Errorf("error saving API key to Database: %v", err)
+		return err // Return formatted error if setting the document fails.
+	}
+
+	log.Printf("%s: API key %s created successfully.", IP, apiKey.Prefix) // Log success.
+	return nil                                                            // Return nil error on success.
+}
+
+// DeleteApiKey deletes an API key from Firestore based on UUID and key value.
+func (s *FirestoreStore) DeleteApiKey(IP, UUID, apiKey string) error {
+	ref := s.Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
+
+	// Query for the API key document based on UUID and the hash of the key.
+	doc, err := s.findAPIKey(ref.Where("UUID", "==", UUID).Where("KeyHash", "==", HashAPIKey(apiKey)))
+	if err != nil {
+		return err
+	}
+
+	_, err = doc.Ref.Delete(ctx) // Delete the document from Firestore.
+	if err != nil {
+		return fmt.Errorf("failed to delete API Key: %v", err) // Return formatted error if delete fails.
+	}
+
+	log.Printf("%s: API key %s deleted successfully.", IP, APIKeyPrefix(apiKey)) // Log success.
+	return nil                                                                   // Return nil error on success.
+}
+
+// DeleteApiKeyByID deletes an API key from Firestore based on its ID and UUID.
+func (s *FirestoreStore) DeleteApiKeyByID(IP, ID, UUID string) error {
+	ref := s.Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
+
+	doc, err := s.findAPIKey(ref.Where("UUID", "==", UUID).Where("ID", "==", ID)) // Query for the API key by ID.
+	if err != nil {
+		return err
+	}
+
+	_, err = doc.Ref.Delete(ctx) // Delete the document from Firestore.
+	if err != nil {
+		return fmt.Errorf("failed to delete API Key: %v", err) // Return formatted error if delete fails.
+	}
+
+	log.Printf("%s: API key %s deleted successfully.", IP, ID) // Log success.
+	return nil                                                 // Return nil error on success.
+}
+
+// ExpireApiKey sets the time at which an API key in Firestore, identified by its ID and UUID, stops being accepted.
+func (s *FirestoreStore) ExpireApiKey(IP, ID, UUID string, expires time.Time) error {
+	ref := s.Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
+
+	doc, err := s.findAPIKey(ref.Where("UUID", "==", UUID).Where("ID", "==", ID)) // Query for the API key by ID.
+	if err != nil {
+		return err
+	}
+
+	_, err = doc.Ref.Update(ctx, []firestore.Update{{Path: "Expires", Value: expires.UTC()}})
+	if err != nil {
+		return fmt.Errorf("failed to update API Key: %v", err) // Return formatted error if update fails.
+	}
+
+	log.Printf("%s: API key %s set to expire at %s.", IP, ID, expires.UTC().Format(time.RFC3339))
+	return nil // Return nil error on success.
+}
+
+// findAPIKey returns the first API key document matching 'query', or an error if there is none.
+func (s *FirestoreStore) findAPIKey(query firestore.Query) (*firestore.DocumentSnapshot, error) {
+	iter := query.Limit(1).Documents(ctx)
+	defer iter.Stop() // Ensure the iterator is cleaned up properly.
+
+	doc, err := iter.Next()
+	if errors.Is(err, iterator.Done) {
+		return nil, errors.New("API key not found") // Return error if no document was found.
+	}
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+	}
+	return doc, nil
+}
+
+// GetAPIKey retrieves a specific, unexpired API key from Firestore, updating its last-used time.
+func (s *FirestoreStore) GetAPIKey(IP, apiKey string) *structs.APIKey {
+	ref := s.Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
+
+	// Query for the API key document based on the hash of the key.
+	doc, err := s.findAPIKey(ref.Where("KeyHash", "==", HashAPIKey(apiKey)))
+	if err != nil {
+		log.Printf("%s: Error retrieving API key: %v", IP, err)
+		return nil // Return nil if the key is unknown or the lookup fails.
+	}
+
+	var key structs.APIKey // Variable to store the API key data.
+	if err := doc.DataTo(&key); err != nil {
+		log.Println("Error parsing document:", err)
+		return nil // Return nil on parsing error.
+	}
+
+	now := time.Now().UTC()
+	if apiKeyExpired(key, now) {
+		log.Printf("%s: Rejected expired API key: %s.", IP, key.Prefix)
+		return nil // Return nil if the key has expired.
+	}
+
+	if apiKeyUsageStale(key, now) {
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "LastUsed", Value: now}}); err != nil {
+			log.Printf("%s: Error recording API key use: %v", IP, err) // Not fatal; the key is still valid.
+		}
+	}
+
+	log.Printf("%s: UUID: %s successfully retrieved from API key: %s.", IP, key.UUID, key.Prefix)
+	return &key // Return the API key on success.
+}
+
+// GetAPIKeyUUID retrieves the UUID associated with a specific, unexpired API key from Firestore,
+// updating its last-used time.
+func (s *FirestoreStore) GetAPIKeyUUID(IP, apiKey string) string {
+	return apiKeyOwner(s.GetAPIKey(IP, apiKey))
+}
+
+// GetAPIKeys retrieves all API keys belonging to a given user (UUID) from Firestore.
+func (s *FirestoreStore) GetAPIKeys(IP, UUID string) ([]structs.APIKey, error) {
+	ref := s.Client.Collection(Firestore.ApiKeyCollection) // Reference to the APIKey collection in Firestore.
+
+	// Query and retrieve all API key documents where 'UUID' matches the provided UUID.
+	docs, err := ref.Where("UUID", "==", UUID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if the fetch fails.
+	}
+
+	var keys []structs.APIKey // Slice to store the fetched API keys.
+	for _, doc := range docs {
+		var key structs.APIKey
+		if err := doc.DataTo(&key); err != nil {
+			return nil, err // Return error if parsing any document fails.
+		}
+		keys = append(keys, key)
+	}
+
+	log.Printf("%s: Successfully retrieved %d API keys for user: %s.", IP, len(keys), UUID)
+	return keys, nil
+}
+
+// AddRegistration adds a new registration document to Firestore, along with the revision recording it.
+func (s *FirestoreStore) AddRegistration(IP, docID string, data *structs.CountryInfoInternal, revision RevisionOf) error {
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	err := s.Client.RunTransaction(ctx, func(c context.Context, tx *firestore.Transaction) error {
+		// Set the registration document in Firestore with the given ID and data.
+		err := tx.Set(ref.Doc(docID), map[string]interface{}{
+			"ID":               data.ID,
+			"UUID":             data.UUID,
+			"Country":          data.Country,
+			"IsoCode":          data.IsoCode,
+			"Features":         data.Features,
+			"Lastchange":       firestore.ServerTimestamp, // Use server timestamp to record last change.
+			SchemaVersionField: SchemaVersion,
+		})
+		if err != nil {
+			return err
+		}
+		return s.addRevision(tx, revision, nil, data)
+	})
+	if err != nil {
+		return err // Return error if the document set operation fails.
+	}
+
+	log.Printf("%s: Registration documents %s created successfully.", IP, data.ID)
+	return nil // Return nil if the addition is successful.
+}
+
+// RestoreRegistration adds a deleted registration back under its original ID, unless a registration has that ID.
+// The document is created under the registration ID, so that concurrent restores conflict.
+func (s *FirestoreStore) RestoreRegistration(IP string, data *structs.CountryInfoInternal, revision RevisionOf) error {
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	// Query for any registration with the ID, which may be stored under another document ID.
+	query := ref.Where("ID", "==", data.ID).Limit(1)
+
+	err := s.Client.RunTransaction(ctx, func(c context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		if len(docs) > 0 {
+			return ErrRegistrationExists
+		}
+
+		// Create fails rather than overwriting a registration restored concurrently.
+		err = tx.Create(ref.Doc(data.ID), map[string]interface{}{
+			"ID":               data.ID,
+			"UUID":             data.UUID,
+			"Country":          data.Country,
+			"IsoCode":          data.IsoCode,
+			"Features":         data.Features,
+			"Lastchange":       firestore.ServerTimestamp, // Use server timestamp to record last change.
+			SchemaVersionField: SchemaVersion,
+		})
+		if err != nil {
+			return err
+		}
+		return s.addRevision(tx, revision, nil, data)
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrRegistrationExists
+	}
+	if err != nil {
+		return err // Return error if the transaction fails.
+	}
+
+	log.Printf("%s: Registration document %s restored successfully.", IP, data.ID)
+	return nil
+}
+
+// GetRegistrations retrieves all registration documents for a given user (UUID) from Firestore.
+func (s *FirestoreStore) GetRegistrations(IP, UUID string) ([]*structs.CountryInfoInternal, error) {
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	// Query and retrieve all documents where 'UUID' matches, ordered by 'Lastchange' descending.
+	docs, err := ref.Where("UUID", "==", UUID).OrderBy("Lastchange", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err // Return error if the fetch operation fails.
+	}
+
+	var cis []*structs.CountryInfoInternal // Slice to store the fetched documents.
+
+	for _, doc := range docs {
+		var ci *structs.CountryInfoInternal
+		if err := doc.DataTo(&ci); err != nil {
+			return nil, err // Return error if parsing any document fails.
+		}
+		cis = append(cis, ci) // Append the parsed document to the slice.
+	}
+	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
+	return cis, nil // Return the slice of documents.
+}
+
+// ListRegistrations retrieves one page of a user's registration documents matching the query from Firestore.
+// Combining filters with the ordering requires matching composite indexes in the Firestore project.
+func (s *FirestoreStore) ListRegistrations(IP, UUID string, query RegistrationQuery) ([]*structs.CountryInfoInternal, string, error) {
+	field, err := featureField(query.Feature)
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	// Build the query with the filters pushed down to Firestore, ordered by 'Lastchange' descending.
+	q := ref.Where("UUID", "==", UUID)
+	if query.IsoCode != "" {
+		q = q.Where("IsoCode", "==", strings.ToUpper(query.IsoCode))
+	}
+	if field != "" {
+		q = q.Where("Features."+field, "==", true)
+	}
+	if !query.ChangedSince.IsZero() {
+		q = q.Where("Lastchange", ">=", query.ChangedSince)
+	}
+	q = q.OrderBy("Lastchange", firestore.Desc).OrderBy("ID", firestore.Asc)
+	if cursor != nil {
+		q = q.StartAfter(time.Unix(0, cursor.Lastchange).UTC(), cursor.ID) // Resume after the previous page.
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit + 1) // Fetch one extra document to detect a following page.
+	}
+
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err // Return error if the fetch operation fails.
+	}
+
+	var cis []*structs.CountryInfoInternal // Slice to store the fetched documents.
+
+	for _, doc := range docs {
+		var ci *structs.CountryInfoInternal
+		if err := doc.DataTo(&ci); err != nil {
+			return nil, "", err // Return error if parsing any document fails.
+		}
+		cis = append(cis, ci) // Append the parsed document to the slice.
+	}
+
+	next := ""
+	if query.Limit > 0 && len(cis) > query.Limit {
+		cis = cis[:query.Limit]
+		last := cis[len(cis)-1]
+		next = encodeCursor(last.ID, last.Lastchange)
+	}
+
+	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
+	return cis, next, nil // Return the page of documents and the next cursor.
+}
+
+// GetSpecificRegistration retrieves a specific registration document by ID and UUID from Firestore.
+func (s *FirestoreStore) GetSpecificRegistration(IP, ID, UUID string) (*structs.CountryInfoInternal, error) {
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	// Query for the specific document with the given 'ID' and 'UUID'.
+	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
+	defer iter.Stop() // Ensure the iterator is cleaned up properly.
+
+	var ci *structs.CountryInfoInternal // Variable to store the fetched document.
+
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break // Exit the loop if all documents have been iterated over.
+		}
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		if err := doc.DataTo(&ci); err != nil {
+			return nil, err // Return error if parsing the document fails.
+		}
+		log.Printf("%s: Registration document %s retrieved successfully.", IP, ci.ID)
+		return ci, nil // Return the parsed document.
+	}
+
+	return nil, ErrRegistrationNotFound // Return error if no document is found.
+}
+
+// PatchRegistration applies 'patch' to a specific registration document by ID and UUID inside a Firestore transaction.
+// Firestore retries the transaction on contention, so 'patch' may run more than once.
+func (s *FirestoreStore) PatchRegistration(IP, ID, UUID, ifMatch string, patch RegistrationPatch, revision RevisionOf) (*structs.CountryInfoInternal, error) {
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	// Query for the specific document to update.
+	query := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1)
+
+	var docRef *firestore.DocumentRef // Reference to the patched document.
+	err := s.Client.RunTransaction(ctx, func(c context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		if len(docs) == 0 {
+			return ErrRegistrationNotFound // Return error if no document is found.
+		}
+
+		var current *structs.CountryInfoInternal
+		if err := docs[0].DataTo(&current); err != nil {
+			return err // Return error if parsing the document fails.
+		}
+		if !MatchesETag(ifMatch, current.Lastchange) {
+			return ErrPreconditionFailed // Return error if the client holds a stale version.
+		}
+
+		data, err := patch(current)
+		if err != nil {
+			return err // Return error if the patch cannot be applied.
+		}
+
+		docRef = docs[0].Ref
+		// Update the document with the patched data; the ID and owner cannot change.
+		err = tx.Set(docRef, map[string]interface{}{
+			"ID":               current.ID,
+			"UUID":             current.UUID,
+			"Country":          data.Country,
+			"IsoCode":          data.IsoCode,
+			"Features":         data.Features,
+			"Lastchange":       firestore.ServerTimestamp, // Use server timestamp to update 'Lastchange'.
+			SchemaVersionField: SchemaVersion,
+		})
+		if err != nil {
+			return err
+		}
+		updated := *data
+		updated.ID, updated.UUID, updated.Lastchange = current.ID, current.UUID, time.Time{} // Set by the server.
+		return s.addRevision(tx, revision, current, &updated)
+	})
+	if err != nil {
+		return nil, err // Return error if the transaction fails.
+	}
+
+	doc, err := docRef.Get(ctx) // Read back the document to resolve the server timestamp.
+	if err != nil {
+		return nil, err
+	}
+	var ci *structs.CountryInfoInternal
+	if err := doc.DataTo(&ci); err != nil {
+		return nil, err // Return error if parsing the document fails.
+	}
+
+	log.Printf("%s: Registration document %s patched successfully.", IP, docRef.ID)
+	return ci, nil // Return the patched document.
+}
+
+// DeleteRegistration deletes a specific registration document by ID and UUID from Firestore.
+func (s *FirestoreStore) DeleteRegistration(IP, ID, UUID, ifMatch string, revision RevisionOf) error {
+	ref := s.Client.Collection(Firestore.RegistrationCollection) // Reference to the Registration collection.
+
+	// Query for the specific document to delete.
+	query := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1)
+
+	var docID string // Variable to store the document ID of the found registration.
+	err := s.Client.RunTransaction(ctx, func(c context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		if len(docs) == 0 {
+			return ErrRegistrationNotFound // Return error if no document is found.
+		}
+
+		var current *structs.CountryInfoInternal
+		if err := docs[0].DataTo(&current); err != nil {
+			return err // Return error if parsing the document fails.
+		}
+		if !MatchesETag(ifMatch, current.Lastchange) {
+			return ErrPreconditionFailed // Return error if the client holds a stale version.
+		}
+
+		docID = docs[0].Ref.ID // Store the document ID.
+		if err := tx.Delete(docs[0].Ref); err != nil {
+			return err
+		}
+		return s.addRevision(tx, revision, current, nil)
+	})
+	if err != nil {
+		if errors.Is(err, ErrPreconditionFailed) || docID == "" {
+			return err
+		}
+		return fmt.Errorf("failed to delete document: %v", err) // Return formatted error if delete fails.
+	}
+
+	log.Printf("%s: Registration document %s deleted successfully\n", IP, docID)
+	return nil // Return nil if the deletion is successful.
+}
+
+// addRevision records the revision built by 'revision' for a change from 'before' to 'after', if any,
+// in the transaction making the change.
+func (s *FirestoreStore) addRevision(tx *firestore.Transaction, revision RevisionOf, before, after *structs.CountryInfoInternal) error {
+	if revision == nil {
+		return nil
+	}
+	docID, rev := revision(before, after)
+	ref := s.Client.Collection(Firestore.RevisionCollection).Doc(docID)
+	// Create fails rather than overwriting an existing revision.
+	return tx.Create(ref, revisionDocument{RevisionInternal: *rev, SchemaVersion: SchemaVersion})
+}
+
+// GetRevisions retrieves all revisions of a registration by ID and UUID from Firestore, newest first.
+func (s *FirestoreStore) GetRevisions(IP, ID, UUID string) ([]structs.RevisionInternal, error) {
+	ref := s.Client.Collection(Firestore.RevisionCollection) // Reference to the Revision collection.
+
+	// Query and retrieve all revisions of the registration, ordered by 'Timestamp' descending.
+	docs, err := ref.Where("UUID", "==", UUID).Where("RegistrationID", "==", ID).
+		OrderBy("Timestamp", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err // Return error if the fetch operation fails.
+	}
+
+	var revisions []structs.RevisionInternal // Slice to store the fetched revisions.
+
+	for _, doc := range docs {
+		var rev structs.RevisionInternal
+		if err := doc.DataTo(&rev); err != nil {
+			return nil, err // Return error if parsing any document fails.
+		}
+		revisions = append(revisions, rev) // Append the parsed document to the slice.
+	}
+
+	log.Printf("%s: Revisions of registration %s retrieved successfully.", IP, ID)
+	return revisions, nil // Return the slice of revisions.
+}
+
+// GetSpecificRevision retrieves a specific revision of a registration from Firestore.
+func (s *FirestoreStore) GetSpecificRevision(IP, revisionID, ID, UUID string) (*structs.RevisionInternal, error) {
+	ref := s.Client.Collection(Firestore.RevisionCollection) // Reference to the Revision collection.
+
+	// Query for the specific revision with the given IDs.
+	iter := ref.Where("ID", "==", revisionID).Where("RegistrationID", "==", ID).Where("UUID", "==", UUID).
+		Limit(1).Documents(ctx)
+	defer iter.Stop() // Ensure the iterator is cleaned up properly.
+
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break // Exit the loop if all documents have been iterated over.
+		}
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		var rev *structs.RevisionInternal
+		if err := doc.DataTo(&rev); err != nil {
+			return nil, err // Return error if parsing the document fails.
+		}
+		log.Printf("%s: Revision %s of registration %s retrieved successfully.", IP, rev.ID, ID)
+		return rev, nil // Return the parsed document.
+	}
+
+	return nil, ErrRevisionNotFound // Return error if no document is found.
+}
+
+// AddWebhook creates a new webhook entry in Firestore.
+func (s *FirestoreStore) AddWebhook(IP, docID string, webhook *structs.WebhookInternal) error {
+	ref := s.Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection in Firestore.
+
+	// Set the webhook document with the provided ID and data.
+	_, err := ref.Doc(docID).Set(ctx, webhookDocument{WebhookInternal: *webhook, SchemaVersion: SchemaVersion})
+	if err != nil {
+		return err // Return error if addition fails.
+	}
+
+	log.Printf("%s: Webhook %s created successfully.", IP, webhook.ID) // Log success.
+	return nil                                                         // Return nil error on successful addition.
+}
+
+// GetWebhooksForEvent retrieves the webhooks to notify of an event for a user (UUID) and country from Firestore.
+// Firestore cannot combine the owner and country disjunctions in one query, so the country is filtered here.
+func (s *FirestoreStore) GetWebhooksForEvent(UUID, country, event string) ([]structs.WebhookInternal, error) {
+	ref := s.Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
+
+	// Query the webhooks of the user and the developer that subscribe to the event.
+	docs, err := ref.Where("UUID", "in", []string{UUID, ""}).Where("Event", "array-contains", event).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err // Return the error if the fetch operation fails.
+	}
+
+	var webhooks []structs.WebhookInternal // Slice to store the matching webhook documents.
+
+	for _, doc := range docs {
+		var webhook structs.WebhookInternal
+		if err := doc.DataTo(&webhook); err != nil {
+			return nil, err // Return error if parsing any document fails.
+		}
+		if webhook.Country == "" || webhook.Country == country {
+			webhooks = append(webhooks, webhook) // Append the matching document to the slice.
+		}
+	}
+	return webhooks, nil
+}
+
+// GetWebhooksUser retrieves all webhook entries for a specific user (UUID) from Firestore.
+func (s *FirestoreStore) GetWebhooksUser(IP, UUID string) ([]structs.WebhookResponse, error) {
+	ref := s.Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
+
+	// Query and retrieve all documents from the webhook collection where 'UUID' matches the provided UUID.
+	docs, err := ref.Where("UUID", "==", UUID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err // Return the error if the fetch operation fails.
+	}
+
+	var webhooks []structs.WebhookResponse // Slice to store the fetched webhook documents for the user.
+
+	for _, doc := range docs {
+		var webhook structs.WebhookResponse
+		if err := doc.DataTo(&webhook); err != nil {
+			return nil, err // Return error if parsing any document fails.
+		}
+		webhooks = append(webhooks, webhook) // Append the parsed document to the slice.
+	}
+
+	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
+	return webhooks, nil                                                      // Return the slice of webhook documents for the user.
+}
+
+// ListWebhooks retrieves one page of a user's webhook entries matching the query from Firestore.
+func (s *FirestoreStore) ListWebhooks(IP, UUID string, query WebhookQuery) ([]structs.WebhookResponse, string, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ref := s.Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
+
+	// Build the query with the filters pushed down to Firestore, ordered by 'ID'.
+	q := ref.Where("UUID", "==", UUID)
+	if query.Country != "" {
+		q = q.Where("Country", "==", strings.ToUpper(query.Country))
+	}
+	if query.Event != "" {
+		q = q.Where("Event", "array-contains", strings.ToUpper(query.Event))
+	}
+	q = q.OrderBy("ID", firestore.Asc)
+	if cursor != nil {
+		q = q.StartAfter(cursor.ID) // Resume after the previous page.
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit + 1) // Fetch one extra document to detect a following page.
+	}
+
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err // Return the error if the fetch operation fails.
+	}
+
+	var webhooks []structs.WebhookResponse // Slice to store the fetched webhook documents for the user.
+
+	for _, doc := range docs {
+		var webhook structs.WebhookResponse
+		if err := doc.DataTo(&webhook); err != nil {
+			return nil, "", err // Return error if parsing any document fails.
+		}
+		webhooks = append(webhooks, webhook) // Append the parsed document to the slice.
+	}
+
+	next := ""
+	if query.Limit > 0 && len(webhooks) > query.Limit {
+		webhooks = webhooks[:query.Limit]
+		next = encodeCursor(webhooks[len(webhooks)-1].ID, time.Time{})
+	}
+
+	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
+	return webhooks, next, nil                                                // Return the page of webhooks and the next cursor.
+}
+
+// GetSpecificWebhook retrieves a specific webhook entry by ID and UUID from Firestore.
+func (s *FirestoreStore) GetSpecificWebhook(IP, ID, UUID string) (*structs.WebhookResponse, error) {
+	ref := s.Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
+
+	// Query for the specific document with the given 'ID' and 'UUID'.
+	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
+	defer iter.Stop() // Ensure the iterator is cleaned up properly.
+
+	var webhook *structs.WebhookResponse // Variable to store the fetched document.
+
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break // Exit the loop if all documents have been iterated over.
+		}
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		if err := doc.DataTo(&webhook); err != nil {
+			return nil, err // Return error if parsing the document fails.
+		}
+
+		log.Printf("%s: Webhook %s retrieved successfully.", IP, webhook.ID) // Log success.
+		return webhook, nil                                                  // Return the parsed document.
+	}
+
+	return nil, errors.New("no document with that ID was found") // Return error if no document is found.
+}
+
+// DeleteWebhook deletes a specific webhook entry by ID and UUID from Firestore.
+func (s *FirestoreStore) DeleteWebhook(IP, ID, UUID string) error {
+	ref := s.Client.Collection(Firestore.WebhookCollection) // Reference to the Webhook collection.
+
+	// Query for the specific document to delete.
+	iter := ref.Where("ID", "==", ID).Where("UUID", "==", UUID).Limit(1).Documents(ctx)
+	defer iter.Stop() // Ensure the iterator is cleaned up properly.
+
+	var docID string // Variable to store the document ID of the found webhook.
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break // Exit the loop if all documents have been iterated over.
+		}
+		if err != nil {
+			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		docID = doc.Ref.ID // Store the document ID.
+	}
+
+	if docID == "" {
+		return fmt.Errorf("ID match was not found") // Return error if no document ID was found.
+	}
+
+	_, err := ref.Doc(docID).Delete(ctx) // Delete the document from Firestore.
+	if err != nil {
+		log.Print(err)                                          // Log errors during the delete operation.
+		return fmt.Errorf("failed to delete document: %v", err) // Return formatted error if delete fails.
+	}
+
+	log.Printf("%s: Webhook %s deleted successfully.", IP, docID) // Log success.
+	return nil                                                    // Return nil error on successful operation.
+}
+
+// AddUser stores a new user in Firestore under their UUID, stamping their creation time.
+func (s *FirestoreStore) AddUser(user *structs.User) error {
+	ref := s.Client.Collection(Firestore.UserCollection) // Reference to the User collection in Firestore.
+
+	stored := *user
+	stored.Created = time.Now().UTC()
+	err := s.Client.RunTransaction(ctx, func(c context.Context, tx *firestore.Transaction) error {
+		taken, err := tx.Documents(ref.Where("Email", "==", user.Email).Limit(1)).GetAll()
+		if err != nil {
+			return fmt.Errorf(IterationFailed, err) // Return formatted error if iteration fails.
+		}
+		if len(taken) > 0 {
+			return ErrEmailTaken
+		}
+		return tx.Create(ref.Doc(user.UUID), userDocument{User: stored, SchemaVersion: SchemaVersion})
+	})
+	if errors.Is(err, ErrEmailTaken) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error saving user to Database: %v", err)
+	}
+
+	*user = stored
+	log.Printf("User %s created successfully.", user.UUID) // Log success.
+	return nil
+}
+
+// GetUser retrieves a user by UUID from Firestore.
+func (s *FirestoreStore) GetUser(UUID string) (*structs.User, error) {
+	doc, err := s.Client.Collection(Firestore.UserCollection).Doc(UUID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving user: %v", err)
+	}
+	return parseUser(doc)
+}
+
+// GetUserByEmail retrieves a user by email from Firestore.
+func (s *FirestoreStore) GetUserByEmail(email string) (*structs.User, error) {
+	iter := s.Client.Collection(Firestore.UserCollection).Where("Email", "==", email).Limit(1).Documents(ctx)
+	defer iter.Stop()
+
+	doc, err := iter.Next()
+	if errors.Is(err, iterator.Done) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+	return parseUser(doc)
+}
+
+// GetUsers retrieves every user from Firestore, in order of registration.
+func (s *FirestoreStore) GetUsers() ([]structs.User, error) {
+	docs, err := s.Client.Collection(Firestore.UserCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	users := make([]structs.User, 0, len(docs))
+	for _, doc := range docs {
+		user, err := parseUser(doc)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Created.Before(users[j].Created) })
+	return users, nil
+}
+
+// parseUser decodes a user document.
+func parseUser(doc *firestore.DocumentSnapshot) (*structs.User, error) {
+	var user structs.User
+	if err := doc.DataTo(&user); err != nil {
+		return nil, fmt.Errorf("error parsing user: %v", err)
+	}
+	return &user, nil
+}
+
+// DeleteUser deletes a user by UUID from Firestore.
+func (s *FirestoreStore) DeleteUser(UUID string) error {
+	_, err := s.Client.Collection(Firestore.UserCollection).Doc(UUID).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+
+	log.Printf("User %s deleted successfully.", UUID) // Log success.
+	return nil
+}
+
+// CountUserData counts the API keys, registrations and webhooks owned by each user (UUID) in Firestore.
+// Only the owner of each document is read.
+func (s *FirestoreStore) CountUserData() (map[string]structs.UserCounts, error) {
+	counts := make(map[string]structs.UserCounts)
+	collections := map[string]func(c *structs.UserCounts){
+		Firestore.ApiKeyCollection:       func(c *structs.UserCounts) { c.APIKeys++ },
+		Firestore.RegistrationCollection: func(c *structs.UserCounts) { c.Registrations++ },
+		Firestore.WebhookCollection:      func(c *structs.UserCounts) { c.Webhooks++ },
+	}
+	for collection, count := range collections {
+		docs, err := s.Client.Collection(collection).Select("UUID").Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+		for _, doc := range docs {
+			UUID, _ := doc.Data()["UUID"].(string)
+			if UUID == "" {
+				continue // Developer webhooks belong to no user.
+			}
+			c := counts[UUID]
+			count(&c)
+			counts[UUID] = c
+		}
+	}
+	return counts, nil
+}
+
+// DisableUser disables the account of a user (UUID) in Firestore, keeping the time it was first disabled.
+func (s *FirestoreStore) DisableUser(IP, UUID string) error {
+	doc := disabledUserDocument{UUID: UUID, Disabled: time.Now().UTC(), SchemaVersion: SchemaVersion}
+	_, err := s.Client.Collection(Firestore.DisabledUserCollection).Doc(UUID).Create(ctx, doc)
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return fmt.Errorf("error disabling user: %v", err)
+	}
+
+	log.Printf("%s: Account of user %s disabled.", IP, UUID) // Log success.
+	return nil
+}
+
+// EnableUser re-enables the account of a disabled user (UUID) in Firestore.
+func (s *FirestoreStore) EnableUser(IP, UUID string) error {
+	if _, err := s.Client.Collection(Firestore.DisabledUserCollection).Doc(UUID).Delete(ctx); err != nil {
+		return fmt.Errorf("error enabling user: %v", err)
+	}
+
+	log.Printf("%s: Account of user %s enabled.", IP, UUID) // Log success.
+	return nil
+}
+
+// IsUserDisabled reports whether the account of a user (UUID) is disabled in Firestore.
+func (s *FirestoreStore) IsUserDisabled(UUID string) (bool, error) {
+	_, err := s.Client.Collection(Firestore.DisabledUserCollection).Doc(UUID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking whether user is disabled: %v", err)
+	}
+	return true, nil
+}
+
+// GetDisabledUsers retrieves every disabled user (UUID) from Firestore along with the time they were disabled.
+func (s *FirestoreStore) GetDisabledUsers() (map[string]time.Time, error) {
+	docs, err := s.Client.Collection(Firestore.DisabledUserCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	disabled := make(map[string]time.Time, len(docs))
+	for _, doc := range docs {
+		var d disabledUserDocument
+		if err := doc.DataTo(&d); err != nil {
+			return nil, fmt.Errorf("error parsing disabled user: %v", err)
+		}
+		disabled[d.UUID] = d.Disabled
+	}
+	return disabled, nil
+}
+
+// AddAuditEntry records an administrative action in Firestore under its ID, stamping its time.
+func (s *FirestoreStore) AddAuditEntry(entry *structs.AuditEntry) error {
+	entry.Time = time.Now().UTC()
+	_, err := s.Client.Collection(Firestore.AuditCollection).Doc(entry.ID).Set(ctx,
+		auditDocument{AuditEntry: *entry, SchemaVersion: SchemaVersion})
+	if err != nil {
+		return fmt.Errorf("error saving audit entry to Database: %v", err)
+	}
+	return nil
+}
+
+// GetAuditEntries retrieves the recorded administrative actions matching the query from Firestore, newest first.
+func (s *FirestoreStore) GetAuditEntries(query AuditQuery) ([]structs.AuditEntry, error) {
+	q := s.Client.Collection(Firestore.AuditCollection).Query
+	if query.Actor != "" {
+		q = q.Where("Actor", "==", query.Actor)
+	}
+	if query.Target != "" {
+		q = q.Where("Target", "==", query.Target)
+	}
+	docs, err := q.OrderBy("Time", firestore.Desc).Limit(query.Limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	entries := make([]structs.AuditEntry, 0, len(docs))
+	for _, doc := range docs {
+		var entry structs.AuditEntry
+		if err := doc.DataTo(&entry); err != nil {
+			return nil, fmt.Errorf("error parsing audit entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// maxTransactionWrites is the most documents a Firestore transaction may write.
+const maxTransactionWrites = 500
+
+// AddUsage adds the given requests to the usage counters in Firestore, in transactions of at most
+// maxTransactionWrites counters. Should a transaction fail, the counters added by those before it stay added.
+func (s *FirestoreStore) AddUsage(usage []structs.Usage) (int, error) {
+	for start := 0; start < len(usage); start += maxTransactionWrites {
+		batch := usage[start:min(start+maxTransactionWrites, len(usage))]
+		refs := make([]*firestore.DocumentRef, len(batch))
+		for i, u := range batch {
+			refs[i] = s.Client.Collection(Firestore.UsageCollection).Doc(keyOf(u).docID())
+		}
+
+		err := s.Client.RunTransaction(ctx, func(c context.Context, tx *firestore.Transaction) error {
+			docs, err := tx.GetAll(refs) // Transactions must complete all reads before any writes.
+			if err != nil {
+				return err
+			}
+			for i, doc := range docs {
+				counter := batch[i]
+				if doc.Exists() {
+					var stored usageDocument
+					if err := doc.DataTo(&stored); err != nil {
+						return fmt.Errorf("error parsing usage: %v", err)
+					}
+					mergeUsage(&stored.Usage, counter)
+					counter = stored.Usage
+				}
+				if err := tx.Set(refs[i], usageDocument{Usage: counter, SchemaVersion: SchemaVersion}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return start, fmt.Errorf("error saving usage to Database: %v", err)
+		}
+	}
+	return len(usage), nil
+}
+
+// GetUsage retrieves the usage counters matching the query from Firestore, ordered by day, API key, endpoint and status.
+func (s *FirestoreStore) GetUsage(query UsageQuery) ([]structs.Usage, error) {
+	q := s.Client.Collection(Firestore.UsageCollection).Where("Day", ">=", query.From).Where("Day", "<=", query.To)
+	if query.UUID != "" {
+		q = q.Where("UUID", "==", query.UUID)
+	}
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	usage := make([]structs.Usage, 0, len(docs))
+	for _, doc := range docs {
+		var u structs.Usage
+		if err := doc.DataTo(&u); err != nil {
+			return nil, fmt.Errorf("error parsing usage: %v", err)
+		}
+		usage = append(usage, u)
+	}
+	sortUsage(usage)
+	return usage, nil
+}
+
+// PendingMigrations reports the document migrations still due for documents written under an older schema version.
+func (s *FirestoreStore) PendingMigrations() ([]MigrationStatus, error) {
+	return s.Migrate(true)
+}
+
+// Migrate upgrades every Firestore document written under an older schema version.
+// Documents lacking a version cannot be queried for, so every collection is read in full.
+// A document changed while it is being migrated is not overwritten; the migration fails and can be run again.
+func (s *FirestoreStore) Migrate(dryRun bool) ([]MigrationStatus, error) {
+	var report []MigrationStatus
+	for _, kind := range documentKinds {
+		docs, err := s.Client.Collection(firestoreCollections[kind]).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+
+		statuses := make(map[int]*MigrationStatus)
+		var updates []*firestore.DocumentSnapshot
+		var upgraded []Document
+		for _, snap := range docs {
+			doc := Document(snap.Data())
+			version := documentVersion(doc)
+			if version >= SchemaVersion {
+				continue // Already current, or written by a newer build.
+			}
+			countOutdated(statuses, kind, version, 1)
+			if err := upgradeDocument(kind, doc); err != nil {
+				return nil, fmt.Errorf("%s document %s: %v", kind, snap.Ref.ID, err)
+			}
+			updates = append(updates, snap)
+			upgraded = append(upgraded, doc)
+		}
+
+		if !dryRun && len(updates) > 0 {
+			if err := s.writeUpgraded(updates, upgraded); err != nil {
+				return nil, fmt.Errorf("error migrating %s: %v", kind, err)
+			}
+			log.Printf("Migrated %d %s documents to schema version %d.", len(updates), kind, SchemaVersion)
+		}
+		report = append(report, sortedStatuses(statuses)...)
+	}
+	return report, nil
+}
+
+// writeUpgraded replaces each snapshot's document with its upgraded version, unless it changed since it was read.
+func (s *FirestoreStore) writeUpgraded(snaps []*firestore.DocumentSnapshot, docs []Document) error {
+	writer := s.Client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(snaps))
+	for i, snap := range snaps {
+		updates := make([]firestore.Update, 0, len(docs[i]))
+		for field, value := range docs[i] {
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{field}, Value: value})
+		}
+		for field := range snap.Data() {
+			if _, ok := docs[i][field]; !ok { // Remove fields the migrations dropped.
+				updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{field}, Value: firestore.Delete})
+			}
+		}
+		job, err := writer.Update(snap.Ref, updates, firestore.LastUpdateTime(snap.UpdateTime))
+		if err != nil {
+			writer.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End() // Flush every pending write and wait for them to complete.
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,830 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"errors"
+	"fmt"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps all data in process memory.
+// It is intended for local development and tests, and loses all data on restart.
+type MemoryStore struct {
+	mu            sync.RWMutex                           // Guards all maps below.
+	apiKeys       map[string]structs.APIKey              // API keys keyed by document ID.
+	registrations map[string]structs.CountryInfoInternal // Registrations keyed by document ID.
+	revisions     map[string]structs.RevisionInternal    // Registration revisions keyed by document ID.
+	webhooks      map[string]structs.WebhookInternal     // Webhooks keyed by document ID.
+	users         map[string]structs.User                // Users of the built-in identity provider keyed by UUID.
+	disabled      map[string]time.Time                   // Times accounts were disabled keyed by UUID.
+	audit         []structs.AuditEntry                   // Audit trail, oldest first.
+	usage         map[usageKey]structs.Usage             // Usage counters keyed by day, API key, endpoint and status.
+}
+
+var _ Store = (*MemoryStore)(nil) // Ensure MemoryStore implements Store.
+
+// NewMemoryStore creates an empty in-memory Store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		apiKeys:       make(map[string]structs.APIKey),
+		registrations: make(map[string]structs.CountryInfoInternal),
+		revisions:     make(map[string]structs.RevisionInternal),
+		webhooks:      make(map[string]structs.WebhookInternal),
+		users:         make(map[string]structs.User),
+		disabled:      make(map[string]time.Time),
+		usage:         make(map[usageKey]structs.Usage),
+	}
+}
+
+// Close is a no-op for the in-memory store.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// TestDBConnection always reports the in-memory store as reachable.
+func (s *MemoryStore) TestDBConnection() string {
+	return fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
+}
+
+// DeleteUserData deletes every API key, registration, revision, webhook and usage counter owned by a user (UUID).
+func (s *MemoryStore) DeleteUserData(IP, UUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0 // Number of documents deleted across all collections.
+	for docID, key := range s.apiKeys {
+		if key.UUID == UUID {
+			delete(s.apiKeys, docID)
+			deleted++
+		}
+	}
+	for docID, reg := range s.registrations {
+		if reg.UUID == UUID {
+			delete(s.registrations, docID)
+			deleted++
+		}
+	}
+	for docID, rev := range s.revisions {
+		if rev.UUID == UUID {
+			delete(s.revisions, docID)
+			deleted++
+		}
+	}
+	for docID, hook := range s.webhooks {
+		if hook.UUID == UUID {
+			delete(s.webhooks, docID)
+			deleted++
+		}
+	}
+	if _, ok := s.disabled[UUID]; ok {
+		delete(s.disabled, UUID)
+		deleted++
+	}
+	for key := range s.usage {
+		if key.UUID == UUID {
+			delete(s.usage, key)
+			deleted++
+		}
+	}
+
+	log.Printf("%s: Deleted %d documents owned by user: %s.", IP, deleted, UUID)
+	return nil
+}
+
+// AddApiKey stores the metadata of a new API key along with the hash of the key itself, stamping its creation time.
+func (s *MemoryStore) AddApiKey(IP, docID string, apiKey *structs.APIKey, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *apiKey
+	stored.Scopes = slices.Clone(apiKey.Scopes) // Copy, so the caller cannot change the stored scopes.
+	if apiKey.Expires != nil {
+		expires := apiKey.Expires.UTC() // Copy, so the caller cannot change the stored expiry.
+		stored.Expires = &expires
+	}
+	stored.KeyHash = HashAPIKey(key)
+	stored.Prefix = APIKeyPrefix(key)
+	stored.Created = time.Now().UTC()
+	s.apiKeys[docID] = stored
+	*apiKey = stored
+
+	log.Printf("%s: API key %s created successfully.", IP, stored.Prefix) // Log success.
+	return nil
+}
+
+// DeleteApiKey deletes an API key based on UUID and key value.
+func (s *MemoryStore) DeleteApiKey(IP, UUID, apiKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyHash := HashAPIKey(apiKey)
+	for docID, k := range s.apiKeys {
+		if k.UUID == UUID && k.KeyHash == keyHash {
+			delete(s.apiKeys, docID)
+			log.Printf("%s: API key %s deleted successfully.", IP, k.Prefix) // Log success.
+			return nil
+		}
+	}
+
+	return errors.New("API key not found") // Return error if no matching key was found.
+}
+
+// DeleteApiKeyByID deletes an API key based on its ID and UUID.
+func (s *MemoryStore) DeleteApiKeyByID(IP, ID, UUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for docID, k := range s.apiKeys {
+		if k.UUID == UUID && k.ID == ID {
+			delete(s.apiKeys, docID)
+			log.Printf("%s: API key %s deleted successfully.", IP, k.Prefix) // Log success.
+			return nil
+		}
+	}
+
+	return errors.New("API key not found") // Return error if no matching key was found.
+}
+
+// ExpireApiKey sets the time at which an API key, identified by its ID and UUID, stops being accepted.
+func (s *MemoryStore) ExpireApiKey(IP, ID, UUID string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for docID, k := range s.apiKeys {
+		if k.UUID == UUID && k.ID == ID {
+			expires := expires.UTC()
+			k.Expires = &expires
+			s.apiKeys[docID] = k
+			log.Printf("%s: API key %s set to expire at %s.", IP, k.Prefix, expires.Format(time.RFC3339))
+			return nil
+		}
+	}
+
+	return errors.New("API key not found") // Return error if no matching key was found.
+}
+
+// GetAPIKey retrieves a specific, unexpired API key, updating its last-used time.
+func (s *MemoryStore) GetAPIKey(IP, apiKey string) *structs.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyHash := HashAPIKey(apiKey)
+	now := time.Now().UTC()
+	for docID, k := range s.apiKeys {
+		if k.KeyHash != keyHash {
+			continue
+		}
+		if apiKeyExpired(k, now) {
+			log.Printf("%s: Rejected expired API key: %s.", IP, k.Prefix)
+			return nil
+		}
+		if apiKeyUsageStale(k, now) {
+			k.LastUsed = &now
+			s.apiKeys[docID] = k
+		}
+		log.Printf("%s: UUID: %s successfully retrieved from API key: %s.", IP, k.UUID, k.Prefix)
+		return &k
+	}
+	return nil // Return nil if the key is unknown.
+}
+
+// GetAPIKeyUUID retrieves the UUID associated with a specific, unexpired API key, updating its last-used time.
+func (s *MemoryStore) GetAPIKeyUUID(IP, apiKey string) string {
+	return apiKeyOwner(s.GetAPIKey(IP, apiKey))
+}
+
+// GetAPIKeys retrieves all API keys belonging to a given user (UUID).
+func (s *MemoryStore) GetAPIKeys(IP, UUID string) ([]structs.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []structs.APIKey
+	for _, k := range s.apiKeys {
+		if k.UUID == UUID {
+			keys = append(keys, k)
+		}
+	}
+
+	log.Printf("%s: Successfully retrieved %d API keys for user: %s.", IP, len(keys), UUID)
+	return keys, nil
+}
+
+// AddRegistration adds a new registration document, stamping it with the current time.
+func (s *MemoryStore) AddRegistration(IP, docID string, data *structs.CountryInfoInternal, revision RevisionOf) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg := copyRegistration(data)
+	reg.Lastchange = time.Now().UTC() // Mirror Firestore's server timestamp.
+	if err := s.addRevision(revision, nil, reg); err != nil {
+		return err
+	}
+	s.registrations[docID] = *reg
+
+	log.Printf("%s: Registration documents %s created successfully.", IP, data.ID)
+	return nil
+}
+
+// RestoreRegistration adds a deleted registration back under its original ID, unless a registration has that ID.
+// The store stays locked between checking and adding, so concurrent restores cannot both succeed.
+func (s *MemoryStore) RestoreRegistration(IP string, data *structs.CountryInfoInternal, revision RevisionOf) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.registrations[data.ID]; taken {
+		return ErrRegistrationExists
+	}
+	for _, reg := range s.registrations {
+		if reg.ID == data.ID {
+			return ErrRegistrationExists
+		}
+	}
+
+	reg := copyRegistration(data)
+	reg.Lastchange = time.Now().UTC() // Mirror Firestore's server timestamp.
+	if err := s.addRevision(revision, nil, reg); err != nil {
+		return err
+	}
+	s.registrations[data.ID] = *reg
+
+	log.Printf("%s: Registration document %s restored successfully.", IP, data.ID)
+	return nil
+}
+
+// GetRegistrations retrieves all registration documents for a given user (UUID), newest change first.
+func (s *MemoryStore) GetRegistrations(IP, UUID string) ([]*structs.CountryInfoInternal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var cis []*structs.CountryInfoInternal
+	for _, reg := range s.registrations {
+		if reg.UUID == UUID {
+			cis = append(cis, copyRegistration(&reg))
+		}
+	}
+	sort.Slice(cis, func(i, j int) bool {
+		return cis[i].Lastchange.After(cis[j].Lastchange)
+	})
+
+	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
+	return cis, nil
+}
+
+// ListRegistrations retrieves one page of a user's registrations matching the query.
+func (s *MemoryStore) ListRegistrations(IP, UUID string, query RegistrationQuery) ([]*structs.CountryInfoInternal, string, error) {
+	field, err := featureField(query.Feature)
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	var cis []*structs.CountryInfoInternal
+	for _, reg := range s.registrations {
+		if reg.UUID != UUID ||
+			(query.IsoCode != "" && !strings.EqualFold(reg.IsoCode, query.IsoCode)) ||
+			(field != "" && !featureEnabled(reg.Features, field)) ||
+			(!query.ChangedSince.IsZero() && reg.Lastchange.Before(query.ChangedSince)) {
+			continue
+		}
+		cis = append(cis, copyRegistration(&reg))
+	}
+	s.mu.RUnlock()
+
+	// Order newest change first, breaking ties by ID to keep the cursor position stable.
+	sort.Slice(cis, func(i, j int) bool {
+		if !cis[i].Lastchange.Equal(cis[j].Lastchange) {
+			return cis[i].Lastchange.After(cis[j].Lastchange)
+		}
+		return cis[i].ID < cis[j].ID
+	})
+
+	if cursor != nil {
+		position := time.Unix(0, cursor.Lastchange).UTC()
+		start := sort.Search(len(cis), func(i int) bool {
+			return cis[i].Lastchange.Before(position) ||
+				(cis[i].Lastchange.Equal(position) && cis[i].ID > cursor.ID)
+		})
+		cis = cis[start:]
+	}
+
+	next := ""
+	if query.Limit > 0 && len(cis) > query.Limit {
+		cis = cis[:query.Limit]
+		last := cis[len(cis)-1]
+		next = encodeCursor(last.ID, last.Lastchange)
+	}
+
+	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
+	return cis, next, nil
+}
+
+// GetSpecificRegistration retrieves a specific registration document by ID and UUID.
+func (s *MemoryStore) GetSpecificRegistration(IP, ID, UUID string) (*structs.CountryInfoInternal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, reg, ok := s.findRegistration(ID, UUID); ok {
+		log.Printf("%s: Registration document %s retrieved successfully.", IP, reg.ID)
+		return copyRegistration(&reg), nil
+	}
+	return nil, ErrRegistrationNotFound
+}
+
+// PatchRegistration atomically applies 'patch' to a specific registration document by ID and UUID.
+// The store stays locked while 'patch' runs, so concurrent writers are serialized.
+func (s *MemoryStore) PatchRegistration(IP, ID, UUID, ifMatch string, patch RegistrationPatch, revision RevisionOf) (*structs.CountryInfoInternal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docID, current, ok := s.findRegistration(ID, UUID)
+	if !ok {
+		return nil, ErrRegistrationNotFound
+	}
+	if !MatchesETag(ifMatch, current.Lastchange) {
+		return nil, ErrPreconditionFailed
+	}
+
+	updated, err := patch(copyRegistration(&current))
+	if err != nil {
+		return nil, err
+	}
+	reg := copyRegistration(updated)
+	reg.ID = current.ID     // The identity of a registration cannot be patched.
+	reg.UUID = current.UUID // Neither can its owner.
+	reg.Lastchange = nextChange(current.Lastchange)
+	if err := s.addRevision(revision, &current, reg); err != nil {
+		return nil, err
+	}
+	s.registrations[docID] = *reg
+
+	log.Printf("%s: Registration document %s patched successfully.", IP, docID)
+	return copyRegistration(reg), nil
+}
+
+// DeleteRegistration deletes a specific registration document by ID and UUID.
+func (s *MemoryStore) DeleteRegistration(IP, ID, UUID, ifMatch string, revision RevisionOf) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docID, reg, ok := s.findRegistration(ID, UUID)
+	if !ok {
+		return ErrRegistrationNotFound
+	}
+	if !MatchesETag(ifMatch, reg.Lastchange) {
+		return ErrPreconditionFailed
+	}
+	if err := s.addRevision(revision, &reg, nil); err != nil {
+		return err
+	}
+	delete(s.registrations, docID)
+
+	log.Printf("%s: Registration document %s deleted successfully\n", IP, docID)
+	return nil
+}
+
+// findRegistration looks up a registration by ID and UUID. Callers must hold the lock.
+func (s *MemoryStore) findRegistration(ID, UUID string) (string, structs.CountryInfoInternal, bool) {
+	for docID, reg := range s.registrations {
+		if reg.ID == ID && reg.UUID == UUID {
+			return docID, reg, true
+		}
+	}
+	return "", structs.CountryInfoInternal{}, false
+}
+
+// addRevision records the revision built by 'revision' for a change from 'before' to 'after', if any.
+// Callers must hold the lock, and make the change only if recording the revision succeeds.
+func (s *MemoryStore) addRevision(revision RevisionOf, before, after *structs.CountryInfoInternal) error {
+	if revision == nil {
+		return nil
+	}
+	docID, rev := revision(before, after)
+	return s.putRevision(docID, rev)
+}
+
+// putRevision stores a revision under a new document ID. Callers must hold the lock.
+func (s *MemoryStore) putRevision(docID string, revision *structs.RevisionInternal) error {
+	if _, exists := s.revisions[docID]; exists {
+		return fmt.Errorf("revision document %s already exists", docID)
+	}
+	s.revisions[docID] = *copyRevision(revision)
+	return nil
+}
+
+// GetRevisions retrieves all revisions of a registration by ID and UUID, newest first.
+func (s *MemoryStore) GetRevisions(IP, ID, UUID string) ([]structs.RevisionInternal, error) {
+	s.mu.RLock()
+	var revisions []structs.RevisionInternal
+	for _, rev := range s.revisions {
+		if rev.RegistrationID == ID && rev.UUID == UUID {
+			revisions = append(revisions, *copyRevision(&rev))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Timestamp.After(revisions[j].Timestamp)
+	})
+
+	log.Printf("%s: Revisions of registration %s retrieved successfully.", IP, ID)
+	return revisions, nil
+}
+
+// GetSpecificRevision retrieves a specific revision of a registration by revision ID, registration ID and UUID.
+func (s *MemoryStore) GetSpecificRevision(IP, revisionID, ID, UUID string) (*structs.RevisionInternal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rev := range s.revisions {
+		if rev.ID == revisionID && rev.RegistrationID == ID && rev.UUID == UUID {
+			log.Printf("%s: Revision %s of registration %s retrieved successfully.", IP, rev.ID, ID)
+			return copyRevision(&rev), nil
+		}
+	}
+	return nil, ErrRevisionNotFound
+}
+
+// copyRevision returns a copy of a revision that shares no slices with the original.
+func copyRevision(rev *structs.RevisionInternal) *structs.RevisionInternal {
+	c := *rev
+	if rev.Features.TargetCurrencies != nil {
+		c.Features.TargetCurrencies = append([]string(nil), rev.Features.TargetCurrencies...)
+	}
+	if rev.Changes != nil {
+		c.Changes = append([]structs.FeatureChange(nil), rev.Changes...)
+	}
+	return &c
+}
+
+// AddWebhook creates a new webhook entry.
+func (s *MemoryStore) AddWebhook(IP, docID string, webhook *structs.WebhookInternal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook := *webhook
+	hook.Event = append([]string(nil), webhook.Event...)
+	s.webhooks[docID] = hook
+
+	log.Printf("%s: Webhook %s created successfully.", IP, webhook.ID) // Log success.
+	return nil
+}
+
+// GetWebhooksForEvent retrieves the webhooks to notify of an event for a user (UUID) and country.
+func (s *MemoryStore) GetWebhooksForEvent(UUID, country, event string) ([]structs.WebhookInternal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var webhooks []structs.WebhookInternal
+	for _, hook := range s.webhooks {
+		if (hook.UUID == "" || hook.UUID == UUID) && (hook.Country == "" || hook.Country == country) &&
+			slices.Contains(hook.Event, event) {
+			hook.Event = append([]string(nil), hook.Event...)
+			webhooks = append(webhooks, hook)
+		}
+	}
+	return webhooks, nil
+}
+
+// GetWebhooksUser retrieves all webhook entries for a specific user (UUID).
+func (s *MemoryStore) GetWebhooksUser(IP, UUID string) ([]structs.WebhookResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var webhooks []structs.WebhookResponse
+	for _, hook := range s.webhooks {
+		if hook.UUID == UUID {
+			webhooks = append(webhooks, webhookResponse(hook))
+		}
+	}
+
+	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
+	return webhooks, nil
+}
+
+// ListWebhooks retrieves one page of a user's webhooks matching the query.
+func (s *MemoryStore) ListWebhooks(IP, UUID string, query WebhookQuery) ([]structs.WebhookResponse, string, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	var webhooks []structs.WebhookResponse
+	for _, hook := range s.webhooks {
+		if hook.UUID != UUID ||
+			(query.Country != "" && !strings.EqualFold(hook.Country, query.Country)) ||
+			(query.Event != "" && !containsString(hook.Event, strings.ToUpper(query.Event))) ||
+			(cursor != nil && hook.ID <= cursor.ID) {
+			continue
+		}
+		webhooks = append(webhooks, webhookResponse(hook))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	next := ""
+	if query.Limit > 0 && len(webhooks) > query.Limit {
+		webhooks = webhooks[:query.Limit]
+		next = encodeCursor(webhooks[len(webhooks)-1].ID, time.Time{})
+	}
+
+	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
+	return webhooks, next, nil
+}
+
+// GetSpecificWebhook retrieves a specific webhook entry by ID and UUID.
+func (s *MemoryStore) GetSpecificWebhook(IP, ID, UUID string) (*structs.WebhookResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, hook := range s.webhooks {
+		if hook.ID == ID && hook.UUID == UUID {
+			webhook := webhookResponse(hook)
+			log.Printf("%s: Webhook %s retrieved successfully.", IP, webhook.ID) // Log success.
+			return &webhook, nil
+		}
+	}
+	return nil, errors.New("no document with that ID was found")
+}
+
+// DeleteWebhook deletes a specific webhook entry by ID and UUID.
+func (s *MemoryStore) DeleteWebhook(IP, ID, UUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for docID, hook := range s.webhooks {
+		if hook.ID == ID && hook.UUID == UUID {
+			delete(s.webhooks, docID)
+			log.Printf("%s: Webhook %s deleted successfully.", IP, docID) // Log success.
+			return nil
+		}
+	}
+	return fmt.Errorf("ID match was not found")
+}
+
+// AddUser stores a new user, stamping their creation time.
+func (s *MemoryStore) AddUser(user *structs.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+	user.Created = time.Now().UTC()
+	s.users[user.UUID] = *user
+	log.Printf("User %s created successfully.", user.UUID) // Log success.
+	return nil
+}
+
+// GetUser retrieves a user by UUID.
+func (s *MemoryStore) GetUser(UUID string) (*structs.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[UUID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &u, nil
+}
+
+// GetUserByEmail retrieves a user by email.
+func (s *MemoryStore) GetUserByEmail(email string) (*structs.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// GetUsers retrieves every user, in order of registration.
+func (s *MemoryStore) GetUsers() ([]structs.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]structs.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Created.Before(users[j].Created) })
+	return users, nil
+}
+
+// DeleteUser deletes a user by UUID.
+func (s *MemoryStore) DeleteUser(UUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[UUID]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, UUID)
+	log.Printf("User %s deleted successfully.", UUID) // Log success.
+	return nil
+}
+
+// CountUserData counts the API keys, registrations and webhooks owned by each user (UUID).
+func (s *MemoryStore) CountUserData() (map[string]structs.UserCounts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]structs.UserCounts)
+	for _, key := range s.apiKeys {
+		c := counts[key.UUID]
+		c.APIKeys++
+		counts[key.UUID] = c
+	}
+	for _, reg := range s.registrations {
+		c := counts[reg.UUID]
+		c.Registrations++
+		counts[reg.UUID] = c
+	}
+	for _, hook := range s.webhooks {
+		if hook.UUID == "" {
+			continue // Developer webhooks belong to no user.
+		}
+		c := counts[hook.UUID]
+		c.Webhooks++
+		counts[hook.UUID] = c
+	}
+	return counts, nil
+}
+
+// DisableUser disables the account of a user (UUID).
+func (s *MemoryStore) DisableUser(IP, UUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.disabled[UUID]; !ok {
+		s.disabled[UUID] = time.Now().UTC()
+	}
+	log.Printf("%s: Account of user %s disabled.", IP, UUID) // Log success.
+	return nil
+}
+
+// EnableUser re-enables the account of a disabled user (UUID).
+func (s *MemoryStore) EnableUser(IP, UUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.disabled, UUID)
+	log.Printf("%s: Account of user %s enabled.", IP, UUID) // Log success.
+	return nil
+}
+
+// IsUserDisabled reports whether the account of a user (UUID) is disabled.
+func (s *MemoryStore) IsUserDisabled(UUID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.disabled[UUID]
+	return ok, nil
+}
+
+// GetDisabledUsers retrieves every disabled user (UUID) along with the time they were disabled.
+func (s *MemoryStore) GetDisabledUsers() (map[string]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	disabled := make(map[string]time.Time, len(s.disabled))
+	for UUID, at := range s.disabled {
+		disabled[UUID] = at
+	}
+	return disabled, nil
+}
+
+// AddAuditEntry records an administrative action, stamping its time.
+func (s *MemoryStore) AddAuditEntry(entry *structs.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.Time = time.Now().UTC()
+	s.audit = append(s.audit, *entry)
+	return nil
+}
+
+// GetAuditEntries retrieves the recorded administrative actions matching the query, newest first.
+func (s *MemoryStore) GetAuditEntries(query AuditQuery) ([]structs.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []structs.AuditEntry
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < query.Limit; i-- {
+		entry := s.audit[i]
+		if (query.Actor == "" || entry.Actor == query.Actor) && (query.Target == "" || entry.Target == query.Target) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// AddUsage adds the given requests to the usage counters.
+func (s *MemoryStore) AddUsage(usage []structs.Usage) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range usage {
+		counter := s.usage[keyOf(u)]
+		if counter.Count == 0 {
+			counter = u
+		} else {
+			mergeUsage(&counter, u)
+		}
+		s.usage[keyOf(u)] = counter
+	}
+	return len(usage), nil
+}
+
+// GetUsage retrieves the usage counters matching the query, ordered by day, API key, endpoint and status.
+func (s *MemoryStore) GetUsage(query UsageQuery) ([]structs.Usage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var usage []structs.Usage
+	for key, counter := range s.usage {
+		if (query.UUID == "" || key.UUID == query.UUID) && key.Day >= query.From && key.Day <= query.To {
+			usage = append(usage, counter)
+		}
+	}
+	sortUsage(usage)
+	return usage, nil
+}
+
+// copyRegistration returns a deep copy of a registration so callers cannot mutate stored data.
+func copyRegistration(reg *structs.CountryInfoInternal) *structs.CountryInfoInternal {
+	c := *reg
+	if reg.Features.TargetCurrencies != nil {
+		c.Features.TargetCurrencies = append([]string(nil), reg.Features.TargetCurrencies...)
+	}
+	return &c
+}
+
+// featureEnabled reports whether the boolean feature with the given storage field name is enabled.
+func featureEnabled(features structs.Features, field string) bool {
+	switch field {
+	case "Temperature":
+		return features.Temperature
+	case "Precipitation":
+		return features.Precipitation
+	case "Capital":
+		return features.Capital
+	case "Coordinates":
+		return features.Coordinates
+	case "Population":
+		return features.Population
+	case "Area":
+		return features.Area
+	default:
+		return false
+	}
+}
+
+// containsString checks if a string is present in a slice of strings.
+func containsString(list []string, str string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookResponse converts an internal webhook to its external representation.
+func webhookResponse(hook structs.WebhookInternal) structs.WebhookResponse {
+	return structs.WebhookResponse{
+		ID:      hook.ID,
+		URL:     hook.URL,
+		Country: hook.Country,
+		Event:   append([]string(nil), hook.Event...),
+	}
+}
+
+// PendingMigrations reports no migrations, as in-memory data never outlives the build that wrote it.
+func (s *MemoryStore) PendingMigrations() ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+// Migrate does nothing, as in-memory data is always written under the current schema version.
+func (s *MemoryStore) Migrate(dryRun bool) ([]MigrationStatus, error) {
+	return nil, nil
+}
@@ -0,0 +1,227 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"globeboard/internal/utils/constants"
+	"slices"
+	"time"
+)
+
+// Kinds of stored documents, named after their SQL tables.
+const (
+	KindAPIKey       = "api_keys"       // KindAPIKey identifies API key documents.
+	KindRegistration = "registrations"  // KindRegistration identifies registration documents.
+	KindRevision     = "revisions"      // KindRevision identifies registration revision documents.
+	KindWebhook      = "webhooks"       // KindWebhook identifies webhook documents.
+	KindUser         = "users"          // KindUser identifies documents of users of the built-in identity provider.
+	KindDisabledUser = "disabled_users" // KindDisabledUser identifies documents marking a user's account as disabled.
+	KindAuditEntry   = "audit_log"      // KindAuditEntry identifies audit trail documents.
+	KindUsage        = "api_usage"      // KindUsage identifies metered usage counter documents.
+
+	KindSchema = "schema" // KindSchema identifies SQL schema migrations, which change tables rather than documents.
+)
+
+// documentKinds lists every kind of stored document, in the order they are migrated.
+var documentKinds = []string{KindAPIKey, KindRegistration, KindRevision, KindWebhook, KindUser, KindDisabledUser, KindAuditEntry, KindUsage}
+
+// SchemaVersionField is the field of every stored document holding the schema version it was written under.
+// Documents written before schema versioning lack it and are treated as version 0.
+const SchemaVersionField = "SchemaVersion"
+
+// Document is a stored document as a map of its field names, as Firestore stores them, to their values.
+type Document map[string]interface{}
+
+// DocumentMigration upgrades stored documents from the previous schema version to the next.
+type DocumentMigration struct {
+	Description string   // Description of what the migration changes.
+	Kinds       []string // Kinds of documents the migration changes; all kinds if empty.
+	// Upgrade rewrites a document of the given kind in place.
+	Upgrade func(kind string, doc Document) error
+	// UpgradeRows rewrites SQL rows written under an older schema version, for changes that schema migrations
+	// cannot express on their own. Optional; the rows are stamped with the new version afterwards.
+	UpgradeRows func(tx *sql.Tx, rebind func(string) string) error
+}
+
+// affects reports whether the migration changes documents of the given kind.
+func (m DocumentMigration) affects(kind string) bool {
+	return len(m.Kinds) == 0 || slices.Contains(m.Kinds, kind)
+}
+
+// documentMigrations holds the ordered document migrations. A migration's version is its index in the slice
+// plus one; never reorder or edit released entries, only append, and write documents under the latest version.
+var documentMigrations = []DocumentMigration{
+	{
+		Description: "Stamp documents written before schema versioning and fill in missing lists",
+		Upgrade: func(kind string, doc Document) error {
+			switch kind {
+			case KindRegistration:
+				features, ok := doc["Features"].(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("registration %v has no features", doc["ID"])
+				}
+				if features["TargetCurrencies"] == nil {
+					features["TargetCurrencies"] = []interface{}{}
+				}
+			case KindWebhook:
+				if doc["Event"] == nil {
+					doc["Event"] = []interface{}{}
+				}
+				if doc["Country"] == nil {
+					doc["Country"] = ""
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Description: "Replace plaintext API keys with their keyed hash and display prefix",
+		Kinds:       []string{KindAPIKey},
+		Upgrade: func(kind string, doc Document) error {
+			key, ok := doc["APIKey"].(string)
+			if !ok {
+				return nil // Not an API key, or already hashed.
+			}
+			doc["KeyHash"] = HashAPIKey(key)
+			doc["Prefix"] = APIKeyPrefix(key)
+			delete(doc, "APIKey")
+			return nil
+		},
+		UpgradeRows: hashPlaintextAPIKeyRows,
+	},
+	{
+		Description: "Give API keys an ID and a creation time, so a user can hold and manage several",
+		Kinds:       []string{KindAPIKey},
+		Upgrade: func(kind string, doc Document) error {
+			keyHash, ok := doc["KeyHash"].(string)
+			if !ok {
+				return fmt.Errorf("API key %v has no hash", doc["Prefix"])
+			}
+			if doc["ID"] == nil {
+				doc["ID"] = legacyAPIKeyID(keyHash)
+			}
+			if doc["Created"] == nil {
+				doc["Created"] = time.Now().UTC() // The true creation time was never recorded.
+			}
+			return nil
+		},
+		UpgradeRows: identifyAPIKeyRows,
+	},
+	{
+		Description: "Grant API keys created before scopes every scope, as they could do everything",
+		Kinds:       []string{KindAPIKey},
+		Upgrade: func(kind string, doc Document) error {
+			if doc["Scopes"] == nil {
+				scopes := make([]interface{}, len(legacyAPIKeyScopes))
+				for i, scope := range legacyAPIKeyScopes {
+					scopes[i] = scope
+				}
+				doc["Scopes"] = scopes
+			}
+			return nil
+		},
+		UpgradeRows: scopeAPIKeyRows,
+	},
+	{
+		Description: "Grant API keys allowed to read the status the scope to read usage, which reading the status allowed",
+		Kinds:       []string{KindAPIKey},
+		Upgrade: func(kind string, doc Document) error {
+			scopes, _ := doc["Scopes"].([]interface{})
+			if slices.Contains(scopes, interface{}(statusReadScope)) && !slices.Contains(scopes, interface{}(usageReadScope)) {
+				doc["Scopes"] = append(scopes, usageReadScope)
+			}
+			return nil
+		},
+		UpgradeRows: grantUsageScopeRows,
+	},
+}
+
+// legacyAPIKeyScopes are the scopes granted to API keys created before scopes existed. Unlike Scopes.All,
+// it must not change once released, so that the migration upgrades every store the same way.
+var legacyAPIKeyScopes = []string{
+	"registrations:read", "registrations:write", "dashboards:read",
+	"notifications:read", "notifications:write", "status:read",
+}
+
+// Scopes named by the migration splitting reading usage from reading the status. Like legacyAPIKeyScopes,
+// they must not change once released.
+const (
+	statusReadScope = "status:read"
+	usageReadScope  = "usage:read"
+)
+
+// SchemaVersion is the version of the document schema written by this build.
+var SchemaVersion = len(documentMigrations)
+
+// MigrationStatus describes a migration and how much of the store it applies to.
+type MigrationStatus struct {
+	Kind        string `json:"kind"`        // Kind of document migrated, or KindSchema for SQL schema migrations
+	Version     int    `json:"version"`     // Version the migration upgrades to
+	Description string `json:"description"` // Description of what the migration changes
+	Documents   int    `json:"documents"`   // Number of documents the migration upgrades (or upgraded)
+}
+
+// Migrator is implemented by stores whose stored data can be upgraded to the current schema.
+type Migrator interface {
+	// PendingMigrations reports the migrations that still have to be applied to the store.
+	PendingMigrations() ([]MigrationStatus, error)
+	// Migrate applies every pending migration and reports what was applied.
+	// With dryRun, nothing is written and the report describes what would be applied.
+	Migrate(dryRun bool) ([]MigrationStatus, error)
+}
+
+// documentVersion returns the schema version a document was written under.
+func documentVersion(doc Document) int {
+	switch version := doc[SchemaVersionField].(type) {
+	case int64: // Firestore decodes integers as int64.
+		return int(version)
+	case int:
+		return version
+	default:
+		return 0 // Written before schema versioning.
+	}
+}
+
+// upgradeDocument applies every migration newer than the document's version and stamps the current version.
+func upgradeDocument(kind string, doc Document) error {
+	for version := documentVersion(doc); version < SchemaVersion; version++ {
+		if err := documentMigrations[version].Upgrade(kind, doc); err != nil {
+			return fmt.Errorf("error applying document migration %d: %v", version+1, err)
+		}
+	}
+	doc[SchemaVersionField] = SchemaVersion
+	return nil
+}
+
+// countOutdated adds 'n' documents of the given kind and version to the per-migration counts in 'statuses'.
+func countOutdated(statuses map[int]*MigrationStatus, kind string, version, n int) {
+	for v := version + 1; v <= SchemaVersion; v++ {
+		if !documentMigrations[v-1].affects(kind) {
+			continue
+		}
+		status, ok := statuses[v]
+		if !ok {
+			status = &MigrationStatus{Kind: kind, Version: v, Description: documentMigrations[v-1].Description}
+			statuses[v] = status
+		}
+		status.Documents += n
+	}
+}
+
+// sortedStatuses flattens per-migration counts into a list ordered by version.
+func sortedStatuses(statuses map[int]*MigrationStatus) []MigrationStatus {
+	var list []MigrationStatus
+	for v := 1; v <= SchemaVersion; v++ {
+		if status, ok := statuses[v]; ok {
+			list = append(list, *status)
+		}
+	}
+	return list
+}
+
+// legacyAPIKeyID derives the ID of an API key created before keys had one from its hash,
+// so that the same key gets the same ID in every backend.
+func legacyAPIKeyID(keyHash string) string {
+	return keyHash[:min(len(keyHash), constants.IdLength)]
+}
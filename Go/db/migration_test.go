@@ -0,0 +1,192 @@
+package db
+
+import (
+	"fmt"
+	"globeboard/internal/utils/structs"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// openSQLiteAt opens a new SQLite database with only the first 'applied' schema migrations applied,
+// as a store last migrated by an older build would be.
+func openSQLiteAt(t *testing.T, applied int) *SQLStore {
+	t.Helper()
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "globeboard.db"), false)
+	if err != nil {
+		t.Fatal("Failed to open SQLite database:", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	all := sqlMigrations
+	sqlMigrations = all[:applied]
+	defer func() { sqlMigrations = all }()
+	if err := s.migrate(); err != nil {
+		t.Fatal("Failed to apply the older schema migrations:", err)
+	}
+	return s
+}
+
+// withUsageRead returns the scopes with the scope to read usage added.
+func withUsageRead(scopes []string) []string {
+	return append(slices.Clone(scopes), usageReadScope)
+}
+
+// TestSQLMigrate seeds a database as written before schema versioning and checks what the migrations
+// report, that a dry run writes nothing, and the documents they upgrade.
+func TestSQLMigrate(t *testing.T) {
+	applied := slices.Index(sqlMigrations, `ALTER TABLE api_keys ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`)
+	s := openSQLiteAt(t, applied)
+	const UUID, key = "legacy-user", "sk-legacy-api-key"
+	for _, seed := range []string{
+		`INSERT INTO api_keys (doc_id, uuid, api_key) VALUES ('key-doc', '` + UUID + `', '` + key + `')`,
+		`INSERT INTO registrations (doc_id, id, uuid, country, iso_code, capital, lastchange)
+			VALUES ('registration-doc', 'registration', '` + UUID + `', 'Norway', 'NO', TRUE, 1)`,
+		`INSERT INTO webhooks (doc_id, id, uuid, url, events) VALUES ('webhook-doc', 'webhook', '` + UUID + `', 'https://example.com', '["INVOKE"]')`,
+	} {
+		if _, err := s.DB.Exec(seed); err != nil {
+			t.Fatal("Failed to seed the database:", err)
+		}
+	}
+
+	var want []MigrationStatus
+	for version := applied + 1; version <= len(sqlMigrations); version++ {
+		want = append(want, MigrationStatus{Kind: KindSchema, Version: version})
+	}
+	for version := 1; version <= SchemaVersion; version++ {
+		want = append(want, MigrationStatus{Kind: KindAPIKey, Version: version, Documents: 1})
+	}
+	want = append(want, MigrationStatus{Kind: KindRegistration, Version: 1, Documents: 1}, MigrationStatus{Kind: KindWebhook, Version: 1, Documents: 1})
+	checkReport := func(name string, report []MigrationStatus) {
+		t.Helper()
+		if len(report) != len(want) {
+			t.Fatalf("%s reported %d migrations, want %d: %+v", name, len(report), len(want), report)
+		}
+		for i, status := range report {
+			if status.Kind != want[i].Kind || status.Version != want[i].Version || status.Documents != want[i].Documents || status.Description == "" {
+				t.Errorf("%s reported %+v, want %+v", name, status, want[i])
+			}
+		}
+	}
+
+	report, err := s.PendingMigrations()
+	if err != nil {
+		t.Fatal("PendingMigrations failed:", err)
+	}
+	checkReport("PendingMigrations", report)
+
+	// A dry run reports the same migrations without applying any.
+	if report, err = s.Migrate(true); err != nil {
+		t.Fatal("Migrate(dryRun) failed:", err)
+	}
+	checkReport("Migrate(dryRun)", report)
+	if version, err := s.appliedSchemaVersion(); err != nil || version != applied {
+		t.Errorf("dry run left the schema at version %d (%v), want %d", version, err, applied)
+	}
+
+	if report, err = s.Migrate(false); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	checkReport("Migrate", report)
+	if report, err = s.PendingMigrations(); err != nil || len(report) != 0 {
+		t.Errorf("PendingMigrations after migrating reported %+v (%v), want none", report, err)
+	}
+
+	// The plaintext key is hashed, identified and given every scope it could use, including reading usage.
+	apiKey := s.GetAPIKey("", key)
+	if apiKey == nil {
+		t.Fatal("migrated API key was not found by its value")
+	}
+	if apiKey.UUID != UUID || apiKey.ID != legacyAPIKeyID(HashAPIKey(key)) || apiKey.Prefix != APIKeyPrefix(key) || apiKey.Created.IsZero() {
+		t.Errorf("migrated API key = %+v", apiKey)
+	}
+	if scopes := withUsageRead(legacyAPIKeyScopes); !slices.Equal(apiKey.Scopes, scopes) {
+		t.Errorf("migrated API key has scopes %v, want %v", apiKey.Scopes, scopes)
+	}
+	var plaintext int
+	if err := s.DB.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE key_hash = ?`, key).Scan(&plaintext); err != nil || plaintext != 0 {
+		t.Errorf("%d API keys (%v) are still stored in plaintext", plaintext, err)
+	}
+
+	if reg, err := s.GetSpecificRegistration("", "registration", UUID); err != nil || reg.Country != "Norway" || !reg.Features.Capital {
+		t.Errorf("migrated registration = %+v (%v)", reg, err)
+	}
+	if webhooks, err := s.GetWebhooksForEvent(UUID, "NO", "INVOKE"); err != nil || len(webhooks) != 1 {
+		t.Errorf("migrated webhooks = %+v (%v), want 1", webhooks, err)
+	}
+}
+
+// TestSQLMigrateUsageScope checks that keys written under schema version 4 are granted the scope to read usage
+// only if they may read the status.
+func TestSQLMigrateUsageScope(t *testing.T) {
+	s := openSQLiteAt(t, len(sqlMigrations))
+	scopes := map[string][]string{
+		"status":        {"registrations:read", statusReadScope},
+		"registrations": {"registrations:read"},
+	}
+	for name, keyScopes := range scopes {
+		apiKey := &structs.APIKey{ID: name, UUID: "user", Scopes: keyScopes}
+		if err := s.AddApiKey("", name, apiKey, "sk-"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.DB.Exec(`UPDATE api_keys SET schema_version = 4`); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Migrate(false)
+	if err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	if len(report) != 1 || report[0].Kind != KindAPIKey || report[0].Version != 5 || report[0].Documents != 2 {
+		t.Errorf("Migrate reported %+v, want migration 5 of 2 API keys", report)
+	}
+
+	for name, want := range map[string][]string{"status": withUsageRead(scopes["status"]), "registrations": scopes["registrations"]} {
+		if apiKey := s.GetAPIKey("", "sk-"+name); apiKey == nil || !slices.Equal(apiKey.Scopes, want) {
+			t.Errorf("API key %s migrated to %+v, want scopes %v", name, apiKey, want)
+		}
+	}
+}
+
+// TestUpgradeDocument checks the upgrade of Firestore documents written under older schema versions.
+func TestUpgradeDocument(t *testing.T) {
+	const key = "sk-legacy-api-key"
+	doc := Document{"UUID": "legacy-user", "APIKey": key}
+	if err := upgradeDocument(KindAPIKey, doc); err != nil {
+		t.Fatal("upgradeDocument failed:", err)
+	}
+	if doc["APIKey"] != nil || doc["KeyHash"] != HashAPIKey(key) || doc["Prefix"] != APIKeyPrefix(key) ||
+		doc["ID"] != legacyAPIKeyID(HashAPIKey(key)) || doc["Created"] == nil || documentVersion(doc) != SchemaVersion {
+		t.Errorf("upgraded API key = %v", doc)
+	}
+	if scopes := fmt.Sprint(doc["Scopes"]); scopes != fmt.Sprint(withUsageRead(legacyAPIKeyScopes)) {
+		t.Errorf("upgraded API key has scopes %s, want %v", scopes, withUsageRead(legacyAPIKeyScopes))
+	}
+
+	// Firestore decodes the version of documents written since versioning as int64.
+	for _, scopes := range [][]interface{}{{"registrations:read", statusReadScope}, {"registrations:read"}} {
+		doc := Document{"KeyHash": "hash", "ID": "id", "Created": 1, "Scopes": slices.Clone(scopes), SchemaVersionField: int64(4)}
+		if err := upgradeDocument(KindAPIKey, doc); err != nil {
+			t.Fatal("upgradeDocument failed:", err)
+		}
+		want := scopes
+		if slices.Contains(scopes, interface{}(statusReadScope)) {
+			want = append(slices.Clone(scopes), usageReadScope)
+		}
+		if fmt.Sprint(doc["Scopes"]) != fmt.Sprint(want) {
+			t.Errorf("upgraded API key with scopes %v has scopes %v, want %v", scopes, doc["Scopes"], want)
+		}
+	}
+
+	// Each kind is counted only against the migrations affecting it.
+	registrations, apiKeys := make(map[int]*MigrationStatus), make(map[int]*MigrationStatus)
+	countOutdated(registrations, KindRegistration, 0, 3)
+	countOutdated(apiKeys, KindAPIKey, 3, 2)
+	if report := sortedStatuses(registrations); len(report) != 1 || report[0].Version != 1 || report[0].Documents != 3 {
+		t.Errorf("countOutdated reported %+v for registrations, want migration 1 of 3", report)
+	}
+	if report := sortedStatuses(apiKeys); len(report) != 2 || report[0].Version != 4 || report[1].Version != 5 || report[1].Documents != 2 {
+		t.Errorf("countOutdated reported %+v for API keys, want migrations 4 and 5 of 2", report)
+	}
+}
@@ -0,0 +1,105 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+	// ErrInvalidFilter is returned when a listing filter is not supported.
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// registrationFeatureFields maps the feature names accepted by RegistrationQuery.Feature
+// to the field names used in storage.
+var registrationFeatureFields = map[string]string{
+	"temperature":   "Temperature",
+	"precipitation": "Precipitation",
+	"capital":       "Capital",
+	"coordinates":   "Coordinates",
+	"population":    "Population",
+	"area":          "Area",
+}
+
+// RegistrationQuery describes a filtered, paginated listing of a user's registrations.
+// Results are ordered by last change, newest first.
+type RegistrationQuery struct {
+	Limit        int       // Maximum number of registrations to return.
+	Cursor       string    // Opaque cursor returned by a previous page, empty for the first page.
+	IsoCode      string    // Only return registrations for this ISO code, if set.
+	Feature      string    // Only return registrations with this boolean feature enabled, if set.
+	ChangedSince time.Time // Only return registrations changed at or after this time, if set.
+}
+
+// WebhookQuery describes a filtered, paginated listing of a user's webhooks, ordered by ID.
+type WebhookQuery struct {
+	Limit   int    // Maximum number of webhooks to return.
+	Cursor  string // Opaque cursor returned by a previous page, empty for the first page.
+	Country string // Only return webhooks registered for this ISO code, if set.
+	Event   string // Only return webhooks triggered by this event, if set.
+}
+
+// AuditQuery describes a filtered listing of the audit trail, ordered by time, newest first.
+type AuditQuery struct {
+	Limit  int    // Maximum number of entries to return.
+	Actor  string // Only return actions taken by this administrator (UUID), if set.
+	Target string // Only return actions taken on this target, if set.
+}
+
+// pageCursor is the decoded form of a pagination cursor.
+type pageCursor struct {
+	Lastchange int64  `json:"t,omitempty"` // Last change (Unix nanoseconds) of the final item on the previous page.
+	ID         string `json:"id"`          // ID of the final item on the previous page.
+}
+
+// encodeCursor encodes the position after the given item as an opaque cursor.
+func encodeCursor(ID string, lastchange time.Time) string {
+	c := pageCursor{ID: ID}
+	if !lastchange.IsZero() {
+		c.Lastchange = lastchange.UnixNano()
+	}
+	data, _ := json.Marshal(c) // Marshalling a flat struct cannot fail.
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes an opaque cursor, returning nil for an empty cursor.
+func decodeCursor(cursor string) (*pageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// featureField resolves a RegistrationQuery feature name to its storage field name.
+func featureField(feature string) (string, error) {
+	if feature == "" {
+		return "", nil
+	}
+	field, ok := registrationFeatureFields[strings.ToLower(feature)]
+	if !ok {
+		return "", fmt.Errorf("%w: unsupported feature %q", ErrInvalidFilter, feature)
+	}
+	return field, nil
+}
+
+// UsageQuery describes a filtered listing of metered usage over a range of days, both given as YYYY-MM-DD.
+type UsageQuery struct {
+	UUID string // Only return the usage of this user, if set.
+	From string // The first day to return.
+	To   string // The last day to return.
+}
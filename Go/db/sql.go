@@ -0,0 +1,1483 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the "pgx" PostgreSQL driver.
+	_ "modernc.org/sqlite"             // Registers the "sqlite" driver.
+)
+
+const (
+	dialectSQLite   = "sqlite"   // dialectSQLite identifies the SQLite SQL dialect.
+	dialectPostgres = "postgres" // dialectPostgres identifies the PostgreSQL SQL dialect.
+)
+
+// sqlMigrations holds the ordered schema migrations for the SQL backends.
+// A migration's version is its index in the slice plus one; never reorder or edit applied entries, only append.
+var sqlMigrations = []string{
+	`CREATE TABLE api_keys (
+		doc_id  TEXT PRIMARY KEY,
+		uuid    TEXT NOT NULL,
+		api_key TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE INDEX api_keys_uuid ON api_keys (uuid)`,
+	`CREATE TABLE registrations (
+		doc_id            TEXT PRIMARY KEY,
+		id                TEXT NOT NULL,
+		uuid              TEXT NOT NULL,
+		country           TEXT NOT NULL,
+		iso_code          TEXT NOT NULL,
+		temperature       BOOLEAN NOT NULL DEFAULT FALSE,
+		precipitation     BOOLEAN NOT NULL DEFAULT FALSE,
+		capital           BOOLEAN NOT NULL DEFAULT FALSE,
+		coordinates       BOOLEAN NOT NULL DEFAULT FALSE,
+		population        BOOLEAN NOT NULL DEFAULT FALSE,
+		area              BOOLEAN NOT NULL DEFAULT FALSE,
+		target_currencies TEXT NOT NULL DEFAULT '[]',
+		lastchange        BIGINT NOT NULL
+	)`,
+	`CREATE INDEX registrations_uuid_lastchange ON registrations (uuid, lastchange)`,
+	`CREATE UNIQUE INDEX registrations_id_uuid ON registrations (id, uuid)`,
+	`CREATE TABLE webhooks (
+		doc_id  TEXT PRIMARY KEY,
+		id      TEXT NOT NULL,
+		uuid    TEXT NOT NULL,
+		url     TEXT NOT NULL,
+		country TEXT NOT NULL DEFAULT '',
+		events  TEXT NOT NULL DEFAULT '[]'
+	)`,
+	`CREATE INDEX webhooks_uuid ON webhooks (uuid)`,
+	`CREATE TABLE connectivity (
+		id           TEXT PRIMARY KEY,
+		psa          TEXT NOT NULL,
+		last_checked BIGINT NOT NULL
+	)`,
+	`CREATE TABLE revisions (
+		doc_id          TEXT PRIMARY KEY,
+		id              TEXT NOT NULL,
+		registration_id TEXT NOT NULL,
+		uuid            TEXT NOT NULL,
+		event           TEXT NOT NULL,
+		caller          TEXT NOT NULL DEFAULT '',
+		ip              TEXT NOT NULL DEFAULT '',
+		country         TEXT NOT NULL,
+		iso_code        TEXT NOT NULL,
+		features        TEXT NOT NULL,
+		changes         TEXT NOT NULL DEFAULT '[]',
+		restored_from   TEXT NOT NULL DEFAULT '',
+		created         BIGINT NOT NULL
+	)`,
+	`CREATE INDEX revisions_registration ON revisions (uuid, registration_id, created)`,
+	`ALTER TABLE api_keys ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE registrations ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE revisions ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE webhooks ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE api_keys RENAME COLUMN api_key TO key_hash`,
+	`ALTER TABLE api_keys ADD COLUMN prefix TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE api_keys ADD COLUMN id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE api_keys ADD COLUMN name TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE api_keys ADD COLUMN created BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE api_keys ADD COLUMN expires BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE api_keys ADD COLUMN last_used BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX api_keys_uuid_id ON api_keys (uuid, id)`,
+	`ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT '[]'`,
+	`CREATE TABLE users (
+		uuid           TEXT PRIMARY KEY,
+		email          TEXT NOT NULL UNIQUE,
+		display_name   TEXT NOT NULL DEFAULT '',
+		password_hash  TEXT NOT NULL,
+		created        BIGINT NOT NULL,
+		schema_version INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE disabled_users (
+		uuid           TEXT PRIMARY KEY,
+		disabled       BIGINT NOT NULL,
+		schema_version INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE audit_log (
+		id             TEXT PRIMARY KEY,
+		created        BIGINT NOT NULL,
+		actor          TEXT NOT NULL,
+		ip             TEXT NOT NULL DEFAULT '',
+		action         TEXT NOT NULL,
+		target         TEXT NOT NULL DEFAULT '',
+		details        TEXT NOT NULL DEFAULT '',
+		schema_version INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX audit_log_created ON audit_log (created)`,
+	`CREATE TABLE api_usage (
+		day              TEXT NOT NULL,
+		uuid             TEXT NOT NULL,
+		key_id           TEXT NOT NULL,
+		endpoint         TEXT NOT NULL,
+		status           INTEGER NOT NULL,
+		count            BIGINT NOT NULL,
+		total_latency_ms DOUBLE PRECISION NOT NULL,
+		max_latency_ms   DOUBLE PRECISION NOT NULL,
+		schema_version   INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, uuid, key_id, endpoint, status)
+	)`,
+	`CREATE INDEX api_usage_uuid_day ON api_usage (uuid, day)`,
+}
+
+// SQLStore is a Store backed by a SQL database, either SQLite or PostgreSQL.
+type SQLStore struct {
+	DB      *sql.DB // Database handle used for all operations.
+	dialect string  // SQL dialect of the connected database.
+}
+
+var _ Store = (*SQLStore)(nil) // Ensure SQLStore implements Store.
+
+// NewSQLiteStore opens (creating if needed) the SQLite database at 'path', applying pending schema migrations
+// if 'migrate' is set.
+func NewSQLiteStore(path string, migrate bool) (*SQLStore, error) {
+	// Enable foreign keys, WAL for concurrent readers and wait on locks instead of failing immediately.
+	dsn := path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	if strings.Contains(path, "?") {
+		dsn = path
+	}
+	database, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	database.SetMaxOpenConns(1) // SQLite allows a single writer; serialize access through one connection.
+	return newSQLStore(database, dialectSQLite, migrate)
+}
+
+// NewPostgresStore connects to the PostgreSQL database described by 'dsn', applying pending schema migrations
+// if 'migrate' is set.
+func NewPostgresStore(dsn string, migrate bool) (*SQLStore, error) {
+	database, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLStore(database, dialectPostgres, migrate)
+}
+
+// newSQLStore verifies the connection and, if 'migrate' is set, brings the schema up to date.
+func newSQLStore(database *sql.DB, dialect string, migrate bool) (*SQLStore, error) {
+	s := &SQLStore{DB: database, dialect: dialect}
+	if err := database.PingContext(ctx); err != nil {
+		_ = database.Close()
+		return nil, fmt.Errorf("error connecting to %s database: %v", dialect, err)
+	}
+	if !migrate {
+		return s, nil
+	}
+	if _, err := s.Migrate(false); err != nil {
+		_ = database.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// appliedSchemaVersion returns the version of the last schema migration recorded in the schema_migrations table.
+func (s *SQLStore) appliedSchemaVersion() (int, error) {
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return 0, fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+
+	var current int
+	err = s.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return current, nil
+}
+
+// migrate applies every schema migration that has not yet been recorded in the schema_migrations table.
+func (s *SQLStore) migrate() error {
+	current, err := s.appliedSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := current; i < len(sqlMigrations); i++ {
+		version := i + 1
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqlMigrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error applying schema migration %d: %v", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+			version, time.Now().UTC().UnixNano()); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error recording schema migration %d: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied %s schema migration %d.", s.dialect, version)
+	}
+	return nil
+}
+
+// rebind converts '?' placeholders into the placeholder style of the connected dialect.
+func (s *SQLStore) rebind(query string) string {
+	if s.dialect != dialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// exec runs a statement written with '?' placeholders.
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.DB.ExecContext(ctx, s.rebind(query), args...)
+}
+
+// query runs a query written with '?' placeholders.
+func (s *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.DB.QueryContext(ctx, s.rebind(query), args...)
+}
+
+// inTx runs 'fn' inside a transaction, committing if it succeeds and rolling back otherwise.
+// With SQLite's single connection, every statement in 'fn' must go through the transaction.
+func (s *SQLStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlWriteAttempts is how many times a registration write without an If-Match condition is attempted when other
+// writers keep changing the row between reading and writing it.
+const sqlWriteAttempts = 3
+
+// errRowChanged is returned by a conditional write transaction when another writer changed the row after it was read.
+var errRowChanged = errors.New("row changed after it was read")
+
+// inConditionalTx runs fn in a transaction writing a row only if it is still as read. Should another writer change
+// the row in between, a write conditioned on an If-Match header fails the precondition, and a write without one is
+// retried on the row as changed, failing with ErrConflict once the attempts run out.
+func (s *SQLStore) inConditionalTx(ifMatch string, fn func(tx *sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.inTx(fn)
+		switch {
+		case !errors.Is(err, errRowChanged):
+			return err
+		case ifMatch != "":
+			return ErrPreconditionFailed
+		case attempt == sqlWriteAttempts:
+			return ErrConflict
+		}
+	}
+}
+
+// Close closes the underlying database handle.
+func (s *SQLStore) Close() error {
+	return s.DB.Close()
+}
+
+// TestDBConnection tests the database connection by writing a connectivity row, mapping failures to HTTP statuses.
+func (s *SQLStore) TestDBConnection() string {
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(c, s.rebind(`INSERT INTO connectivity (id, psa, last_checked) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_checked = excluded.last_checked`),
+		"DB_Connection_Test", "DO NOT DELETE THIS ROW!", time.Now().UTC().UnixNano())
+
+	switch {
+	case err == nil:
+		return fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK))
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Sprintf("%d %s", http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout))
+	case errors.Is(err, context.Canceled):
+		return fmt.Sprintf("%d %s", http.StatusRequestTimeout, http.StatusText(http.StatusRequestTimeout))
+	default:
+		log.Printf("Error testing %s connection: %v", s.dialect, err)
+		return fmt.Sprintf("%d %s", http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
+	}
+}
+
+// DeleteUserData deletes every API key, registration, revision, webhook, disabled marker and usage counter owned by
+// a user (UUID) in one transaction.
+func (s *SQLStore) DeleteUserData(IP, UUID string) error {
+	var deleted int64 // Number of rows deleted across all tables.
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, table := range []string{"api_keys", "registrations", "revisions", "webhooks", "disabled_users", "api_usage"} {
+			res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM `+table+` WHERE uuid = ?`), UUID)
+			if err != nil {
+				return fmt.Errorf("failed to delete %s: %v", table, err)
+			}
+			n, _ := res.RowsAffected()
+			deleted += n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: Deleted %d documents owned by user: %s.", IP, deleted, UUID)
+	return nil
+}
+
+// apiKeyColumns lists the API key columns in the order scanned by scanAPIKey.
+const apiKeyColumns = `id, uuid, name, scopes, key_hash, prefix, created, expires, last_used`
+
+// scanAPIKey reads an API key row selected with apiKeyColumns. Unset times are stored as 0.
+func scanAPIKey(row rowScanner) (structs.APIKey, error) {
+	var key structs.APIKey
+	var scopes string
+	var created, expires, lastUsed int64
+	err := row.Scan(&key.ID, &key.UUID, &key.Name, &scopes, &key.KeyHash, &key.Prefix, &created, &expires, &lastUsed)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return key, fmt.Errorf("error parsing API key scopes: %v", err)
+	}
+	key.Created = time.Unix(0, created).UTC()
+	key.Expires = optionalTime(expires)
+	key.LastUsed = optionalTime(lastUsed)
+	return key, nil
+}
+
+// optionalTime converts a stored unix-nanosecond time to a time, or nil if it is unset (0).
+func optionalTime(nanos int64) *time.Time {
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos).UTC()
+	return &t
+}
+
+// AddApiKey stores the metadata of a new API key along with the hash of the key itself, stamping its creation time.
+func (s *SQLStore) AddApiKey(IP, docID string, apiKey *structs.APIKey, key string) error {
+	apiKey.KeyHash = HashAPIKey(key)
+	apiKey.Prefix = APIKeyPrefix(key)
+	apiKey.Created = time.Now().UTC()
+	var expires int64
+	if apiKey.Expires != nil {
+		expires = apiKey.Expires.UnixNano()
+	}
+
+	_, err := s.exec(`INSERT INTO api_keys (doc_id, id, uuid, name, scopes, key_hash, prefix, created, expires, schema_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		docID, apiKey.ID, apiKey.UUID, apiKey.Name, encodeStrings(apiKey.Scopes), apiKey.KeyHash, apiKey.Prefix,
+		apiKey.Created.UnixNano(), expires, SchemaVersion)
+	if err != nil {
+		return fmt.Errorf("error saving API key to Database: %v", err)
+	}
+
+	log.Printf("%s: API key %s created successfully.", IP, apiKey.Prefix) // Log success.
+	return nil
+}
+
+// DeleteApiKey deletes an API key based on UUID and key value.
+func (s *SQLStore) DeleteApiKey(IP, UUID, apiKey string) error {
+	res, err := s.exec(`DELETE FROM api_keys WHERE uuid = ? AND key_hash = ?`, UUID, HashAPIKey(apiKey))
+	if err != nil {
+		return fmt.Errorf("failed to delete API Key: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("API key not found") // Return error if no row was deleted.
+	}
+
+	log.Printf("%s: API key %s deleted successfully.", IP, APIKeyPrefix(apiKey)) // Log success.
+	return nil
+}
+
+// DeleteApiKeyByID deletes an API key based on its ID and UUID.
+func (s *SQLStore) DeleteApiKeyByID(IP, ID, UUID string) error {
+	res, err := s.exec(`DELETE FROM api_keys WHERE uuid = ? AND id = ?`, UUID, ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API Key: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("API key not found") // Return error if no row was deleted.
+	}
+
+	log.Printf("%s: API key %s deleted successfully.", IP, ID) // Log success.
+	return nil
+}
+
+// ExpireApiKey sets the time at which an API key, identified by its ID and UUID, stops being accepted.
+func (s *SQLStore) ExpireApiKey(IP, ID, UUID string, expires time.Time) error {
+	res, err := s.exec(`UPDATE api_keys SET expires = ? WHERE uuid = ? AND id = ?`, expires.UnixNano(), UUID, ID)
+	if err != nil {
+		return fmt.Errorf("failed to update API Key: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("API key not found") // Return error if no row was updated.
+	}
+
+	log.Printf("%s: API key %s set to expire at %s.", IP, ID, expires.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// GetAPIKey retrieves a specific, unexpired API key, updating its last-used time.
+func (s *SQLStore) GetAPIKey(IP, apiKey string) *structs.APIKey {
+	row := s.DB.QueryRowContext(ctx, s.rebind(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`), HashAPIKey(apiKey))
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("%s: Error retrieving API key: %v", IP, err)
+		}
+		return nil // Return nil if the key is unknown or the lookup fails.
+	}
+
+	now := time.Now().UTC()
+	if apiKeyExpired(key, now) {
+		log.Printf("%s: Rejected expired API key: %s.", IP, key.Prefix)
+		return nil
+	}
+	if apiKeyUsageStale(key, now) {
+		if _, err := s.exec(`UPDATE api_keys SET last_used = ? WHERE key_hash = ?`, now.UnixNano(), key.KeyHash); err != nil {
+			log.Printf("%s: Error recording API key use: %v", IP, err) // Not fatal; the key is still valid.
+		}
+	}
+
+	log.Printf("%s: UUID: %s successfully retrieved from API key: %s.", IP, key.UUID, key.Prefix)
+	return &key
+}
+
+// GetAPIKeyUUID retrieves the UUID associated with a specific, unexpired API key, updating its last-used time.
+func (s *SQLStore) GetAPIKeyUUID(IP, apiKey string) string {
+	return apiKeyOwner(s.GetAPIKey(IP, apiKey))
+}
+
+// GetAPIKeys retrieves all API keys belonging to a given user (UUID), expired ones included.
+func (s *SQLStore) GetAPIKeys(IP, UUID string) ([]structs.APIKey, error) {
+	rows, err := s.query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE uuid = ?`, UUID)
+	if err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+	defer closeRows(rows)
+
+	var keys []structs.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	log.Printf("%s: Successfully retrieved %d API keys for user: %s.", IP, len(keys), UUID)
+	return keys, nil
+}
+
+// registrationColumns lists the registration columns in the order scanned by scanRegistration.
+const registrationColumns = `id, uuid, country, iso_code, temperature, precipitation, capital, coordinates,
+	population, area, target_currencies, lastchange`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRegistration reads a registration row selected with registrationColumns.
+func scanRegistration(row rowScanner) (*structs.CountryInfoInternal, error) {
+	var ci structs.CountryInfoInternal
+	var currencies string
+	var lastchange int64
+	err := row.Scan(&ci.ID, &ci.UUID, &ci.Country, &ci.IsoCode,
+		&ci.Features.Temperature, &ci.Features.Precipitation, &ci.Features.Capital, &ci.Features.Coordinates,
+		&ci.Features.Population, &ci.Features.Area, &currencies, &lastchange)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(currencies), &ci.Features.TargetCurrencies); err != nil {
+		return nil, fmt.Errorf("error parsing target currencies: %v", err)
+	}
+	ci.Lastchange = time.Unix(0, lastchange).UTC()
+	return &ci, nil
+}
+
+// encodeStrings serializes a string slice to JSON for storage in a TEXT column.
+func encodeStrings(list []string) string {
+	if list == nil {
+		list = []string{}
+	}
+	data, _ := json.Marshal(list) // Marshalling a string slice cannot fail.
+	return string(data)
+}
+
+// AddRegistration adds a new registration row, stamping it with the current server time.
+func (s *SQLStore) AddRegistration(IP, docID string, data *structs.CountryInfoInternal, revision RevisionOf) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		added := copyRegistration(data)
+		added.Lastchange = time.Now().UTC() // Use the server time to record last change.
+		_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO registrations (doc_id, schema_version, `+registrationColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			docID, SchemaVersion, added.ID, added.UUID, added.Country, added.IsoCode,
+			added.Features.Temperature, added.Features.Precipitation, added.Features.Capital, added.Features.Coordinates,
+			added.Features.Population, added.Features.Area, encodeStrings(added.Features.TargetCurrencies),
+			added.Lastchange.UnixNano())
+		if err != nil {
+			return err
+		}
+		return s.addRevision(tx, revision, nil, added)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: Registration documents %s created successfully.", IP, data.ID)
+	return nil
+}
+
+// RestoreRegistration adds a deleted registration back under its original ID, unless a registration has that ID.
+// The unique index on the ID and owner of registrations makes concurrent restores conflict.
+func (s *SQLStore) RestoreRegistration(IP string, data *structs.CountryInfoInternal, revision RevisionOf) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		restored := copyRegistration(data)
+		restored.Lastchange = time.Now().UTC() // Use the server time to record last change.
+		res, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO registrations (doc_id, schema_version, `+registrationColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`),
+			restored.ID, SchemaVersion, restored.ID, restored.UUID, restored.Country, restored.IsoCode,
+			restored.Features.Temperature, restored.Features.Precipitation, restored.Features.Capital, restored.Features.Coordinates,
+			restored.Features.Population, restored.Features.Area, encodeStrings(restored.Features.TargetCurrencies),
+			restored.Lastchange.UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrRegistrationExists
+		}
+		return s.addRevision(tx, revision, nil, restored)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: Registration document %s restored successfully.", IP, data.ID)
+	return nil
+}
+
+// GetRegistrations retrieves all registrations for a given user (UUID), newest change first.
+func (s *SQLStore) GetRegistrations(IP, UUID string) ([]*structs.CountryInfoInternal, error) {
+	rows, err := s.query(`SELECT `+registrationColumns+` FROM registrations WHERE uuid = ? ORDER BY lastchange DESC`, UUID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	var cis []*structs.CountryInfoInternal
+	for rows.Next() {
+		ci, err := scanRegistration(rows)
+		if err != nil {
+			return nil, err
+		}
+		cis = append(cis, ci)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
+	return cis, nil
+}
+
+// ListRegistrations retrieves one page of a user's registrations matching the query.
+func (s *SQLStore) ListRegistrations(IP, UUID string, query RegistrationQuery) ([]*structs.CountryInfoInternal, string, error) {
+	field, err := featureField(query.Feature)
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := []string{"uuid = ?"}
+	args := []interface{}{UUID}
+	if query.IsoCode != "" {
+		where = append(where, "iso_code = ?")
+		args = append(args, strings.ToUpper(query.IsoCode))
+	}
+	if field != "" {
+		where = append(where, strings.ToLower(field)+" = ?") // Column names match the lower-cased field names.
+		args = append(args, true)
+	}
+	if !query.ChangedSince.IsZero() {
+		where = append(where, "lastchange >= ?")
+		args = append(args, query.ChangedSince.UnixNano())
+	}
+	if cursor != nil {
+		where = append(where, "(lastchange < ? OR (lastchange = ? AND id > ?))")
+		args = append(args, cursor.Lastchange, cursor.Lastchange, cursor.ID)
+	}
+	statement := `SELECT ` + registrationColumns + ` FROM registrations WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY lastchange DESC, id ASC`
+	if query.Limit > 0 {
+		statement += ` LIMIT ` + strconv.Itoa(query.Limit+1) // Fetch one extra row to detect a following page.
+	}
+
+	rows, err := s.query(statement, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer closeRows(rows)
+
+	var cis []*structs.CountryInfoInternal
+	for rows.Next() {
+		ci, err := scanRegistration(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		cis = append(cis, ci)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf(IterationFailed, err)
+	}
+
+	next := ""
+	if query.Limit > 0 && len(cis) > query.Limit {
+		cis = cis[:query.Limit]
+		last := cis[len(cis)-1]
+		next = encodeCursor(last.ID, last.Lastchange)
+	}
+
+	log.Printf("%s: Registration documents for user: %s retrieved successfully.", IP, UUID)
+	return cis, next, nil
+}
+
+// GetSpecificRegistration retrieves a specific registration by ID and UUID.
+func (s *SQLStore) GetSpecificRegistration(IP, ID, UUID string) (*structs.CountryInfoInternal, error) {
+	row := s.DB.QueryRowContext(ctx, s.rebind(`SELECT `+registrationColumns+` FROM registrations WHERE id = ? AND uuid = ?`), ID, UUID)
+	ci, err := scanRegistration(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRegistrationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s: Registration document %s retrieved successfully.", IP, ci.ID)
+	return ci, nil
+}
+
+// PatchRegistration atomically applies 'patch' to a specific registration by ID and UUID.
+// The update is conditional on the last change time read, see inConditionalTx for how concurrent writers are handled.
+func (s *SQLStore) PatchRegistration(IP, ID, UUID, ifMatch string, patch RegistrationPatch, revision RevisionOf) (*structs.CountryInfoInternal, error) {
+	var updated *structs.CountryInfoInternal
+	err := s.inConditionalTx(ifMatch, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, s.rebind(`SELECT `+registrationColumns+` FROM registrations WHERE id = ? AND uuid = ?`), ID, UUID)
+		current, err := scanRegistration(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRegistrationNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !MatchesETag(ifMatch, current.Lastchange) {
+			return ErrPreconditionFailed
+		}
+
+		data, err := patch(current)
+		if err != nil {
+			return err
+		}
+		lastchange := nextChange(current.Lastchange)
+		res, err := tx.ExecContext(ctx, s.rebind(`UPDATE registrations SET country = ?, iso_code = ?, temperature = ?,
+			precipitation = ?, capital = ?, coordinates = ?, population = ?, area = ?, target_currencies = ?, lastchange = ?,
+			schema_version = ? WHERE id = ? AND uuid = ? AND lastchange = ?`),
+			data.Country, data.IsoCode, data.Features.Temperature, data.Features.Precipitation,
+			data.Features.Capital, data.Features.Coordinates, data.Features.Population, data.Features.Area,
+			encodeStrings(data.Features.TargetCurrencies), lastchange.UnixNano(), SchemaVersion, ID, UUID,
+			current.Lastchange.UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errRowChanged
+		}
+
+		updated = copyRegistration(data)
+		updated.ID, updated.UUID, updated.Lastchange = current.ID, current.UUID, lastchange
+		return s.addRevision(tx, revision, current, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s: Registration document %s patched successfully.", IP, ID)
+	return updated, nil
+}
+
+// DeleteRegistration deletes a specific registration by ID and UUID.
+func (s *SQLStore) DeleteRegistration(IP, ID, UUID, ifMatch string, revision RevisionOf) error {
+	err := s.inConditionalTx(ifMatch, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, s.rebind(`SELECT `+registrationColumns+` FROM registrations WHERE id = ? AND uuid = ?`), ID, UUID)
+		current, err := scanRegistration(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRegistrationNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !MatchesETag(ifMatch, current.Lastchange) {
+			return ErrPreconditionFailed
+		}
+
+		res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM registrations WHERE id = ? AND uuid = ? AND lastchange = ?`),
+			ID, UUID, current.Lastchange.UnixNano())
+		if err != nil {
+			return fmt.Errorf("failed to delete document: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errRowChanged
+		}
+		return s.addRevision(tx, revision, current, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: Registration document %s deleted successfully\n", IP, ID)
+	return nil
+}
+
+// revisionColumns lists the revision columns in the order scanned by scanRevision.
+const revisionColumns = `id, registration_id, uuid, event, caller, ip, country, iso_code, features, changes, restored_from, created`
+
+// scanRevision reads a revision row selected with revisionColumns.
+func scanRevision(row rowScanner) (*structs.RevisionInternal, error) {
+	var rev structs.RevisionInternal
+	var features, changes string
+	var created int64
+	err := row.Scan(&rev.ID, &rev.RegistrationID, &rev.UUID, &rev.Event, &rev.Caller, &rev.IP,
+		&rev.Country, &rev.IsoCode, &features, &changes, &rev.RestoredFrom, &created)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(features), &rev.Features); err != nil {
+		return nil, fmt.Errorf("error parsing revision features: %v", err)
+	}
+	if err := json.Unmarshal([]byte(changes), &rev.Changes); err != nil {
+		return nil, fmt.Errorf("error parsing revision changes: %v", err)
+	}
+	rev.Timestamp = time.Unix(0, created).UTC()
+	return &rev, nil
+}
+
+// addRevision records the revision built by 'revision' for a change from 'before' to 'after', if any,
+// in the transaction making the change.
+func (s *SQLStore) addRevision(tx *sql.Tx, revision RevisionOf, before, after *structs.CountryInfoInternal) error {
+	if revision == nil {
+		return nil
+	}
+	docID, rev := revision(before, after)
+	return s.insertRevision(tx, docID, rev)
+}
+
+// insertRevision inserts a revision row in a transaction.
+func (s *SQLStore) insertRevision(tx *sql.Tx, docID string, revision *structs.RevisionInternal) error {
+	features, err := json.Marshal(revision.Features)
+	if err != nil {
+		return err
+	}
+	changes := []byte("[]")
+	if revision.Changes != nil {
+		if changes, err = json.Marshal(revision.Changes); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO revisions (doc_id, schema_version, `+revisionColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		docID, SchemaVersion, revision.ID, revision.RegistrationID, revision.UUID, revision.Event, revision.Caller, revision.IP,
+		revision.Country, revision.IsoCode, string(features), string(changes), revision.RestoredFrom,
+		revision.Timestamp.UTC().UnixNano())
+	return err
+}
+
+// GetRevisions retrieves all revisions of a registration by ID and UUID, newest first.
+func (s *SQLStore) GetRevisions(IP, ID, UUID string) ([]structs.RevisionInternal, error) {
+	rows, err := s.query(`SELECT `+revisionColumns+` FROM revisions WHERE uuid = ? AND registration_id = ?
+		ORDER BY created DESC`, UUID, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	var revisions []structs.RevisionInternal
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+
+	log.Printf("%s: Revisions of registration %s retrieved successfully.", IP, ID)
+	return revisions, nil
+}
+
+// GetSpecificRevision retrieves a specific revision of a registration by revision ID, registration ID and UUID.
+func (s *SQLStore) GetSpecificRevision(IP, revisionID, ID, UUID string) (*structs.RevisionInternal, error) {
+	row := s.DB.QueryRowContext(ctx, s.rebind(`SELECT `+revisionColumns+` FROM revisions
+		WHERE id = ? AND registration_id = ? AND uuid = ?`), revisionID, ID, UUID)
+	rev, err := scanRevision(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s: Revision %s of registration %s retrieved successfully.", IP, rev.ID, ID)
+	return rev, nil
+}
+
+// scanWebhook reads a webhook row selected as id, uuid, url, country, events.
+func scanWebhook(row rowScanner) (structs.WebhookInternal, error) {
+	var hook structs.WebhookInternal
+	var events string
+	if err := row.Scan(&hook.ID, &hook.UUID, &hook.URL, &hook.Country, &events); err != nil {
+		return hook, err
+	}
+	if err := json.Unmarshal([]byte(events), &hook.Event); err != nil {
+		return hook, fmt.Errorf("error parsing webhook events: %v", err)
+	}
+	return hook, nil
+}
+
+// queryWebhooks runs a webhook query and scans all resulting rows.
+func (s *SQLStore) queryWebhooks(query string, args ...interface{}) ([]structs.WebhookInternal, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	var webhooks []structs.WebhookInternal
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(IterationFailed, err)
+	}
+	return webhooks, nil
+}
+
+// AddWebhook creates a new webhook row.
+func (s *SQLStore) AddWebhook(IP, docID string, webhook *structs.WebhookInternal) error {
+	_, err := s.exec(`INSERT INTO webhooks (doc_id, id, uuid, url, country, events, schema_version) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		docID, webhook.ID, webhook.UUID, webhook.URL, webhook.Country, encodeStrings(webhook.Event), SchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: Webhook %s created successfully.", IP, webhook.ID) // Log success.
+	return nil
+}
+
+// GetWebhooksForEvent retrieves the webhooks to notify of an event for a user (UUID) and country.
+func (s *SQLStore) GetWebhooksForEvent(UUID, country, event string) ([]structs.WebhookInternal, error) {
+	return s.queryWebhooks(`SELECT id, uuid, url, country, events FROM webhooks
+		WHERE uuid IN (?, '') AND country IN (?, '') AND events LIKE ?`, UUID, country, `%"`+event+`"%`)
+}
+
+// GetWebhooksUser retrieves all webhooks for a specific user (UUID).
+func (s *SQLStore) GetWebhooksUser(IP, UUID string) ([]structs.WebhookResponse, error) {
+	hooks, err := s.queryWebhooks(`SELECT id, uuid, url, country, events FROM webhooks WHERE uuid = ?`, UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []structs.WebhookResponse
+	for _, hook := range hooks {
+		webhooks = append(webhooks, webhookResponse(hook))
+	}
+
+	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
+	return webhooks, nil
+}
+
+// ListWebhooks retrieves one page of a user's webhooks matching the query.
+func (s *SQLStore) ListWebhooks(IP, UUID string, query WebhookQuery) ([]structs.WebhookResponse, string, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := []string{"uuid = ?"}
+	args := []interface{}{UUID}
+	if query.Country != "" {
+		where = append(where, "country = ?")
+		args = append(args, strings.ToUpper(query.Country))
+	}
+	if query.Event != "" {
+		where = append(where, "events LIKE ?") // Events are stored as a JSON array of quoted names.
+		args = append(args, `%"`+strings.ToUpper(query.Event)+`"%`)
+	}
+	if cursor != nil {
+		where = append(where, "id > ?")
+		args = append(args, cursor.ID)
+	}
+	statement := `SELECT id, uuid, url, country, events FROM webhooks WHERE ` + strings.Join(where, " AND ") + ` ORDER BY id ASC`
+	if query.Limit > 0 {
+		statement += ` LIMIT ` + strconv.Itoa(query.Limit+1) // Fetch one extra row to detect a following page.
+	}
+
+	hooks, err := s.queryWebhooks(statement, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if query.Limit > 0 && len(hooks) > query.Limit {
+		hooks = hooks[:query.Limit]
+		next = encodeCursor(hooks[len(hooks)-1].ID, time.Time{})
+	}
+
+	var webhooks []structs.WebhookResponse
+	for _, hook := range hooks {
+		webhooks = append(webhooks, webhookResponse(hook))
+	}
+
+	log.Printf("%s: Webhooks retrieved successfully for user: %s.", IP, UUID) // Log success.
+	return webhooks, next, nil
+}
+
+// GetSpecificWebhook retrieves a specific webhook by ID and UUID.
+func (s *SQLStore) GetSpecificWebhook(IP, ID, UUID string) (*structs.WebhookResponse, error) {
+	row := s.DB.QueryRowContext(ctx, s.rebind(`SELECT id, uuid, url, country, events FROM webhooks WHERE id = ? AND uuid = ?`), ID, UUID)
+	hook, err := scanWebhook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("no document with that ID was found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := webhookResponse(hook)
+	log.Printf("%s: Webhook %s retrieved successfully.", IP, webhook.ID) // Log success.
+	return &webhook, nil
+}
+
+// DeleteWebhook deletes a specific webhook by ID and UUID.
+func (s *SQLStore) DeleteWebhook(IP, ID, UUID string) error {
+	res, err := s.exec(`DELETE FROM webhooks WHERE id = ? AND uuid = ?`, ID, UUID)
+	if err != nil {
+		log.Print(err)                                          // Log errors during the delete operation.
+		return fmt.Errorf("failed to delete document: %v", err) // Return formatted error if delete fails.
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("ID match was not found")
+	}
+
+	log.Printf("%s: Webhook %s deleted successfully.", IP, ID) // Log success.
+	return nil
+}
+
+// AddUser stores a new user, stamping their creation time.
+func (s *SQLStore) AddUser(user *structs.User) error {
+	created := time.Now().UTC()
+	err := s.inTx(func(tx *sql.Tx) error {
+		var taken int
+		err := tx.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM users WHERE email = ?`), user.Email).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrEmailTaken
+		}
+		_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO users (uuid, email, display_name, password_hash, created, schema_version)
+			VALUES (?, ?, ?, ?, ?, ?)`),
+			user.UUID, user.Email, user.DisplayName, user.PasswordHash, created.UnixNano(), SchemaVersion)
+		return err
+	})
+	if errors.Is(err, ErrEmailTaken) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error saving user to database: %v", err)
+	}
+
+	user.Created = created
+	log.Printf("User %s created successfully.", user.UUID) // Log success.
+	return nil
+}
+
+// userColumns lists the user columns in the order scanned by scanUser.
+const userColumns = `uuid, email, display_name, password_hash, created`
+
+// GetUser retrieves a user by UUID.
+func (s *SQLStore) GetUser(UUID string) (*structs.User, error) {
+	return s.getUser(`SELECT `+userColumns+` FROM users WHERE uuid = ?`, UUID)
+}
+
+// GetUserByEmail retrieves a user by email.
+func (s *SQLStore) GetUserByEmail(email string) (*structs.User, error) {
+	return s.getUser(`SELECT `+userColumns+` FROM users WHERE email = ?`, email)
+}
+
+// getUser retrieves the user selected by a query written with '?' placeholders.
+func (s *SQLStore) getUser(query string, args ...interface{}) (*structs.User, error) {
+	user, err := scanUser(s.DB.QueryRowContext(ctx, s.rebind(query), args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving user: %v", err)
+	}
+	return &user, nil
+}
+
+// scanUser reads a user row selected with userColumns.
+func scanUser(row rowScanner) (structs.User, error) {
+	var user structs.User
+	var created int64
+	err := row.Scan(&user.UUID, &user.Email, &user.DisplayName, &user.PasswordHash, &created)
+	user.Created = time.Unix(0, created).UTC()
+	return user, err
+}
+
+// GetUsers retrieves every user, in order of registration.
+func (s *SQLStore) GetUsers() ([]structs.User, error) {
+	rows, err := s.query(`SELECT ` + userColumns + ` FROM users ORDER BY created`)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving users: %v", err)
+	}
+	defer closeRows(rows)
+
+	var users []structs.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUser deletes a user by UUID.
+func (s *SQLStore) DeleteUser(UUID string) error {
+	res, err := s.exec(`DELETE FROM users WHERE uuid = ?`, UUID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+
+	log.Printf("User %s deleted successfully.", UUID) // Log success.
+	return nil
+}
+
+// CountUserData counts the API keys, registrations and webhooks owned by each user (UUID).
+func (s *SQLStore) CountUserData() (map[string]structs.UserCounts, error) {
+	counts := make(map[string]structs.UserCounts)
+	tables := map[string]func(c *structs.UserCounts, n int){
+		"api_keys":      func(c *structs.UserCounts, n int) { c.APIKeys = n },
+		"registrations": func(c *structs.UserCounts, n int) { c.Registrations = n },
+		"webhooks":      func(c *structs.UserCounts, n int) { c.Webhooks = n },
+	}
+	for table, set := range tables {
+		rows, err := s.query(`SELECT uuid, COUNT(*) FROM ` + table + ` WHERE uuid <> '' GROUP BY uuid`)
+		if err != nil {
+			return nil, fmt.Errorf("error counting %s: %v", table, err)
+		}
+		for rows.Next() {
+			var UUID string
+			var n int
+			if err := rows.Scan(&UUID, &n); err != nil {
+				closeRows(rows)
+				return nil, fmt.Errorf(IterationFailed, err)
+			}
+			c := counts[UUID]
+			set(&c, n)
+			counts[UUID] = c
+		}
+		closeRows(rows)
+	}
+	return counts, nil
+}
+
+// DisableUser disables the account of a user (UUID).
+func (s *SQLStore) DisableUser(IP, UUID string) error {
+	_, err := s.exec(`INSERT INTO disabled_users (uuid, disabled, schema_version) VALUES (?, ?, ?)
+		ON CONFLICT (uuid) DO NOTHING`, UUID, time.Now().UTC().UnixNano(), SchemaVersion)
+	if err != nil {
+		return fmt.Errorf("error disabling user: %v", err)
+	}
+
+	log.Printf("%s: Account of user %s disabled.", IP, UUID) // Log success.
+	return nil
+}
+
+// EnableUser re-enables the account of a disabled user (UUID).
+func (s *SQLStore) EnableUser(IP, UUID string) error {
+	if _, err := s.exec(`DELETE FROM disabled_users WHERE uuid = ?`, UUID); err != nil {
+		return fmt.Errorf("error enabling user: %v", err)
+	}
+
+	log.Printf("%s: Account of user %s enabled.", IP, UUID) // Log success.
+	return nil
+}
+
+// IsUserDisabled reports whether the account of a user (UUID) is disabled.
+func (s *SQLStore) IsUserDisabled(UUID string) (bool, error) {
+	var n int
+	err := s.DB.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM disabled_users WHERE uuid = ?`), UUID).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error checking whether user is disabled: %v", err)
+	}
+	return n > 0, nil
+}
+
+// GetDisabledUsers retrieves every disabled user (UUID) along with the time they were disabled.
+func (s *SQLStore) GetDisabledUsers() (map[string]time.Time, error) {
+	rows, err := s.query(`SELECT uuid, disabled FROM disabled_users`)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving disabled users: %v", err)
+	}
+	defer closeRows(rows)
+
+	disabled := make(map[string]time.Time)
+	for rows.Next() {
+		var UUID string
+		var at int64
+		if err := rows.Scan(&UUID, &at); err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+		disabled[UUID] = time.Unix(0, at).UTC()
+	}
+	return disabled, rows.Err()
+}
+
+// AddAuditEntry records an administrative action, stamping its time.
+func (s *SQLStore) AddAuditEntry(entry *structs.AuditEntry) error {
+	entry.Time = time.Now().UTC()
+	_, err := s.exec(`INSERT INTO audit_log (id, created, actor, ip, action, target, details, schema_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.Time.UnixNano(), entry.Actor, entry.IP, entry.Action, entry.Target, entry.Details, SchemaVersion)
+	if err != nil {
+		return fmt.Errorf("error saving audit entry to database: %v", err)
+	}
+	return nil
+}
+
+// GetAuditEntries retrieves the recorded administrative actions matching the query, newest first.
+func (s *SQLStore) GetAuditEntries(query AuditQuery) ([]structs.AuditEntry, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if query.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, query.Actor)
+	}
+	if query.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, query.Target)
+	}
+	args = append(args, query.Limit)
+
+	rows, err := s.query(`SELECT id, created, actor, ip, action, target, details FROM audit_log
+		WHERE `+strings.Join(where, " AND ")+` ORDER BY created DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving audit entries: %v", err)
+	}
+	defer closeRows(rows)
+
+	var entries []structs.AuditEntry
+	for rows.Next() {
+		var entry structs.AuditEntry
+		var at int64
+		if err := rows.Scan(&entry.ID, &at, &entry.Actor, &entry.IP, &entry.Action, &entry.Target, &entry.Details); err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+		entry.Time = time.Unix(0, at).UTC()
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AddUsage adds the given requests to the usage counters in one transaction, so that none are added should it fail.
+func (s *SQLStore) AddUsage(usage []structs.Usage) (int, error) {
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, u := range usage {
+			_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO api_usage (day, uuid, key_id, endpoint, status, count,
+				total_latency_ms, max_latency_ms, schema_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (day, uuid, key_id, endpoint, status) DO UPDATE SET
+				count = api_usage.count + excluded.count,
+				total_latency_ms = api_usage.total_latency_ms + excluded.total_latency_ms,
+				max_latency_ms = CASE WHEN excluded.max_latency_ms > api_usage.max_latency_ms
+					THEN excluded.max_latency_ms ELSE api_usage.max_latency_ms END`),
+				u.Day, u.UUID, u.KeyID, u.Endpoint, u.Status, u.Count, u.TotalLatencyMs, u.MaxLatencyMs, SchemaVersion)
+			if err != nil {
+				return fmt.Errorf("error saving usage to database: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(usage), nil
+}
+
+// GetUsage retrieves the usage counters matching the query, ordered by day, API key, endpoint and status.
+func (s *SQLStore) GetUsage(query UsageQuery) ([]structs.Usage, error) {
+	where := []string{"day >= ?", "day <= ?"}
+	args := []interface{}{query.From, query.To}
+	if query.UUID != "" {
+		where = append(where, "uuid = ?")
+		args = append(args, query.UUID)
+	}
+
+	rows, err := s.query(`SELECT day, uuid, key_id, endpoint, status, count, total_latency_ms, max_latency_ms
+		FROM api_usage WHERE `+strings.Join(where, " AND ")+` ORDER BY day, uuid, key_id, endpoint, status`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving usage: %v", err)
+	}
+	defer closeRows(rows)
+
+	var usage []structs.Usage
+	for rows.Next() {
+		var u structs.Usage
+		err := rows.Scan(&u.Day, &u.UUID, &u.KeyID, &u.Endpoint, &u.Status, &u.Count, &u.TotalLatencyMs, &u.MaxLatencyMs)
+		if err != nil {
+			return nil, fmt.Errorf(IterationFailed, err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// closeRows closes a result set, logging any error.
+func closeRows(rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing result rows: %v", err)
+	}
+}
+
+// PendingMigrations reports the schema migrations not yet applied to the database,
+// followed by the document migrations still due for rows written under an older schema version.
+func (s *SQLStore) PendingMigrations() ([]MigrationStatus, error) {
+	current, err := s.appliedSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []MigrationStatus
+	for i := current; i < len(sqlMigrations); i++ {
+		pending = append(pending, MigrationStatus{
+			Kind:        KindSchema,
+			Version:     i + 1,
+			Description: strings.Join(strings.Fields(sqlMigrations[i]), " "),
+		})
+	}
+
+	for _, kind := range documentKinds {
+		statuses := make(map[int]*MigrationStatus)
+		rows, err := s.query(`SELECT schema_version, COUNT(*) FROM `+kind+` WHERE schema_version < ? GROUP BY schema_version`,
+			SchemaVersion)
+		if err != nil {
+			// Tables or versions not created yet by pending schema migrations hold nothing but version 0 rows.
+			var count int
+			if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+kind).Scan(&count); err == nil && count > 0 {
+				countOutdated(statuses, kind, 0, count)
+			}
+			pending = append(pending, sortedStatuses(statuses)...)
+			continue
+		}
+		for rows.Next() {
+			var version, count int
+			if err := rows.Scan(&version, &count); err != nil {
+				closeRows(rows)
+				return nil, fmt.Errorf(IterationFailed, err)
+			}
+			countOutdated(statuses, kind, version, count)
+		}
+		closeRows(rows)
+		pending = append(pending, sortedStatuses(statuses)...)
+	}
+	return pending, nil
+}
+
+// Migrate applies pending schema migrations, rewrites older rows where a document migration requires it,
+// and stamps them with the current schema version. Changes to the shape of data are made by schema migrations;
+// document migrations only rewrite rows for changes SQL cannot express, such as hashing.
+func (s *SQLStore) Migrate(dryRun bool) ([]MigrationStatus, error) {
+	pending, err := s.PendingMigrations()
+	if err != nil || dryRun {
+		return pending, err
+	}
+
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	err = s.inTx(func(tx *sql.Tx) error {
+		for version, migration := range documentMigrations {
+			if migration.UpgradeRows == nil {
+				continue
+			}
+			if err := migration.UpgradeRows(tx, s.rebind); err != nil {
+				return fmt.Errorf("error applying document migration %d: %v", version+1, err)
+			}
+		}
+		for _, kind := range documentKinds {
+			_, err := tx.ExecContext(ctx, s.rebind(`UPDATE `+kind+` SET schema_version = ? WHERE schema_version < ?`),
+				SchemaVersion, SchemaVersion)
+			if err != nil {
+				return fmt.Errorf("error stamping %s with schema version %d: %v", kind, SchemaVersion, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) > 0 {
+		log.Printf("Migrated %s database to schema version %d.", s.dialect, SchemaVersion)
+	}
+	return pending, nil
+}
+
+// hashPlaintextAPIKeyRows replaces the plaintext API keys stored before schema version 2 with their keyed hash.
+func hashPlaintextAPIKeyRows(tx *sql.Tx, rebind func(string) string) error {
+	rows, err := tx.QueryContext(ctx, rebind(`SELECT doc_id, key_hash FROM api_keys WHERE schema_version < ?`), 2)
+	if err != nil {
+		return err
+	}
+	plaintext := make(map[string]string) // Plaintext keys by document ID, read fully before updating.
+	for rows.Next() {
+		var docID, key string
+		if err := rows.Scan(&docID, &key); err != nil {
+			closeRows(rows)
+			return err
+		}
+		plaintext[docID] = key
+	}
+	closeRows(rows)
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for docID, key := range plaintext {
+		_, err := tx.ExecContext(ctx, rebind(`UPDATE api_keys SET key_hash = ?, prefix = ? WHERE doc_id = ?`),
+			HashAPIKey(key), APIKeyPrefix(key), docID)
+		if err != nil {
+			return err
+		}
+	}
+	if len(plaintext) > 0 {
+		log.Printf("Hashed %d plaintext API keys.", len(plaintext))
+	}
+	return nil
+}
+
+// identifyAPIKeyRows gives the API keys stored before schema version 3 an ID and a creation time.
+func identifyAPIKeyRows(tx *sql.Tx, rebind func(string) string) error {
+	rows, err := tx.QueryContext(ctx, rebind(`SELECT doc_id, key_hash FROM api_keys WHERE schema_version < ? AND id = ''`), 3)
+	if err != nil {
+		return err
+	}
+	hashes := make(map[string]string) // Key hashes by document ID, read fully before updating.
+	for rows.Next() {
+		var docID, keyHash string
+		if err := rows.Scan(&docID, &keyHash); err != nil {
+			closeRows(rows)
+			return err
+		}
+		hashes[docID] = keyHash
+	}
+	closeRows(rows)
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().UnixNano() // The true creation time was never recorded.
+	for docID, keyHash := range hashes {
+		_, err := tx.ExecContext(ctx, rebind(`UPDATE api_keys SET id = ?, created = ? WHERE doc_id = ?`),
+			legacyAPIKeyID(keyHash), now, docID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scopeAPIKeyRows grants the API keys stored before schema version 4 the scopes they implicitly had.
+func scopeAPIKeyRows(tx *sql.Tx, rebind func(string) string) error {
+	_, err := tx.ExecContext(ctx, rebind(`UPDATE api_keys SET scopes = ? WHERE schema_version < ?`),
+		encodeStrings(legacyAPIKeyScopes), 4)
+	return err
+}
+
+// grantUsageScopeRows grants the API keys stored before schema version 5 that may read the status the scope to
+// read usage, which reading the status allowed until then.
+func grantUsageScopeRows(tx *sql.Tx, rebind func(string) string) error {
+	rows, err := tx.QueryContext(ctx, rebind(`SELECT doc_id, scopes FROM api_keys WHERE schema_version < ?`), 5)
+	if err != nil {
+		return err
+	}
+	granted := make(map[string][]string) // Scopes by document ID, read fully before updating.
+	for rows.Next() {
+		var docID, encoded string
+		if err := rows.Scan(&docID, &encoded); err != nil {
+			closeRows(rows)
+			return err
+		}
+		var scopes []string
+		if err := json.Unmarshal([]byte(encoded), &scopes); err != nil {
+			closeRows(rows)
+			return fmt.Errorf("error parsing API key scopes: %v", err)
+		}
+		if slices.Contains(scopes, statusReadScope) && !slices.Contains(scopes, usageReadScope) {
+			granted[docID] = append(scopes, usageReadScope)
+		}
+	}
+	closeRows(rows)
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for docID, scopes := range granted {
+		_, err := tx.ExecContext(ctx, rebind(`UPDATE api_keys SET scopes = ? WHERE doc_id = ?`), encodeStrings(scopes), docID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"globeboard/internal/utils/structs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// usageKey identifies a usage counter.
+type usageKey struct {
+	Day      string
+	UUID     string
+	KeyID    string
+	Endpoint string
+	Status   int
+}
+
+// keyOf returns the key of the counter a usage record adds to.
+func keyOf(u structs.Usage) usageKey {
+	return usageKey{Day: u.Day, UUID: u.UUID, KeyID: u.KeyID, Endpoint: u.Endpoint, Status: u.Status}
+}
+
+// docID returns the document ID of a usage counter, derived from its key, as endpoints hold slashes.
+func (k usageKey) docID() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{k.Day, k.UUID, k.KeyID, k.Endpoint, strconv.Itoa(k.Status)}, "\x00")))
+	return k.Day + "-" + hex.EncodeToString(sum[:16])
+}
+
+// mergeUsage adds the requests counted by 'add' to 'into', which counts the same day, API key, endpoint and status.
+func mergeUsage(into *structs.Usage, add structs.Usage) {
+	into.Count += add.Count
+	into.TotalLatencyMs += add.TotalLatencyMs
+	into.MaxLatencyMs = max(into.MaxLatencyMs, add.MaxLatencyMs)
+}
+
+// sortUsage orders usage counters by day, user, API key, endpoint and status.
+func sortUsage(usage []structs.Usage) {
+	slices.SortFunc(usage, func(a, b structs.Usage) int {
+		return cmp.Or(
+			cmp.Compare(a.Day, b.Day),
+			cmp.Compare(a.UUID, b.UUID),
+			cmp.Compare(a.KeyID, b.KeyID),
+			cmp.Compare(a.Endpoint, b.Endpoint),
+			cmp.Compare(a.Status, b.Status),
+		)
+	})
+}
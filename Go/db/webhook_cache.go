@@ -0,0 +1,111 @@
+// Package db provides data access functions for interacting with the application's storage backends.
+package db
+
+import (
+	"globeboard/internal/utils/structs"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWebhookCacheTTL = time.Minute // DefaultWebhookCacheTTL bounds how stale a cached route may be when webhooks change elsewhere.
+	maxWebhookCacheEntries = 10000       // maxWebhookCacheEntries bounds the memory used by cached routes.
+)
+
+// webhookRoute identifies the webhooks matching a single user, country and event.
+type webhookRoute struct {
+	UUID    string
+	Country string
+	Event   string
+}
+
+// cachedWebhooks is a cached lookup result and when it expires.
+type cachedWebhooks struct {
+	webhooks []structs.WebhookInternal
+	expires  time.Time
+}
+
+// WebhookCacheStore wraps a Store, caching the webhooks routed to each user, country and event.
+// Cached routes are invalidated whenever this instance creates or deletes webhooks, and expire after
+// the TTL so that changes made by other instances sharing the backend are eventually seen.
+type WebhookCacheStore struct {
+	Store
+	ttl        time.Duration
+	mu         sync.RWMutex
+	routes     map[webhookRoute]cachedWebhooks
+	generation uint64 // Incremented on every invalidation, so lookups racing one are not cached.
+}
+
+// NewWebhookCacheStore wraps 'store' with a webhook routing cache whose entries live for 'ttl'.
+func NewWebhookCacheStore(store Store, ttl time.Duration) *WebhookCacheStore {
+	return &WebhookCacheStore{
+		Store:  store,
+		ttl:    ttl,
+		routes: make(map[webhookRoute]cachedWebhooks),
+	}
+}
+
+// GetWebhooksForEvent retrieves the webhooks to notify of an event, from the cache when possible.
+func (s *WebhookCacheStore) GetWebhooksForEvent(UUID, country, event string) ([]structs.WebhookInternal, error) {
+	route := webhookRoute{UUID: UUID, Country: country, Event: event}
+
+	s.mu.RLock()
+	cached, ok := s.routes[route]
+	generation := s.generation
+	s.mu.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.webhooks, nil
+	}
+
+	webhooks, err := s.Store.GetWebhooksForEvent(UUID, country, event)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if generation != s.generation {
+		return webhooks, nil // Webhooks changed during the lookup, so the result may already be stale.
+	}
+	if len(s.routes) >= maxWebhookCacheEntries {
+		s.routes = make(map[webhookRoute]cachedWebhooks) // Start over rather than grow without bound.
+	}
+	s.routes[route] = cachedWebhooks{webhooks: webhooks, expires: time.Now().Add(s.ttl)}
+	return webhooks, nil
+}
+
+// AddWebhook creates a new webhook entry and invalidates the routes it affects.
+func (s *WebhookCacheStore) AddWebhook(IP, docID string, webhook *structs.WebhookInternal) error {
+	defer s.invalidate(webhook.UUID)
+	return s.Store.AddWebhook(IP, docID, webhook)
+}
+
+// DeleteWebhook deletes a specific webhook entry and invalidates the routes it affects.
+func (s *WebhookCacheStore) DeleteWebhook(IP, ID, UUID string) error {
+	defer s.invalidate(UUID)
+	return s.Store.DeleteWebhook(IP, ID, UUID)
+}
+
+// DeleteUserData deletes everything owned by a user, including their webhooks, and invalidates their routes.
+func (s *WebhookCacheStore) DeleteUserData(IP, UUID string) error {
+	defer s.invalidate(UUID)
+	return s.Store.DeleteUserData(IP, UUID)
+}
+
+// invalidate drops the cached routes of a user (UUID). Developer webhooks (empty UUID) reach every user,
+// so changing them drops every route.
+func (s *WebhookCacheStore) invalidate(UUID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	if UUID == "" {
+		s.routes = make(map[webhookRoute]cachedWebhooks)
+		return
+	}
+	for route := range s.routes {
+		if route.UUID == UUID {
+			delete(s.routes, route)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package _func provides developer-made utility functions for use within the application.
+package _func
+
+import "globeboard/internal/utils/structs"
+
+// APIKeyMetadata describes an API key without revealing the key or its hash.
+func APIKeyMetadata(key structs.APIKey) structs.APIKeyMetadata {
+	return structs.APIKeyMetadata{
+		ID:       key.ID,
+		Name:     key.Name,
+		Scopes:   key.Scopes,
+		Prefix:   key.Prefix,
+		Created:  key.Created,
+		Expires:  key.Expires,
+		LastUsed: key.LastUsed,
+	}
+}
@@ -0,0 +1,69 @@
+// Package _func provides developer-made utility functions for use within the application.
+package _func
+
+import (
+	"context"
+	"errors"
+	"globeboard/internal/utils/structs"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// profileFields lists the REST Countries fields every dashboard feature is assembled from.
+const profileFields = "capital,latlng,population,area,currencies"
+
+// CountryProfile defines the structure of the facts about a country that dashboards are assembled from,
+// as returned by the REST Countries API.
+type CountryProfile struct {
+	Capital    []string  `json:"capital"`    // Capital cities, typically containing only one element.
+	LatLng     []float64 `json:"latlng"`     // Latitude and longitude.
+	Population int       `json:"population"` // Population.
+	Area       float64   `json:"area"`       // Area in square kilometers.
+	Currencies map[string]struct {
+		Name   string `json:"name"`   // Name of the currency.
+		Symbol string `json:"symbol"` // Symbol of the currency.
+	} `json:"currencies"` // Currencies by currency code.
+}
+
+// CapitalCity returns the capital city of the country.
+func (p CountryProfile) CapitalCity() (string, error) {
+	if len(p.Capital) == 0 {
+		return "Earth", errors.New("no capital found for the specified ISO code") // Handle cases where no capital is available.
+	}
+	return p.Capital[0], nil // Assume the first element is the desired capital.
+}
+
+// Coordinates returns the geographical coordinates (latitude and longitude) of the country.
+func (p CountryProfile) Coordinates() (structs.CoordinatesDashboard, error) {
+	if len(p.LatLng) < 2 {
+		return structs.CoordinatesDashboard{}, errors.New("no coordinates found for the specified ISO code")
+	}
+	return structs.CoordinatesDashboard{
+		Latitude:  strconv.FormatFloat(p.LatLng[0], 'f', 5, 64),
+		Longitude: strconv.FormatFloat(p.LatLng[1], 'f', 5, 64),
+	}, nil
+}
+
+// GetCountryProfile returns the profile of a country identified by its ISO code, from the upstream cache if present.
+func GetCountryProfile(ctx context.Context, isocode string) (CountryProfile, error) {
+	return cached(ctx, CacheCountry, strings.ToUpper(isocode), func(ctx context.Context) (CountryProfile, error) {
+		return fetchCountryProfile(ctx, isocode)
+	})
+}
+
+// fetchCountryProfile fetches every field of the profile of a country identified by its ISO code in one request.
+func fetchCountryProfile(ctx context.Context, isocode string) (CountryProfile, error) {
+	var profiles []CountryProfile // Slice to hold the parsed JSON data.
+	// Construct the request URL with ISO code and fields parameter.
+	err := getUpstreamJSON(ctx, UpstreamCountries, alphaCodes+isocode+"&fields="+profileFields, &profiles)
+	if err != nil {
+		log.Print(err)
+		return CountryProfile{}, err
+	}
+	if len(profiles) == 0 {
+		return CountryProfile{}, errors.New("no country found for the specified ISO code") // Handle cases where no country is found.
+	}
+
+	return profiles[0], nil // Assume the first entry is the correct one.
+}
@@ -2,40 +2,35 @@
 package _func
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"globeboard/apikey"
 )
 
-// init initializes the package by seeding the random number generator with the current time.
-func init() {
-	rand.New(rand.NewSource(time.Now().UnixNano())) // Seed rand with time, making it nondeterministic.
-}
-
 // Runes is the list of characters to use for generating IDs.
-var Runes = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+var Runes = []rune(apikey.Alphabet)
 
-// GenerateAPIKey generates an API key of length 'n'.
-func GenerateAPIKey(n int) string {
-	// Make two slices that are 'n' long, a and b.
-	a := make([]rune, n)
-	b := make([]rune, n)
-	// loop through the slices and insert a random character at each index for each slice.
-	for i := range a {
-		a[i] = Runes[rand.Intn(len(Runes))]
-		b[i] = Runes[rand.Intn(len(Runes))]
-	}
-	// concatenate the slices to strings, following standard format.
-	return "sk-" + string(a) + "tTRjPv" + string(b)
+// GenerateAPIKey generates an API key for the given environment, carrying the key identifier 'ID',
+// with a secret of length 'n' and a checksum.
+func GenerateAPIKey(environment, ID string, n int) string {
+	return apikey.Format(environment, ID, GenerateUID(n))
 }
 
-// GenerateUID returns a Unique Identifier of length 'n'.
+// GenerateUID returns a Unique Identifier of length 'n', drawn from a cryptographically secure source.
 func GenerateUID(n int) string {
-	// Make a slice that is 'n' long.
-	b := make([]rune, n)
-	// loop through the slice and insert a random character at each index.
-	for i := range b {
-		b[i] = Runes[rand.Intn(len(Runes))]
+	// The largest multiple of len(Runes) a byte can hold; bytes above it are skipped, so every rune is equally likely.
+	limit := 256 - 256%len(Runes)
+
+	b := make([]rune, 0, n)
+	buf := make([]byte, n+n/4) // Draw some spare bytes, as a few are skipped.
+	for len(b) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic("crypto/rand failed: " + err.Error()) // The system's secure random source is unavailable.
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(b) < n {
+				b = append(b, Runes[int(c)%len(Runes)])
+			}
+		}
 	}
-	// concatenate the slice to string.
 	return string(b)
 }
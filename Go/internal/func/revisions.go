@@ -0,0 +1,86 @@
+// Package _func provides developer-made utility functions for use within the application.
+package _func
+
+import (
+	"globeboard/db"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/structs"
+	"slices"
+	"time"
+)
+
+// DiffFeatures lists the features that differ between 'before' and 'after'.
+// A nil 'before' describes a new registration and a nil 'after' a deleted one.
+func DiffFeatures(before, after *structs.Features) []structs.FeatureChange {
+	var changes []structs.FeatureChange
+	// compare records a change if the values differ, treating a missing side as null.
+	compare := func(feature string, get func(f *structs.Features) interface{}, equal func(a, b *structs.Features) bool) {
+		if before != nil && after != nil && equal(before, after) {
+			return
+		}
+		change := structs.FeatureChange{Feature: feature}
+		if before != nil {
+			change.Old = get(before)
+		}
+		if after != nil {
+			change.New = get(after)
+		}
+		changes = append(changes, change)
+	}
+
+	compare("temperature", func(f *structs.Features) interface{} { return f.Temperature },
+		func(a, b *structs.Features) bool { return a.Temperature == b.Temperature })
+	compare("precipitation", func(f *structs.Features) interface{} { return f.Precipitation },
+		func(a, b *structs.Features) bool { return a.Precipitation == b.Precipitation })
+	compare("capital", func(f *structs.Features) interface{} { return f.Capital },
+		func(a, b *structs.Features) bool { return a.Capital == b.Capital })
+	compare("coordinates", func(f *structs.Features) interface{} { return f.Coordinates },
+		func(a, b *structs.Features) bool { return a.Coordinates == b.Coordinates })
+	compare("population", func(f *structs.Features) interface{} { return f.Population },
+		func(a, b *structs.Features) bool { return a.Population == b.Population })
+	compare("area", func(f *structs.Features) interface{} { return f.Area },
+		func(a, b *structs.Features) bool { return a.Area == b.Area })
+	compare("targetCurrencies", func(f *structs.Features) interface{} { return f.TargetCurrencies },
+		func(a, b *structs.Features) bool { return slices.Equal(a.TargetCurrencies, b.TargetCurrencies) })
+
+	return changes
+}
+
+// Revision returns the builder of the revision recording a change made by a caller, identified by the prefix or ID of
+// their API key, to a registration of a user (UUID) from a client address (IP), for the store to write along with the
+// change. A nil 'before' records a new registration and a nil 'after' a deleted one. Revisions of changes whose time
+// is set by the store are stamped with the current time.
+func Revision(IP, UUID, caller, event, restoredFrom string) db.RevisionOf {
+	return func(before, after *structs.CountryInfoInternal) (string, *structs.RevisionInternal) {
+		snapshot := after // The state the revision captures; the final state for deletions.
+		timestamp := time.Now().UTC()
+		var beforeFeatures, afterFeatures *structs.Features
+		if before != nil {
+			beforeFeatures = &before.Features
+		}
+		if after != nil {
+			afterFeatures = &after.Features
+			if !after.Lastchange.IsZero() {
+				timestamp = after.Lastchange
+			}
+		} else {
+			snapshot = before
+		}
+
+		rev := &structs.RevisionInternal{
+			ID:             GenerateUID(constants.IdLength),
+			RegistrationID: snapshot.ID,
+			UUID:           UUID,
+			Event:          event,
+			Caller:         caller,
+			IP:             IP,
+			Country:        snapshot.Country,
+			IsoCode:        snapshot.IsoCode,
+			Features:       snapshot.Features,
+			Changes:        DiffFeatures(beforeFeatures, afterFeatures),
+			RestoredFrom:   restoredFrom,
+			Timestamp:      timestamp,
+		}
+		return GenerateUID(constants.DocIdLength), rev
+	}
+}
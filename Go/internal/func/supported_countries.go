@@ -2,54 +2,34 @@
 package _func
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"globeboard/internal/utils/constants/External"
 	"globeboard/internal/utils/structs"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
-	"io"
 	"log"
-	"net/http"
 	"strings"
-	"time"
 )
 
-// getSupportedCountries fetches supported countries with their common names and ISO 3166-1 alpha-2 codes.
-func getSupportedCountries() (map[string]string, error) {
-	url := fmt.Sprintf("%sall?fields=name,cca2", External.CountriesAPI) // Constructing the API request URL.
-	var responseData []struct {                                         // Struct to parse the JSON response.
+// getSupportedCountries returns supported countries with their common names and ISO 3166-1 alpha-2 codes, from the
+// upstream cache if present.
+func getSupportedCountries(ctx context.Context) (map[string]string, error) {
+	return cached(ctx, CacheCountries, "all", fetchSupportedCountries)
+}
+
+// fetchSupportedCountries fetches supported countries with their common names and ISO 3166-1 alpha-2 codes.
+func fetchSupportedCountries(ctx context.Context) (map[string]string, error) {
+	var responseData []struct { // Struct to parse the JSON response.
 		Name struct {
 			Common string `json:"common"` // Common name of the country.
 		} `json:"name"`
 		CCA2 string `json:"cca2"` // ISO 3166-1 alpha-2 code of the country.
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}     // HTTP client with a timeout.
-	req, err := http.NewRequest(http.MethodGet, url, nil) // Creating a new HTTP GET request.
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		return nil, fmt.Errorf("error creating request: %v", err)
-	}
-	req.Header.Add("content-type", "application/json") // Setting content-type of the request.
-
-	res, err := client.Do(req) // Send the request.
-	if err != nil {
-		log.Printf("Error issuing request: %v", err)
-		return nil, fmt.Errorf("error issuing request: %v", err)
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Printf("Error closing response body: %v", err)
-		}
-	}(res.Body) // Ensure the response body is closed after processing.
-
-	err = json.NewDecoder(res.Body).Decode(&responseData) // Decoding the JSON response into the struct.
-	if err != nil {
-		log.Printf("Error decoding JSON: %v", err)
-		return nil, fmt.Errorf("error decoding JSON: %v", err)
+	if err := getUpstreamJSON(ctx, UpstreamCountries, "all?fields=name,cca2", &responseData); err != nil {
+		log.Print(err)
+		return nil, err
 	}
 
 	countriesMap := make(map[string]string) // Map to hold country codes and their common names.
@@ -61,12 +41,21 @@ func getSupportedCountries() (map[string]string, error) {
 }
 
 // ValidateCountryInfo validates the country information.
-func ValidateCountryInfo(ci *structs.CountryInfoInternal) error {
-	err := validateCountryNameIsoCode(ci) // Validate the name and ISO code.
-	if err != nil {
+func ValidateCountryInfo(ctx context.Context, ci *structs.CountryInfoInternal) error {
+	if err := ValidateCountry(ctx, ci); err != nil { // Validate the name and ISO code.
 		return err
 	}
+	return ValidateFeatures(ci)
+}
+
+// ValidateCountry validates the country name and/or ISO code against the supported countries, which may take an
+// upstream request, completing and normalizing them.
+func ValidateCountry(ctx context.Context, ci *structs.CountryInfoInternal) error {
+	return validateCountryNameIsoCode(ctx, ci)
+}
 
+// ValidateFeatures validates the features of the country information, without any upstream request.
+func ValidateFeatures(ci *structs.CountryInfoInternal) error {
 	// Ensure that at least one feature is populated.
 	if !ci.Features.Temperature && !ci.Features.Precipitation && !ci.Features.Capital &&
 		!ci.Features.Coordinates && !ci.Features.Population && !ci.Features.Area &&
@@ -77,8 +66,8 @@ func ValidateCountryInfo(ci *structs.CountryInfoInternal) error {
 }
 
 // validateCountryNameIsoCode validates the provided country name and/or ISO code against supported countries.
-func validateCountryNameIsoCode(ci *structs.CountryInfoInternal) error {
-	validCountries, err := getSupportedCountries() // Fetch the list of supported countries.
+func validateCountryNameIsoCode(ctx context.Context, ci *structs.CountryInfoInternal) error {
+	validCountries, err := getSupportedCountries(ctx) // Fetch the list of supported countries.
 	if err != nil {
 		log.Printf("Error retriving supported countries: %v", err)
 		return fmt.Errorf("error retriving supported countries: %v", err)
@@ -0,0 +1,230 @@
+// Package _func provides developer-made utility functions for use within the application.
+package _func
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"globeboard/internal/utils/config"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/External"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the upstream APIs.
+const (
+	UpstreamCountries = "countries" // UpstreamCountries names the RESTCountries API.
+	UpstreamMeteo     = "meteo"     // UpstreamMeteo names the Open-Meteo API.
+	UpstreamCurrency  = "currency"  // UpstreamCurrency names the Currency API.
+)
+
+// UpstreamNames lists the upstream APIs.
+var UpstreamNames = []string{UpstreamCountries, UpstreamMeteo, UpstreamCurrency}
+
+// Upstream configures how an upstream API is reached.
+type Upstream struct {
+	BaseURL string            // Base URL the paths of requests are appended to, ending with a slash.
+	Timeout time.Duration     // How long a request may take.
+	Headers map[string]string // Headers sent with every request, e.g. for authentication.
+}
+
+// upstreamFile defines the structure of the upstream APIs in the configuration file.
+type upstreamFile struct {
+	Upstreams map[string]struct {
+		URL     string            `json:"url"`     // Base URL of the API.
+		Timeout string            `json:"timeout"` // How long a request may take, e.g. "5s".
+		Headers map[string]string `json:"headers"` // Headers sent with every request.
+	} `json:"upstreams"`
+}
+
+// DefaultUpstreams returns the default configuration of the upstream APIs.
+func DefaultUpstreams() map[string]Upstream {
+	return map[string]Upstream{
+		UpstreamCountries: {BaseURL: External.CountriesAPI, Timeout: constants.DefaultUpstreamTimeout},
+		UpstreamMeteo:     {BaseURL: External.OpenMeteoAPI, Timeout: constants.DefaultUpstreamTimeout},
+		UpstreamCurrency:  {BaseURL: External.CurrencyAPI, Timeout: constants.DefaultUpstreamTimeout},
+	}
+}
+
+// LoadUpstreams returns the configuration of the upstream APIs: the defaults, overridden by the "upstreams" of the
+// JSON configuration file at path (unless empty), overridden by $UPSTREAM_{NAME}_URL, $UPSTREAM_{NAME}_TIMEOUT and
+// $UPSTREAM_{NAME}_HEADERS (comma-separated "Name: value" items).
+func LoadUpstreams(path string) (map[string]Upstream, error) {
+	upstreams := DefaultUpstreams()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration file: %v", err)
+		}
+		var file upstreamFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("error decoding configuration file %s: %v", path, err)
+		}
+		for name, configured := range file.Upstreams {
+			upstream, ok := upstreams[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown upstream in configuration file: %q, known upstreams are %s", name, strings.Join(UpstreamNames, ", "))
+			}
+			if configured.URL != "" {
+				upstream.BaseURL = configured.URL
+			}
+			if configured.Timeout != "" {
+				timeout, err := time.ParseDuration(configured.Timeout)
+				if err != nil {
+					return nil, fmt.Errorf("invalid timeout of upstream %s: %v", name, err)
+				}
+				upstream.Timeout = timeout
+			}
+			if configured.Headers != nil {
+				upstream.Headers = configured.Headers
+			}
+			upstreams[name] = upstream
+		}
+	}
+
+	for _, name := range UpstreamNames {
+		upstream := upstreams[name]
+		prefix := "UPSTREAM_" + strings.ToUpper(name) + "_"
+		upstream.BaseURL = config.String(prefix+"URL", upstream.BaseURL)
+		upstream.Timeout = config.Duration(prefix+"TIMEOUT", upstream.Timeout)
+		if items := config.List(prefix + "HEADERS"); items != nil {
+			upstream.Headers = make(map[string]string)
+			for _, item := range items {
+				key, value, ok := strings.Cut(item, ":")
+				if key = strings.TrimSpace(key); !ok || key == "" {
+					return nil, fmt.Errorf("invalid header of $%sHEADERS: %q, want \"Name: value\"", prefix, item)
+				}
+				upstream.Headers[key] = strings.TrimSpace(value)
+			}
+		}
+		upstreams[name] = upstream
+	}
+
+	for name, upstream := range upstreams {
+		normalized, err := validateUpstream(name, upstream)
+		if err != nil {
+			return nil, err
+		}
+		upstreams[name] = normalized
+	}
+	return upstreams, nil
+}
+
+// validateUpstream checks the base URL and timeout of an upstream API, ending its base URL with a slash.
+func validateUpstream(name string, upstream Upstream) (Upstream, error) {
+	u, err := url.Parse(upstream.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return upstream, fmt.Errorf("invalid URL of upstream %s: %q, want an absolute http(s) URL", name, upstream.BaseURL)
+	}
+	if upstream.Timeout <= 0 {
+		return upstream, fmt.Errorf("invalid timeout of upstream %s: %s, want a positive duration", name, upstream.Timeout)
+	}
+	if !strings.HasSuffix(upstream.BaseURL, "/") {
+		upstream.BaseURL += "/" // Paths are relative to the base URL.
+	}
+	return upstream, nil
+}
+
+var (
+	upstreamsMu sync.RWMutex
+	upstreams   = DefaultUpstreams() // The configuration of the upstream APIs.
+)
+
+// ConfigureUpstreams replaces the configuration of the upstream APIs.
+func ConfigureUpstreams(configured map[string]Upstream) {
+	upstreamsMu.Lock()
+	defer upstreamsMu.Unlock()
+	upstreams = maps.Clone(configured)
+}
+
+// Upstreams returns the configuration of the upstream APIs.
+func Upstreams() map[string]Upstream {
+	upstreamsMu.RLock()
+	defer upstreamsMu.RUnlock()
+	return maps.Clone(upstreams)
+}
+
+// upstreamClient sends the requests to the upstream APIs, each of which is bound by the timeout of its API and by the
+// context it is sent with.
+var upstreamClient = &http.Client{}
+
+// doUpstream sends a GET request for a path of an upstream API, with the headers and within the timeout configured for
+// it. The request is cancelled as the context is done. The caller must close the body of the response.
+func doUpstream(ctx context.Context, name, path string) (*http.Response, context.CancelFunc, error) {
+	upstreamsMu.RLock()
+	upstream, ok := upstreams[name]
+	upstreamsMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("upstream %s is not configured", name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstream.Timeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.BaseURL+path, nil) // Creating a new HTTP GET request.
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Add("content-type", "application/json") // Setting content-type of the request.
+	for key, value := range upstream.Headers {
+		req.Header.Set(key, value) // Setting the headers configured for the API, e.g. for authentication.
+	}
+
+	res, err := upstreamClient.Do(req) // Send the request.
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("error issuing request: %w", err)
+	}
+	return res, cancel, nil
+}
+
+// getUpstreamJSON sends a GET request for a path of an upstream API and decodes its JSON response into v.
+// The request is cancelled as the context is done.
+func getUpstreamJSON(ctx context.Context, name, path string, v any) error {
+	res, cancel, err := doUpstream(ctx, name, path)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf(ResponseBodyCloseError, err)
+		}
+	}(res.Body) // Ensure the response body is closed after processing.
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream responded %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil { // Decoding the JSON response into v.
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+	return nil
+}
+
+// ProbeUpstream sends a GET request for a path of an upstream API as configured, returning the status of its response
+// or why there was none.
+func ProbeUpstream(ctx context.Context, name, path string) string {
+	res, cancel, err := doUpstream(ctx, name, path)
+	if err != nil {
+		log.Printf("Error probing upstream %s: %v", name, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "Timed out"
+		}
+		return "Failed to connect"
+	}
+	defer cancel()
+	if err := res.Body.Close(); err != nil {
+		log.Printf(ResponseBodyCloseError, err)
+	}
+	return res.Status // Return the status code of the response.
+}
@@ -0,0 +1,155 @@
+// Package _func provides developer-made utility functions for use within the application.
+package _func
+
+import (
+	"context"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/structs"
+	"log"
+	"sync"
+	"time"
+)
+
+// Data types of the upstream cache, each kept for its own TTL.
+const (
+	CacheCountries = "countries" // CacheCountries caches the list of supported countries.
+	CacheCountry   = "country"   // CacheCountry caches country facts: capital, coordinates, population, area and currencies.
+	CacheRates     = "rates"     // CacheRates caches exchange rates.
+	CacheWeather   = "weather"   // CacheWeather caches the current weather.
+)
+
+// CacheConfig configures the upstream cache.
+type CacheConfig struct {
+	TTLs map[string]time.Duration // How long values of each data type are fresh; zero disables caching the type.
+	// StaleWhileRevalidate serves values for another TTL after they go stale, while refreshing them in the background.
+	StaleWhileRevalidate bool
+}
+
+// DefaultCacheConfig returns the default configuration of the upstream cache: days for country facts,
+// an hour for exchange rates and minutes for the weather, served stale while revalidating.
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		TTLs: map[string]time.Duration{
+			CacheCountries: constants.DefaultCacheTTLCountries,
+			CacheCountry:   constants.DefaultCacheTTLCountry,
+			CacheRates:     constants.DefaultCacheTTLRates,
+			CacheWeather:   constants.DefaultCacheTTLWeather,
+		},
+		StaleWhileRevalidate: true,
+	}
+}
+
+// cacheEntry is a value fetched from upstream.
+type cacheEntry struct {
+	kind       string    // The data type of the value.
+	value      any       // The value.
+	fetched    time.Time // The time the value was fetched.
+	refreshing bool      // Whether the value is being refreshed in the background.
+}
+
+// upstreamCache keeps the values fetched from the upstream APIs in process memory.
+type upstreamCache struct {
+	mu      sync.Mutex
+	config  CacheConfig
+	entries map[string]*cacheEntry         // Values by data type and key.
+	stats   map[string]*structs.CacheStats // Hit and miss counts by data type.
+}
+
+var cache = &upstreamCache{
+	config:  DefaultCacheConfig(),
+	entries: make(map[string]*cacheEntry),
+	stats:   make(map[string]*structs.CacheStats),
+}
+
+// ConfigureCache replaces the configuration of the upstream cache, emptying it.
+func ConfigureCache(config CacheConfig) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.config = config
+	cache.entries = make(map[string]*cacheEntry)
+	cache.stats = make(map[string]*structs.CacheStats)
+}
+
+// CacheStats reports the hit and miss counts and the number of values held, by data type.
+func CacheStats() map[string]structs.CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	stats := make(map[string]structs.CacheStats)
+	for _, kind := range []string{CacheCountries, CacheCountry, CacheRates, CacheWeather} {
+		stats[kind] = *cache.statsOf(kind)
+	}
+	for _, entry := range cache.entries {
+		s := stats[entry.kind]
+		s.Entries++
+		stats[entry.kind] = s
+	}
+	return stats
+}
+
+// statsOf returns the counts of a data type. The caller must hold the lock.
+func (c *upstreamCache) statsOf(kind string) *structs.CacheStats {
+	s, ok := c.stats[kind]
+	if !ok {
+		s = &structs.CacheStats{}
+		c.stats[kind] = s
+	}
+	return s
+}
+
+// put stores a freshly fetched value.
+func (c *upstreamCache) put(kind, id string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[id] = &cacheEntry{kind: kind, value: value, fetched: time.Now()}
+}
+
+// cached returns the value of a data type under a key from the upstream cache, fetching it with the context on a miss.
+// Stale values are returned while being refreshed in the background, if the configuration allows; the refresh outlives
+// the context. Errors are not cached.
+func cached[T any](ctx context.Context, kind, key string, fetch func(context.Context) (T, error)) (T, error) {
+	c := cache
+	id := kind + "|" + key
+
+	c.mu.Lock()
+	ttl := c.config.TTLs[kind]
+	stats := c.statsOf(kind)
+	if entry, ok := c.entries[id]; ok && ttl > 0 {
+		age := time.Since(entry.fetched)
+		switch {
+		case age < ttl:
+			stats.Hits++
+			c.mu.Unlock()
+			return entry.value.(T), nil
+		case c.config.StaleWhileRevalidate && age < 2*ttl:
+			stats.StaleHits++
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.revalidate(entry, kind, id, func() (any, error) { return fetch(context.Background()) })
+			}
+			c.mu.Unlock()
+			return entry.value.(T), nil
+		}
+	}
+	stats.Misses++
+	c.mu.Unlock()
+
+	value, err := fetch(ctx)
+	if err == nil && ttl > 0 {
+		c.put(kind, id, value)
+	}
+	return value, err
+}
+
+// revalidate refreshes a stale value in the background, keeping the stale one if the refresh fails.
+func (c *upstreamCache) revalidate(entry *cacheEntry, kind, id string, fetch func() (any, error)) {
+	value, err := fetch()
+	if err != nil {
+		log.Printf("Error refreshing cached %s %s: %v", kind, id, err)
+		c.mu.Lock()
+		entry.refreshing = false
+		c.mu.Unlock()
+		return
+	}
+	c.put(kind, id, value)
+}
@@ -6,32 +6,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	authenticate "globeboard/auth"
 	"globeboard/db"
 	"globeboard/internal/utils/constants/Endpoints"
 	"globeboard/internal/utils/constants/Webhooks"
 	"globeboard/internal/utils/structs"
+	"globeboard/lockout"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 )
 
-var (
-	isocode = "" // Variable to store ISO code.
-	title   = "" // Variable to store the title for webhook messages.
-	color   = 0  // Variable to store the color code for webhook messages.
-	method  = "" // Variable to store the HTTP method by which the webhook triggered.
-)
-
 // LoopSendWebhooksRegistrations sends notifications to registered webhooks about registration events.
-func LoopSendWebhooksRegistrations(caller string, ci *structs.CountryInfoExternal, endpoint, eventAction string) {
-	ctx := context.Background()
-
-	// Retrieve user information; ignore error as the user is pre-validated.
-	user, _ := authenticate.Client.GetUser(ctx, caller)
-	email := user.DisplayName + " (" + strings.ToLower(user.Email) + ")"
-
+func LoopSendWebhooksRegistrations(store db.Store, users UserDirectory, caller string, ci *structs.CountryInfoExternal, endpoint, eventAction string) {
+	var (
+		title  string // The title of the webhook messages.
+		color  int    // The color code of the webhook messages.
+		method string // The HTTP method by which the webhooks are triggered.
+	)
 	// Select appropriate message components based on the event type.
 	switch eventAction {
 	case Webhooks.EventRegister:
@@ -52,97 +44,135 @@ func LoopSendWebhooksRegistrations(caller string, ci *structs.CountryInfoExterna
 		method = http.MethodGet
 	}
 
-	// Get the isocode from the payload data.
-	isocode = ci.IsoCode
-
-	// Fetch all webhooks from the database.
-	webhooks, err := db.GetAllWebhooks()
+	// Fetch the webhooks of the user and the developer that are interested in this country and event.
+	webhooks, err := store.GetWebhooksForEvent(caller, ci.IsoCode, eventAction)
 	if err != nil {
 		log.Printf("Error retrieving webhooks from database: %v", err)
 		return
 	}
+	if len(webhooks) == 0 {
+		return // Nobody to notify; skip looking up the user.
+	}
+	email := callerLabel(users, caller)
 
-	// Iterate through each webhook and send notifications if conditions are met.
+	// Iterate through each matching webhook and send notifications.
 	for _, webhook := range webhooks {
-		if isRegistrationWebhookValid(caller, ci, eventAction, webhook) {
-			if strings.Contains(webhook.URL, "https://discord.com") {
-				sendDiscordWebhookPayload(email, title, color, method, endpoint, ci, webhook.URL)
-			} else {
-				sendWebhookPayload(email, title, method, endpoint, isocode, webhook.URL)
-			}
+		if strings.Contains(webhook.URL, "https://discord.com") {
+			sendDiscordWebhookPayload(email, title, color, method, endpoint, ci.IsoCode, ci, webhook.URL)
+		} else {
+			sendWebhookPayload(email, title, method, endpoint, ci.IsoCode, webhook.URL)
 		}
 	}
 }
 
 // LoopSendWebhooksDashboard sends notifications to registered webhooks about dashboard events.
-func LoopSendWebhooksDashboard(caller string, dr *structs.DashboardResponse) {
-	ctx := context.Background()
-
-	// Retrieve user information; ignore error as the user is pre-validated.
-	user, _ := authenticate.Client.GetUser(ctx, caller)
-	email := user.DisplayName + " (" + strings.ToLower(user.Email) + ")"
-
+func LoopSendWebhooksDashboard(store db.Store, users UserDirectory, caller string, dr *structs.DashboardResponse) {
 	// Default to INVOKE title as Dashboard endpoint GET populated dashboards at this time.
-	title = Webhooks.GETTitle
-	color = Webhooks.GETColor
-	method = Webhooks.EventInvoke
+	title := Webhooks.GETTitle
+	color := Webhooks.GETColor
+	method := Webhooks.EventInvoke
 
-	// Get the isocode from the payload data.
-	isocode = dr.IsoCode
-
-	// Fetch all webhooks from the database.
-	webhooks, err := db.GetAllWebhooks()
+	// Fetch the webhooks of the user and the developer that are interested in this country and event.
+	webhooks, err := store.GetWebhooksForEvent(caller, dr.IsoCode, Webhooks.EventInvoke)
 	if err != nil {
 		log.Printf("Error retrieving webhooks from database: %v", err)
 		return
 	}
+	if len(webhooks) == 0 {
+		return // Nobody to notify; skip looking up the user.
+	}
+	email := callerLabel(users, caller)
 
-	// Iterate through each webhook and send notifications if conditions are met.
+	// Iterate through each matching webhook and send notifications.
 	for _, webhook := range webhooks {
-		if isDashboardWebhookValid(caller, dr, Webhooks.EventInvoke, webhook) {
-			if strings.Contains(webhook.URL, "discord") {
-				sendDiscordWebhookPayload(email, title, color, method, Endpoints.DashboardsID, dr, webhook.URL)
-			} else {
-				sendWebhookPayload(email, title, method, Endpoints.DashboardsID, isocode, webhook.URL)
-			}
+		if strings.Contains(webhook.URL, "discord") {
+			sendDiscordWebhookPayload(email, title, color, method, Endpoints.DashboardsID, dr.IsoCode, dr, webhook.URL)
+		} else {
+			sendWebhookPayload(email, title, method, Endpoints.DashboardsID, dr.IsoCode, webhook.URL)
 		}
 	}
 }
 
-// isRegistrationWebhookValid checks if the webhook should trigger for the registration event.
-func isRegistrationWebhookValid(caller string, ci *structs.CountryInfoExternal, eventAction string, webhook structs.WebhookInternal) bool {
-	if webhook.UUID == "" || webhook.UUID == caller { // Validate that the webhook is associated with the user.
-		// (Empty UUID is for developer webhooks)
-		if webhook.Country == "" || webhook.Country == ci.IsoCode { // Validate webhook for country.
-			// The event is about.
-			return stringListContains(webhook.Event, eventAction) // Validate and return if webhook contains trigger event.
-		}
+// UserDirectory looks up users by UUID, as the identity providers do. It is declared here rather than taken from
+// them, as the identity providers depend on this package.
+type UserDirectory interface {
+	GetUser(ctx context.Context, UUID string) (*structs.User, error)
+}
+
+// UserLabel describes a user for notifications and logs, as "Display Name (email)".
+func UserLabel(user *structs.User) string {
+	return user.DisplayName + " (" + strings.ToLower(user.Email) + ")"
+}
+
+// callerLabel describes the user triggering an event for webhook messages, falling back to their UUID
+// if the identity provider cannot tell who they are.
+func callerLabel(users UserDirectory, caller string) string {
+	user, err := users.GetUser(context.Background(), caller)
+	if err != nil {
+		log.Printf("Error retrieving user %s for webhooks: %v", caller, err)
+		return caller
 	}
-	return false
+	return UserLabel(user)
 }
 
-// isDashboardWebhookValid checks if the webhook should trigger for the dashboard event.
-func isDashboardWebhookValid(caller string, dr *structs.DashboardResponse, eventAction string, webhook structs.WebhookInternal) bool {
-	if webhook.UUID == "" || webhook.UUID == caller {
-		if webhook.Country == "" || webhook.Country == dr.IsoCode {
-			return stringListContains(webhook.Event, eventAction)
+// LoopSendWebhooksUserDeleted notifies developer webhooks that a user and all their data have been deleted.
+// The user's own webhooks are gone by then, so only developer webhooks (empty UUID) are considered.
+func LoopSendWebhooksUserDeleted(store db.Store, email, UUID string) {
+	title := Webhooks.UserDeleteTitle
+	color := Webhooks.DELETEColor
+	method := http.MethodDelete
+	isocode := "" // A user deletion is not about any country.
+
+	// Fetch the developer webhooks subscribed to user deletions; the deleted user's own webhooks are already gone.
+	webhooks, err := store.GetWebhooksForEvent("", isocode, Webhooks.EventUserDelete)
+	if err != nil {
+		log.Printf("Error retrieving webhooks from database: %v", err)
+		return
+	}
+
+	payload := map[string]string{"uuid": UUID, "event": Webhooks.EventUserDelete}
+
+	// Iterate through each developer webhook and send notifications.
+	for _, webhook := range webhooks {
+		if strings.Contains(webhook.URL, "https://discord.com") {
+			sendDiscordWebhookPayload(email, title, color, method, Endpoints.UserDeletionID, isocode, payload, webhook.URL)
+		} else {
+			sendWebhookPayload(email, title, method, Endpoints.UserDeletionID, isocode, webhook.URL)
 		}
 	}
-	return false
 }
 
-// stringListContains checks if a string is present in a slice of strings.
-func stringListContains(s []string, str string) bool {
-	for _, v := range s {
-		if v == str {
-			return true
+// LoopSendWebhooksSecurityEvent notifies developer webhooks of a security event, such as a lockout.
+// The messages name the account the event is about, if any, never the credentials attempted.
+func LoopSendWebhooksSecurityEvent(store db.Store, event lockout.Event) {
+	title := Webhooks.SecurityTitle
+	color := Webhooks.DELETEColor
+	method := event.Type
+	isocode := "" // A security event is not about any country.
+	account := lockout.Account(event.Key)
+	if account == "" {
+		account = "Unknown" // The event is about a client address rather than an account.
+	}
+
+	// Fetch the developer webhooks subscribed to security events.
+	webhooks, err := store.GetWebhooksForEvent("", isocode, Webhooks.EventSecurity)
+	if err != nil {
+		log.Printf("Error retrieving webhooks from database: %v", err)
+		return
+	}
+
+	// Iterate through each developer webhook and send notifications.
+	for _, webhook := range webhooks {
+		if strings.Contains(webhook.URL, "https://discord.com") {
+			sendDiscordWebhookPayload(account, title, color, method, event.Endpoint, isocode, event, webhook.URL)
+		} else {
+			sendWebhookPayload(account, title, method, event.Endpoint, isocode, webhook.URL)
 		}
 	}
-	return false
 }
 
 // sendDiscordWebhookPayload sends a structured message as a Discord webhook.
-func sendDiscordWebhookPayload(email, title string, color int, event, endpoint string, requestBody interface{}, payloadUrl string) {
+func sendDiscordWebhookPayload(email, title string, color int, event, endpoint, country string, requestBody interface{}, payloadUrl string) {
 	requestBodyJSON, err := json.MarshalIndent(requestBody, "", "  ") // Pretty-print JSON for readability.
 	if err != nil {
 		log.Println("Error marshaling request body:", err)
@@ -156,7 +186,7 @@ func sendDiscordWebhookPayload(email, title string, color int, event, endpoint s
 	fields := []structs.Field{
 		{Name: "Event", Value: event, Inline: true},
 		{Name: "Endpoint", Value: endpoint, Inline: true},
-		{Name: "Country", Value: isocode, Inline: true},
+		{Name: "Country", Value: country, Inline: true},
 		{Name: "Payload", Value: requestBodyString, Inline: false},
 	}
 
@@ -0,0 +1,27 @@
+// Package _func provides developer-made utility functions for use within the application.
+package _func
+
+import (
+	"errors"
+	"fmt"
+	"globeboard/internal/utils/structs"
+	"net/url"
+	"slices"
+)
+
+// ValidateWebhook checks that a webhook has a usable URL and subscribes only to the given events.
+func ValidateWebhook(hook structs.WebhookResponse, events []string) error {
+	target, err := url.ParseRequestURI(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("webhook URL must be an absolute http or https URL")
+	}
+	if len(hook.Event) == 0 {
+		return errors.New("webhook must subscribe to at least one event")
+	}
+	for _, event := range hook.Event {
+		if !slices.Contains(events, event) {
+			return fmt.Errorf("unknown webhook event: '%s'", event)
+		}
+	}
+	return nil
+}
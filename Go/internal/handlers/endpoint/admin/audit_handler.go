@@ -0,0 +1,116 @@
+// Package admin provides HTTP handlers for the administrative API, through which administrators manage users
+// and developer webhooks. Every action taken through it is recorded in the audit trail.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Actions recorded in the audit trail.
+const (
+	ActionListUsers      = "users.list"     // ActionListUsers records listing the users.
+	ActionListKeys       = "keys.list"      // ActionListKeys records listing the API keys of a user.
+	ActionRevokeKey      = "key.revoke"     // ActionRevokeKey records revoking an API key of a user.
+	ActionRevokeKeys     = "keys.revoke"    // ActionRevokeKeys records revoking every API key of a user.
+	ActionDisableUser    = "user.disable"   // ActionDisableUser records disabling the account of a user.
+	ActionEnableUser     = "user.enable"    // ActionEnableUser records re-enabling the account of a user.
+	ActionListWebhooks   = "webhooks.list"  // ActionListWebhooks records listing the developer webhooks.
+	ActionGetWebhook     = "webhook.get"    // ActionGetWebhook records reading a developer webhook.
+	ActionCreateWebhook  = "webhook.create" // ActionCreateWebhook records creating a developer webhook.
+	ActionDeleteWebhook  = "webhook.delete" // ActionDeleteWebhook records deleting a developer webhook.
+	ActionReadAuditTrail = "audit.read"     // ActionReadAuditTrail records reading the audit trail.
+	ActionReadUsage      = "usage.read"     // ActionReadUsage records reading the API usage of every user or of one.
+	ActionListLockouts   = "lockouts.list"  // ActionListLockouts records listing the lockouts.
+	ActionClearLockout   = "lockout.clear"  // ActionClearLockout records clearing a lockout.
+)
+
+const (
+	ContentType     = "Content-Type"     // HTTP header field for content-type
+	ApplicationJSON = "application/json" // MIME type for JSON
+)
+
+// AuditHandler handles HTTP requests for reading the audit trail.
+func AuditHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleAuditGetRequest(w, r, store) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminAudit, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// handleAuditGetRequest lists the audit trail, newest first, optionally limited with '?limit=' and filtered
+// to the actions of one administrator with '?actor=' or on one target with '?target='.
+func handleAuditGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	query := r.URL.Query()
+	auditQuery := db.AuditQuery{
+		Limit:  constants.DefaultPageLimit,
+		Actor:  query.Get("actor"),
+		Target: query.Get("target"),
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > constants.MaxPageLimit {
+			http.Error(w, fmt.Sprintf("limit must be a number between 1 and %d", constants.MaxPageLimit), http.StatusBadRequest)
+			return
+		}
+		auditQuery.Limit = limit
+	}
+
+	entries, err := store.GetAuditEntries(auditQuery) // Retrieve the matching entries.
+	if err != nil {
+		log.Printf("%s: Error retrieving audit trail: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []structs.AuditEntry{}
+	}
+	record(store, r, ActionReadAuditTrail, "", query.Encode())
+
+	writeJSON(w, r, http.StatusOK, entries)
+}
+
+// record adds an action the administrator behind the request took to the audit trail. The action has already
+// been taken, so failing to record it is logged rather than reported to the administrator.
+func record(store db.AdminStore, r *http.Request, action, target, details string) {
+	entry := &structs.AuditEntry{
+		ID:      _func.GenerateUID(constants.IdLength),
+		IP:      r.RemoteAddr,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	if key, _ := middleware.APIKey(r); key != nil {
+		entry.Actor = key.UUID
+	}
+	if err := store.AddAuditEntry(entry); err != nil {
+		log.Printf("%s: Error recording %s of %q by %s in the audit trail: %v", r.RemoteAddr, action, target, entry.Actor, err)
+		return
+	}
+	log.Printf("%s: Recorded %s of %q by administrator %s.", r.RemoteAddr, action, target, entry.Actor)
+}
+
+// writeJSON sends the given value as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set(ContentType, ApplicationJSON) // Set the content type of the response.
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("%s: Error encoding JSON response: %v", r.RemoteAddr, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Package admin provides HTTP handlers for the administrative API, through which administrators manage users
+// and developer webhooks. Every action taken through it is recorded in the audit trail.
+package admin
+
+import (
+	"globeboard/db"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/lockout"
+	"log"
+	"net/http"
+)
+
+// LockoutsHandler handles HTTP requests for listing the clients and accounts locked out after too many
+// failed authentication attempts.
+func LockoutsHandler(store db.Store, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			record(store, r, ActionListLockouts, "", "")
+			writeJSON(w, r, http.StatusOK, guard.Lockouts()) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminLockouts, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// LockoutKeyHandler handles HTTP requests for clearing a specific lockout by its key, e.g. "ip:203.0.113.7".
+func LockoutKeyHandler(store db.Store, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			handleLockoutDeleteRequest(w, r, store, guard) // Handle DELETE requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminLockoutKey, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodDelete, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// handleLockoutDeleteRequest lifts a lockout, letting the client or account try again at once.
+func handleLockoutDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store, guard *lockout.Guard) {
+	key := r.PathValue("KEY") // Extract the lockout key from the URL path.
+
+	var actor string
+	if apiKey, _ := middleware.APIKey(r); apiKey != nil {
+		actor = apiKey.UUID
+	}
+	if !guard.Clear(key, actor) {
+		http.Error(w, "Lockout not found", http.StatusNotFound)
+		return
+	}
+	record(store, r, ActionClearLockout, key, "")
+
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful clearing.
+}
@@ -0,0 +1,53 @@
+// Package admin provides HTTP handlers for the administrative API, through which administrators manage users
+// and developer webhooks. Every action taken through it is recorded in the audit trail.
+package admin
+
+import (
+	"globeboard/db"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/metering"
+	"log"
+	"net/http"
+)
+
+// UsageHandler handles HTTP requests for reading the API usage of every user.
+func UsageHandler(store db.Store, meter *metering.Meter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleUsageGetRequest(w, r, store, meter) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminUsage, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// handleUsageGetRequest reports the API usage of every user per day, API key, endpoint and status code over
+// the date range given with '?from=' and '?to=' (YYYY-MM-DD, both inclusive), by default the last 30 days,
+// optionally limited to one user with '?uuid='.
+func handleUsageGetRequest(w http.ResponseWriter, r *http.Request, store db.Store, meter *metering.Meter) {
+	query := r.URL.Query()
+	from, to, err := metering.ParseRange(query.Get("from"), query.Get("to"), constants.DefaultUsageDays, constants.MaxUsageDays)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := meter.Flush(); err != nil { // Include the requests metered since the last flush.
+		log.Printf("%s: Error flushing API usage: %v", r.RemoteAddr, err)
+	}
+	UUID := query.Get("uuid")
+	usage, err := store.GetUsage(db.UsageQuery{UUID: UUID, From: from, To: to})
+	if err != nil {
+		log.Printf("%s: Error retrieving API usage: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	record(store, r, ActionReadUsage, UUID, query.Encode())
+
+	writeJSON(w, r, http.StatusOK, metering.Report(from, to, usage))
+}
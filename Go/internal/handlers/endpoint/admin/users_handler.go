@@ -0,0 +1,262 @@
+// Package admin provides HTTP handlers for the administrative API, through which administrators manage users
+// and developer webhooks. Every action taken through it is recorded in the audit trail.
+package admin
+
+import (
+	"errors"
+	"fmt"
+	authenticate "globeboard/auth"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// UsersHandler handles HTTP requests for listing users, marking the given administrators (UUIDs).
+func UsersHandler(store db.Store, users authenticate.Provider, admins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleUsersGetRequest(w, r, store, users, admins) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminUsers, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// UserKeysHandler handles HTTP requests for listing and revoking all API keys of a user.
+func UserKeysHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET requests
+			handleUserKeysGetRequest(w, r, store)
+		case http.MethodDelete: // Handle DELETE requests
+			handleUserKeysDeleteRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminUserKeys, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are: GET, DELETE", http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// UserKeyIdHandler handles HTTP requests for revoking a specific API key of a user.
+func UserKeyIdHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			handleUserKeyIdDeleteRequest(w, r, store) // Handle DELETE requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminUserKeyID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodDelete, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// UserDisableHandler handles HTTP requests for disabling and re-enabling the account of a user.
+func UserDisableHandler(store db.Store, users authenticate.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost: // Handle POST requests
+			handleUserDisableRequest(w, r, store, users)
+		case http.MethodDelete: // Handle DELETE requests
+			handleUserEnableRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminUserDisable, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are: POST, DELETE", http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// handleUsersGetRequest lists every user along with how many API keys, registrations and webhooks they own.
+// Users known to the store but not to the identity provider, such as those of another provider, are listed
+// without their email and name.
+func handleUsersGetRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider, admins []string) {
+	known, err := users.ListUsers(r.Context()) // Retrieve the users of the identity provider.
+	if err != nil {
+		log.Printf("%s: Error listing users: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving users from identity provider", http.StatusInternalServerError)
+		return
+	}
+	counts, err := store.CountUserData() // Count what each user owns.
+	if err != nil {
+		log.Printf("%s: Error counting user data: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	disabled, err := store.GetDisabledUsers() // Retrieve which users are disabled.
+	if err != nil {
+		log.Printf("%s: Error retrieving disabled users: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make(map[string]*structs.UserSummary)
+	summary := func(UUID string) *structs.UserSummary {
+		if s, ok := summaries[UUID]; ok {
+			return s
+		}
+		s := &structs.UserSummary{UUID: UUID, Admin: middleware.IsAdmin(admins, UUID), UserCounts: counts[UUID]}
+		if t, ok := disabled[UUID]; ok {
+			s.Disabled = &t
+		}
+		summaries[UUID] = s
+		return s
+	}
+	for _, user := range known {
+		s := summary(user.UUID)
+		s.Email = strings.ToLower(user.Email)
+		s.DisplayName = user.DisplayName
+		if !user.Created.IsZero() {
+			created := user.Created
+			s.Created = &created
+		}
+	}
+	for UUID := range counts {
+		summary(UUID)
+	}
+	for UUID := range disabled {
+		summary(UUID)
+	}
+
+	// Sort the users by registration, those registered at an unknown time last, and by UUID otherwise.
+	response := make([]structs.UserSummary, 0, len(summaries))
+	for _, s := range summaries {
+		response = append(response, *s)
+	}
+	slices.SortFunc(response, func(a, b structs.UserSummary) int {
+		switch {
+		case a.Created != nil && b.Created != nil && !a.Created.Equal(*b.Created):
+			return a.Created.Compare(*b.Created)
+		case a.Created != nil && b.Created == nil:
+			return -1
+		case a.Created == nil && b.Created != nil:
+			return 1
+		}
+		return strings.Compare(a.UUID, b.UUID)
+	})
+	record(store, r, ActionListUsers, "", fmt.Sprintf("%d users", len(response)))
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleUserKeysGetRequest lists the metadata of a user's API keys, oldest first, without the keys themselves.
+func handleUserKeysGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID := r.PathValue("ID") // Extract the user's ID from the URL path.
+
+	keys, err := store.GetAPIKeys(r.RemoteAddr, UUID) // Retrieve all the user's API keys.
+	if err != nil {
+		log.Printf("%s: Error retrieving API keys: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	slices.SortFunc(keys, func(a, b structs.APIKey) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	response := []structs.APIKeyMetadata{}
+	for _, key := range keys {
+		response = append(response, _func.APIKeyMetadata(key))
+	}
+	record(store, r, ActionListKeys, UUID, "")
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleUserKeysDeleteRequest revokes every API key of a user.
+func handleUserKeysDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID := r.PathValue("ID") // Extract the user's ID from the URL path.
+
+	keys, err := store.GetAPIKeys(r.RemoteAddr, UUID) // Retrieve all the user's API keys.
+	if err != nil {
+		log.Printf("%s: Error retrieving API keys: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+
+	revoked := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := store.DeleteApiKeyByID(r.RemoteAddr, key.ID, UUID); err != nil {
+			log.Printf("%s: Error revoking API Key: %v", r.RemoteAddr, err)
+			record(store, r, ActionRevokeKeys, UUID, "partially revoked: "+strings.Join(revoked, ", "))
+			http.Error(w, "Error deleting data from database", http.StatusInternalServerError)
+			return
+		}
+		revoked = append(revoked, key.ID)
+	}
+	record(store, r, ActionRevokeKeys, UUID, strings.Join(revoked, ", "))
+
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful revocation.
+}
+
+// handleUserKeyIdDeleteRequest revokes a specific API key of a user.
+func handleUserKeyIdDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID := r.PathValue("ID") // Extract the user's ID from the URL path.
+	ID := r.PathValue("KEY")  // Extract the API key ID from the URL path.
+
+	if err := store.DeleteApiKeyByID(r.RemoteAddr, ID, UUID); err != nil {
+		log.Printf("%s: Error revoking API Key: %v", r.RemoteAddr, err)
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	record(store, r, ActionRevokeKey, UUID, ID)
+
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful revocation.
+}
+
+// handleUserDisableRequest disables the account of a user, after which their API keys and sessions are refused
+// and they cannot sign in until re-enabled. Administrators may not disable themselves.
+func handleUserDisableRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	UUID := r.PathValue("ID") // Extract the user's ID from the URL path.
+
+	if key, _ := middleware.APIKey(r); key != nil && key.UUID == UUID {
+		http.Error(w, "Administrators may not disable their own account", http.StatusConflict)
+		return
+	}
+	if _, err := users.GetUser(r.Context(), UUID); err != nil { // Check the user exists.
+		if errors.Is(err, authenticate.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("%s: Error retrieving user: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving user from identity provider", http.StatusInternalServerError)
+		return
+	}
+
+	if err := store.DisableUser(r.RemoteAddr, UUID); err != nil {
+		log.Printf("%s: Error disabling user: %v", r.RemoteAddr, err)
+		http.Error(w, "Error storing data in database", http.StatusInternalServerError)
+		return
+	}
+	record(store, r, ActionDisableUser, UUID, "")
+
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful disabling.
+}
+
+// handleUserEnableRequest re-enables the account of a disabled user.
+func handleUserEnableRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID := r.PathValue("ID") // Extract the user's ID from the URL path.
+
+	if err := store.EnableUser(r.RemoteAddr, UUID); err != nil {
+		log.Printf("%s: Error enabling user: %v", r.RemoteAddr, err)
+		http.Error(w, "Error deleting data from database", http.StatusInternalServerError)
+		return
+	}
+	record(store, r, ActionEnableUser, UUID, "")
+
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful re-enabling.
+}
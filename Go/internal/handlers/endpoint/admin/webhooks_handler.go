@@ -0,0 +1,138 @@
+// Package admin provides HTTP handlers for the administrative API, through which administrators manage users
+// and developer webhooks. Every action taken through it is recorded in the audit trail.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Webhooks"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// developerWebhookUUID owns developer webhooks, which are notified of the events of every user.
+const developerWebhookUUID = ""
+
+// developerEvents lists the events developer webhooks may subscribe to.
+var developerEvents = []string{Webhooks.EventRegister, Webhooks.EventChange, Webhooks.EventDelete, Webhooks.EventInvoke, Webhooks.EventUserDelete, Webhooks.EventSecurity}
+
+// WebhooksHandler handles HTTP requests for creating and listing developer webhooks.
+func WebhooksHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost: // Handle POST requests
+			handleWebhookPostRequest(w, r, store)
+		case http.MethodGet: // Handle GET requests
+			handleWebhooksGetRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminWebhooks, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are: POST, GET", http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// WebhookIdHandler handles HTTP requests for a specific developer webhook by ID.
+func WebhookIdHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET requests
+			handleWebhookGetRequest(w, r, store)
+		case http.MethodDelete: // Handle DELETE requests
+			handleWebhookDeleteRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.AdminWebhookID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are: GET, DELETE", http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// handleWebhookPostRequest creates a developer webhook, which unlike the webhooks of users is also notified
+// of user deletions.
+func handleWebhookPostRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	var hook structs.WebhookResponse
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil { // Decode the JSON request body into webhook struct.
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	hook.Country = strings.ToUpper(hook.Country)
+	if err := _func.ValidateWebhook(hook, developerEvents); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook := &structs.WebhookInternal{
+		ID:      _func.GenerateUID(constants.IdLength), // Generate a unique ID for the webhook.
+		UUID:    developerWebhookUUID,
+		URL:     hook.URL,
+		Country: hook.Country,
+		Event:   hook.Event,
+	}
+	err := store.AddWebhook(r.RemoteAddr, _func.GenerateUID(constants.DocIdLength), webhook) // Add the webhook to the database.
+	if err != nil {
+		log.Printf("%s: Error saving developer webhook: %v", r.RemoteAddr, err)
+		http.Error(w, "Error storing data in database", http.StatusInternalServerError)
+		return
+	}
+	record(store, r, ActionCreateWebhook, webhook.ID, webhook.URL+" "+strings.Join(webhook.Event, ","))
+
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{"id": webhook.ID})
+}
+
+// handleWebhooksGetRequest lists every developer webhook.
+func handleWebhooksGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	hooks, err := store.GetWebhooksUser(r.RemoteAddr, developerWebhookUUID) // Retrieve the developer webhooks.
+	if err != nil {
+		log.Printf("%s: Error retrieving developer webhooks: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	if hooks == nil {
+		hooks = []structs.WebhookResponse{}
+	}
+	slices.SortFunc(hooks, func(a, b structs.WebhookResponse) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	record(store, r, ActionListWebhooks, "", "")
+
+	writeJSON(w, r, http.StatusOK, hooks)
+}
+
+// handleWebhookGetRequest retrieves a specific developer webhook.
+func handleWebhookGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	ID := r.PathValue("ID") // Extract the webhook ID from the URL path.
+
+	hook, err := store.GetSpecificWebhook(r.RemoteAddr, ID, developerWebhookUUID)
+	if err != nil {
+		log.Printf("%s: Error getting developer webhook: %v", r.RemoteAddr, err)
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	record(store, r, ActionGetWebhook, ID, "")
+
+	writeJSON(w, r, http.StatusOK, hook)
+}
+
+// handleWebhookDeleteRequest deletes a specific developer webhook.
+func handleWebhookDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	ID := r.PathValue("ID") // Extract the webhook ID from the URL path.
+
+	if err := store.DeleteWebhook(r.RemoteAddr, ID, developerWebhookUUID); err != nil {
+		log.Printf("%s: Error deleting developer webhook: %v", r.RemoteAddr, err)
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	record(store, r, ActionDeleteWebhook, ID, "")
+
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful deletion.
+}
@@ -2,52 +2,49 @@
 package dashboard
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	authenticate "globeboard/auth"
 	"globeboard/db"
 	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/External"
+	"globeboard/internal/utils/constants/Scopes"
 	"globeboard/internal/utils/structs"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 )
 
-const (
-	APIInfoRetrivalError   = "Error getting country information"       // Error message for when country information cannot be retrieved.
-	APICoordsRetrivalError = "Error getting Coordinates Information: " // Error message for when coordinates information cannot be retrieved.
-)
-
-// DashboardsIdHandler handles requests to the dashboard endpoint.
-func DashboardsIdHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet: // Handle GET request.
-		handleDashboardGetRequest(w, r)
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.DashboardsID, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
-		return
+// DashboardsIdHandler handles requests to the dashboard endpoint, resolving the features of a dashboard within timeout.
+// Dashboards missing some of their features are returned with partialStatus.
+func DashboardsIdHandler(store db.Store, users authenticate.Provider, timeout time.Duration, partialStatus int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET request.
+			handleDashboardGetRequest(w, r, store, users, timeout, partialStatus)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.DashboardsID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
 // handleDashboardGetRequest processes GET requests to retrieve dashboards by ID.
-func handleDashboardGetRequest(w http.ResponseWriter, r *http.Request) {
-	ID := r.PathValue("ID")     // Retrieve ID from URL path.
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Retrieve token from URL query parameters.
-	if token == "" {            // Check if a token is provided.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.DashboardsID)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve UUID associated with API token.
-	if UUID == "" {                               // Check if UUID is retrieved.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.DashboardsID)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleDashboardGetRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider, timeout time.Duration, partialStatus int) {
+	ID := r.PathValue("ID")                                                               // Retrieve ID from URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.DashboardsID, Scopes.DashboardsRead) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 	if ID == "" || ID == " " { // Check if the ID is valid.
@@ -56,7 +53,7 @@ func handleDashboardGetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reg, err := db.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve registration by ID for user (UUID).
+	reg, err := store.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve registration by ID for user (UUID).
 	if err != nil {
 		log.Printf("%s: Error getting registration: %v", r.RemoteAddr, err)
 		err := fmt.Sprintf("Dashboard doesn't exist: %v", err)
@@ -71,26 +68,29 @@ func handleDashboardGetRequest(w http.ResponseWriter, r *http.Request) {
 	dr.Country = reg.Country
 	dr.IsoCode = reg.IsoCode
 
-	// Country information API integration.
-	if getCountryInfo(w, reg, dr) {
-		return
-	}
+	// Resolve the features within the deadline, cancelling the upstream requests in flight as it passes or as the
+	// client disconnects.
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	// Currency information API integration.
-	if getCurrencyInfo(w, reg, dr) {
-		return
-	}
-
-	// Weather information API integration.
-	if getWeatherInfo(w, reg, dr) {
-		return
+	// Return the features that were resolved, along with the errors of those that were not.
+	resolved := resolveFeatures(ctx, reg, dr)
+	failed := resolved == 0 && len(dr.Errors) > 0 // Whether no feature could be resolved.
+	status := http.StatusOK
+	switch {
+	case failed:
+		log.Printf("%s: Error resolving dashboard features: %+v", r.RemoteAddr, dr.Errors)
+		status = failedStatus(dr.Errors)
+	case len(dr.Errors) > 0:
+		log.Printf("%s: Returning partial dashboard, failed to resolve: %+v", r.RemoteAddr, dr.Errors)
+		status = partialStatus
 	}
 
 	// Set the LastRetrieval time and format it to ISO8601 format to mirror Firestore Server Timestamp.
 	dr.LastRetrieval = time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	err = json.NewEncoder(w).Encode(dr) // Encode the dashboard response into JSON and write to the response writer.
 	if err != nil {
 		log.Print(err)
@@ -98,99 +98,157 @@ func handleDashboardGetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_func.LoopSendWebhooksDashboard(UUID, dr) // Send notifications to webhooks.
+	if failed { // Nothing was retrieved to notify webhooks of.
+		return
+	}
+	_func.LoopSendWebhooksDashboard(store, users, UUID, dr) // Send notifications to webhooks.
 }
 
-// getWeatherInfo fetches weather information for a specific registration and updates the dashboard response.
-func getWeatherInfo(w http.ResponseWriter, reg *structs.CountryInfoInternal, dr *structs.DashboardResponse) bool {
-	if reg.Features.Temperature { // Check if the temperature feature is enabled.
-		coords, err := _func.GetCoordinates(reg.IsoCode) // Get coordinates for the ISO code.
-		if err != nil {
-			log.Print(APICoordsRetrivalError, err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
-		}
-		temp, err := _func.GetTemp(coords) // Get temperature for the coordinates.
-		if err != nil {
-			log.Print("Error getting Temperature Information: ", err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+// failedStatus returns the status of a dashboard none of whose features could be resolved: 503 Service Unavailable
+// if every upstream request timed out or was cancelled, or 502 Bad Gateway if any upstream failed outright.
+func failedStatus(errs []structs.FeatureError) int {
+	for _, e := range errs {
+		if e.Error != featureErrorReason(context.DeadlineExceeded) && e.Error != featureErrorReason(context.Canceled) {
+			return http.StatusBadGateway
 		}
-		dr.Features.Temperature = strconv.FormatFloat(temp, 'f', 1, 64) // Format temperature and set to dashboard response.
 	}
+	return http.StatusServiceUnavailable
+}
 
-	if reg.Features.Precipitation { // Check if the precipitation feature is enabled.
-		coords, err := _func.GetCoordinates(reg.IsoCode) // Get coordinates for the ISO code.
-		if err != nil {
-			log.Print(APICoordsRetrivalError, err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+// resolveFeatures resolves the features of a registration concurrently, setting them on the dashboard response and
+// adding an error to it for each feature that failed. It returns the number of features resolved.
+func resolveFeatures(ctx context.Context, reg *structs.CountryInfoInternal, dr *structs.DashboardResponse) int {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		resolved int
+	)
+	// fail adds the error of a feature to the dashboard response.
+	fail := func(feature, source string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dr.Errors = append(dr.Errors, structs.FeatureError{Feature: feature, Source: source, Error: featureErrorReason(err)})
+	}
+	// resolve resolves a feature in its own goroutine; each feature sets a field of its own on the dashboard response.
+	resolve := func(feature, source string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				fail(feature, source, err)
+				return
+			}
+			mu.Lock()
+			resolved++
+			mu.Unlock()
+		}()
+	}
+
+	// Fetch the country profile every feature is assembled from, in a single REST Countries request.
+	profile, profileErr := _func.GetCountryProfile(ctx, reg.IsoCode)
+
+	// Country information is assembled from the profile.
+	for feature, enabled := range map[string]bool{
+		"capital":     reg.Features.Capital,
+		"coordinates": reg.Features.Coordinates,
+		"population":  reg.Features.Population,
+		"area":        reg.Features.Area,
+	} {
+		if !enabled {
+			continue
 		}
-		precipitation, err := _func.GetPrecipitation(coords) // Get precipitation for the coordinates.
-		if err != nil {
-			log.Print("Error getting Temperature Information: ", err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+		if profileErr != nil {
+			fail(feature, External.CountriesSource, profileErr) // The feature cannot be assembled without the profile.
+			continue
 		}
-		dr.Features.Precipitation = strconv.FormatFloat(precipitation, 'f', 2, 64) // Format precipitation and set to dashboard response.
-	}
-	return false
-}
-
-// getCurrencyInfo fetches currency exchange information for a specific registration and updates the dashboard response.
-func getCurrencyInfo(w http.ResponseWriter, reg *structs.CountryInfoInternal, dr *structs.DashboardResponse) bool {
-	if reg.Features.TargetCurrencies != nil && len(reg.Features.TargetCurrencies) > 0 { // Check if target-currencies feature is non nil and non-empty.
-		exchangeRate, err := _func.GetExchangeRate(reg.IsoCode, reg.Features.TargetCurrencies) // Get exchange rates for the target currencies.
-		if err != nil {
-			log.Print("Error getting Exchange Rate Information: ", err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+		if err := getCountryInfo(feature, profile, dr); err != nil {
+			fail(feature, External.CountriesSource, err)
+			continue
 		}
-		dr.Features.TargetCurrencies = exchangeRate // Set exchange rates to dashboard response.
+		resolved++
 	}
-	return false
-}
 
-// getCountryInfo fetches country-specific information for a specific registration and updates the dashboard response.
-func getCountryInfo(w http.ResponseWriter, reg *structs.CountryInfoInternal, dr *structs.DashboardResponse) bool {
-	if reg.Features.Capital { // Check if the capital feature is enabled.
-		capital, err := _func.GetCapital(reg.IsoCode) // Get capital for the ISO code.
-		if err != nil {
-			log.Print("Error getting Capital Information: ", err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+	// Currency information API integration.
+	if len(reg.Features.TargetCurrencies) > 0 { // Check if target-currencies feature is non-empty.
+		if profileErr != nil {
+			fail("targetCurrencies", External.CountriesSource, profileErr)
+		} else {
+			resolve("targetCurrencies", External.CurrencySource, func() error {
+				exchangeRate, err := _func.GetExchangeRate(ctx, profile, reg.Features.TargetCurrencies) // Get exchange rates for the target currencies.
+				if err != nil {
+					return err
+				}
+				dr.Features.TargetCurrencies = exchangeRate // Set exchange rates to dashboard response.
+				return nil
+			})
 		}
-		dr.Features.Capital = capital // Set capital to dashboard response.
 	}
 
-	if reg.Features.Coordinates { // Check if the coordinate feature is enabled.
-		coords, err := _func.GetCoordinates(reg.IsoCode) // Get coordinates for the ISO code.
-		if err != nil {
-			log.Print(APICoordsRetrivalError, err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+	// Weather information API integration, for the coordinates in the profile.
+	weather := map[string]bool{"temperature": reg.Features.Temperature, "precipitation": reg.Features.Precipitation}
+	coords, coordsErr := profile.Coordinates()
+	for feature, enabled := range weather {
+		if !enabled {
+			continue
 		}
-		dr.Features.Coordinates = &coords // Set coordinates to dashboard response.
+		if err := cmp.Or(profileErr, coordsErr); err != nil {
+			fail(feature, External.CountriesSource, err) // The weather cannot be looked up without coordinates.
+			continue
+		}
+		resolve(feature, External.MeteoSource, func() error {
+			if feature == "temperature" {
+				temp, err := _func.GetTemp(ctx, coords) // Get temperature for the coordinates.
+				if err != nil {
+					return err
+				}
+				dr.Features.Temperature = strconv.FormatFloat(temp, 'f', 1, 64) // Format temperature and set to dashboard response.
+				return nil
+			}
+			precipitation, err := _func.GetPrecipitation(ctx, coords) // Get precipitation for the coordinates.
+			if err != nil {
+				return err
+			}
+			dr.Features.Precipitation = strconv.FormatFloat(precipitation, 'f', 2, 64) // Format precipitation and set to dashboard response.
+			return nil
+		})
+	}
+
+	wg.Wait()
+	slices.SortFunc(dr.Errors, func(a, b structs.FeatureError) int { return cmp.Compare(a.Feature, b.Feature) }) // Report errors in a stable order.
+	return resolved
+}
+
+// featureErrorReason describes why a feature failed to resolve for the dashboard response.
+func featureErrorReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return err.Error()
 	}
+}
 
-	if reg.Features.Population { // Check if the population feature is enabled.
-		pop, err := _func.GetPopulation(reg.IsoCode) // Get population for the ISO code.
+// getCountryInfo assembles a country-specific feature from the country profile and updates the dashboard response.
+func getCountryInfo(feature string, profile _func.CountryProfile, dr *structs.DashboardResponse) error {
+	switch feature {
+	case "capital":
+		capital, err := profile.CapitalCity() // Get capital from the country profile.
 		if err != nil {
-			log.Print("Error getting Population Information: ", err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+			return err
 		}
-		dr.Features.Population = pop // Set population to dashboard response.
-	}
-
-	if reg.Features.Area { // Check if area feature is enabled.
-		area, err := _func.GetArea(reg.IsoCode) // Get area for the ISO code.
+		dr.Features.Capital = capital // Set capital to dashboard response.
+	case "coordinates":
+		coords, err := profile.Coordinates() // Get coordinates from the country profile.
 		if err != nil {
-			log.Print("Error getting Area Information: ", err)
-			http.Error(w, APIInfoRetrivalError, http.StatusInternalServerError)
-			return true
+			return err
 		}
-		dr.Features.Area = strconv.FormatFloat(area, 'f', 1, 64) // Format area and set to dashboard response.
+		dr.Features.Coordinates = &coords // Set coordinates to dashboard response.
+	case "population":
+		dr.Features.Population = profile.Population // Set population from the country profile to dashboard response.
+	case "area":
+		dr.Features.Area = strconv.FormatFloat(profile.Area, 'f', 1, 64) // Format area from the country profile and set to dashboard response.
 	}
-	return false
+	return nil
 }
@@ -6,42 +6,36 @@ import (
 	"fmt"
 	"globeboard/db"
 	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
 	"globeboard/internal/utils/structs"
 	"log"
 	"net/http"
 )
 
 // NotificationsHandler handles HTTP requests related to notification webhooks.
-func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost: // Handle POST request
-		handleNotifPostRequest(w, r)
-	case http.MethodGet: // Handle GET request
-		handleNotifGetAllRequest(w, r)
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Notifications, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost+"\n"+http.MethodGet, http.StatusNotImplemented)
-		return
+func NotificationsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost: // Handle POST request
+			handleNotifPostRequest(w, r, store)
+		case http.MethodGet: // Handle GET request
+			handleNotifGetAllRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Notifications, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost+"\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
 // handleNotifPostRequest processes POST requests to create a new notification webhook.
-func handleNotifPostRequest(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Retrieve token from the URL query parameters.
-	if token == "" {            // Check if a token is provided.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.Notifications)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve UUID associated with the API token.
-	if UUID == "" {                               // Check if UUID is valid.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.Notifications)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleNotifPostRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID, ok := middleware.Authorize(w, r, Endpoints.Notifications, Scopes.NotificationsWrite) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 
@@ -65,14 +59,14 @@ func handleNotifPostRequest(w http.ResponseWriter, r *http.Request) {
 	webhook.ID = ID
 	webhook.UUID = UUID
 
-	err := db.AddWebhook(r.RemoteAddr, UDID, webhook) // Add the webhook to the database.
+	err := store.AddWebhook(r.RemoteAddr, UDID, webhook) // Add the webhook to the database.
 	if err != nil {
 		log.Println("Error saving data to database" + err.Error())
 		http.Error(w, "Error storing data in database", http.StatusInternalServerError)
 		return
 	}
 
-	hook, err := db.GetSpecificWebhook(r.RemoteAddr, ID, UUID) // Retrieve the newly added webhook to confirm its addition.
+	hook, err := store.GetSpecificWebhook(r.RemoteAddr, ID, UUID) // Retrieve the newly added webhook to confirm its addition.
 	if err != nil {
 		log.Print("Error getting document from database: ", err)
 		http.Error(w, "Error confirming data added to database", http.StatusInternalServerError)
@@ -96,23 +90,23 @@ func handleNotifPostRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleNotifGetAllRequest processes GET requests to retrieve all notification webhooks for a user.
-func handleNotifGetAllRequest(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Retrieve token from the URL query parameters.
-	if token == "" {            // Check if a token is provided.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.Notifications)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
+func handleNotifGetAllRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	query := r.URL.Query()                                                                    // Extract the query parameters.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.Notifications, Scopes.NotificationsRead) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve UUID associated with the API token.
-	if UUID == "" {                               // Check if UUID is valid.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.Notifications)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+	hookQuery, err := parseWebhookQuery(query) // Parse the pagination and filter parameters.
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	regs, err := db.GetWebhooksUser(r.RemoteAddr, UUID) // Retrieve all webhooks associated with the user (UUID).
+	regs, next, err := store.ListWebhooks(r.RemoteAddr, UUID, hookQuery) // Retrieve a page of the user's webhooks.
 	if err != nil {
+		if isQueryError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("%s: Error retrieving webhooks from database: %v", r.RemoteAddr, err)
 		errmsg := fmt.Sprint("Error retrieving webhooks from database: ", err)
 		http.Error(w, errmsg, http.StatusInternalServerError)
@@ -120,6 +114,7 @@ func handleNotifGetAllRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set(ContentType, ApplicationJSON) // Set the content type of the response.
+	setNextLink(w, r, next)                      // Point to the next page, if any.
 
 	w.WriteHeader(http.StatusOK) // Set HTTP status to "OK".
 
@@ -5,42 +5,36 @@ import (
 	"encoding/json"
 	"fmt"
 	"globeboard/db"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
 	"log"
 	"net/http"
 )
 
 // NotificationsIdHandler handles HTTP requests related to specific notification settings by ID.
-func NotificationsIdHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet: // Handle GET request
-		handleNotifGetRequest(w, r)
-	case http.MethodDelete: // Handle DELETE request
-		handleNotifDeleteRequest(w, r)
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.NotificationsID, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are:\n"+http.MethodGet+"\n"+http.MethodDelete, http.StatusNotImplemented)
-		return
+func NotificationsIdHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET request
+			handleNotifGetRequest(w, r, store)
+		case http.MethodDelete: // Handle DELETE request
+			handleNotifDeleteRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.NotificationsID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are:\n"+http.MethodGet+"\n"+http.MethodDelete, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
 // handleNotifGetRequest processes GET requests to retrieve a specific notification webhook by its ID.
-func handleNotifGetRequest(w http.ResponseWriter, r *http.Request) {
-	ID := r.PathValue("ID")     // Retrieve the ID from the URL path.
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Retrieve token from the URL query parameters.
-	if token == "" {            // Check if a token is provided.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.NotificationsID)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve UUID associated with the API token.
-	if UUID == "" {                               // Check if UUID is valid.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.NotificationsID)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleNotifGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	ID := r.PathValue("ID")                                                                     // Retrieve the ID from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.NotificationsID, Scopes.NotificationsRead) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 	if ID == "" || ID == " " { // Check if the ID is valid.
@@ -49,7 +43,7 @@ func handleNotifGetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hook, err := db.GetSpecificWebhook(r.RemoteAddr, ID, UUID) // Retrieve the specific webhook by ID and UUID.
+	hook, err := store.GetSpecificWebhook(r.RemoteAddr, ID, UUID) // Retrieve the specific webhook by ID and UUID.
 	if err != nil {
 		log.Printf("%s: Error getting webhook from database: %v", r.RemoteAddr, err)
 		err := fmt.Sprintf("Error getting webhook from database: %v", err)
@@ -70,20 +64,10 @@ func handleNotifGetRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleNotifDeleteRequest processes DELETE requests to remove a specific notification webhook by its ID.
-func handleNotifDeleteRequest(w http.ResponseWriter, r *http.Request) {
-	ID := r.PathValue("ID")     // Retrieve the ID from the URL path.
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Retrieve token from the URL query parameters.
-	if token == "" {            // Check if a token is provided.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.NotificationsID)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve UUID associated with the API token.
-	if UUID == "" {                               // Check if UUID is valid.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.NotificationsID)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleNotifDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	ID := r.PathValue("ID")                                                                      // Retrieve the ID from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.NotificationsID, Scopes.NotificationsWrite) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 	if ID == "" || ID == " " { // Check if the ID is valid.
@@ -92,7 +76,7 @@ func handleNotifDeleteRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := db.DeleteWebhook(r.RemoteAddr, ID, UUID) // Delete the specific webhook by ID and UUID from the database.
+	err := store.DeleteWebhook(r.RemoteAddr, ID, UUID) // Delete the specific webhook by ID and UUID from the database.
 	if err != nil {
 		log.Printf(" %s: Error deleting data from database: %v", r.RemoteAddr, err)
 		err := fmt.Sprintf("Error deleting data from database: %v", err)
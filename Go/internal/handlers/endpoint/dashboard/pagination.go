@@ -0,0 +1,83 @@
+// Package dashboard provides handlers for managing dashboard-related functionalities through HTTP endpoints.
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"globeboard/db"
+	"globeboard/internal/utils/constants"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parsePageLimit reads the 'limit' query parameter, applying the default and maximum page sizes.
+func parsePageLimit(query url.Values) (int, error) {
+	raw := query.Get("limit")
+	if raw == "" {
+		return constants.DefaultPageLimit, nil // Use the default page size when no limit is given.
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > constants.MaxPageLimit {
+		return 0, fmt.Errorf("limit must be a number between 1 and %d", constants.MaxPageLimit)
+	}
+	return limit, nil
+}
+
+// parseRegistrationQuery builds a registration listing query from the request's query parameters.
+func parseRegistrationQuery(query url.Values) (db.RegistrationQuery, error) {
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		return db.RegistrationQuery{}, err
+	}
+	q := db.RegistrationQuery{
+		Limit:   limit,
+		Cursor:  query.Get("cursor"),
+		IsoCode: query.Get("isoCode"),
+		Feature: query.Get("feature"),
+	}
+	if raw := query.Get("changedSince"); raw != "" {
+		q.ChangedSince, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return db.RegistrationQuery{}, errors.New("changedSince must be an RFC 3339 timestamp")
+		}
+	}
+	return q, nil
+}
+
+// parseWebhookQuery builds a webhook listing query from the request's query parameters.
+func parseWebhookQuery(query url.Values) (db.WebhookQuery, error) {
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		return db.WebhookQuery{}, err
+	}
+	return db.WebhookQuery{
+		Limit:   limit,
+		Cursor:  query.Get("cursor"),
+		Country: query.Get("country"),
+		Event:   query.Get("event"),
+	}, nil
+}
+
+// isQueryError reports whether a listing error was caused by the client's query rather than the database.
+func isQueryError(err error) bool {
+	return errors.Is(err, db.ErrInvalidCursor) || errors.Is(err, db.ErrInvalidFilter)
+}
+
+// credentialParams lists the query parameters carrying credentials, which are never echoed back in links.
+var credentialParams = []string{"token"}
+
+// setNextLink sets a 'Link' header pointing at the next page, if there is one.
+func setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return // No following page.
+	}
+	query := r.URL.Query()
+	for _, param := range credentialParams {
+		query.Del(param) // Keep credentials out of the header, which proxies and clients log.
+	}
+	query.Set("cursor", next) // Keep every other parameter so filters apply to the next page as well.
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", link.String()))
+}
@@ -2,12 +2,16 @@
 package dashboard
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	authenticate "globeboard/auth"
 	"globeboard/db"
 	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
 	"globeboard/internal/utils/constants/Webhooks"
 	"globeboard/internal/utils/structs"
 	"io"
@@ -17,35 +21,37 @@ import (
 
 // Constant strings used for API responses and header configurations
 const (
-	ProvideAPI      = "Please provide API Token" // Message prompting for API token
-	APINotAccepted  = "API key not accepted"     // Message for an unauthorized API token
-	ContentType     = "Content-Type"             // HTTP header field for content-type
-	ApplicationJSON = "application/json"         // MIME type for JSON
+	ContentType     = "Content-Type"     // HTTP header field for content-type
+	ApplicationJSON = "application/json" // MIME type for JSON
+	ETag            = "ETag"             // HTTP header field carrying a registration's version
+	IfMatch         = "If-Match"         // HTTP header field for conditional writes
 )
 
 // RegistrationsHandler routes the HTTP request based on the method (POST, GET) to appropriate handlers
-func RegistrationsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		handleRegPostRequest(w, r) // Handle POST requests
-	case http.MethodGet:
-		handleRegGetAllRequest(w, r) // Handle GET requests
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Registrations, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost+"\n"+http.MethodGet+"\n"+http.MethodPatch, http.StatusNotImplemented)
-		return
+func RegistrationsHandler(store db.Store, users authenticate.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			handleRegPostRequest(w, r, store, users) // Handle POST requests
+		case http.MethodGet:
+			handleRegGetAllRequest(w, r, store, users) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Registrations, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost+"\n"+http.MethodGet+"\n"+http.MethodPatch, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
-// DecodeCountryInfo decodes JSON data from the request body into a CountryInfoInternal struct
-func DecodeCountryInfo(data io.ReadCloser) (*structs.CountryInfoInternal, error) {
+// DecodeCountryInfo decodes JSON data from the request body into a CountryInfoInternal struct, validating it within ctx
+func DecodeCountryInfo(ctx context.Context, data io.ReadCloser) (*structs.CountryInfoInternal, error) {
 	var ci *structs.CountryInfoInternal
 	if err := json.NewDecoder(data).Decode(&ci); err != nil {
 		return nil, err // Return error if decoding fails
 	}
 
-	err := _func.ValidateCountryInfo(ci) // Validate the decoded information
+	err := _func.ValidateCountryInfo(ctx, ci) // Validate the decoded information
 	if err != nil {
 		return nil, err // Return validation errors
 	}
@@ -54,19 +60,9 @@ func DecodeCountryInfo(data io.ReadCloser) (*structs.CountryInfoInternal, error)
 }
 
 // handleRegPostRequest handles the POST requests for registration endpoint
-func handleRegPostRequest(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Extract the 'token' parameter from the query.
-	if token == "" {            // Validate token presence.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.Registrations)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve the UUID for the API key.
-	if UUID == "" {                               // Validate UUID presence.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.Registrations)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleRegPostRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	UUID, ok := middleware.Authorize(w, r, Endpoints.Registrations, Scopes.RegistrationsWrite) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 
@@ -77,7 +73,7 @@ func handleRegPostRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ci, err := DecodeCountryInfo(r.Body) // Decode request body into CountryInfoInternal struct.
+	ci, err := DecodeCountryInfo(r.Context(), r.Body) // Decode request body into CountryInfoInternal struct.
 	if err != nil {
 		log.Printf("%s: Error decoding request body: %v", r.RemoteAddr, err)
 		err := fmt.Sprintf("Error decoding request body: %v", err)
@@ -91,14 +87,15 @@ func handleRegPostRequest(w http.ResponseWriter, r *http.Request) {
 	ci.ID = URID
 	ci.UUID = UUID
 
-	err = db.AddRegistration(r.RemoteAddr, UDID, ci) // Add Registration to the Database.
+	revision := _func.Revision(r.RemoteAddr, UUID, middleware.Caller(r), Webhooks.EventRegister, "") // Record the registration in its history.
+	err = store.AddRegistration(r.RemoteAddr, UDID, ci, revision)                                    // Add Registration to the Database.
 	if err != nil {
 		log.Println("Error saving data to database" + err.Error())
 		http.Error(w, "Error storing data in database", http.StatusInternalServerError)
 		return
 	}
 
-	reg, err := db.GetSpecificRegistration(r.RemoteAddr, URID, UUID) // Retrieve specific registration details.
+	reg, err := store.GetSpecificRegistration(r.RemoteAddr, URID, UUID) // Retrieve specific registration details.
 	if err != nil {
 		log.Print("Error getting document from database: ", err)
 		http.Error(w, "Error confirming data added to database", http.StatusInternalServerError)
@@ -128,27 +125,27 @@ func handleRegPostRequest(w http.ResponseWriter, r *http.Request) {
 	cie.Features = reg.Features
 	cie.Lastchange = reg.Lastchange
 
-	_func.LoopSendWebhooksRegistrations(UUID, cie, Endpoints.Registrations, Webhooks.EventRegister) // Send webhook notifications
+	_func.LoopSendWebhooksRegistrations(store, users, UUID, cie, Endpoints.Registrations, Webhooks.EventRegister) // Send webhook notifications
 }
 
 // handleRegGetAllRequest handles the GET requests for registration endpoint to retrieve all registrations
-func handleRegGetAllRequest(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Extract the 'token' parameter from the query.
-	if token == "" {            // Validate token presence.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.Registrations)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
+func handleRegGetAllRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	query := r.URL.Query()                                                                    // Extract the query parameters.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.Registrations, Scopes.RegistrationsRead) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Extract the UUID parameter from the API token.
-	if UUID == "" {                               // Validate UUID presence.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.Registrations)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+	regQuery, err := parseRegistrationQuery(query) // Parse the pagination and filter parameters.
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	regs, err := db.GetRegistrations(r.RemoteAddr, UUID) // Retrieve the user's Registrations.
+	regs, next, err := store.ListRegistrations(r.RemoteAddr, UUID, regQuery) // Retrieve a page of the user's Registrations.
 	if err != nil {
+		if isQueryError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("%s: Error retrieving documents from database: %s", r.RemoteAddr, err)
 		errmsg := fmt.Sprint("Error retrieving documents from database: ", err)
 		http.Error(w, errmsg, http.StatusInternalServerError)
@@ -156,6 +153,7 @@ func handleRegGetAllRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set(ContentType, ApplicationJSON) // Set the content type of response
+	setNextLink(w, r, next)                      // Point to the next page, if any.
 
 	w.WriteHeader(http.StatusOK) // Set HTTP status code to 200 OK
 
@@ -178,6 +176,6 @@ func handleRegGetAllRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, cie := range cies {
-		_func.LoopSendWebhooksRegistrations(UUID, cie, Endpoints.Registrations, Webhooks.EventInvoke) // Send webhook notifications on data retrieval
+		_func.LoopSendWebhooksRegistrations(store, users, UUID, cie, Endpoints.Registrations, Webhooks.EventInvoke) // Send webhook notifications on data retrieval
 	}
 }
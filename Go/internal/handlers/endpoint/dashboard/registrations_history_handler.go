@@ -0,0 +1,216 @@
+// Package dashboard provides handlers for managing dashboard-related functionalities through HTTP endpoints.
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	authenticate "globeboard/auth"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/internal/utils/constants/Webhooks"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+)
+
+// ProvideRevision is the message requesting a revision ID when it is missing.
+const ProvideRevision = "Please Provide Revision ID"
+
+// RegistrationsHistoryHandler handles requests for the /registrations/{ID}/history endpoint.
+func RegistrationsHistoryHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET requests.
+			handleRegHistoryGetRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.RegistrationsHistory, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// RegistrationsRestoreHandler handles requests for the /registrations/{ID}/history/{REV}/restore endpoint.
+func RegistrationsRestoreHandler(store db.Store, users authenticate.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost: // Handle POST requests.
+			handleRegRestorePostRequest(w, r, store, users)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.RegistrationsRestore, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// handleRegHistoryGetRequest processes GET requests listing the revisions of a registration, newest first.
+func handleRegHistoryGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	ID := r.PathValue("ID")                                                                          // Extract the 'ID' parameter from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.RegistrationsHistory, Scopes.RegistrationsRead) // Resolve the API key to its owner.
+	if !ok {
+		return
+	}
+	if ID == "" || ID == " " { // Validate ID presence.
+		log.Printf(constants.ClientConnectNoID, r.RemoteAddr, r.Method, Endpoints.RegistrationsHistory)
+		http.Error(w, ProvideID, http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := store.GetRevisions(r.RemoteAddr, ID, UUID) // Retrieve the revisions from the database.
+	if err != nil {
+		log.Printf("%s: Error retrieving revisions from database: %v", r.RemoteAddr, err)
+		errmsg := fmt.Sprint("Error retrieving revisions from database: ", err)
+		http.Error(w, errmsg, http.StatusInternalServerError)
+		return
+	}
+	if len(revisions) == 0 { // A registration that never existed has no history.
+		http.Error(w, "No history found for that registration ID", http.StatusNotFound)
+		return
+	}
+
+	responses := make([]structs.RevisionResponse, 0, len(revisions)) // Construct RevisionResponse slice.
+	for _, rev := range revisions {
+		responses = append(responses, structs.RevisionResponse{
+			ID:           rev.ID,
+			Event:        rev.Event,
+			Caller:       rev.Caller,
+			Country:      rev.Country,
+			IsoCode:      rev.IsoCode,
+			Features:     rev.Features,
+			Changes:      rev.Changes,
+			RestoredFrom: rev.RestoredFrom,
+			Timestamp:    rev.Timestamp,
+		})
+	}
+
+	w.Header().Set(ContentType, ApplicationJSON) // Set the Content-Type header.
+
+	w.WriteHeader(http.StatusOK) // Set the HTTP status code to 200.
+
+	err = json.NewEncoder(w).Encode(responses) // Encode the revisions into JSON and write to the response.
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleRegRestorePostRequest processes POST requests restoring a registration to the state recorded in a revision.
+// A deleted registration is recreated under its original ID.
+func handleRegRestorePostRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	ID := r.PathValue("ID")                                                                           // Extract the 'ID' parameter from the URL path.
+	REV := r.PathValue("REV")                                                                         // Extract the 'REV' parameter from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.RegistrationsRestore, Scopes.RegistrationsWrite) // Resolve the API key to its owner.
+	if !ok {
+		return
+	}
+	if ID == "" || ID == " " { // Validate ID presence.
+		log.Printf(constants.ClientConnectNoID, r.RemoteAddr, r.Method, Endpoints.RegistrationsRestore)
+		http.Error(w, ProvideID, http.StatusBadRequest)
+		return
+	}
+	if REV == "" || REV == " " { // Validate revision ID presence.
+		log.Printf(constants.ClientConnectNoID, r.RemoteAddr, r.Method, Endpoints.RegistrationsRestore)
+		http.Error(w, ProvideRevision, http.StatusBadRequest)
+		return
+	}
+
+	rev, err := store.GetSpecificRevision(r.RemoteAddr, REV, ID, UUID) // Retrieve the revision to restore.
+	if err != nil {
+		log.Printf("%s: Error getting revision: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving revision from database", http.StatusNotFound)
+		return
+	}
+
+	var reg *structs.CountryInfoInternal // The registration after restoring.
+	status := http.StatusOK              // Status of the response, 201 if the registration is recreated.
+	event := Webhooks.EventChange        // Event to notify webhooks of.
+
+	_, err = store.GetSpecificRegistration(r.RemoteAddr, ID, UUID)
+	switch {
+	case errors.Is(err, db.ErrRegistrationNotFound): // The registration was deleted; recreate it.
+		ci := &structs.CountryInfoInternal{
+			ID:       rev.RegistrationID,
+			UUID:     UUID,
+			Country:  rev.Country,
+			IsoCode:  rev.IsoCode,
+			Features: rev.Features,
+		}
+		revision := _func.Revision(r.RemoteAddr, UUID, middleware.Caller(r), Webhooks.EventRegister, rev.ID)
+		err = store.RestoreRegistration(r.RemoteAddr, ci, revision)
+		if errors.Is(err, db.ErrRegistrationExists) { // Restored concurrently by another request.
+			log.Printf("%s: Error restoring registration: %v", r.RemoteAddr, err)
+			http.Error(w, fmt.Sprintf("Error restoring registration: %v", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("Error saving data to database" + err.Error())
+			http.Error(w, "Error storing data in database", http.StatusInternalServerError)
+			return
+		}
+		reg, err = store.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve the recreated registration.
+		if err != nil {
+			log.Print("Error getting document from database: ", err)
+			http.Error(w, "Error confirming data added to database", http.StatusInternalServerError)
+			return
+		}
+		status = http.StatusCreated
+		event = Webhooks.EventRegister
+
+	case err != nil:
+		log.Printf(RegistrationRetrivalError, r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+
+	default: // The registration exists; reapply the revision's features to it.
+		revision := _func.Revision(r.RemoteAddr, UUID, middleware.Caller(r), Webhooks.EventChange, rev.ID)
+		reg, err = store.PatchRegistration(r.RemoteAddr, ID, UUID, r.Header.Get(IfMatch), func(current *structs.CountryInfoInternal) (*structs.CountryInfoInternal, error) {
+			restored := *current
+			restored.Features = rev.Features
+			return &restored, nil
+		}, revision)
+		if errors.Is(err, db.ErrPreconditionFailed) {
+			log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+			http.Error(w, fmt.Sprintf("Error restoring registration: %v", err), http.StatusPreconditionFailed)
+			return
+		}
+		if errors.Is(err, db.ErrConflict) {
+			log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+			http.Error(w, fmt.Sprintf("Error restoring registration: %v", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+			http.Error(w, fmt.Sprintf("Error restoring registration: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	cie := new(structs.CountryInfoExternal) // Create new external country info struct.
+	cie.ID = reg.ID
+	cie.Country = reg.Country
+	cie.IsoCode = reg.IsoCode
+	cie.Features = reg.Features
+	cie.Lastchange = reg.Lastchange
+
+	w.Header().Set(ContentType, ApplicationJSON)              // Set the Content-Type header.
+	w.Header().Set(ETag, db.RegistrationETag(reg.Lastchange)) // Expose the new version for conditional writes.
+	w.WriteHeader(status)
+
+	err = json.NewEncoder(w).Encode(cie) // Encode the restored registration into JSON and write to the response.
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_func.LoopSendWebhooksRegistrations(store, users, UUID, cie, Endpoints.RegistrationsRestore, event) // Trigger webhooks for the restore.
+}
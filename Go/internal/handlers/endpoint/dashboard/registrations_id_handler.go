@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	authenticate "globeboard/auth"
 	"globeboard/db"
 	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
 	"globeboard/internal/utils/constants/Webhooks"
 	"globeboard/internal/utils/structs"
 	"io"
@@ -24,37 +27,29 @@ const (
 )
 
 // RegistrationsIdHandler handles requests for the /registrations/{ID} endpoint.
-func RegistrationsIdHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet: // Handle GET requests.
-		handleRegGetRequest(w, r)
-	case http.MethodPatch: // Handle PATCH requests.
-		handleRegPatchRequest(w, r)
-	case http.MethodDelete: // Handle DELETE requests.
-		handleRegDeleteRequest(w, r)
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.RegistrationsID, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodGet+"\n"+http.MethodPatch+"\n"+http.MethodDelete, http.StatusNotImplemented)
-		return
+func RegistrationsIdHandler(store db.Store, users authenticate.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET requests.
+			handleRegGetRequest(w, r, store, users)
+		case http.MethodPatch: // Handle PATCH requests.
+			handleRegPatchRequest(w, r, store, users)
+		case http.MethodDelete: // Handle DELETE requests.
+			handleRegDeleteRequest(w, r, store, users)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.RegistrationsID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodGet+"\n"+http.MethodPatch+"\n"+http.MethodDelete, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
 // handleRegGetRequest processes GET requests for registration data by ID.
-func handleRegGetRequest(w http.ResponseWriter, r *http.Request) {
-	ID := r.PathValue("ID")     // Extract the 'ID' parameter from the URL path.
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Extract the 'token' parameter from the query.
-	if token == "" {            // Validate token presence.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.RegistrationsID)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve the UUID for the API key.
-	if UUID == "" {                               // Validate UUID presence.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.RegistrationsID)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleRegGetRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	ID := r.PathValue("ID")                                                                     // Extract the 'ID' parameter from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.RegistrationsID, Scopes.RegistrationsRead) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 	if ID == "" || ID == " " { // Validate ID presence.
@@ -63,14 +58,15 @@ func handleRegGetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reg, err := db.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve registration data from the database.
+	reg, err := store.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve registration data from the database.
 	if err != nil {
 		log.Printf(RegistrationRetrivalError, r.RemoteAddr, err)
 		http.Error(w, "Error retrieving data from database", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set(ContentType, ApplicationJSON) // Set the Content-Type header.
+	w.Header().Set(ContentType, ApplicationJSON)              // Set the Content-Type header.
+	w.Header().Set(ETag, db.RegistrationETag(reg.Lastchange)) // Expose the version for conditional writes.
 
 	w.WriteHeader(http.StatusOK) // Set the HTTP status code to 200.
 
@@ -88,24 +84,14 @@ func handleRegGetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_func.LoopSendWebhooksRegistrations(UUID, cie, Endpoints.RegistrationsID, Webhooks.EventInvoke) // Trigger webhooks for the registration.
+	_func.LoopSendWebhooksRegistrations(store, users, UUID, cie, Endpoints.RegistrationsID, Webhooks.EventInvoke) // Trigger webhooks for the registration.
 }
 
 // handleRegPatchRequest processes PATCH requests to update registration data by ID.
-func handleRegPatchRequest(w http.ResponseWriter, r *http.Request) {
-	ID := r.PathValue("ID")     // Extract the 'ID' parameter from the URL path.
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Extract the 'token' parameter from the query.
-	if token == "" {            // Validate token presence.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.RegistrationsID)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve the UUID for the API key.
-	if UUID == "" {                               // Validate UUID presence.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.RegistrationsID)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleRegPatchRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	ID := r.PathValue("ID")                                                                      // Extract the 'ID' parameter from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.RegistrationsID, Scopes.RegistrationsWrite) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 	if ID == "" || ID == " " { // Validate ID presence.
@@ -121,36 +107,79 @@ func handleRegPatchRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ci, err, errcode := patchCountryInformation(r, ID, UUID) // Process the patch request.
+	body, err := io.ReadAll(r.Body) // Read the patch once, as the store may retry the update.
 	if err != nil {
-		log.Printf(RegistrationPatchError, r.RemoteAddr, err)
-		err := fmt.Sprintf("Error patching registration: %v", err)
-		http.Error(w, err, errcode)
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = _func.ValidateCountryInfo(ci) // Validate the patched country information.
+	// Validate the country of the patched registration before writing, as doing so may take an upstream request,
+	// which must not hold up the store.
+	current, err := store.GetSpecificRegistration(r.RemoteAddr, ID, UUID)
+	if err != nil {
+		log.Printf(RegistrationRetrivalError, r.RemoteAddr, err)
+		code := http.StatusInternalServerError
+		if errors.Is(err, db.ErrRegistrationNotFound) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("Error patching registration: %v", err), code)
+		return
+	}
+	patched, err, code := patchCountryInformation(current, body) // Process the patch request.
 	if err != nil {
 		log.Printf(RegistrationPatchError, r.RemoteAddr, err)
 		err := fmt.Sprintf("Error patching registration: %v", err)
-		http.Error(w, err, http.StatusBadRequest)
+		http.Error(w, err, code)
 		return
 	}
-
-	err = db.UpdateRegistration(r.RemoteAddr, ID, UUID, ci) // Update the registration in the database.
-	if err != nil {
-		log.Printf("%s: Error saving patched data to database: %v", r.RemoteAddr, err)
-		err := fmt.Sprintf("Error saving patched data to database: %v", err)
-		http.Error(w, err, http.StatusInternalServerError)
+	validated := *patched
+	if err := _func.ValidateCountry(r.Context(), &validated); err != nil { // Validate the patched country.
+		log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+		err := fmt.Sprintf("Error patching registration: %v", err)
+		http.Error(w, err, http.StatusBadRequest)
 		return
 	}
 
-	reg, err := db.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve the updated registration.
-	if err != nil {
+	errcode := http.StatusInternalServerError                                                      // Status to report if the patch itself is rejected.
+	revision := _func.Revision(r.RemoteAddr, UUID, middleware.Caller(r), Webhooks.EventChange, "") // Record the change in the registration's history.
+	reg, err := store.PatchRegistration(r.RemoteAddr, ID, UUID, r.Header.Get(IfMatch), func(current *structs.CountryInfoInternal) (*structs.CountryInfoInternal, error) {
+		ci, err, code := patchCountryInformation(current, body) // Process the patch request.
+		if err != nil {
+			errcode = code
+			return nil, err
+		}
+		if ci.Country != patched.Country || ci.IsoCode != patched.IsoCode { // The country validated is no longer the one patched.
+			return nil, db.ErrConflict
+		}
+		ci.Country, ci.IsoCode = validated.Country, validated.IsoCode
+		if err := _func.ValidateFeatures(ci); err != nil { // Validate the patched features.
+			errcode = http.StatusBadRequest
+			return nil, err
+		}
+		return ci, nil
+	}, revision)
+	switch {
+	case errors.Is(err, db.ErrRegistrationNotFound):
 		log.Printf(RegistrationRetrivalError, r.RemoteAddr, err)
-		err := fmt.Sprint("Error retrieving updated document: ", err)
+		err := fmt.Sprintf("Error patching registration: %v", err)
 		http.Error(w, err, http.StatusNotFound)
 		return
+	case errors.Is(err, db.ErrPreconditionFailed):
+		log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+		err := fmt.Sprintf("Error patching registration: %v", err)
+		http.Error(w, err, http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, db.ErrConflict):
+		log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+		err := fmt.Sprintf("Error patching registration: %v", err)
+		http.Error(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf(RegistrationPatchError, r.RemoteAddr, err)
+		err := fmt.Sprintf("Error patching registration: %v", err)
+		http.Error(w, err, errcode)
+		return
 	}
 
 	cie := new(structs.CountryInfoExternal) // Create new external country info struct.
@@ -160,8 +189,9 @@ func handleRegPatchRequest(w http.ResponseWriter, r *http.Request) {
 	cie.Features = reg.Features
 	cie.Lastchange = reg.Lastchange
 
-	w.Header().Set("content-type", "application/json") // Set the Content-Type header.
-	w.WriteHeader(http.StatusAccepted)                 // Set the HTTP status code to 202.
+	w.Header().Set("content-type", "application/json")        // Set the Content-Type header.
+	w.Header().Set(ETag, db.RegistrationETag(reg.Lastchange)) // Expose the new version for further conditional writes.
+	w.WriteHeader(http.StatusAccepted)                        // Set the HTTP status code to 202.
 
 	response := map[string]interface{}{
 		"lastChange": cie.Lastchange, // Prepare the response data.
@@ -174,17 +204,11 @@ func handleRegPatchRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_func.LoopSendWebhooksRegistrations(UUID, cie, Endpoints.RegistrationsID, Webhooks.EventChange) // Trigger webhooks for the change event.
+	_func.LoopSendWebhooksRegistrations(store, users, UUID, cie, Endpoints.RegistrationsID, Webhooks.EventChange) // Trigger webhooks for the change event.
 }
 
-// patchCountryInformation updates the country information based on the provided patch data.
-func patchCountryInformation(r *http.Request, ID, UUID string) (*structs.CountryInfoInternal, error, int) {
-	reg, err := db.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve the specific registration.
-	if err != nil {
-		log.Printf(RegistrationRetrivalError, r.RemoteAddr, err)
-		return nil, err, http.StatusNotFound
-	}
-
+// patchCountryInformation merges the patch data in 'body' into the registration 'reg'.
+func patchCountryInformation(reg *structs.CountryInfoInternal, body []byte) (*structs.CountryInfoInternal, error, int) {
 	bytes, err := json.Marshal(reg) // Marshal the registration data to JSON bytes.
 	if err != nil {
 		log.Print(err)
@@ -198,14 +222,8 @@ func patchCountryInformation(r *http.Request, ID, UUID string) (*structs.Country
 		return nil, err, http.StatusInternalServerError
 	}
 
-	all, err := io.ReadAll(r.Body) // Read all data from the request body.
-	if err != nil {
-		log.Print(err)
-		return nil, err, http.StatusInternalServerError
-	}
-
 	var patchData map[string]interface{} // Unmarshal the patch data from the request body.
-	err = json.Unmarshal(all, &patchData)
+	err = json.Unmarshal(body, &patchData)
 	if err != nil {
 		log.Print(err)
 		return nil, err, http.StatusInternalServerError
@@ -266,20 +284,10 @@ func validatePatchData(patchData map[string]interface{}, originalData map[string
 }
 
 // handleRegDeleteRequest processes DELETE requests to remove registration data by ID.
-func handleRegDeleteRequest(w http.ResponseWriter, r *http.Request) {
-	ID := r.PathValue("ID")     // Extract the 'ID' parameter from the URL path.
-	query := r.URL.Query()      // Extract the query parameters.
-	token := query.Get("token") // Extract the 'token' parameter from the query.
-	if token == "" {            // Validate token presence.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.RegistrationsID)
-		http.Error(w, ProvideAPI, http.StatusUnauthorized)
-		return
-	}
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve the UUID for the API key.
-	if UUID == "" {                               // Validate UUID presence.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.RegistrationsID)
-		err := fmt.Sprintf(APINotAccepted)
-		http.Error(w, err, http.StatusNotAcceptable)
+func handleRegDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider) {
+	ID := r.PathValue("ID")                                                                      // Extract the 'ID' parameter from the URL path.
+	UUID, ok := middleware.Authorize(w, r, Endpoints.RegistrationsID, Scopes.RegistrationsWrite) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 	if ID == "" || ID == " " { // Validate ID presence.
@@ -288,7 +296,7 @@ func handleRegDeleteRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reg, err := db.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve the specific registration to be deleted.
+	reg, err := store.GetSpecificRegistration(r.RemoteAddr, ID, UUID) // Retrieve the specific registration to be deleted.
 	if err != nil {
 		log.Printf(RegistrationRetrivalError, r.RemoteAddr, err)
 		err := fmt.Sprint("Error getting registration: ", err)
@@ -296,7 +304,26 @@ func handleRegDeleteRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.DeleteRegistration(r.RemoteAddr, ID, UUID) // Delete the registration from the database.
+	revision := _func.Revision(r.RemoteAddr, UUID, middleware.Caller(r), Webhooks.EventDelete, "") // Record the deletion so it can be restored.
+	err = store.DeleteRegistration(r.RemoteAddr, ID, UUID, r.Header.Get(IfMatch), revision)        // Delete the registration from the database.
+	if errors.Is(err, db.ErrRegistrationNotFound) {
+		log.Printf("%s: Error deleting registration from database: %v", r.RemoteAddr, err)
+		err := fmt.Sprintf("Error deleting registration from database: %v", err)
+		http.Error(w, err, http.StatusNotFound) // The registration was deleted since it was retrieved.
+		return
+	}
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		log.Printf("%s: Error deleting registration from database: %v", r.RemoteAddr, err)
+		err := fmt.Sprintf("Error deleting registration from database: %v", err)
+		http.Error(w, err, http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, db.ErrConflict) {
+		log.Printf("%s: Error deleting registration from database: %v", r.RemoteAddr, err)
+		err := fmt.Sprintf("Error deleting registration from database: %v", err)
+		http.Error(w, err, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("%s: Error deleting registration from database: %v", r.RemoteAddr, err)
 		err := fmt.Sprintf("Error deleting registration from database: %v", err)
@@ -313,5 +340,5 @@ func handleRegDeleteRequest(w http.ResponseWriter, r *http.Request) {
 	cie.Features = reg.Features
 	cie.Lastchange = reg.Lastchange
 
-	_func.LoopSendWebhooksRegistrations(UUID, cie, Endpoints.RegistrationsID, Webhooks.EventDelete) // Trigger webhooks for the delete event.
+	_func.LoopSendWebhooksRegistrations(store, users, UUID, cie, Endpoints.RegistrationsID, Webhooks.EventDelete) // Trigger webhooks for the delete event.
 }
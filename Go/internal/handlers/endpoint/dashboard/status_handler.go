@@ -5,72 +5,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
-	"globeboard/internal/utils/constants/External"
+	"globeboard/internal/utils/constants/Scopes"
 	"globeboard/internal/utils/structs"
 	"log"
 	"net/http"
 	"time"
 )
 
-// getEndpointStatus sends a GET request to the specified endpoint URL and returns the HTTP status code as a string.
-func getEndpointStatus(endpointURL string) string {
-	r, err := http.NewRequest(http.MethodGet, endpointURL, nil) // Create a new GET request for the endpoint URL.
-	if err != nil {
-		log.Printf("%s: Error in creating request: %v", r.RemoteAddr, err)
-		return "Failed to create request"
-	}
-
-	r.Header.Add("content-type", "application/json") // Set content-type of request.
-
-	client := &http.Client{Timeout: 10 * time.Second} // Initialize a new HTTP client with a timeout.
-	defer client.CloseIdleConnections()               // Ensure that idle connections are closed upon function exit.
-
-	res, err := client.Do(r) // Execute the request.
-	if err != nil {
-		log.Printf("%s: Error in receiving response: %v", r.RemoteAddr, err)
-		return "Failed to connect"
-	}
-	// Ensure the response body is closed after the function exits, checking for errors.
-	defer func() {
-		if err := res.Body.Close(); err != nil {
-			log.Printf("error closing response body: %v", err)
-		}
-	}()
-
-	return res.Status // Return the status code of the response.
-}
-
 // StatusHandler routes requests based on HTTP method to handle status retrieval.
-func StatusHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		handleStatusGetRequest(w, r) // Handle GET requests with handleStatusGetRequest.
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Status, r.Method)
-		http.Error(w, fmt.Sprintf("REST Method: %s not supported. Only GET is supported for this endpoint", r.Method), http.StatusNotImplemented)
+func StatusHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleStatusGetRequest(w, r, store) // Handle GET requests with handleStatusGetRequest.
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Status, r.Method)
+			http.Error(w, fmt.Sprintf("REST Method: %s not supported. Only GET is supported for this endpoint", r.Method), http.StatusNotImplemented)
+		}
 	}
 }
 
 // handleStatusGetRequest processes GET requests to retrieve and report the status of various services and endpoints.
-func handleStatusGetRequest(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token") // Retrieve the API token from query parameters.
-	if token == "" {                    // Validate token presence.
-		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.Status)
-		http.Error(w, "Please provide API Token", http.StatusUnauthorized)
-		return
-	}
-
-	UUID := db.GetAPIKeyUUID(r.RemoteAddr, token) // Retrieve the UUID associated with the API token.
-	if UUID == "" {                               // Validate UUID presence.
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.Status)
-		http.Error(w, "API key not accepted", http.StatusNotAcceptable)
+func handleStatusGetRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID, ok := middleware.Authorize(w, r, Endpoints.Status, Scopes.StatusRead) // Resolve the API key to its owner.
+	if !ok {
 		return
 	}
 
-	webhooksUser, err := db.GetWebhooksUser(r.RemoteAddr, UUID) // Retrieve user data associated with webhooks.
+	webhooksUser, err := store.GetWebhooksUser(r.RemoteAddr, UUID) // Retrieve user data associated with webhooks.
 	if err != nil {
 		log.Printf("%s: Error retrieving user's webhooks: %v", r.RemoteAddr, err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -79,13 +46,14 @@ func handleStatusGetRequest(w http.ResponseWriter, r *http.Request) {
 
 	// Create a status response object.
 	status := structs.StatusResponse{
-		CountriesApi:    getEndpointStatus(External.CountriesAPI + "alpha?codes=no"),
-		MeteoApi:        getEndpointStatus(External.OpenMeteoAPI),
-		CurrencyApi:     getEndpointStatus(External.CurrencyAPI + "nok"),
-		FirebaseDB:      db.TestDBConnection(), // Test the database connection.
+		CountriesApi:    _func.ProbeUpstream(r.Context(), _func.UpstreamCountries, "alpha?codes=no"), // Probe the APIs as configured.
+		MeteoApi:        _func.ProbeUpstream(r.Context(), _func.UpstreamMeteo, "forecast"),
+		CurrencyApi:     _func.ProbeUpstream(r.Context(), _func.UpstreamCurrency, "nok"),
+		FirebaseDB:      store.TestDBConnection(), // Test the database connection.
 		Webhooks:        len(webhooksUser),
 		Version:         constants.APIVersion,                                       // Include the API version.
 		UptimeInSeconds: fmt.Sprintf("%f Seconds", time.Since(startTime).Seconds()), // Calculate uptime.
+		Cache:           _func.CacheStats(),                                         // Report the hit and miss counts of the upstream cache.
 	}
 
 	w.Header().Set("Content-Type", "application/json") // Set response content type to application/json.
@@ -0,0 +1,64 @@
+// Package dashboard provides handlers for managing dashboard-related functionalities through HTTP endpoints.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"globeboard/db"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/metering"
+	"log"
+	"net/http"
+)
+
+// UsageHandler routes requests based on HTTP method to report the caller's API usage.
+func UsageHandler(store db.Store, meter *metering.Meter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleUsageGetRequest(w, r, store, meter) // Handle GET requests with handleUsageGetRequest.
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Usage, r.Method)
+			http.Error(w, fmt.Sprintf("REST Method: %s not supported. Only GET is supported for this endpoint", r.Method), http.StatusNotImplemented)
+		}
+	}
+}
+
+// handleUsageGetRequest reports the API usage of the caller per day, API key, endpoint and status code over
+// the date range given with '?from=' and '?to=' (YYYY-MM-DD, both inclusive), by default the last 30 days.
+func handleUsageGetRequest(w http.ResponseWriter, r *http.Request, store db.Store, meter *metering.Meter) {
+	UUID, ok := middleware.Authorize(w, r, Endpoints.Usage, Scopes.UsageRead) // Resolve the API key to its owner.
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := metering.ParseRange(query.Get("from"), query.Get("to"), constants.DefaultUsageDays, constants.MaxUsageDays)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := meter.Flush(); err != nil { // Include the requests metered since the last flush.
+		log.Printf("%s: Error flushing API usage: %v", r.RemoteAddr, err)
+	}
+	usage, err := store.GetUsage(db.UsageQuery{UUID: UUID, From: from, To: to})
+	if err != nil {
+		log.Printf("%s: Error retrieving API usage: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json") // Set response content type to application/json.
+
+	err = json.NewEncoder(w).Encode(metering.Report(from, to, usage)) // Encode the usage report to JSON and send it.
+	if err != nil {
+		log.Print(err)
+		http.Error(w, fmt.Sprintf("Error during encoding: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -2,57 +2,108 @@
 package util
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
-	authenticate "globeboard/auth"
 	"globeboard/db"
 	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/internal/utils/structs"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
 // APIKeyHandler routes API Key management requests to the appropriate functions based on the HTTP method.
-func APIKeyHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet: // Handle GET requests
-		handleApiKeyGetRequest(w, r)
-	case http.MethodDelete: // Handle DELETE requests
-		handleApiKeyDeleteRequest(w, r)
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.ApiKey, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are: GET, DELETE", http.StatusNotImplemented)
-		return
+// Only the given administrators (UUIDs) may create keys with the admin scope. Keys are issued for the given environment.
+func APIKeyHandler(store db.Store, admins []string, environment string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet: // Handle GET requests
+			handleApiKeyGetRequest(w, r, store, admins, environment)
+		case http.MethodDelete: // Handle DELETE requests
+			handleApiKeyDeleteRequest(w, r, store)
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.ApiKey, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint are: GET, DELETE", http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
-// handleApiKeyDeleteRequest handles the deletion of an API key.
-func handleApiKeyDeleteRequest(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-	token := query.Get("token") // Retrieve the token from query parameters.
+// APIKeyListHandler handles HTTP requests for listing the metadata of a user's API keys.
+func APIKeyListHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleApiKeyListRequest(w, r, store) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.ApiKeyList, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// APIKeyIdHandler handles HTTP requests for managing a specific API key by ID.
+func APIKeyIdHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			handleApiKeyIdDeleteRequest(w, r, store) // Handle DELETE requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.ApiKeyID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodDelete, http.StatusNotImplemented)
+			return
+		}
+	}
+}
 
-	UUID := r.Header.Get("Authorization") // Retrieve the UUID from the Authorization header.
+// APIKeyRotateHandler handles HTTP requests for rotating a specific API key by ID. The replaced key stays valid
+// for 'grace' unless the request asks for another grace period. Only the given administrators (UUIDs) may
+// rotate keys with the admin scope. Replacement keys are issued for the given environment.
+func APIKeyRotateHandler(store db.Store, grace time.Duration, admins []string, environment string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			handleApiKeyRotateRequest(w, r, store, grace, admins, environment) // Handle POST requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.ApiKeyRotate, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodPost, http.StatusNotImplemented)
+			return
+		}
+	}
+}
 
-	ctx := context.Background() // Create a new background context.
+// handleApiKeyDeleteRequest handles the deletion of an API key.
+func handleApiKeyDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	token := strings.TrimSpace(r.Header.Get(middleware.APIKeyHeader)) // Retrieve the key from its header, never the URL.
 
-	_, err := authenticate.Client.GetUser(ctx, UUID) // Verify the UUID with Firebase Authentication.
-	if err != nil {
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.ApiKey)
-		log.Printf("Error verifying UUID: %v\n", err)
-		http.Error(w, "Not Authorized", http.StatusUnauthorized) // Respond with unauthorized if UUID is invalid.
+	UUID, ok := middleware.SessionUser(w, r, Endpoints.ApiKey)
+	if !ok {
 		return
 	}
 
-	if token == "" || token == " " { // Validate token presence.
+	if r.URL.Query().Has("token") { // Refuse keys in the query string, which ends up in logs and referrers.
+		log.Printf("%s: Client attempted %s to %s with an API key in the query string.", r.RemoteAddr, r.Method, Endpoints.ApiKey)
+		http.Error(w, "API keys are not accepted in the query string; please specify API Key to delete: '"+middleware.APIKeyHeader+": {API_Key}', or delete it by ID", http.StatusBadRequest)
+		return
+	}
+	if token == "" { // Validate token presence.
 		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, Endpoints.ApiKey)
-		http.Error(w, "Please specify API Key to delete: '?token={API_Key}'", http.StatusBadRequest)
+		http.Error(w, "Please specify API Key to delete: '"+middleware.APIKeyHeader+": {API_Key}'", http.StatusBadRequest)
 		return
 	}
 
-	err = db.DeleteApiKey(r.RemoteAddr, UUID, token) // Attempt to delete the API key.
+	err := store.DeleteApiKey(r.RemoteAddr, UUID, token) // Attempt to delete the API key.
 	if err != nil {
 		log.Printf("%s: Error deleting API Key: %v", r.RemoteAddr, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError) // Respond with internal server error if deletion fails.
@@ -62,41 +113,233 @@ func handleApiKeyDeleteRequest(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful deletion.
 }
 
-// handleApiKeyGetRequest handles the creation and retrieval of a new API key.
-func handleApiKeyGetRequest(w http.ResponseWriter, r *http.Request) {
-	UDID := _func.GenerateUID(constants.DocIdLength)    // Generate a unique document ID.
-	key := _func.GenerateAPIKey(constants.ApiKeyLength) // Generate a new API key.
+// handleApiKeyGetRequest handles the creation and retrieval of a new API key, optionally named with '?name=',
+// limited in lifetime with '?expiresIn=' and limited in what it may do with '?scopes='.
+func handleApiKeyGetRequest(w http.ResponseWriter, r *http.Request, store db.Store, admins []string, environment string) {
+	UUID, ok := middleware.SessionUser(w, r, Endpoints.ApiKey)
+	if !ok {
+		return
+	}
+
+	apiKey, err := parseApiKeyOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiKey.UUID = UUID
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = Scopes.All // New keys may do everything unless asked for fewer scopes.
+	}
+	if !mayHaveScopes(w, r, apiKey, admins) {
+		return
+	}
+
+	created, err := createApiKey(r, store, apiKey, environment)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating API Key: %v", err), http.StatusInternalServerError) // Respond with internal server error if addition fails.
+		return
+	}
+
+	writeNewApiKey(w, created)
+}
+
+// handleApiKeyListRequest lists the metadata of the caller's API keys, oldest first, without the keys themselves.
+func handleApiKeyListRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID, ok := middleware.SessionUser(w, r, Endpoints.ApiKeyList)
+	if !ok {
+		return
+	}
+
+	keys, err := store.GetAPIKeys(r.RemoteAddr, UUID) // Retrieve all the user's API keys.
+	if err != nil {
+		log.Printf("%s: Error retrieving API keys: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	slices.SortFunc(keys, func(a, b structs.APIKey) int {
+		return a.Created.Compare(b.Created)
+	})
 
-	UUID := r.Header.Get("Authorization") // Retrieve the UUID from the Authorization header.
+	response := []structs.APIKeyMetadata{}
+	for _, key := range keys {
+		response = append(response, _func.APIKeyMetadata(key))
+	}
 
 	w.Header().Set("Content-Type", "application/json") // Set the content type of the response to application/json.
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("%s: Error encoding API key list: %v", r.RemoteAddr, err)
+		return
+	}
+}
+
+// handleApiKeyIdDeleteRequest handles the deletion of an API key by its ID.
+func handleApiKeyIdDeleteRequest(w http.ResponseWriter, r *http.Request, store db.Store) {
+	ID := r.PathValue("ID") // Extract the API key ID from the URL path.
 
-	ctx := context.Background() // Create a new background context.
+	UUID, ok := middleware.SessionUser(w, r, Endpoints.ApiKeyID)
+	if !ok {
+		return
+	}
 
-	_, err := authenticate.Client.GetUser(ctx, UUID) // Verify the UUID with Firebase Authentication.
+	err := store.DeleteApiKeyByID(r.RemoteAddr, ID, UUID) // Attempt to delete the API key.
 	if err != nil {
-		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.ApiKey)
-		log.Printf("%s: Error verifying UUID: %v\n", r.RemoteAddr, err)
-		http.Error(w, "Not Authorized", http.StatusUnauthorized) // Respond with unauthorized if UUID is invalid.
+		log.Printf("%s: Error deleting API Key: %v", r.RemoteAddr, err)
+		http.Error(w, "API key not found", http.StatusNotFound)
 		return
 	}
 
-	err = db.AddApiKey(r.RemoteAddr, UDID, UUID, key) // Attempt to add the new API key to the database.
+	w.WriteHeader(http.StatusNoContent) // Respond with no content on successful deletion.
+}
+
+// handleApiKeyRotateRequest replaces an API key with a new one, accepting the same options as creating a key.
+// The old key stays valid for the grace period, which may be overridden with '?gracePeriod=',
+// so that clients can switch over without downtime.
+func handleApiKeyRotateRequest(w http.ResponseWriter, r *http.Request, store db.Store, grace time.Duration, admins []string, environment string) {
+	ID := r.PathValue("ID") // Extract the API key ID from the URL path.
+
+	UUID, ok := middleware.SessionUser(w, r, Endpoints.ApiKeyRotate)
+	if !ok {
+		return
+	}
+
+	if value := r.URL.Query().Get("gracePeriod"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 || parsed > constants.MaxApiKeyRotationGrace {
+			http.Error(w, fmt.Sprintf("Invalid gracePeriod: '%s', must be a duration such as '1h30m' of at most %s",
+				value, constants.MaxApiKeyRotationGrace), http.StatusBadRequest)
+			return
+		}
+		grace = parsed
+	}
+
+	keys, err := store.GetAPIKeys(r.RemoteAddr, UUID) // Retrieve the user's API keys to find the one to rotate.
 	if err != nil {
-		log.Printf("%s: Error creating API Key: %v", r.RemoteAddr, err)
-		errorMessage := fmt.Sprintf("Error creating API Key: %v", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError) // Respond with internal server error if addition fails.
+		log.Printf("%s: Error retrieving API keys: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	index := slices.IndexFunc(keys, func(key structs.APIKey) bool { return key.ID == ID })
+	if index < 0 {
+		http.Error(w, "API key not found", http.StatusNotFound)
 		return
 	}
+	old := keys[index]
 
-	w.WriteHeader(http.StatusCreated) // Set HTTP status to 201 Created on successful API key creation.
+	now := time.Now().UTC()
+	if old.Expires != nil && !now.Before(*old.Expires) {
+		http.Error(w, "API key has already expired", http.StatusConflict)
+		return
+	}
 
-	response := map[string]string{
-		"token": key, // Include the new API key in the response.
+	apiKey, err := parseApiKeyOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiKey.UUID = UUID
+	if apiKey.Name == "" {
+		apiKey.Name = old.Name // The new key keeps the name of the old key unless renamed.
+	}
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = old.Scopes // The new key keeps the scopes of the old key unless given others.
+	}
+	if !mayHaveScopes(w, r, apiKey, admins) {
+		return
+	}
+	created, err := createApiKey(r, store, apiKey, environment)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating API Key: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Error encoding JSON response: "+err.Error(), http.StatusInternalServerError) // Handle errors in JSON encoding.
+	// Shorten the old key's lifetime to the grace period, never extend it.
+	oldExpires := now.Add(grace)
+	if old.Expires != nil && old.Expires.Before(oldExpires) {
+		oldExpires = *old.Expires
+	}
+	if err := store.ExpireApiKey(r.RemoteAddr, old.ID, UUID, oldExpires); err != nil {
+		log.Printf("%s: Error expiring rotated API Key: %v", r.RemoteAddr, err)
+		http.Error(w, "Error storing data in database", http.StatusInternalServerError)
+		return
+	}
+	old.Expires = &oldExpires
+	replaced := _func.APIKeyMetadata(old)
+	created.Replaces = &replaced
+
+	log.Printf("%s: API key %s rotated to %s for user: %s.", r.RemoteAddr, old.ID, created.ID, UUID)
+	writeNewApiKey(w, created)
+}
+
+// parseApiKeyOptions reads the optional name, scopes and lifetime of a new API key from the request's query
+// parameters. The scopes are nil if none were asked for.
+func parseApiKeyOptions(r *http.Request) (*structs.APIKey, error) {
+	query := r.URL.Query()
+	apiKey := &structs.APIKey{Name: query.Get("name")}
+
+	if len(apiKey.Name) > constants.ApiKeyNameMaxLength {
+		return nil, fmt.Errorf("API key name must be at most %d characters long", constants.ApiKeyNameMaxLength)
+	}
+
+	if value := query.Get("scopes"); value != "" {
+		apiKey.Scopes = []string{}
+		for _, scope := range strings.Split(value, ",") {
+			scope = strings.TrimSpace(scope)
+			if !slices.Contains(Scopes.All, scope) && scope != Scopes.Admin {
+				return nil, fmt.Errorf("unknown scope: '%s', must be one of: %s", scope,
+					strings.Join(append(slices.Clone(Scopes.All), Scopes.Admin), ", "))
+			}
+			if !slices.Contains(apiKey.Scopes, scope) {
+				apiKey.Scopes = append(apiKey.Scopes, scope)
+			}
+		}
+	}
+
+	if value := query.Get("expiresIn"); value != "" {
+		lifetime, err := time.ParseDuration(value)
+		if err != nil || lifetime <= 0 {
+			return nil, fmt.Errorf("invalid expiresIn: '%s', must be a positive duration such as '720h'", value)
+		}
+		expires := time.Now().UTC().Add(lifetime)
+		apiKey.Expires = &expires
+	} // Otherwise the key never expires.
+
+	return apiKey, nil
+}
+
+// mayHaveScopes checks that the owner of a new API key may give it its scopes, responding with an error (403)
+// if it asks for the admin scope and they are not one of the given administrators.
+func mayHaveScopes(w http.ResponseWriter, r *http.Request, apiKey *structs.APIKey, admins []string) bool {
+	if slices.Contains(apiKey.Scopes, Scopes.Admin) && !middleware.IsAdmin(admins, apiKey.UUID) {
+		log.Printf(constants.ClientConnectNotAdmin, r.RemoteAddr, r.Method, r.URL.Path, apiKey.UUID)
+		http.Error(w, "Only administrators may give API keys the admin scope", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// createApiKey generates a new API key for the given environment with the given metadata and stores it,
+// returning it along with its metadata.
+func createApiKey(r *http.Request, store db.Store, apiKey *structs.APIKey, environment string) (structs.NewAPIKey, error) {
+	UDID := _func.GenerateUID(constants.DocIdLength) // Generate a unique document ID.
+
+	apiKey.ID = _func.GenerateUID(constants.IdLength)
+	key := _func.GenerateAPIKey(environment, apiKey.ID, constants.ApiKeyLength) // Generate a new API key carrying its ID.
+	err := store.AddApiKey(r.RemoteAddr, UDID, apiKey, key)                     // Attempt to add the new API key to the database.
+	if err != nil {
+		log.Printf("%s: Error creating API Key: %v", r.RemoteAddr, err)
+		return structs.NewAPIKey{}, err
+	}
+	return structs.NewAPIKey{Token: key, APIKeyMetadata: _func.APIKeyMetadata(*apiKey)}, nil
+}
+
+// writeNewApiKey responds with a newly created API key and its metadata.
+func writeNewApiKey(w http.ResponseWriter, created structs.NewAPIKey) {
+	w.Header().Set("Content-Type", "application/json") // Set the content type of the response to application/json.
+	w.WriteHeader(http.StatusCreated)                  // Set HTTP status to 201 Created on successful API key creation.
+
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		log.Printf("Error encoding JSON response: %v", err) // Handle errors in JSON encoding.
 		return
 	}
 }
@@ -0,0 +1,115 @@
+// Package util provides HTTP handlers for user and API key management within the application.
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	authenticate "globeboard/auth"
+	"globeboard/db"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/lockout"
+	"log"
+	"net/http"
+	"time"
+)
+
+// LoginHandler handles HTTP requests exchanging a user's credentials for a session token,
+// which the API key management endpoints require. Disabled users are refused, as are clients and
+// accounts the guard has blocked after too many failed sign-ins.
+func LoginHandler(store db.AdminStore, provider authenticate.Provider, sessions *authenticate.Sessions, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			login(w, r, store, provider, sessions, guard) // Handle POST requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.Login, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodPost, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// login verifies an email and password, or an ID token issued by the identity provider, and issues a session.
+func login(w http.ResponseWriter, r *http.Request, store db.AdminStore, provider authenticate.Provider, sessions *authenticate.Sessions, guard *lockout.Guard) {
+	email := r.FormValue("email")       // Extract email from form data.
+	password := r.FormValue("password") // Extract password from form data.
+	idToken := r.FormValue("idToken")   // Extract ID token from form data.
+
+	var targets []string // The accounts the attempt is counted against, besides the client address.
+	if email != "" && idToken == "" {
+		targets = append(targets, lockout.User(email))
+	}
+	if middleware.Throttled(w, r, guard, targets...) {
+		return
+	}
+
+	var UUID string
+	var err error
+	switch {
+	case idToken != "":
+		UUID, err = provider.VerifyIDToken(r.Context(), idToken) // Verify the ID token with the identity provider.
+	case email != "" && password != "":
+		UUID, err = provider.SignIn(r.Context(), email, password) // Verify the credentials with the identity provider.
+	default:
+		log.Printf("%s: Failed %s attempt to %s: No credentials.\n", r.RemoteAddr, r.Method, Endpoints.Login)
+		http.Error(w, "Please provide 'email' and 'password', or an 'idToken'", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case errors.Is(err, authenticate.ErrInvalidCredentials):
+		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.Login)
+		middleware.AuthenticationFailed(r, guard, targets...)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	case errors.Is(err, authenticate.ErrPasswordSignInUnavailable):
+		log.Printf("%s: Error signing in: %v\n", r.RemoteAddr, err)
+		http.Error(w, "Password sign-in is not available; please sign in with an 'idToken'", http.StatusNotImplemented)
+		return
+	case err != nil:
+		log.Printf("%s: Error signing in: %v\n", r.RemoteAddr, err)
+		http.Error(w, ISE, http.StatusInternalServerError)
+		return
+	}
+
+	disabled, err := store.IsUserDisabled(UUID) // Check the verified user may use the service.
+	if err != nil {
+		log.Printf("%s: Error checking whether user is disabled: %v\n", r.RemoteAddr, err)
+		http.Error(w, ISE, http.StatusInternalServerError)
+		return
+	}
+	if disabled {
+		log.Printf(constants.ClientConnectDisabled, r.RemoteAddr, r.Method, Endpoints.Login, UUID)
+		http.Error(w, middleware.AccountDisabled, http.StatusForbidden)
+		return
+	}
+
+	guard.Succeed(targets...) // Forget the failed sign-ins to the account.
+
+	token, expires := sessions.Issue(UUID) // Issue a session for the verified user.
+
+	// Prepare the JSON response with the session token.
+	response := struct {
+		Session string    `json:"session"` // Session token.
+		Expires time.Time `json:"expires"` // Time the session token expires.
+		UserID  string    `json:"userid"`  // User ID.
+	}{
+		Session: token,
+		Expires: expires,
+		UserID:  UUID,
+	}
+
+	w.Header().Set("content-type", "application/json") // Set response content type.
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(response) // Encode the response as JSON.
+	if err != nil {
+		log.Printf("%s: Error encoding JSON response: %v\n", r.RemoteAddr, err) // Log encoding error.
+		http.Error(w, ISE, http.StatusInternalServerError)                      // Report encoding error.
+		return
+	}
+
+	log.Printf("%s: Successfully signed in user: %v\n", r.RemoteAddr, UUID)
+}
@@ -0,0 +1,246 @@
+// Package util provides HTTP handlers for user and API key management within the application.
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/internal/utils/constants/Webhooks"
+	"globeboard/internal/utils/structs"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// importableEvents lists the webhook events a user may subscribe to, and thus import.
+var importableEvents = []string{Webhooks.EventRegister, Webhooks.EventChange, Webhooks.EventDelete, Webhooks.EventInvoke}
+
+// UserExportHandler handles HTTP requests for exporting a user's data.
+func UserExportHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			exportUser(w, r, store) // Handle GET requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.UserExport, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodGet, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// UserImportHandler handles HTTP requests for importing a user's data.
+func UserImportHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			importUser(w, r, store) // Handle POST requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.UserImport, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodPost, http.StatusNotImplemented)
+			return
+		}
+	}
+}
+
+// exportUser bundles the caller's registrations, webhooks and API key metadata into a single archive.
+func exportUser(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID, ok := middleware.Authorize(w, r, Endpoints.UserExport, Scopes.RegistrationsRead, Scopes.NotificationsRead)
+	if !ok {
+		return
+	}
+
+	regs, err := store.GetRegistrations(r.RemoteAddr, UUID) // Retrieve all the user's registrations.
+	if err != nil {
+		log.Printf("%s: Error retrieving registrations for export: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	hooks, err := store.GetWebhooksUser(r.RemoteAddr, UUID) // Retrieve all the user's webhooks.
+	if err != nil {
+		log.Printf("%s: Error retrieving webhooks for export: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+	keys, err := store.GetAPIKeys(r.RemoteAddr, UUID) // Retrieve all the user's API keys.
+	if err != nil {
+		log.Printf("%s: Error retrieving API keys for export: %v", r.RemoteAddr, err)
+		http.Error(w, "Error retrieving data from database", http.StatusInternalServerError)
+		return
+	}
+
+	archive := structs.UserArchive{
+		Version:       constants.ArchiveVersion,
+		ExportedAt:    time.Now().UTC(),
+		Registrations: []structs.CountryInfoExternal{},
+		Webhooks:      []structs.WebhookResponse{},
+		APIKeys:       []structs.APIKeyMetadata{},
+	}
+	for _, reg := range regs {
+		archive.Registrations = append(archive.Registrations, structs.CountryInfoExternal{
+			ID:         reg.ID,
+			Country:    reg.Country,
+			IsoCode:    reg.IsoCode,
+			Features:   reg.Features,
+			Lastchange: reg.Lastchange,
+		})
+	}
+	archive.Webhooks = append(archive.Webhooks, hooks...)
+	for _, key := range keys {
+		archive.APIKeys = append(archive.APIKeys, _func.APIKeyMetadata(key))
+	}
+
+	w.Header().Set("Content-Type", "application/json") // Set the content type of the response to application/json.
+	w.Header().Set("Content-Disposition", `attachment; filename="globeboard-export.json"`)
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(archive) // Encode the archive as JSON and send it.
+	if err != nil {
+		log.Printf("%s: Error encoding export: %v", r.RemoteAddr, err)
+		return
+	}
+	log.Printf("%s: Exported %d registrations and %d webhooks for user: %s.", r.RemoteAddr, len(archive.Registrations), len(archive.Webhooks), UUID)
+}
+
+// importUser validates every entry of an archive and, unless it is a dry-run, recreates them under the caller's UUID.
+// Nothing is imported if any entry is invalid. Should storing an entry fail, the report names the entries imported
+// before it, so that the import can be completed by sending the archive again without them.
+func importUser(w http.ResponseWriter, r *http.Request, store db.Store) {
+	UUID, ok := middleware.Authorize(w, r, Endpoints.UserImport, Scopes.RegistrationsWrite, Scopes.NotificationsWrite)
+	if !ok {
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid dryRun value: '"+value+"', must be true or false", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+
+	if r.Body == nil { // Check if request body is empty.
+		log.Printf(constants.ClientConnectEmptyBody, r.RemoteAddr, r.Method, Endpoints.UserImport)
+		http.Error(w, "Please send a request body", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, constants.MaxArchiveSize) // Refuse archives too large to hold in memory.
+
+	var archive structs.UserArchive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil { // Decode the JSON request body into the archive.
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Archive too large, the limit is %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if archive.Version != constants.ArchiveVersion {
+		http.Error(w, fmt.Sprintf("Unsupported archive version: %d, supported version is %d", archive.Version, constants.ArchiveVersion), http.StatusBadRequest)
+		return
+	}
+
+	report := structs.ImportReport{
+		DryRun:        dryRun,
+		Registrations: []structs.ImportResult{},
+		Webhooks:      []structs.ImportResult{},
+	}
+	valid := true
+
+	// Validate every entry up front, so that an invalid archive leaves no trace.
+	regs := make([]*structs.CountryInfoInternal, len(archive.Registrations))
+	for i, archived := range archive.Registrations {
+		result := structs.ImportResult{Index: i, SourceID: archived.ID}
+		reg := &structs.CountryInfoInternal{
+			UUID:     UUID,
+			Country:  archived.Country,
+			IsoCode:  archived.IsoCode,
+			Features: archived.Features,
+		}
+		if err := _func.ValidateCountryInfo(r.Context(), reg); err != nil {
+			result.Error = err.Error()
+			valid = false
+		}
+		regs[i] = reg
+		report.Registrations = append(report.Registrations, result)
+	}
+
+	hooks := make([]*structs.WebhookInternal, len(archive.Webhooks))
+	for i, archived := range archive.Webhooks {
+		result := structs.ImportResult{Index: i, SourceID: archived.ID}
+		if err := _func.ValidateWebhook(archived, importableEvents); err != nil {
+			result.Error = err.Error()
+			valid = false
+		}
+		hooks[i] = &structs.WebhookInternal{
+			UUID:    UUID,
+			URL:     archived.URL,
+			Country: archived.Country,
+			Event:   archived.Event,
+		}
+		report.Webhooks = append(report.Webhooks, result)
+	}
+
+	if !valid {
+		log.Printf("%s: Rejected invalid import for user: %s.", r.RemoteAddr, UUID)
+		writeImportReport(w, http.StatusBadRequest, report)
+		return
+	}
+	if dryRun {
+		writeImportReport(w, http.StatusOK, report)
+		return
+	}
+
+	for i, reg := range regs {
+		reg.ID = _func.GenerateUID(constants.IdLength)
+		revision := _func.Revision(r.RemoteAddr, UUID, middleware.Caller(r), Webhooks.EventRegister, "")
+		err := store.AddRegistration(r.RemoteAddr, _func.GenerateUID(constants.DocIdLength), reg, revision)
+		if err != nil {
+			log.Printf("%s: Error importing registration: %v", r.RemoteAddr, err)
+			report.Registrations[i].Error = storeFailed
+			writeImportReport(w, http.StatusInternalServerError, report)
+			return
+		}
+		report.Registrations[i].ID = reg.ID
+	}
+
+	for i, hook := range hooks {
+		hook.ID = _func.GenerateUID(constants.IdLength)
+		err := store.AddWebhook(r.RemoteAddr, _func.GenerateUID(constants.DocIdLength), hook)
+		if err != nil {
+			log.Printf("%s: Error importing webhook: %v", r.RemoteAddr, err)
+			report.Webhooks[i].Error = storeFailed
+			writeImportReport(w, http.StatusInternalServerError, report)
+			return
+		}
+		report.Webhooks[i].ID = hook.ID
+	}
+
+	log.Printf("%s: Imported %d registrations and %d webhooks for user: %s.", r.RemoteAddr, len(regs), len(hooks), UUID)
+	writeImportReport(w, http.StatusCreated, report)
+}
+
+// storeFailed reports an archived entry that could not be stored; the entries before it were imported.
+const storeFailed = "Error storing data in database"
+
+// writeImportReport sends the import report with the given status code.
+func writeImportReport(w http.ResponseWriter, status int, report structs.ImportReport) {
+	w.Header().Set("Content-Type", "application/json") // Set the content type of the response to application/json.
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Print(err)
+	}
+}
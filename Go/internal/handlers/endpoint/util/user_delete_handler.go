@@ -3,28 +3,42 @@ package util
 
 import (
 	"context"
+	"errors"
 	authenticate "globeboard/auth"
+	"globeboard/db"
+	_func "globeboard/internal/func"
+	"globeboard/internal/handlers/middleware"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/lockout"
 	"log"
 	"net/http"
+	"strings"
 )
 
-// UserDeletionHandler handles HTTP requests for user deletion.
-func UserDeletionHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodDelete:
-		deleteUser(w, r) // Handle DELETE requests
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.UserDeletionID, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodDelete, http.StatusNotImplemented)
-		return
+// UserDeletionHandler handles HTTP requests for user deletion. Attempts to delete another user count as
+// failed attempts of the client with the guard, so that user IDs cannot be probed through it; ID tokens that are
+// not accepted also count against the user they were presented for.
+func UserDeletionHandler(store db.Store, users authenticate.Provider, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			deleteUser(w, r, store, users, guard) // Handle DELETE requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.UserDeletionID, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported method for this endpoint is:\n"+http.MethodDelete, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
 // deleteUser processes the user deletion using the user ID from the request path.
-func deleteUser(w http.ResponseWriter, r *http.Request) {
+// The caller must prove they are that user. The user is deleted with the identity provider before their data,
+// so that a failure cannot leave a user behind whose data is gone. Should deleting the data fail, the caller's
+// API keys are kept, so that repeating the request with one finishes the deletion.
+func deleteUser(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider, guard *lockout.Guard) {
 	ID := r.PathValue("ID")    // Extract user ID from the URL path.
 	if ID == "" || ID == " " { // Check if the user ID is provided.
 		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.UserDeletionID)
@@ -32,15 +46,95 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if middleware.Throttled(w, r, guard, lockout.User(ID)) {
+		return
+	}
+
 	ctx := context.Background() // Create a new background context.
 
-	err := authenticate.Client.DeleteUser(ctx, ID) // Attempt to delete user in Firebase.
+	UUID, status, reason := identifyCaller(ctx, r, store, users) // Establish who is asking for the deletion.
+	if status != http.StatusOK {
+		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.UserDeletionID)
+		if status == http.StatusUnauthorized && r.Header.Get("Authorization") != "" { // An ID token was not accepted.
+			middleware.AuthenticationFailed(r, guard, lockout.User(ID))
+		}
+		http.Error(w, reason, status)
+		return
+	}
+	if UUID != ID { // Users may only delete themselves.
+		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, Endpoints.UserDeletionID)
+		middleware.AuthenticationFailed(r, guard) // Not a failed proof of being that user, so only the client is counted.
+		http.Error(w, "Not Authorized to delete this user", http.StatusForbidden)
+		return
+	}
+
+	email := ID                         // Describe the user by their ID if they were deleted by an earlier attempt.
+	user, err := users.GetUser(ctx, ID) // Retrieve the user before deletion, for the deletion event.
+	switch {
+	case errors.Is(err, authenticate.ErrUserNotFound): // Finish a deletion that failed after deleting the user.
+		log.Printf("%s: User %s already deleted; deleting their remaining data.", r.RemoteAddr, ID)
+	case err != nil:
+		log.Printf("%s: Error retrieving user: %v\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	default:
+		email = _func.UserLabel(user)
+		err = users.DeleteUser(ctx, ID) // Attempt to delete user with the identity provider.
+		if err != nil && !errors.Is(err, authenticate.ErrUserNotFound) {
+			log.Printf("%s: Error deleting user: %v\n", r.RemoteAddr, err) // Log the error.
+			http.Error(w, err.Error(), http.StatusInternalServerError)     // Report deletion error.
+			return
+		}
+	}
+
+	err = store.DeleteUserData(r.RemoteAddr, ID) // Remove everything the user owns.
 	if err != nil {
-		log.Printf("%s: Error deleting user: %v\n", r.RemoteAddr, err) // Log the error.
-		http.Error(w, err.Error(), http.StatusInternalServerError)     // Report deletion error.
+		log.Printf("%s: Error deleting user data: %v\n", r.RemoteAddr, err)
+		http.Error(w, "User deleted, but deleting their data failed; repeat the request with your API key to finish: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)                                 // Set HTTP status to 204 No Content on successful deletion.
 	log.Printf("%s: Successfully deleted user: %v\n", r.RemoteAddr, ID) // Log successful deletion.
+
+	_func.LoopSendWebhooksUserDeleted(store, email, ID) // Notify developer webhooks of the deletion.
+}
+
+// deletionScopes are the scopes an API key needs to delete its owner, as everything the user owns goes with them.
+var deletionScopes = []string{Scopes.RegistrationsWrite, Scopes.NotificationsWrite}
+
+// identifyCaller resolves the user making the request from the API key the request was authenticated with,
+// or from an ID token issued by the identity provider in the Authorization header. It returns the user's UUID and
+// http.StatusOK on success, or the status and reason to refuse the request with. Disabled users are refused (403)
+// however they authenticate.
+func identifyCaller(ctx context.Context, r *http.Request, store db.AdminStore, users authenticate.Provider) (string, int, string) {
+	if key, presented := middleware.APIKey(r); presented {
+		if middleware.Disabled(r) {
+			return "", http.StatusForbidden, middleware.AccountDisabled
+		}
+		if key == nil {
+			return "", http.StatusNotAcceptable, middleware.APINotAccepted
+		}
+		if middleware.MissingScope(key, deletionScopes...) != "" {
+			return "", http.StatusForbidden, "API key lacks the required scopes: " + strings.Join(deletionScopes, ", ")
+		}
+		return key.UUID, http.StatusOK, ""
+	}
+
+	if idToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && idToken != "" {
+		UUID, err := users.VerifyIDToken(ctx, idToken) // Verify the ID token with the identity provider.
+		if err != nil {
+			log.Printf("%s: Error verifying ID token: %v\n", r.RemoteAddr, err)
+			return "", http.StatusUnauthorized, provideCredentials
+		}
+		if middleware.UserDisabled(r, store, UUID) {
+			return "", http.StatusForbidden, middleware.AccountDisabled
+		}
+		return UUID, http.StatusOK, ""
+	}
+
+	return "", http.StatusUnauthorized, provideCredentials
 }
+
+// provideCredentials prompts for the credentials a user may delete themselves with.
+const provideCredentials = "Please provide your API key 'X-API-Key: {API_Key}' or ID token 'Authorization: Bearer {ID_Token}'"
@@ -2,14 +2,14 @@
 package util
 
 import (
-	"context"
 	"encoding/json"
-	"firebase.google.com/go/auth"
+	"errors"
 	authenticate "globeboard/auth"
 	"globeboard/db"
-	_func "globeboard/internal/func"
 	"globeboard/internal/utils/constants"
 	"globeboard/internal/utils/constants/Endpoints"
+	"globeboard/internal/utils/constants/Scopes"
+	"globeboard/internal/utils/structs"
 	"log"
 	"net/http"
 	"regexp"
@@ -19,21 +19,23 @@ const (
 	ISE = "Internal Server Error" // ISE is the error message used when an internal server error occurs.
 )
 
-// UserRegistrationHandler handles HTTP requests for user registration.
-func UserRegistrationHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		registerUser(w, r) // Handle POST requests
-	default:
-		// Log and return an error for unsupported HTTP methods
-		log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.UserRegistration, r.Method)
-		http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost, http.StatusNotImplemented)
-		return
+// UserRegistrationHandler handles HTTP requests for user registration, issuing new users an API key for the given environment.
+func UserRegistrationHandler(store db.Store, users authenticate.Provider, environment string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			registerUser(w, r, store, users, environment) // Handle POST requests
+		default:
+			// Log and return an error for unsupported HTTP methods
+			log.Printf(constants.ClientConnectUnsupported, r.RemoteAddr, Endpoints.UserRegistration, r.Method)
+			http.Error(w, "REST Method: "+r.Method+" not supported. Only supported methods for this endpoint is:\n"+http.MethodPost, http.StatusNotImplemented)
+			return
+		}
 	}
 }
 
 // registerUser processes the user registration, including input validation and user creation.
-func registerUser(w http.ResponseWriter, r *http.Request) {
+func registerUser(w http.ResponseWriter, r *http.Request, store db.Store, users authenticate.Provider, environment string) {
 	name := r.FormValue("username")     // Extract username from form data.
 	email := r.FormValue("email")       // Extract email from form data.
 	password := r.FormValue("password") // Extract password from form data.
@@ -52,15 +54,12 @@ func registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background() // Create a new background context.
-
-	// Define user creation parameters.
-	params := (&auth.UserToCreate{}).
-		DisplayName(name).
-		Email(email).
-		Password(password)
-
-	u, err := authenticate.Client.CreateUser(ctx, params) // Attempt to create user in Firebase.
+	u, err := users.CreateUser(r.Context(), email, password, name) // Attempt to create user with the identity provider.
+	if errors.Is(err, authenticate.ErrEmailTaken) {
+		log.Printf("%s attempted to register a user with an email already in use.", r.RemoteAddr)
+		http.Error(w, "A user with that email already exists", http.StatusConflict) // Report the conflict.
+		return
+	}
 	if err != nil {
 		log.Printf("%s: Error creating user: %v\n", r.RemoteAddr, err) // Log the error.
 		http.Error(w, err.Error(), http.StatusInternalServerError)     // Report creation error.
@@ -69,10 +68,7 @@ func registerUser(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("content-type", "application/json") // Set response content type.
 
-	UDID := _func.GenerateUID(constants.DocIdLength)    // Generate a unique document ID.
-	key := _func.GenerateAPIKey(constants.ApiKeyLength) // Generate a new API key.
-
-	err = db.AddApiKey(r.RemoteAddr, UDID, u.UID, key) // Store the new API key in the database.
+	created, err := createApiKey(r, store, &structs.APIKey{UUID: u.UUID, Scopes: Scopes.All}, environment) // Generate and store the user's first API key.
 	if err != nil {
 		log.Printf("%s Error saving API Key: %v\n", r.RemoteAddr, err) // Log the error.
 		http.Error(w, ISE, http.StatusInternalServerError)             // Report API key storage error.
@@ -82,10 +78,10 @@ func registerUser(w http.ResponseWriter, r *http.Request) {
 	// Prepare the JSON response with the user ID and token.
 	response := struct {
 		Token  string `json:"token"`  // API token.
-		UserID string `json:"userid"` // Firebase user ID.
+		UserID string `json:"userid"` // User ID.
 	}{
-		Token:  key,
-		UserID: u.UID,
+		Token:  created.Token,
+		UserID: u.UUID,
 	}
 
 	w.WriteHeader(http.StatusCreated) // Set HTTP status to 201 Created.
@@ -99,7 +95,7 @@ func registerUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log successful creation events.
-	log.Printf("%s: Successfully created user: %v with API Key: %v\n", r.RemoteAddr, response.UserID, response.Token)
+	log.Printf("%s: Successfully created user: %v with API Key: %v\n", r.RemoteAddr, response.UserID, db.APIKeyPrefix(response.Token))
 }
 
 // isValidEmail checks if the provided email string matches the expected format.
@@ -0,0 +1,35 @@
+// Package middleware provides HTTP middleware shared by the endpoint handlers.
+package middleware
+
+import (
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/constants/Scopes"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// Admin returns middleware admitting only requests authenticated with an API key that has the admin scope and
+// belongs to one of the given administrators (UUIDs). Removing a user from the administrators revokes their
+// access even though their keys keep the scope. It must be wrapped in Authenticate.
+func Admin(admins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			UUID, ok := Authorize(w, r, r.URL.Path, Scopes.Admin) // Resolve the API key to its owner.
+			if !ok {
+				return
+			}
+			if !IsAdmin(admins, UUID) { // Validate the owner is still an administrator.
+				log.Printf(constants.ClientConnectNotAdmin, r.RemoteAddr, r.Method, r.URL.Path, UUID)
+				http.Error(w, "Admin role required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsAdmin reports whether a user (UUID) is one of the given administrators.
+func IsAdmin(admins []string, UUID string) bool {
+	return UUID != "" && slices.Contains(admins, UUID)
+}
@@ -0,0 +1,197 @@
+// Package middleware provides HTTP middleware shared by the endpoint handlers.
+package middleware
+
+import (
+	"context"
+	"globeboard/apikey"
+	"globeboard/db"
+	"globeboard/internal/utils/constants"
+	"globeboard/internal/utils/structs"
+	"globeboard/lockout"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Policies for API keys passed in the 'token' query parameter, which leaks them into proxy logs and browser history.
+const (
+	QueryTokensAllow     = "allow"     // QueryTokensAllow accepts query-string API keys silently.
+	QueryTokensDeprecate = "deprecate" // QueryTokensDeprecate accepts query-string API keys, flagging the response as deprecated.
+	QueryTokensReject    = "reject"    // QueryTokensReject refuses query-string API keys.
+)
+
+// QueryTokenPolicies lists every valid query-string API key policy.
+var QueryTokenPolicies = []string{QueryTokensAllow, QueryTokensDeprecate, QueryTokensReject}
+
+const (
+	APIKeyHeader = "X-API-Key" // APIKeyHeader is the header an API key may be passed in, as an alternative to a bearer token.
+	bearerPrefix = "Bearer "   // bearerPrefix precedes a bearer token in the Authorization header.
+
+	ProvideAPI      = "Please provide API Token: 'Authorization: Bearer {API_Key}' or 'X-API-Key: {API_Key}'" // ProvideAPI prompts for an API key.
+	APINotAccepted  = "API key not accepted"                                                                  // APINotAccepted reports an unknown or expired API key.
+	AccountDisabled = "Account disabled; please contact an administrator"                                     // AccountDisabled reports a request on behalf of a disabled user.
+)
+
+// authentication is the outcome of authenticating a request, as stored in its context.
+type authentication struct {
+	key       *structs.APIKey // The API key the request was authenticated with, nil if none was accepted.
+	presented bool            // Whether the request presented an API key at all.
+	disabled  bool            // Whether the key belongs to a disabled user.
+}
+
+// contextKey keys the authentication outcome in a request context.
+type contextKey struct{}
+
+// Authenticate returns middleware resolving the API key presented with a request, once, into the request context.
+// Keys are taken from an 'Authorization: Bearer' header, the X-API-Key header or, as the policy allows,
+// the 'token' query parameter. Handlers turn the outcome into a response with Authorize, so that requests
+// they would refuse for other reasons, such as unsupported methods, are still refused for those reasons.
+// Keys not in the API key format of the given environment are refused without looking them up. Keys that are
+// not accepted count as failed attempts with the guard, against the client and the identifier of the key, and
+// the guard refuses clients and keys with too many.
+func Authenticate(store db.Store, queryTokens, environment string, guard *lockout.Guard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := headerToken(r)
+			if token == "" {
+				if token = r.URL.Query().Get("token"); token != "" {
+					switch queryTokens {
+					case QueryTokensReject:
+						log.Printf("%s: Rejected API key in query string for %s %s.", r.RemoteAddr, r.Method, r.URL.Path)
+						http.Error(w, "API keys are no longer accepted in the query string. "+ProvideAPI, http.StatusUnauthorized)
+						return
+					case QueryTokensDeprecate:
+						w.Header().Set("Deprecation", "true")
+						w.Header().Set("Warning", `299 - "API keys in the query string are deprecated; use the Authorization or X-API-Key header"`)
+						log.Printf("%s: Deprecated API key in query string for %s %s.", r.RemoteAddr, r.Method, r.URL.Path)
+					}
+				}
+			}
+
+			auth := authentication{presented: token != ""}
+			if auth.presented {
+				target := lockout.Credential(apikey.Identifier(token))
+				if Throttled(w, r, guard, target) { // Refuse clients guessing API keys.
+					return
+				}
+				if err := checkFormat(token, environment); err != nil {
+					log.Printf("%s: Refused API key %s: %v", r.RemoteAddr, apikey.Identifier(token), err)
+				} else {
+					auth.key = store.GetAPIKey(r.RemoteAddr, token) // Resolve the API key to its owner and scopes.
+				}
+				if auth.key == nil {
+					AuthenticationFailed(r, guard, target)
+				}
+			}
+			if auth.key != nil {
+				auth.disabled = UserDisabled(r, store, auth.key.UUID)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, auth)))
+		})
+	}
+}
+
+// headerToken returns the API key presented in the request headers, or an empty string if there is none.
+// Bearer tokens that are not API keys, such as Firebase ID tokens, are left for the handler.
+func headerToken(r *http.Request) string {
+	if token := strings.TrimSpace(r.Header.Get(APIKeyHeader)); token != "" {
+		return token
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix); ok {
+		if token = strings.TrimSpace(token); apikey.Recognisable(token) {
+			return token
+		}
+	}
+	return ""
+}
+
+// checkFormat checks that a key is in the API key format of the given environment, or was issued
+// before keys were checksummed.
+func checkFormat(key, environment string) error {
+	if apikey.IsLegacy(key) {
+		return nil
+	}
+	_, err := apikey.Parse(key, environment)
+	return err
+}
+
+// UserDisabled reports whether the account of a user (UUID) is disabled. Accounts are taken to be enabled
+// if the store cannot tell, so that an unavailable store does not lock everyone out.
+func UserDisabled(r *http.Request, store db.AdminStore, UUID string) bool {
+	disabled, err := store.IsUserDisabled(UUID)
+	if err != nil {
+		log.Printf("%s: Error checking whether user %s is disabled: %v", r.RemoteAddr, UUID, err)
+		return false
+	}
+	return disabled
+}
+
+// APIKey returns the API key the request was authenticated with, or nil if none was accepted or its owner
+// is disabled, and whether the request presented an API key at all.
+func APIKey(r *http.Request) (*structs.APIKey, bool) {
+	auth, _ := r.Context().Value(contextKey{}).(authentication)
+	if auth.disabled {
+		return nil, auth.presented
+	}
+	return auth.key, auth.presented
+}
+
+// Disabled reports whether the request was authenticated with an API key whose owner is disabled.
+func Disabled(r *http.Request) bool {
+	auth, _ := r.Context().Value(contextKey{}).(authentication)
+	return auth.disabled
+}
+
+// Caller identifies the API key the request was authenticated with by its prefix, or its ID for keys stored without
+// one, for recording who made a change without the key itself. It returns an empty string if no key was accepted.
+func Caller(r *http.Request) string {
+	key, _ := APIKey(r)
+	switch {
+	case key == nil:
+		return ""
+	case key.Prefix != "":
+		return key.Prefix
+	default:
+		return key.ID
+	}
+}
+
+// Authorize returns the UUID of the user owning the API key the request was authenticated with, responding with
+// an error if no key was presented (401), the key was not accepted (406), its owner is disabled (403)
+// or it lacks any of the given scopes (403).
+func Authorize(w http.ResponseWriter, r *http.Request, endpoint string, scopes ...string) (string, bool) {
+	auth, _ := r.Context().Value(contextKey{}).(authentication)
+	if auth.disabled { // Validate the owner may use the service.
+		log.Printf(constants.ClientConnectDisabled, r.RemoteAddr, r.Method, endpoint, auth.key.UUID)
+		http.Error(w, AccountDisabled, http.StatusForbidden)
+		return "", false
+	}
+	key, presented := APIKey(r)
+	if !presented { // Validate token presence.
+		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, endpoint)
+		http.Error(w, ProvideAPI, http.StatusUnauthorized)
+		return "", false
+	}
+	if key == nil { // Validate the key was accepted.
+		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, endpoint)
+		http.Error(w, APINotAccepted, http.StatusNotAcceptable)
+		return "", false
+	}
+	if missing := MissingScope(key, scopes...); missing != "" { // Validate the key may be used for the request.
+		log.Printf(constants.ClientConnectForbidden, r.RemoteAddr, r.Method, endpoint, missing)
+		http.Error(w, "API key lacks the required scope: "+missing, http.StatusForbidden)
+		return "", false
+	}
+	return key.UUID, true
+}
+
+// MissingScope returns the first of the given scopes the API key lacks, or an empty string if it has them all.
+func MissingScope(key *structs.APIKey, scopes ...string) string {
+	for _, scope := range scopes {
+		if !slices.Contains(key.Scopes, scope) {
+			return scope
+		}
+	}
+	return ""
+}
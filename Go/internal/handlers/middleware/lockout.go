@@ -0,0 +1,36 @@
+// Package middleware provides HTTP middleware shared by the endpoint handlers.
+package middleware
+
+import (
+	"fmt"
+	"globeboard/lockout"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Throttled reports whether the guard refuses an authentication attempt from the client making the request
+// against the given targets, such as lockout.User keys, responding with 429 Too Many Requests and a Retry-After
+// header if it does. The client address is always checked.
+func Throttled(w http.ResponseWriter, r *http.Request, guard *lockout.Guard, targets ...string) bool {
+	decision := guard.Check(append([]string{lockout.IP(clientAddress(r))}, targets...)...)
+	if decision.Allowed {
+		return false
+	}
+
+	retryAfter := ceilSeconds(decision.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	message := fmt.Sprintf("Too many failed attempts; please retry in %d seconds", retryAfter)
+	if decision.Locked {
+		message = fmt.Sprintf("Locked out after too many failed attempts; please retry in %d seconds", retryAfter)
+	}
+	log.Printf("%s: Throttled %s attempt to %s: %s is blocked.", r.RemoteAddr, r.Method, r.URL.Path, decision.Key)
+	http.Error(w, message, http.StatusTooManyRequests)
+	return true
+}
+
+// AuthenticationFailed counts a failed authentication attempt from the client making the request against
+// its address and the given targets.
+func AuthenticationFailed(r *http.Request, guard *lockout.Guard, targets ...string) {
+	guard.Fail(r.RemoteAddr, r.URL.Path, append([]string{lockout.IP(clientAddress(r))}, targets...)...)
+}
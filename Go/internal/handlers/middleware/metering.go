@@ -0,0 +1,57 @@
+// Package middleware provides HTTP middleware shared by the endpoint handlers.
+package middleware
+
+import (
+	"globeboard/metering"
+	"net/http"
+	"time"
+)
+
+// statusRecorder remembers the status code of the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped response writer, so that http.ResponseController reaches its Flush and deadlines.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Meter returns middleware counting the requests made with an accepted API key, once Authenticate has resolved it,
+// by the endpoint pattern they were routed to in the given mux, the status code they were answered with and
+// the time it took.
+func Meter(meter *metering.Meter, mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, _ := APIKey(r)
+			if key == nil { // Only requests made with an accepted API key are metered.
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			_, endpoint := mux.Handler(r) // The pattern the request was routed to.
+			meter.Record(key.UUID, key.ID, endpoint, recorder.status, time.Since(start))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Package middleware provides HTTP middleware shared by the endpoint handlers.
+package middleware
+
+import (
+	"fmt"
+	"globeboard/ratelimit"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit returns middleware limiting requests per client address and, once Authenticate has resolved it,
+// per API key, under the tiers of the given policy. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers of the tightest limit; requests over a limit
+// are refused with 429 Too Many Requests and a Retry-After header. Requests are let through if the limiter fails,
+// so that its failure does not take the service down.
+func RateLimit(limiter ratelimit.Limiter, policy ratelimit.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			type limit struct {
+				key  string
+				tier ratelimit.Tier
+			}
+			limits := []limit{{"ip:" + clientAddress(r), policy.IPTier()}}
+			if key, _ := APIKey(r); key != nil {
+				limits = append(limits, limit{"key:" + key.ID, policy.UserTier(key.UUID)})
+			}
+
+			var tightest *ratelimit.Decision
+			var policies []string
+			for _, l := range limits {
+				if l.tier.Unlimited() {
+					continue
+				}
+				decision, err := limiter.Allow(r.Context(), l.key, l.tier)
+				if err != nil {
+					log.Printf("%s: Error checking rate limit of %s: %v", r.RemoteAddr, l.key, err)
+					continue
+				}
+				policies = append(policies, l.tier.Policy())
+				if tightest == nil || !decision.Allowed || decision.Remaining < tightest.Remaining {
+					tightest = &decision
+				}
+				if !decision.Allowed {
+					break
+				}
+			}
+			if tightest == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.Reset)))
+			w.Header().Set("RateLimit-Policy", strings.Join(policies, ", "))
+			if !tightest.Allowed {
+				retryAfter := ceilSeconds(tightest.RetryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				message := fmt.Sprintf("Rate limit exceeded; please retry in %d seconds", retryAfter)
+				if tightest.Quota {
+					message = fmt.Sprintf("Daily quota of %d requests exceeded; please retry in %d seconds", tightest.Limit, retryAfter)
+				}
+				log.Printf("%s: Rate limited %s attempt to %s.", r.RemoteAddr, r.Method, r.URL.Path)
+				http.Error(w, message, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientAddress returns the address of the client making a request, without its port.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ceilSeconds rounds a duration up to whole seconds, as the rate limit headers count in seconds.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,79 @@
+// Package middleware provides HTTP middleware shared by the endpoint handlers.
+package middleware
+
+import (
+	"context"
+	authenticate "globeboard/auth"
+	"globeboard/db"
+	"globeboard/internal/utils/constants"
+	"globeboard/lockout"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ProvideSession prompts for a session token.
+const ProvideSession = "Please sign in and provide the session token: 'Authorization: Bearer {Session}'"
+
+// session is the outcome of verifying the session token of a request, as stored in its context.
+type session struct {
+	UUID      string // The user the session was issued to, empty if none was accepted.
+	presented bool   // Whether the request presented a session token at all.
+	disabled  bool   // Whether the session was issued to a user who has since been disabled.
+}
+
+// sessionKey keys the session outcome in a request context.
+type sessionKey struct{}
+
+// AuthenticateSession returns middleware verifying the session token presented in an 'Authorization: Bearer'
+// header into the request context. As with Authenticate, handlers turn the outcome into a response with SessionUser.
+// Sessions of users disabled after signing in are refused, and tokens that are not accepted count as failed
+// attempts with the guard, against the client and the session the token claims to be.
+func AuthenticateSession(sessions *authenticate.Sessions, store db.AdminStore, guard *lockout.Guard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix)
+			token = strings.TrimSpace(token)
+
+			s := session{presented: token != ""}
+			if s.presented {
+				target := lockout.Credential(authenticate.SessionIdentifier(token))
+				if Throttled(w, r, guard, target) { // Refuse clients guessing session tokens.
+					return
+				}
+				UUID, err := sessions.Verify(token)
+				if err != nil {
+					log.Printf("%s: Error verifying session: %v\n", r.RemoteAddr, err)
+					AuthenticationFailed(r, guard, target)
+				}
+				s.UUID = UUID
+			}
+			if s.UUID != "" {
+				s.disabled = UserDisabled(r, store, s.UUID)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+		})
+	}
+}
+
+// SessionUser returns the UUID of the user the request's session was issued to, responding with
+// an error if no session token was presented or it was not accepted (401), or the user is disabled (403).
+func SessionUser(w http.ResponseWriter, r *http.Request, endpoint string) (string, bool) {
+	s, _ := r.Context().Value(sessionKey{}).(session)
+	if !s.presented { // Validate session presence.
+		log.Printf(constants.ClientConnectNoToken, r.RemoteAddr, r.Method, endpoint)
+		http.Error(w, ProvideSession, http.StatusUnauthorized)
+		return "", false
+	}
+	if s.UUID == "" { // Validate the session was accepted.
+		log.Printf(constants.ClientConnectUnauthorized, r.RemoteAddr, r.Method, endpoint)
+		http.Error(w, "Session not accepted; please sign in again", http.StatusUnauthorized)
+		return "", false
+	}
+	if s.disabled { // Validate the user may use the service.
+		log.Printf(constants.ClientConnectDisabled, r.RemoteAddr, r.Method, endpoint, s.UUID)
+		http.Error(w, AccountDisabled, http.StatusForbidden)
+		return "", false
+	}
+	return s.UUID, true
+}
@@ -0,0 +1,73 @@
+// Package config provides typed access to the runtime configuration of the application.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the value of the environment variable 'key', or 'fallback' if it is unset or empty.
+func String(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Int returns the environment variable 'key' parsed as an integer, or 'fallback' if it is unset or invalid.
+func Int(key string, fallback int) int {
+	value := String(key, "")
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for $%s: %q, using default: %d", key, value, fallback)
+		return fallback
+	}
+	return i
+}
+
+// Bool returns the environment variable 'key' parsed as a boolean, or 'fallback' if it is unset or invalid.
+func Bool(key string, fallback bool) bool {
+	value := String(key, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for $%s: %q, using default: %t", key, value, fallback)
+		return fallback
+	}
+	return b
+}
+
+// Duration returns the environment variable 'key' parsed as a duration (e.g. "90s", "24h"),
+// or 'fallback' if it is unset or invalid.
+func Duration(key string, fallback time.Duration) time.Duration {
+	value := String(key, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for $%s: %q, using default: %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// List returns the environment variable 'key' split on commas, with blank entries left out,
+// or nil if it is unset or empty.
+func List(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
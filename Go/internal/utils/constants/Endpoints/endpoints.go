@@ -10,14 +10,30 @@ import (
 const (
 	// ApiKey endpoint for API key operations.
 	ApiKey = Paths.Util + constants.APIVersion + "/key"
+	// ApiKeyList endpoint for listing the metadata of a user's API keys.
+	ApiKeyList = Paths.Util + constants.APIVersion + "/key/list"
+	// ApiKeyID endpoint for managing a specific API key by ID.
+	ApiKeyID = Paths.Util + constants.APIVersion + "/key/{ID}"
+	// ApiKeyRotate endpoint for replacing a specific API key by ID with a new one.
+	ApiKeyRotate = Paths.Util + constants.APIVersion + "/key/{ID}/rotate"
+	// Login endpoint for exchanging a user's credentials for a session token.
+	Login = Paths.Util + constants.APIVersion + "/login"
 	// UserRegistration endpoint for user registration operations.
 	UserRegistration = Paths.Util + constants.APIVersion + "/user/register"
 	// UserDeletion endpoint URL for user registration operations without the ID wildcard.
 	UserDeletion = Paths.Util + constants.APIVersion + "/user/delete"
 	// UserDeletionID endpoint for user deletion operations by ID.
 	UserDeletionID = Paths.Util + constants.APIVersion + "/user/delete/{ID}"
+	// UserExport endpoint for exporting everything a user owns.
+	UserExport = Paths.Util + constants.APIVersion + "/user/export"
+	// UserImport endpoint for importing a previously exported archive.
+	UserImport = Paths.Util + constants.APIVersion + "/user/import"
 	// RegistrationsID endpoint for accessing specific registration by ID.
 	RegistrationsID = Paths.Dashboards + constants.APIVersion + "/registrations/{ID}"
+	// RegistrationsHistory endpoint for listing the revisions of a specific registration by ID.
+	RegistrationsHistory = Paths.Dashboards + constants.APIVersion + "/registrations/{ID}/history"
+	// RegistrationsRestore endpoint for restoring a specific registration to a revision.
+	RegistrationsRestore = Paths.Dashboards + constants.APIVersion + "/registrations/{ID}/history/{REV}/restore"
 	// Registrations endpoint for accessing registrations without ID.
 	Registrations = Paths.Dashboards + constants.APIVersion + "/registrations"
 	// DashboardsID endpoint for accessing specific dashboard by ID.
@@ -30,4 +46,26 @@ const (
 	Notifications = Paths.Dashboards + constants.APIVersion + "/notifications"
 	// Status endpoint for checking the status of the dashboard services.
 	Status = Paths.Dashboards + constants.APIVersion + "/status"
+	// Usage endpoint for reading the caller's API usage over a date range.
+	Usage = Paths.Dashboards + constants.APIVersion + "/usage"
+	// AdminUsers endpoint for listing users along with what they own.
+	AdminUsers = Paths.Admin + constants.APIVersion + "/users"
+	// AdminUserKeys endpoint for listing and revoking all API keys of a specific user by ID.
+	AdminUserKeys = Paths.Admin + constants.APIVersion + "/users/{ID}/keys"
+	// AdminUserKeyID endpoint for revoking a specific API key of a specific user.
+	AdminUserKeyID = Paths.Admin + constants.APIVersion + "/users/{ID}/keys/{KEY}"
+	// AdminUserDisable endpoint for disabling and re-enabling the account of a specific user by ID.
+	AdminUserDisable = Paths.Admin + constants.APIVersion + "/users/{ID}/disable"
+	// AdminWebhooks endpoint for creating and listing developer webhooks, which are notified of every user's events.
+	AdminWebhooks = Paths.Admin + constants.APIVersion + "/webhooks"
+	// AdminWebhookID endpoint for accessing a specific developer webhook by ID.
+	AdminWebhookID = Paths.Admin + constants.APIVersion + "/webhooks/{ID}"
+	// AdminAudit endpoint for reading the audit trail of administrative actions.
+	AdminAudit = Paths.Admin + constants.APIVersion + "/audit"
+	// AdminUsage endpoint for reading the API usage of every user, or a specific one, over a date range.
+	AdminUsage = Paths.Admin + constants.APIVersion + "/usage"
+	// AdminLockouts endpoint for listing the clients and accounts locked out after too many failed authentication attempts.
+	AdminLockouts = Paths.Admin + constants.APIVersion + "/lockouts"
+	// AdminLockoutKey endpoint for clearing a specific lockout by its key.
+	AdminLockoutKey = Paths.Admin + constants.APIVersion + "/lockouts/{KEY}"
 )
@@ -2,7 +2,13 @@
 package External
 
 const (
-	CurrencyAPI  = "http://129.241.150.113:9090/currency/"  // CurrencyAPI specifies the endpoint URL for the Currency API.
-	OpenMeteoAPI = "https://api.open-meteo.com/v1/forecast" // OpenMeteoAPI specifies the endpoint URL for the Open-Meteo API.
-	CountriesAPI = "http://129.241.150.113:8080/v3.1/"      // CountriesAPI specifies the endpoint URL for the RESTCountries API.
+	CurrencyAPI  = "http://129.241.150.113:9090/currency/" // CurrencyAPI specifies the default base URL of the Currency API.
+	OpenMeteoAPI = "https://api.open-meteo.com/v1/"        // OpenMeteoAPI specifies the default base URL of the Open-Meteo API.
+	CountriesAPI = "http://129.241.150.113:8080/v3.1/"     // CountriesAPI specifies the default base URL of the RESTCountries API.
+)
+
+const (
+	CountriesSource = "countries_api" // CountriesSource names the RESTCountries API in errors of features it failed to provide.
+	MeteoSource     = "meteo_api"     // MeteoSource names the Open-Meteo API in errors of features it failed to provide.
+	CurrencySource  = "currency_api"  // CurrencySource names the Currency API in errors of features it failed to provide.
 )
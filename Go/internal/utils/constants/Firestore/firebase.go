@@ -2,7 +2,12 @@
 package Firestore
 
 const (
-	ApiKeyCollection       = "API_keys"      // ApiKeyCollection specifies the Firestore collection name for API keys.
-	RegistrationCollection = "Registrations" // RegistrationCollection specifies the Firestore collection name for country registrations.
-	WebhookCollection      = "Webhooks"      // WebhookCollection specifies the Firestore collection name for webhook data.
+	ApiKeyCollection       = "API_keys"       // ApiKeyCollection specifies the Firestore collection name for API keys.
+	RegistrationCollection = "Registrations"  // RegistrationCollection specifies the Firestore collection name for country registrations.
+	WebhookCollection      = "Webhooks"       // WebhookCollection specifies the Firestore collection name for webhook data.
+	RevisionCollection     = "Revisions"      // RevisionCollection specifies the Firestore collection name for registration revisions.
+	UserCollection         = "Users"          // UserCollection specifies the Firestore collection name for users of the built-in identity provider.
+	DisabledUserCollection = "Disabled_users" // DisabledUserCollection specifies the Firestore collection name for disabled accounts.
+	AuditCollection        = "Audit_log"      // AuditCollection specifies the Firestore collection name for the audit trail.
+	UsageCollection        = "Usage"          // UsageCollection specifies the Firestore collection name for metered usage counters.
 )
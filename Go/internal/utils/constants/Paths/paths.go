@@ -5,4 +5,5 @@ const (
 	Root       = "/"            // Root represents the root path of the application.
 	Util       = "/util/"       // Util represents the root path to the utility-specific endpoints.
 	Dashboards = "/dashboards/" // Dashboards represent the root path to the dashboard endpoints.
+	Admin      = "/admin/"      // Admin represents the root path to the administrative endpoints.
 )
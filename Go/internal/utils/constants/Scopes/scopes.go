@@ -0,0 +1,18 @@
+// Package Scopes defines the scopes that limit what an API key may be used for.
+package Scopes
+
+const (
+	RegistrationsRead  = "registrations:read"  // RegistrationsRead allows reading registrations and their history.
+	RegistrationsWrite = "registrations:write" // RegistrationsWrite allows creating, changing, restoring and deleting registrations.
+	DashboardsRead     = "dashboards:read"     // DashboardsRead allows populating dashboards.
+	NotificationsRead  = "notifications:read"  // NotificationsRead allows reading webhooks.
+	NotificationsWrite = "notifications:write" // NotificationsWrite allows creating and deleting webhooks.
+	StatusRead         = "status:read"         // StatusRead allows reading the status of the service.
+	UsageRead          = "usage:read"          // UsageRead allows reading the usage of the API.
+
+	Admin = "admin" // Admin allows administrators to use the administrative API; only they may give it to their keys.
+)
+
+// All lists every scope any API key may be given, which new keys get unless asked for fewer.
+// Admin is left out, so administrators have to ask for it explicitly.
+var All = []string{RegistrationsRead, RegistrationsWrite, DashboardsRead, NotificationsRead, NotificationsWrite, StatusRead, UsageRead}
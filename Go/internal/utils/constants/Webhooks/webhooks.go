@@ -7,6 +7,9 @@ const (
 	DELETETitle = "Deleted Country Data from GlobeBoard"      // DELETETitle defines the title for DELETE webhook events.
 	GETTitle    = "Invoked Country Data from GlobeBoard"      // GETTitle defines the title for GET webhook events.
 
+	UserDeleteTitle = "Deleted User from GlobeBoard" // UserDeleteTitle defines the title for user deletion webhook events.
+	SecurityTitle   = "Security Event on GlobeBoard" // SecurityTitle defines the title for security webhook events.
+
 	POSTColor   = 2664261  // Success Color - light green
 	PUTColor    = 16761095 // Update Color - bright orange
 	DELETEColor = 14431557 // Warning Color - pale red
@@ -16,4 +19,7 @@ const (
 	EventChange   = "CHANGE"   // EventChange defines the event type for PATCH operations.
 	EventDelete   = "DELETE"   // EventDelete defines the event type for DELETE operations.
 	EventInvoke   = "INVOKE"   // EventInvoke defines the event type for GET operations.
+
+	EventUserDelete = "USER_DELETE" // EventUserDelete defines the event type for user deletions, sent to developer webhooks.
+	EventSecurity   = "SECURITY"    // EventSecurity defines the event type for lockouts and their clearing, sent to developer webhooks.
 )
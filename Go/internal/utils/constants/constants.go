@@ -1,12 +1,49 @@
 // Package constants provide constant values that are used within the application.
 package constants
 
+import "time"
+
 const (
 	APIVersion   = "v1" // APIVersion specifies the version of the API being used.
-	ApiKeyLength = 20   // ApiKeyLength specifies the length of API keys generated.
+	ApiKeyLength = 32   // ApiKeyLength specifies the length of the secret of API keys generated.
 	DocIdLength  = 24   // DocIdLength specifies the length of document identifiers.
 	IdLength     = 20   // IdLength specifies the length of general purpose identifiers.
 
+	DefaultPageLimit = 100 // DefaultPageLimit specifies the page size of listings when no limit is given.
+	MaxPageLimit     = 500 // MaxPageLimit specifies the largest page size a client may request.
+
+	ArchiveVersion     = 1       // ArchiveVersion specifies the version of user export archives.
+	MaxArchiveSize     = 4 << 20 // MaxArchiveSize specifies the largest archive, in bytes, that may be imported.
+	ApiKeyPrefixLength = 8       // ApiKeyPrefixLength specifies how much of a legacy API key may be shown to identify it.
+
+	ApiKeyNameMaxLength        = 64                  // ApiKeyNameMaxLength specifies the longest name an API key may be given.
+	DefaultApiKeyRotationGrace = 24 * time.Hour      // DefaultApiKeyRotationGrace specifies how long a rotated key stays valid by default.
+	MaxApiKeyRotationGrace     = 30 * 24 * time.Hour // MaxApiKeyRotationGrace specifies the longest a rotated key may stay valid.
+	DefaultSessionTTL          = time.Hour           // DefaultSessionTTL specifies how long a session token issued at login stays valid by default.
+	// DefaultRateLimitTiers specifies the default rate limits as 'name=rate:burst:quota': API keys may make 5 requests
+	// a second in bursts of 20 and 10000 a day, and client addresses 20 a second in bursts of 50.
+	DefaultRateLimitTiers = "default=5:20:10000,ip=20:50:0"
+
+	DefaultUsageFlushInterval = 10 * time.Second // DefaultUsageFlushInterval specifies how often metered API usage is persisted by default.
+	DefaultUsageDays          = 30               // DefaultUsageDays specifies how many days a usage report covers when no range is given.
+	MaxUsageDays              = 366              // MaxUsageDays specifies the most days a usage report may cover.
+
+	DefaultLockoutFreeAttempts = 3                // DefaultLockoutFreeAttempts specifies the failed authentication attempts allowed before delays by default.
+	DefaultLockoutDelay        = time.Second      // DefaultLockoutDelay specifies the delay after the first punished failure by default.
+	DefaultLockoutMaxDelay     = time.Minute      // DefaultLockoutMaxDelay specifies the longest delay between attempts by default.
+	DefaultLockoutThreshold    = 10               // DefaultLockoutThreshold specifies the failed attempts locking a client or account out by default.
+	DefaultLockoutDuration     = 15 * time.Minute // DefaultLockoutDuration specifies how long a lockout lasts by default.
+	DefaultLockoutWindow       = time.Hour        // DefaultLockoutWindow specifies how long failed attempts are remembered by default.
+
+	DefaultCacheTTLCountries = 24 * time.Hour     // DefaultCacheTTLCountries specifies how long the list of supported countries is cached by default.
+	DefaultCacheTTLCountry   = 7 * 24 * time.Hour // DefaultCacheTTLCountry specifies how long country facts are cached by default.
+	DefaultCacheTTLRates     = time.Hour          // DefaultCacheTTLRates specifies how long exchange rates are cached by default.
+	DefaultCacheTTLWeather   = 10 * time.Minute   // DefaultCacheTTLWeather specifies how long the current weather is cached by default.
+
+	DefaultUpstreamTimeout  = 10 * time.Second // DefaultUpstreamTimeout specifies how long a request to an upstream API may take by default.
+	DefaultDashboardTimeout = 15 * time.Second // DefaultDashboardTimeout specifies how long resolving the features of a dashboard may take by default.
+	DefaultShutdownTimeout  = 30 * time.Second // DefaultShutdownTimeout specifies how long requests in flight may take to finish on shutdown by default.
+
 	// ClientConnectUnsupported formats an error message for when a client tries to connect using an unsupported method.
 	ClientConnectUnsupported = "%s attempted to connect to %s with unsupported method: %s\n"
 	// ClientConnectNoToken formats an error message for connection attempts where no token is provided.
@@ -15,6 +52,12 @@ const (
 	ClientConnectNoID = "%s: Failed %s attempt to %s: No ID.\n"
 	// ClientConnectUnauthorized formats an error message for unauthorized connection attempts.
 	ClientConnectUnauthorized = "%s: Unauthorized %s attempted to %s.\n"
+	// ClientConnectForbidden formats an error message for connection attempts with a key lacking the required scope.
+	ClientConnectForbidden = "%s: Forbidden %s attempt to %s: Missing scope %s.\n"
+	// ClientConnectDisabled formats an error message for connection attempts on behalf of a disabled user.
+	ClientConnectDisabled = "%s: Forbidden %s attempt to %s: User %s is disabled.\n"
+	// ClientConnectNotAdmin formats an error message for administrative requests by a user who is no administrator.
+	ClientConnectNotAdmin = "%s: Forbidden %s attempt to %s: User %s is not an administrator.\n"
 	// ClientConnectEmptyBody formats an error message for connection attempts with no body content.
 	ClientConnectEmptyBody = "%s: Failed %s attempt to %s: No Body.\n"
 )
@@ -4,9 +4,75 @@ package structs
 import "time"
 
 // APIKey represents a structure for storing an API key associated with a unique identifier (UUID).
+// The key itself is never stored, only a keyed hash of it and a short prefix for display.
 type APIKey struct {
-	UUID   string `json:"uuid"`    // The unique identifier for the API key
-	APIKey string `json:"api_key"` // The actual API key string
+	ID       string     `json:"id"`       // Identifier of the API key, for managing it without the key itself
+	UUID     string     `json:"uuid"`     // The unique identifier for the API key
+	Name     string     `json:"name"`     // Name given to the API key by its owner
+	Scopes   []string   `json:"scopes"`   // What the API key may be used for
+	KeyHash  string     `json:"-"`        // Keyed hash of the API key string
+	Prefix   string     `json:"prefix"`   // The first few characters of the API key, for recognising it
+	Created  time.Time  `json:"created"`  // The time the API key was created
+	Expires  *time.Time `json:"expires"`  // The time the API key stops being accepted, if ever
+	LastUsed *time.Time `json:"lastUsed"` // The last time the API key was accepted, if ever
+}
+
+// User represents a user of the built-in identity provider. Only a bcrypt hash of their password is stored.
+type User struct {
+	UUID         string    `json:"uuid"`        // The unique identifier of the user
+	Email        string    `json:"email"`       // Email the user signs in with, in lower case
+	DisplayName  string    `json:"displayName"` // Name the user registered with
+	PasswordHash string    `json:"-"`           // bcrypt hash of the user's password
+	Created      time.Time `json:"created"`     // The time the user registered
+}
+
+// UserCounts counts what a user owns.
+type UserCounts struct {
+	APIKeys       int `json:"apiKeys"`       // Number of API keys, expired ones included
+	Registrations int `json:"registrations"` // Number of registrations
+	Webhooks      int `json:"webhooks"`      // Number of webhooks
+}
+
+// UserSummary describes a user to administrators, along with what they own.
+type UserSummary struct {
+	UUID        string     `json:"uuid"`                  // The unique identifier of the user
+	Email       string     `json:"email,omitempty"`       // Email of the user, if the identity provider knows them
+	DisplayName string     `json:"displayName,omitempty"` // Name of the user, if the identity provider knows them
+	Created     *time.Time `json:"created,omitempty"`     // The time the user registered, if known
+	Admin       bool       `json:"admin"`                 // Whether the user holds the admin role
+	Disabled    *time.Time `json:"disabled"`              // The time the user's account was disabled, if it is
+	UserCounts
+}
+
+// AuditEntry records an action taken through the administrative API.
+type AuditEntry struct {
+	ID      string    `json:"id"`                // Unique identifier of the entry
+	Time    time.Time `json:"time"`              // The time the action was taken
+	Actor   string    `json:"actor"`             // UUID of the administrator taking the action
+	IP      string    `json:"ip"`                // Address the action was taken from
+	Action  string    `json:"action"`            // What was done, e.g. "user.disable"
+	Target  string    `json:"target,omitempty"`  // What it was done to, e.g. a UUID or webhook ID
+	Details string    `json:"details,omitempty"` // Further details of the action
+}
+
+// Usage counts the requests one API key made to one endpoint on one day that were answered with one status code.
+type Usage struct {
+	Day            string  `json:"day"`            // The UTC day, as YYYY-MM-DD
+	UUID           string  `json:"uuid"`           // The user owning the API key
+	KeyID          string  `json:"keyId"`          // Identifier of the API key
+	Endpoint       string  `json:"endpoint"`       // The endpoint pattern, e.g. "/dashboards/v1/dashboard/{ID}"
+	Status         int     `json:"status"`         // The status code of the responses
+	Count          int     `json:"count"`          // Number of requests
+	TotalLatencyMs float64 `json:"totalLatencyMs"` // Time spent answering the requests, in milliseconds
+	MaxLatencyMs   float64 `json:"maxLatencyMs"`   // Time spent answering the slowest request, in milliseconds
+}
+
+// UsageReport is the usage of one user, or of every user, over a range of days.
+type UsageReport struct {
+	From     string  `json:"from"`     // The first day of the range, as YYYY-MM-DD
+	To       string  `json:"to"`       // The last day of the range, as YYYY-MM-DD
+	Requests int     `json:"requests"` // Number of requests over the range
+	Usage    []Usage `json:"usage"`    // Usage per day, API key, endpoint and status code
 }
 
 // CountryInfoExternal is a structure to store external-facing country information.
@@ -39,13 +105,57 @@ type Features struct {
 	TargetCurrencies []string `json:"targetCurrencies"` // List of target currencies
 }
 
+// RevisionInternal is an immutable record of a single change to a registration.
+type RevisionInternal struct {
+	ID             string          `json:"id"`                     // Unique identifier for the revision
+	RegistrationID string          `json:"registrationId"`         // ID of the registration that changed
+	UUID           string          `json:"uuid"`                   // UUID of the user owning the registration
+	Event          string          `json:"event"`                  // Event that produced the revision (REGISTER, CHANGE or DELETE)
+	Caller         string          `json:"caller"`                 // Identity of the client that made the change
+	IP             string          `json:"ip"`                     // Address the change was made from
+	Country        string          `json:"country"`                // Name of the country
+	IsoCode        string          `json:"isoCode"`                // ISO code for the country
+	Features       Features        `json:"features"`               // Features as of this revision (before removal, for DELETE)
+	Changes        []FeatureChange `json:"changes"`                // Features that changed in this revision
+	RestoredFrom   string          `json:"restoredFrom,omitempty"` // ID of the revision this one restored, if any
+	Timestamp      time.Time       `json:"timestamp"`              // The time of the change
+}
+
+// RevisionResponse is a structure to store external-facing revision information.
+type RevisionResponse struct {
+	ID           string          `json:"id"`                     // Unique identifier for the revision
+	Event        string          `json:"event"`                  // Event that produced the revision (REGISTER, CHANGE or DELETE)
+	Caller       string          `json:"caller"`                 // Identity of the client that made the change
+	Country      string          `json:"country"`                // Name of the country
+	IsoCode      string          `json:"isoCode"`                // ISO code for the country
+	Features     Features        `json:"features"`               // Features as of this revision (before removal, for DELETE)
+	Changes      []FeatureChange `json:"changes"`                // Features that changed in this revision
+	RestoredFrom string          `json:"restoredFrom,omitempty"` // ID of the revision this one restored, if any
+	Timestamp    time.Time       `json:"timestamp"`              // The time of the change
+}
+
+// FeatureChange describes how a single feature changed between two revisions.
+type FeatureChange struct {
+	Feature string      `json:"feature"` // JSON name of the changed feature
+	Old     interface{} `json:"old"`     // Value before the change, null if the registration did not exist
+	New     interface{} `json:"new"`     // Value after the change, null if the registration was deleted
+}
+
 // DashboardResponse defines the structure for dashboard service responses.
 type DashboardResponse struct {
-	ID            string            `json:"id"`            // Unique identifier for the dashboard entry
-	Country       string            `json:"country"`       // Country name
-	IsoCode       string            `json:"iso_code"`      // ISO code for the country
-	Features      FeaturesDashboard `json:"features"`      // Detailed features used in the dashboard
-	LastRetrieval string            `json:"lastRetrieval"` // Last retrieval time of the data
+	ID            string            `json:"id"`               // Unique identifier for the dashboard entry
+	Country       string            `json:"country"`          // Country name
+	IsoCode       string            `json:"iso_code"`         // ISO code for the country
+	Features      FeaturesDashboard `json:"features"`         // Detailed features used in the dashboard
+	LastRetrieval string            `json:"lastRetrieval"`    // Last retrieval time of the data
+	Errors        []FeatureError    `json:"errors,omitempty"` // Features that could not be retrieved, if any
+}
+
+// FeatureError defines why a feature of a dashboard could not be retrieved.
+type FeatureError struct {
+	Feature string `json:"feature"` // Name of the feature, as in the features of the dashboard
+	Source  string `json:"source"`  // Upstream API that failed to provide it
+	Error   string `json:"error"`   // Why it failed
 }
 
 // FeaturesDashboard defines detailed features available on the dashboard for a country.
@@ -74,6 +184,16 @@ type StatusResponse struct {
 	Webhooks        int    `json:"webhooks"`      // Number of active webhooks
 	Version         string `json:"version"`       // Current version of the application
 	UptimeInSeconds string `json:"uptime"`        // Uptime in seconds
+	// Hit and miss counts of the upstream cache by data type
+	Cache map[string]CacheStats `json:"cache"`
+}
+
+// CacheStats defines the structure for the hit and miss counts of a data type in the upstream cache.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`      // Requests served from fresh values
+	StaleHits int64 `json:"staleHits"` // Requests served from stale values while they were refreshed
+	Misses    int64 `json:"misses"`    // Requests fetched from upstream
+	Entries   int   `json:"entries"`   // Values held
 }
 
 // WebhookResponse defines the structure for external webhook responses.
@@ -93,6 +213,48 @@ type WebhookInternal struct {
 	Event   []string `json:"event"`             // Events that trigger the webhook
 }
 
+// UserArchive is a versioned export of everything a user owns, leaving out secrets such as API key values.
+type UserArchive struct {
+	Version       int                   `json:"version"`       // Version of the archive format
+	ExportedAt    time.Time             `json:"exportedAt"`    // The time the archive was created
+	Registrations []CountryInfoExternal `json:"registrations"` // The user's registrations
+	Webhooks      []WebhookResponse     `json:"webhooks"`      // The user's webhooks
+	APIKeys       []APIKeyMetadata      `json:"apiKeys"`       // Metadata of the user's API keys
+}
+
+// APIKeyMetadata describes an API key without revealing the key itself.
+type APIKeyMetadata struct {
+	ID       string     `json:"id"`       // Identifier of the API key
+	Name     string     `json:"name"`     // Name given to the API key by its owner
+	Scopes   []string   `json:"scopes"`   // What the API key may be used for
+	Prefix   string     `json:"prefix"`   // The first few characters of the key, for recognising it
+	Created  time.Time  `json:"created"`  // The time the API key was created
+	Expires  *time.Time `json:"expires"`  // The time the API key stops being accepted, null if never
+	LastUsed *time.Time `json:"lastUsed"` // The last time the API key was accepted, null if never
+}
+
+// NewAPIKey is the response to creating or rotating an API key, the only time the key itself is shown.
+type NewAPIKey struct {
+	Token string `json:"token"` // The API key itself
+	APIKeyMetadata
+	Replaces *APIKeyMetadata `json:"replaces,omitempty"` // The key this one replaces when rotating, with its new expiry
+}
+
+// ImportReport describes the outcome of importing a UserArchive.
+type ImportReport struct {
+	DryRun        bool           `json:"dryRun"`        // Whether the import was only validated, not applied
+	Registrations []ImportResult `json:"registrations"` // Outcome for each archived registration
+	Webhooks      []ImportResult `json:"webhooks"`      // Outcome for each archived webhook
+}
+
+// ImportResult describes the outcome of importing a single archived entry.
+type ImportResult struct {
+	Index    int    `json:"index"`              // Position of the entry in the archive
+	SourceID string `json:"sourceId,omitempty"` // ID of the entry in the archive
+	ID       string `json:"id,omitempty"`       // ID of the recreated entry, once imported
+	Error    string `json:"error,omitempty"`    // Why the entry is invalid, if it is
+}
+
 // Author defines an author element for use in structured messages.
 type Author struct {
 	Name string `json:"name"` // Name of the author
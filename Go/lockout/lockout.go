@@ -0,0 +1,253 @@
+// Package lockout provides the brute-force protection that slows down and then locks out clients and targets,
+// such as user accounts, after repeated failed authentication attempts.
+package lockout
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the guard forgets keys whose failures have expired.
+const sweepInterval = time.Minute
+
+// Prefixes of the keys failed attempts are counted under.
+const (
+	prefixIP         = "ip:"         // prefixIP counts the failures of a client address.
+	prefixUser       = "user:"       // prefixUser counts the failures against a user, by UUID or email.
+	prefixCredential = "credential:" // prefixCredential counts the failures against an API key or session.
+)
+
+// Types of security events.
+const (
+	EventLockout = "LOCKOUT" // EventLockout reports a key being locked out after too many failed attempts.
+	EventUnlock  = "UNLOCK"  // EventUnlock reports an administrator clearing a lockout.
+)
+
+// IP returns the key counting the failed attempts of a client address.
+func IP(addr string) string {
+	return prefixIP + addr
+}
+
+// User returns the key counting the failed attempts against a user, identified by UUID or email.
+func User(ID string) string {
+	return prefixUser + strings.ToLower(strings.TrimSpace(ID))
+}
+
+// Credential returns the key counting the failed attempts against an API key or session, by the identifier
+// that may be logged for it, so that guessing its secret from many client addresses is caught.
+func Credential(identifier string) string {
+	return prefixCredential + identifier
+}
+
+// Account returns the account, by UUID or email, a key counts the failed attempts against,
+// or an empty string if the key counts those of a client address or credential.
+func Account(key string) string {
+	if account, ok := strings.CutPrefix(key, prefixUser); ok {
+		return account
+	}
+	return ""
+}
+
+// Policy describes how failed attempts are punished. The first Free failures are let go; each failure after
+// them delays the next attempt by Delay, doubling with every further failure up to MaxDelay. Threshold failures
+// lock the key out for Lockout. Failures are forgotten once there have been none for Window.
+type Policy struct {
+	Free      int           // Failures allowed before attempts are delayed.
+	Delay     time.Duration // Delay after the first failure beyond Free; zero disables delays.
+	MaxDelay  time.Duration // Longest delay between attempts.
+	Threshold int           // Failures after which the key is locked out; zero disables lockouts.
+	Lockout   time.Duration // How long a lockout lasts.
+	Window    time.Duration // How long failures are remembered after the last one.
+}
+
+// Decision is the outcome of checking whether an attempt may be made.
+type Decision struct {
+	Allowed    bool          // Whether the attempt may be made.
+	Locked     bool          // Whether the attempt is refused by a lockout rather than a delay.
+	Key        string        // The key refusing the attempt.
+	RetryAfter time.Duration // Time until the next attempt would be allowed, if this one was not.
+}
+
+// Lockout describes a key locked out after too many failed attempts.
+type Lockout struct {
+	Key      string    `json:"key"`      // The locked out key, e.g. "ip:203.0.113.7" or "user:tester@testing.test"
+	Failures int       `json:"failures"` // Failed attempts counted against the key
+	Until    time.Time `json:"until"`    // The time the lockout ends
+}
+
+// Event is a security event raised by the guard.
+type Event struct {
+	Type     string     `json:"type"`               // The type of event, e.g. "LOCKOUT"
+	Key      string     `json:"key"`                // The key the event is about
+	IP       string     `json:"ip,omitempty"`       // The client address whose attempt raised the event
+	Endpoint string     `json:"endpoint,omitempty"` // The endpoint the attempt was made to
+	Actor    string     `json:"actor,omitempty"`    // The administrator clearing a lockout
+	Failures int        `json:"failures"`           // Failed attempts counted against the key
+	Until    *time.Time `json:"until,omitempty"`    // The time a lockout ends
+	Time     time.Time  `json:"time"`               // The time of the event
+}
+
+// record is the state of the failed attempts counted against a key.
+type record struct {
+	failures int       // Failed attempts since failures were last forgotten.
+	last     time.Time // The time of the last failed attempt.
+	blocked  time.Time // The time attempts are allowed again.
+	locked   bool      // Whether attempts are blocked by a lockout rather than a delay.
+}
+
+// Guard counts failed attempts in process memory, so they are lost on restart and not shared between instances.
+type Guard struct {
+	policy  Policy
+	notify  func(Event) // Receives every security event, if set.
+	mu      sync.Mutex
+	records map[string]*record
+	swept   time.Time // The last time expired keys were forgotten.
+}
+
+// NewGuard returns a Guard punishing failed attempts under the given policy, passing security events to 'notify',
+// which may be nil. Every event is logged either way.
+func NewGuard(policy Policy, notify func(Event)) *Guard {
+	return &Guard{policy: policy, notify: notify, records: make(map[string]*record), swept: time.Now()}
+}
+
+// Check reports whether an attempt counted against the given keys may be made now.
+func (g *Guard) Check(keys ...string) Decision {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sweep(now)
+
+	decision := Decision{Allowed: true}
+	for _, key := range keys {
+		rec, ok := g.records[key]
+		if !ok || !now.Before(rec.blocked) {
+			continue
+		}
+		if wait := rec.blocked.Sub(now); decision.Allowed || wait > decision.RetryAfter {
+			decision = Decision{Locked: rec.locked, Key: key, RetryAfter: wait}
+		}
+	}
+	return decision
+}
+
+// Fail counts a failed attempt from a client address (IP) to an endpoint against the given keys,
+// delaying or locking out the keys with too many failures.
+func (g *Guard) Fail(IP, endpoint string, keys ...string) {
+	now := time.Now()
+	var events []Event
+
+	g.mu.Lock()
+	for _, key := range keys {
+		rec, ok := g.records[key]
+		if !ok || now.Sub(rec.last) > g.policy.Window && !now.Before(rec.blocked) {
+			rec = &record{}
+			g.records[key] = rec
+		}
+		rec.failures++
+		rec.last = now
+		if rec.locked && now.Before(rec.blocked) {
+			continue // Already locked out.
+		}
+
+		rec.locked = false
+		switch p := g.policy; {
+		case p.Threshold > 0 && rec.failures >= p.Threshold:
+			rec.locked, rec.blocked = true, now.Add(p.Lockout)
+			until := rec.blocked
+			events = append(events, Event{Type: EventLockout, Key: key, IP: IP, Endpoint: endpoint, Failures: rec.failures, Until: &until, Time: now})
+		case p.Delay > 0 && rec.failures > p.Free:
+			delay := p.Delay << min(rec.failures-p.Free-1, 30) // Double the delay with every failure, without overflowing.
+			if p.MaxDelay > 0 {
+				delay = min(delay, p.MaxDelay)
+			}
+			rec.blocked = now.Add(delay)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, event := range events {
+		g.raise(event)
+	}
+}
+
+// Succeed forgets the failed attempts counted against the given keys, as the attempt made against them succeeded.
+// Keys that are locked out stay locked out.
+func (g *Guard) Succeed(keys ...string) {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		if rec, ok := g.records[key]; ok && !(rec.locked && now.Before(rec.blocked)) {
+			delete(g.records, key)
+		}
+	}
+}
+
+// Lockouts lists the keys currently locked out, ordered by key.
+func (g *Guard) Lockouts() []Lockout {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	lockouts := []Lockout{}
+	for key, rec := range g.records {
+		if rec.locked && now.Before(rec.blocked) {
+			lockouts = append(lockouts, Lockout{Key: key, Failures: rec.failures, Until: rec.blocked})
+		}
+	}
+	slices.SortFunc(lockouts, func(a, b Lockout) int { return strings.Compare(a.Key, b.Key) })
+	return lockouts
+}
+
+// Clear lifts the lockout of a key on behalf of an administrator (actor), forgetting its failed attempts.
+// It reports whether the key was locked out.
+func (g *Guard) Clear(key, actor string) bool {
+	now := time.Now()
+
+	g.mu.Lock()
+	rec, ok := g.records[key]
+	locked := ok && rec.locked && now.Before(rec.blocked)
+	if locked {
+		delete(g.records, key)
+	}
+	g.mu.Unlock()
+
+	if locked {
+		g.raise(Event{Type: EventUnlock, Key: key, Actor: actor, Failures: rec.failures, Time: now})
+	}
+	return locked
+}
+
+// raise logs a security event and passes it on.
+func (g *Guard) raise(event Event) {
+	switch event.Type {
+	case EventLockout:
+		log.Printf("%s: Security event %s: %s locked out until %s after %d failed attempts to %s.",
+			event.IP, event.Type, event.Key, event.Until.Format(time.RFC3339), event.Failures, event.Endpoint)
+	default:
+		log.Printf("Security event %s: %s cleared by %s after %d failed attempts.", event.Type, event.Key, event.Actor, event.Failures)
+	}
+	if g.notify != nil {
+		g.notify(event)
+	}
+}
+
+// sweep forgets the keys that are not blocked and whose last failure is older than the window.
+// The caller must hold the lock.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.swept) < sweepInterval {
+		return
+	}
+	g.swept = now
+	for key, rec := range g.records {
+		if now.Sub(rec.last) > g.policy.Window && !now.Before(rec.blocked) {
+			delete(g.records, key)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package lockout
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the security events raised by a guard.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) notify(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestKeys(t *testing.T) {
+	if got := IP("203.0.113.7"); got != "ip:203.0.113.7" {
+		t.Errorf("IP() = %q", got)
+	}
+	if got := User(" Tester@Testing.test "); got != "user:tester@testing.test" {
+		t.Errorf("User() = %q, want the trimmed, lower-cased ID", got)
+	}
+	if got := Account(User("tester@testing.test")); got != "tester@testing.test" {
+		t.Errorf("Account() of a user key = %q, want the account", got)
+	}
+	if got := Account(IP("203.0.113.7")); got != "" {
+		t.Errorf("Account() of an address key = %q, want none", got)
+	}
+}
+
+func TestGuardDelay(t *testing.T) {
+	g := NewGuard(Policy{Free: 1, Delay: time.Second, MaxDelay: 3 * time.Second, Window: time.Hour}, nil)
+	key := IP("203.0.113.7")
+
+	g.Fail("203.0.113.7", "/login", key)
+	if decision := g.Check(key); !decision.Allowed {
+		t.Fatalf("Check after a free failure returned %+v, want allowed", decision)
+	}
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		g.Fail("203.0.113.7", "/login", key)
+		decision := g.Check(key)
+		if decision.Allowed || decision.Locked || decision.Key != key {
+			t.Fatalf("Check returned %+v, want delayed", decision)
+		}
+		if decision.RetryAfter > want || decision.RetryAfter < want-100*time.Millisecond {
+			t.Errorf("Check returned a delay of %s, want %s", decision.RetryAfter, want)
+		}
+	}
+}
+
+func TestGuardLockout(t *testing.T) {
+	r := &recorder{}
+	g := NewGuard(Policy{Threshold: 3, Lockout: time.Minute, Window: time.Hour}, r.notify)
+	ip, user := IP("203.0.113.7"), User("tester@testing.test")
+
+	for range 3 {
+		if decision := g.Check(ip, user); !decision.Allowed {
+			t.Fatalf("Check before the threshold returned %+v, want allowed", decision)
+		}
+		g.Fail("203.0.113.7", "/login", ip, user)
+	}
+
+	decision := g.Check(ip, user)
+	if decision.Allowed || !decision.Locked || decision.RetryAfter <= 0 || decision.RetryAfter > time.Minute {
+		t.Errorf("Check after the threshold returned %+v, want locked out for a minute", decision)
+	}
+	if decision := g.Check(IP("198.51.100.1")); !decision.Allowed {
+		t.Errorf("Check of another key returned %+v, want allowed", decision)
+	}
+
+	if len(r.events) != 2 {
+		t.Fatalf("raised %d events, want a lockout of both keys", len(r.events))
+	}
+	for _, event := range r.events {
+		if event.Type != EventLockout || event.Failures != 3 || event.Endpoint != "/login" || event.Until == nil {
+			t.Errorf("raised %+v, want a lockout after 3 failures", event)
+		}
+	}
+
+	// A success does not lift a lockout.
+	g.Succeed(ip, user)
+	if decision := g.Check(ip); decision.Allowed {
+		t.Errorf("Check after a success returned %+v, want still locked out", decision)
+	}
+
+	lockouts := g.Lockouts()
+	if len(lockouts) != 2 || lockouts[0].Key != ip || lockouts[1].Key != user {
+		t.Fatalf("Lockouts() = %+v, want both keys ordered by key", lockouts)
+	}
+}
+
+func TestGuardClear(t *testing.T) {
+	r := &recorder{}
+	g := NewGuard(Policy{Threshold: 1, Lockout: time.Minute, Window: time.Hour}, r.notify)
+	key := User("tester@testing.test")
+
+	if g.Clear(key, "admin") {
+		t.Errorf("Clear of a key not locked out returned true")
+	}
+	g.Fail("203.0.113.7", "/login", key)
+	if !g.Clear(key, "admin") {
+		t.Fatalf("Clear of a locked out key returned false")
+	}
+	if decision := g.Check(key); !decision.Allowed {
+		t.Errorf("Check after Clear returned %+v, want allowed", decision)
+	}
+	if len(g.Lockouts()) != 0 {
+		t.Errorf("Lockouts() after Clear = %+v, want none", g.Lockouts())
+	}
+	if last := r.events[len(r.events)-1]; last.Type != EventUnlock || last.Actor != "admin" || last.Key != key {
+		t.Errorf("raised %+v, want an unlock by admin", last)
+	}
+}
+
+func TestGuardSucceedForgets(t *testing.T) {
+	g := NewGuard(Policy{Threshold: 2, Lockout: time.Minute, Window: time.Hour}, nil)
+	key := IP("203.0.113.7")
+
+	g.Fail("203.0.113.7", "/login", key)
+	g.Succeed(key)
+	g.Fail("203.0.113.7", "/login", key)
+	if decision := g.Check(key); !decision.Allowed {
+		t.Errorf("Check returned %+v, want the failure before the success forgotten", decision)
+	}
+}
+
+func TestGuardWindow(t *testing.T) {
+	g := NewGuard(Policy{Threshold: 2, Lockout: time.Minute, Window: 10 * time.Millisecond}, nil)
+	key := IP("203.0.113.7")
+
+	g.Fail("203.0.113.7", "/login", key)
+	time.Sleep(20 * time.Millisecond)
+	g.Fail("203.0.113.7", "/login", key)
+	if decision := g.Check(key); !decision.Allowed {
+		t.Errorf("Check returned %+v, want the failure outside the window forgotten", decision)
+	}
+}
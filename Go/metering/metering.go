@@ -0,0 +1,176 @@
+// Package metering provides the metering of API usage, which counts the requests made with every API key
+// per day, endpoint and status code, along with their latency, and persists the counts through the storage layer.
+package metering
+
+import (
+	"errors"
+	"fmt"
+	"globeboard/db"
+	"globeboard/internal/utils/structs"
+	"log"
+	"sync"
+	"time"
+)
+
+// counter identifies the requests a usage record counts.
+type counter struct {
+	Day      string
+	UUID     string
+	KeyID    string
+	Endpoint string
+	Status   int
+}
+
+// Meter counts requests in memory and adds the counts to the usage stored through the storage layer
+// every flush interval, so that metering does not cost every request a database write.
+type Meter struct {
+	store   db.UsageStore
+	mu      sync.Mutex
+	pending map[counter]*structs.Usage // Counts not yet added to the store.
+	stop    chan struct{}
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// NewMeter returns a Meter flushing its counts to the given store every interval. A non-positive interval
+// leaves flushing to the callers of Flush.
+func NewMeter(store db.UsageStore, interval time.Duration) *Meter {
+	m := &Meter{
+		store:   store,
+		pending: make(map[counter]*structs.Usage),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go m.run(interval)
+	return m
+}
+
+// run flushes the counts every interval until the meter is closed.
+func (m *Meter) run(interval time.Duration) {
+	defer close(m.done)
+	if interval <= 0 {
+		<-m.stop
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Printf("Error flushing API usage: %v", err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Record counts a request made with an API key (keyID) of a user (UUID) to an endpoint, answered
+// with the given status code after the given latency.
+func (m *Meter) Record(UUID, keyID, endpoint string, status int, latency time.Duration) {
+	c := counter{
+		Day:      time.Now().UTC().Format(time.DateOnly),
+		UUID:     UUID,
+		KeyID:    keyID,
+		Endpoint: endpoint,
+		Status:   status,
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.pending[c]
+	if !ok {
+		u = &structs.Usage{Day: c.Day, UUID: c.UUID, KeyID: c.KeyID, Endpoint: c.Endpoint, Status: c.Status}
+		m.pending[c] = u
+	}
+	u.Count++
+	u.TotalLatencyMs += ms
+	u.MaxLatencyMs = max(u.MaxLatencyMs, ms)
+}
+
+// Flush adds the counts recorded since the last flush to the store. Counts the store fails to add
+// are kept for the next flush, while those it added before failing are not added again.
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[counter]*structs.Usage)
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	usage := make([]structs.Usage, 0, len(pending))
+	for _, u := range pending {
+		usage = append(usage, *u)
+	}
+	written, err := m.store.AddUsage(usage)
+	if err == nil {
+		return nil
+	}
+
+	// Put the counts not added back, merging them with those recorded in the meantime.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range usage[written:] {
+		c := counter{Day: u.Day, UUID: u.UUID, KeyID: u.KeyID, Endpoint: u.Endpoint, Status: u.Status}
+		if recorded, ok := m.pending[c]; ok {
+			u.Count += recorded.Count
+			u.TotalLatencyMs += recorded.TotalLatencyMs
+			u.MaxLatencyMs = max(u.MaxLatencyMs, recorded.MaxLatencyMs)
+		}
+		m.pending[c] = &u
+	}
+	return err
+}
+
+// Close stops flushing periodically and flushes the counts recorded since the last flush.
+func (m *Meter) Close() error {
+	m.closed.Do(func() { close(m.stop) })
+	<-m.done
+	return m.Flush()
+}
+
+// ErrInvalidRange reports a malformed or too long date range of a usage report.
+var ErrInvalidRange = errors.New("invalid date range")
+
+// ParseRange parses the date range of a usage report, given as YYYY-MM-DD days, both inclusive. The range
+// defaults to the last 'days' days up to today (UTC), and may span at most 'maxDays' days.
+func ParseRange(from, to string, days, maxDays int) (string, string, error) {
+	end := time.Now().UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: 'to' must be a date formatted YYYY-MM-DD", ErrInvalidRange)
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, 1-days)
+	if from != "" {
+		f, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: 'from' must be a date formatted YYYY-MM-DD", ErrInvalidRange)
+		}
+		start = f
+	}
+	if start.After(end) {
+		return "", "", fmt.Errorf("%w: 'from' must not be after 'to'", ErrInvalidRange)
+	}
+	if start.AddDate(0, 0, maxDays).Compare(end) <= 0 {
+		return "", "", fmt.Errorf("%w: the range may span at most %d days", ErrInvalidRange, maxDays)
+	}
+	return start.Format(time.DateOnly), end.Format(time.DateOnly), nil
+}
+
+// Report sums up the usage counters of a date range.
+func Report(from, to string, usage []structs.Usage) structs.UsageReport {
+	report := structs.UsageReport{From: from, To: to, Usage: usage}
+	if report.Usage == nil {
+		report.Usage = []structs.Usage{}
+	}
+	for _, u := range usage {
+		report.Requests += u.Count
+	}
+	return report
+}
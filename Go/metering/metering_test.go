@@ -0,0 +1,200 @@
+package metering
+
+import (
+	"errors"
+	"globeboard/db"
+	"globeboard/internal/utils/structs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is a usage store keeping the counts it is given, failing while 'fail' is set after
+// keeping the first 'partial' of them.
+type fakeStore struct {
+	mu      sync.Mutex
+	fail    error
+	partial int
+	usage   []structs.Usage
+}
+
+func (s *fakeStore) AddUsage(usage []structs.Usage) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		written := min(s.partial, len(usage))
+		s.usage = append(s.usage, usage[:written]...)
+		return written, s.fail
+	}
+	s.usage = append(s.usage, usage...)
+	return len(usage), nil
+}
+
+func (s *fakeStore) GetUsage(db.UsageQuery) ([]structs.Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage, nil
+}
+
+func TestFlush(t *testing.T) {
+	store := &fakeStore{}
+	m := NewMeter(store, 0)
+	defer m.Close()
+
+	m.Record("user", "key", "/dashboards", 200, 10*time.Millisecond)
+	m.Record("user", "key", "/dashboards", 200, 30*time.Millisecond)
+	m.Record("user", "key", "/dashboards", 404, 5*time.Millisecond)
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	if len(store.usage) != 2 {
+		t.Fatalf("flushed %d counters, want one per status", len(store.usage))
+	}
+	for _, u := range store.usage {
+		if u.UUID != "user" || u.KeyID != "key" || u.Endpoint != "/dashboards" || u.Day != time.Now().UTC().Format(time.DateOnly) {
+			t.Errorf("flushed %+v, want the recorded user, key, endpoint and day", u)
+		}
+		switch u.Status {
+		case 200:
+			if u.Count != 2 || u.TotalLatencyMs != 40 || u.MaxLatencyMs != 30 {
+				t.Errorf("flushed %+v, want 2 requests taking 40ms, at most 30ms", u)
+			}
+		case 404:
+			if u.Count != 1 || u.TotalLatencyMs != 5 || u.MaxLatencyMs != 5 {
+				t.Errorf("flushed %+v, want 1 request taking 5ms", u)
+			}
+		}
+	}
+
+	// Counts are flushed once.
+	if err := m.Flush(); err != nil || len(store.usage) != 2 {
+		t.Errorf("second Flush returned %v and flushed %d counters, want nothing flushed", err, len(store.usage))
+	}
+}
+
+func TestFailedFlushMerge(t *testing.T) {
+	store := &fakeStore{fail: errors.New("unavailable")}
+	m := NewMeter(store, 0)
+	defer m.Close()
+
+	m.Record("user", "key", "/dashboards", 200, 10*time.Millisecond)
+	if err := m.Flush(); err == nil {
+		t.Fatalf("Flush returned no error, want the store's")
+	}
+
+	// Counts recorded after the failed flush are merged with those kept from it.
+	m.Record("user", "key", "/dashboards", 200, 20*time.Millisecond)
+	store.fail = nil
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if len(store.usage) != 1 {
+		t.Fatalf("flushed %d counters, want 1", len(store.usage))
+	}
+	if u := store.usage[0]; u.Count != 2 || u.TotalLatencyMs != 30 || u.MaxLatencyMs != 20 {
+		t.Errorf("flushed %+v, want 2 requests taking 30ms, at most 20ms", u)
+	}
+}
+
+func TestPartialFlush(t *testing.T) {
+	store := &fakeStore{fail: errors.New("unavailable"), partial: 2}
+	m := NewMeter(store, 0)
+	defer m.Close()
+
+	for _, endpoint := range []string{"/dashboards", "/registrations", "/notifications"} {
+		m.Record("user", "key", endpoint, 200, time.Millisecond)
+	}
+	if err := m.Flush(); err == nil {
+		t.Fatalf("Flush returned no error, want the store's")
+	}
+
+	// Only the counts the store failed to add are added again.
+	store.fail = nil
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if len(store.usage) != 3 {
+		t.Fatalf("flushed %d counters, want 3", len(store.usage))
+	}
+	endpoints := make(map[string]bool)
+	for _, u := range store.usage {
+		if endpoints[u.Endpoint] || u.Count != 1 {
+			t.Errorf("flushed %+v again, want every request counted once", u)
+		}
+		endpoints[u.Endpoint] = true
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	store := &fakeStore{}
+	m := NewMeter(store, time.Hour)
+	m.Record("user", "key", "/dashboards", 200, time.Millisecond)
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if len(store.usage) != 1 {
+		t.Errorf("Close flushed %d counters, want 1", len(store.usage))
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close returned error: %v", err)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	today := time.Now().UTC().Format(time.DateOnly)
+	weekAgo := time.Now().UTC().AddDate(0, 0, -6).Format(time.DateOnly)
+
+	tests := []struct {
+		name     string
+		from, to string
+		wantFrom string
+		wantTo   string
+	}{
+		{"default", "", "", weekAgo, today},
+		{"to only", "", "2024-03-10", "2024-03-04", "2024-03-10"},
+		{"single day", "2024-03-10", "2024-03-10", "2024-03-10", "2024-03-10"},
+		{"longest", "2024-01-01", "2024-01-31", "2024-01-01", "2024-01-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := ParseRange(tt.from, tt.to, 7, 31)
+			if err != nil {
+				t.Fatalf("ParseRange returned error: %v", err)
+			}
+			if from != tt.wantFrom || to != tt.wantTo {
+				t.Errorf("ParseRange(%q, %q) = %s, %s, want %s, %s", tt.from, tt.to, from, to, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{"malformed from", "10-03-2024", "2024-03-10"},
+		{"malformed to", "2024-03-01", "tomorrow"},
+		{"reversed", "2024-03-11", "2024-03-10"},
+		{"too long", "2024-01-01", "2024-02-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ParseRange(tt.from, tt.to, 7, 31); !errors.Is(err, ErrInvalidRange) {
+				t.Errorf("ParseRange(%q, %q) returned error %v, want %v", tt.from, tt.to, err, ErrInvalidRange)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := Report("2024-03-01", "2024-03-07", nil)
+	if report.Usage == nil || report.Requests != 0 {
+		t.Errorf("Report of no usage = %+v, want an empty list and no requests", report)
+	}
+	report = Report("2024-03-01", "2024-03-07", []structs.Usage{{Count: 2}, {Count: 3}})
+	if report.Requests != 5 || report.From != "2024-03-01" || report.To != "2024-03-07" {
+		t.Errorf("Report = %+v, want 5 requests over the range", report)
+	}
+}
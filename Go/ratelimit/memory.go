@@ -0,0 +1,116 @@
+// Package ratelimit provides the token-bucket rate limits and daily quotas that keep any one client
+// from degrading the service for everyone else.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the in-memory limiter forgets clients whose allowance is fully replenished.
+const sweepInterval = time.Minute
+
+// allowance is the state of the rate limits of one client.
+type allowance struct {
+	tokens  float64   // Tokens in the bucket as of 'updated'.
+	updated time.Time // The time the bucket was last refilled.
+	full    time.Time // The time the bucket will be full again.
+	day     string    // The UTC day 'count' counts requests of.
+	count   int       // Requests counted towards the quota on 'day'.
+}
+
+// MemoryLimiter is a Limiter keeping the state of the rate limits in process memory, so it is lost on restart
+// and not shared between instances.
+type MemoryLimiter struct {
+	mu         sync.Mutex
+	allowances map[string]*allowance
+	swept      time.Time // The last time idle clients were forgotten.
+}
+
+var _ Limiter = (*MemoryLimiter)(nil) // Ensure MemoryLimiter implements Limiter.
+
+// NewMemoryLimiter returns an empty in-memory Limiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{allowances: make(map[string]*allowance), swept: time.Now()}
+}
+
+// Allow takes one request from the allowance of a client under the given tier.
+func (l *MemoryLimiter) Allow(_ context.Context, key string, tier Tier) (Decision, error) {
+	now := time.Now().UTC()
+	bucket := tier.Rate > 0 && tier.Burst > 0
+	quota := tier.Quota > 0
+	if !bucket && !quota {
+		return Decision{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	a, ok := l.allowances[key]
+	if !ok {
+		a = &allowance{tokens: float64(tier.Burst), updated: now}
+		l.allowances[key] = a
+	}
+	if bucket { // Refill the bucket for the time passed since the last request.
+		a.tokens = math.Min(float64(tier.Burst), a.tokens+now.Sub(a.updated).Seconds()*tier.Rate)
+		a.updated = now
+	}
+	if day := now.Format(time.DateOnly); a.day != day { // Start counting a new day.
+		a.day, a.count = day, 0
+	}
+	untilTomorrow := now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+
+	var decision Decision
+	switch {
+	case bucket && a.tokens < 1:
+		decision = Decision{
+			Limit:      tier.Burst,
+			Reset:      seconds((float64(tier.Burst) - a.tokens) / tier.Rate),
+			RetryAfter: seconds((1 - a.tokens) / tier.Rate),
+		}
+	case quota && a.count >= tier.Quota:
+		decision = Decision{Quota: true, Limit: tier.Quota, Reset: untilTomorrow, RetryAfter: untilTomorrow}
+	default:
+		decision.Allowed = true
+		if bucket {
+			a.tokens--
+			decision.Limit = tier.Burst
+			decision.Remaining = int(a.tokens)
+			decision.Reset = seconds((float64(tier.Burst) - a.tokens) / tier.Rate)
+		}
+		if quota {
+			a.count++
+			// Report the quota if it is the tighter limit.
+			if remaining := tier.Quota - a.count; !bucket || remaining < decision.Remaining {
+				decision = Decision{Allowed: true, Quota: true, Limit: tier.Quota, Remaining: remaining, Reset: untilTomorrow}
+			}
+		}
+	}
+	if bucket {
+		a.full = now.Add(seconds((float64(tier.Burst) - a.tokens) / tier.Rate))
+	}
+	return decision, nil
+}
+
+// sweep forgets the clients whose bucket is full and who have made no requests counted today,
+// as they are indistinguishable from clients never seen. The caller must hold the lock.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	today := now.Format(time.DateOnly)
+	for key, a := range l.allowances {
+		if !now.Before(a.full) && (a.day != today || a.count == 0) {
+			delete(l.allowances, key)
+		}
+	}
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
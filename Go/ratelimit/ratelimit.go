@@ -0,0 +1,153 @@
+// Package ratelimit provides the token-bucket rate limits and daily quotas that keep any one client
+// from degrading the service for everyone else.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tiers every policy has, used unless a user is assigned another.
+const (
+	TierDefault = "default" // TierDefault limits each API key of users not assigned another tier.
+	TierIP      = "ip"      // TierIP limits each client address, whether it presents an API key or not.
+)
+
+// Tier describes how many requests a client may make: a token bucket refilled at Rate tokens a second holding
+// at most Burst tokens, each request taking one, and at most Quota requests per UTC day.
+type Tier struct {
+	Name  string  // Name of the tier, e.g. "default".
+	Rate  float64 // Tokens added to the bucket per second; zero disables the bucket.
+	Burst int     // Size of the bucket, i.e. how many requests may be made at once.
+	Quota int     // Requests allowed per UTC day; zero disables the quota.
+}
+
+// Unlimited reports whether the tier limits nothing.
+func (t Tier) Unlimited() bool {
+	return (t.Rate <= 0 || t.Burst <= 0) && t.Quota <= 0
+}
+
+// Policy returns the tier as a RateLimit-Policy header item, e.g. `20;w=4` for a bucket of 20 refilled in 4 seconds.
+func (t Tier) Policy() string {
+	var items []string
+	if t.Rate > 0 && t.Burst > 0 {
+		items = append(items, fmt.Sprintf("%d;w=%d", t.Burst, int(math.Ceil(float64(t.Burst)/t.Rate))))
+	}
+	if t.Quota > 0 {
+		items = append(items, fmt.Sprintf("%d;w=%d", t.Quota, int((24*time.Hour).Seconds())))
+	}
+	return strings.Join(items, ", ")
+}
+
+// Decision is the outcome of taking a request from a client's allowance.
+type Decision struct {
+	Allowed    bool          // Whether the request may be served.
+	Quota      bool          // Whether the decision was made by the daily quota rather than the bucket.
+	Limit      int           // Size of the binding limit: the bucket, or the daily quota.
+	Remaining  int           // Requests left under the binding limit.
+	Reset      time.Duration // Time until the binding limit is fully replenished.
+	RetryAfter time.Duration // Time until the next request would be allowed, if this one was not.
+}
+
+// Limiter keeps the state of the rate limits of every client. The in-memory limiter serves a single instance;
+// instances sharing limits need a Limiter backed by shared storage.
+type Limiter interface {
+	// Allow takes one request from the allowance of the client identified by 'key' under the given tier.
+	// A request refused by the bucket does not count towards the quota, and one refused by the quota takes no token.
+	Allow(ctx context.Context, key string, tier Tier) (Decision, error)
+}
+
+// Policy assigns tiers to clients.
+type Policy struct {
+	Tiers map[string]Tier   // Tiers by name, always including TierDefault and TierIP.
+	Users map[string]string // Tier names by user (UUID), for users not on the default tier.
+}
+
+// UserTier returns the tier limiting the API keys of a user (UUID).
+func (p Policy) UserTier(UUID string) Tier {
+	if name, ok := p.Users[UUID]; ok {
+		if tier, ok := p.Tiers[name]; ok {
+			return tier
+		}
+	}
+	return p.Tiers[TierDefault]
+}
+
+// IPTier returns the tier limiting each client address.
+func (p Policy) IPTier() Tier {
+	return p.Tiers[TierIP]
+}
+
+// ParsePolicy parses tiers given as comma-separated 'name=rate:burst:quota' items, e.g. "default=5:20:10000",
+// where rate is in requests per second, and assignments of users to tiers given as comma-separated 'UUID=name'
+// items. Tiers not given keep the limits of 'defaults', which are parsed the same way.
+func ParsePolicy(defaults, tiers, users string) (Policy, error) {
+	policy := Policy{Tiers: map[string]Tier{}, Users: map[string]string{}}
+	for _, spec := range []string{defaults, tiers} {
+		for _, item := range splitList(spec) {
+			name, limits, ok := strings.Cut(item, "=")
+			if !ok {
+				return Policy{}, fmt.Errorf("invalid rate limit tier: '%s', must be 'name=rate:burst:quota'", item)
+			}
+			tier, err := parseTier(strings.TrimSpace(name), limits)
+			if err != nil {
+				return Policy{}, err
+			}
+			policy.Tiers[tier.Name] = tier
+		}
+	}
+	for _, name := range []string{TierDefault, TierIP} {
+		if _, ok := policy.Tiers[name]; !ok {
+			return Policy{}, fmt.Errorf("rate limit tier '%s' is missing", name)
+		}
+	}
+
+	for _, item := range splitList(users) {
+		UUID, name, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return Policy{}, fmt.Errorf("invalid rate limit assignment: '%s', must be 'UUID=tier'", item)
+		}
+		if _, ok := policy.Tiers[name]; !ok {
+			return Policy{}, fmt.Errorf("unknown rate limit tier: '%s'", name)
+		}
+		policy.Users[strings.TrimSpace(UUID)] = name
+	}
+	return policy, nil
+}
+
+// parseTier parses the 'rate:burst:quota' limits of a tier.
+func parseTier(name, limits string) (Tier, error) {
+	parts := strings.Split(limits, ":")
+	if name == "" || len(parts) != 3 {
+		return Tier{}, fmt.Errorf("invalid rate limit tier: '%s=%s', must be 'name=rate:burst:quota'", name, limits)
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return Tier{}, fmt.Errorf("invalid rate of rate limit tier '%s': '%s'", name, parts[0])
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || burst < 0 {
+		return Tier{}, fmt.Errorf("invalid burst of rate limit tier '%s': '%s'", name, parts[1])
+	}
+	quota, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil || quota < 0 {
+		return Tier{}, fmt.Errorf("invalid quota of rate limit tier '%s': '%s'", name, parts[2])
+	}
+	return Tier{Name: name, Rate: rate, Burst: burst, Quota: quota}, nil
+}
+
+// splitList splits a comma-separated list, leaving out blank items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("default=5:20:10000, ip=10:50:0", "gold=50:100:0", "user-1=gold, user-2 = default")
+	if err != nil {
+		t.Fatalf("ParsePolicy returned error: %v", err)
+	}
+	if got, want := policy.UserTier("user-1"), (Tier{Name: "gold", Rate: 50, Burst: 100}); got != want {
+		t.Errorf("UserTier(user-1) = %+v, want %+v", got, want)
+	}
+	if got, want := policy.UserTier("unassigned"), (Tier{Name: TierDefault, Rate: 5, Burst: 20, Quota: 10000}); got != want {
+		t.Errorf("UserTier(unassigned) = %+v, want %+v", got, want)
+	}
+	if got := policy.IPTier(); got.Name != TierIP || got.Burst != 50 {
+		t.Errorf("IPTier() = %+v, want the ip tier", got)
+	}
+
+	// Tiers given override the defaults.
+	policy, err = ParsePolicy("default=5:20:10000,ip=10:50:0", "default=1:2:3", "")
+	if err != nil {
+		t.Fatalf("ParsePolicy returned error: %v", err)
+	}
+	if got := policy.UserTier("user-1"); got.Rate != 1 || got.Burst != 2 || got.Quota != 3 {
+		t.Errorf("UserTier(user-1) = %+v, want the overriding default tier", got)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := []struct {
+		name                   string
+		defaults, tiers, users string
+	}{
+		{"missing default", "ip=1:1:0", "", ""},
+		{"missing ip", "default=1:1:0", "", ""},
+		{"no limits", "default=1:1:0,ip=1:1:0", "gold", ""},
+		{"too few limits", "default=1:1:0,ip=1:1:0", "gold=1:1", ""},
+		{"negative rate", "default=1:1:0,ip=1:1:0", "gold=-1:1:0", ""},
+		{"infinite rate", "default=1:1:0,ip=1:1:0", "gold=Inf:1:0", ""},
+		{"invalid burst", "default=1:1:0,ip=1:1:0", "gold=1:x:0", ""},
+		{"negative quota", "default=1:1:0,ip=1:1:0", "gold=1:1:-1", ""},
+		{"unknown tier", "default=1:1:0,ip=1:1:0", "", "user-1=gold"},
+		{"invalid assignment", "default=1:1:0,ip=1:1:0", "", "user-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicy(tt.defaults, tt.tiers, tt.users); err == nil {
+				t.Errorf("ParsePolicy(%q, %q, %q) returned no error", tt.defaults, tt.tiers, tt.users)
+			}
+		})
+	}
+}
+
+func TestTierPolicy(t *testing.T) {
+	tests := []struct {
+		tier Tier
+		want string
+	}{
+		{Tier{Rate: 5, Burst: 20}, "20;w=4"},
+		{Tier{Quota: 1000}, "1000;w=86400"},
+		{Tier{Rate: 5, Burst: 20, Quota: 1000}, "20;w=4, 1000;w=86400"},
+		{Tier{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.tier.Policy(); got != tt.want {
+			t.Errorf("%+v.Policy() = %q, want %q", tt.tier, got, tt.want)
+		}
+		if got, want := tt.tier.Unlimited(), tt.want == ""; got != want {
+			t.Errorf("%+v.Unlimited() = %v, want %v", tt.tier, got, want)
+		}
+	}
+}
+
+func TestMemoryLimiterBucket(t *testing.T) {
+	l := NewMemoryLimiter()
+	tier := Tier{Name: "test", Rate: 0.001, Burst: 3} // Refills too slowly to matter during the test.
+
+	for i := 2; i >= 0; i-- {
+		decision, err := l.Allow(context.Background(), "client", tier)
+		if err != nil {
+			t.Fatalf("Allow returned error: %v", err)
+		}
+		if !decision.Allowed || decision.Limit != 3 || decision.Remaining != i {
+			t.Fatalf("Allow returned %+v, want allowed with %d of 3 remaining", decision, i)
+		}
+	}
+
+	decision, err := l.Allow(context.Background(), "client", tier)
+	if err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	if decision.Allowed || decision.Quota || decision.RetryAfter <= 0 {
+		t.Errorf("Allow returned %+v, want refused by the bucket with a retry delay", decision)
+	}
+
+	// Every client has its own bucket.
+	if decision, _ := l.Allow(context.Background(), "other", tier); !decision.Allowed {
+		t.Errorf("Allow for another client returned %+v, want allowed", decision)
+	}
+}
+
+func TestMemoryLimiterRefill(t *testing.T) {
+	l := NewMemoryLimiter()
+	tier := Tier{Name: "test", Rate: 100, Burst: 1}
+
+	if decision, _ := l.Allow(context.Background(), "client", tier); !decision.Allowed {
+		t.Fatalf("Allow returned %+v, want allowed", decision)
+	}
+	time.Sleep(20 * time.Millisecond) // Long enough for two tokens at 100 a second.
+	if decision, _ := l.Allow(context.Background(), "client", tier); !decision.Allowed {
+		t.Errorf("Allow after refill returned %+v, want allowed", decision)
+	}
+}
+
+func TestMemoryLimiterQuota(t *testing.T) {
+	l := NewMemoryLimiter()
+	tier := Tier{Name: "test", Quota: 2}
+
+	for i := 1; i >= 0; i-- {
+		decision, _ := l.Allow(context.Background(), "client", tier)
+		if !decision.Allowed || !decision.Quota || decision.Remaining != i {
+			t.Fatalf("Allow returned %+v, want allowed by the quota with %d remaining", decision, i)
+		}
+	}
+	decision, _ := l.Allow(context.Background(), "client", tier)
+	if decision.Allowed || !decision.Quota || decision.RetryAfter <= 0 || decision.RetryAfter > 24*time.Hour {
+		t.Errorf("Allow returned %+v, want refused by the quota until tomorrow", decision)
+	}
+}
+
+func TestMemoryLimiterBucketRefusalSparesQuota(t *testing.T) {
+	l := NewMemoryLimiter()
+	tier := Tier{Name: "test", Rate: 0.001, Burst: 1, Quota: 2}
+
+	l.Allow(context.Background(), "client", tier)
+	if decision, _ := l.Allow(context.Background(), "client", tier); decision.Allowed || decision.Quota {
+		t.Fatalf("Allow returned %+v, want refused by the bucket", decision)
+	}
+	if got := l.allowances["client"].count; got != 1 {
+		t.Errorf("quota counted %d requests, want 1 as the refused request does not count", got)
+	}
+}
+
+func TestMemoryLimiterUnlimited(t *testing.T) {
+	l := NewMemoryLimiter()
+	for range 10 {
+		if decision, _ := l.Allow(context.Background(), "client", Tier{Name: "none"}); !decision.Allowed {
+			t.Fatalf("Allow returned %+v, want allowed", decision)
+		}
+	}
+	if len(l.allowances) != 0 {
+		t.Errorf("unlimited tier kept state for %d clients, want none", len(l.allowances))
+	}
+}